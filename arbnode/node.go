@@ -976,6 +976,18 @@ func (n *Node) GetBatchParentChainBlock(seqNum uint64) (uint64, error) {
 	return n.InboxTracker.GetBatchParentChainBlock(seqNum)
 }
 
+func (n *Node) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	return n.InboxTracker.GetBatchMessageCount(seqNum)
+}
+
+func (n *Node) GetBatchCount() (uint64, error) {
+	return n.InboxTracker.GetBatchCount()
+}
+
+func (n *Node) GetDelayedCount() (uint64, error) {
+	return n.InboxTracker.GetDelayedCount()
+}
+
 func (n *Node) FullSyncProgressMap() map[string]interface{} {
 	return n.SyncMonitor.FullSyncProgressMap()
 }
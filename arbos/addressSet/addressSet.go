@@ -88,6 +88,22 @@ func (as *AddressSet) AllMembers(maxNumToReturn uint64) ([]common.Address, error
 	return ret, nil
 }
 
+// MembersFree returns every member of the set for free, in the order they're listed. Dangerous due to DoS potential.
+func (as *AddressSet) MembersFree() []common.Address {
+	size := as.backingStorage.GetFree(util.UintToHash(0)).Big().Uint64()
+	members := make([]common.Address, size)
+	for i := range members {
+		members[i] = common.BytesToAddress(as.backingStorage.GetFree(util.UintToHash(uint64(i + 1))).Bytes())
+	}
+	return members
+}
+
+// PositionsStorage returns the storage mapping each member, keyed by util.AddressToHash, to its position in the list.
+// Used to compare states.
+func (as *AddressSet) PositionsStorage() *storage.Storage {
+	return as.byAddress
+}
+
 func (as *AddressSet) ClearList() error {
 	size, err := as.size.Get()
 	if err != nil || size == 0 {
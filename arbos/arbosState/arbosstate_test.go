@@ -5,13 +5,17 @@ package arbosState
 
 import (
 	"bytes"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/offchainlabs/nitro/arbos/burn"
 	"github.com/offchainlabs/nitro/arbos/storage"
 	"github.com/offchainlabs/nitro/arbos/util"
 	"github.com/offchainlabs/nitro/util/colors"
+	"github.com/offchainlabs/nitro/util/testhelpers"
 )
 
 func TestStorageOpenFromEmpty(t *testing.T) {
@@ -91,3 +95,88 @@ func TestStorageSlots(t *testing.T) {
 		Fail(t, "page offset mismatch")
 	}
 }
+
+func TestDiff(t *testing.T) {
+	before, statedb := NewArbosMemoryBackedArbOSState()
+	after, err := OpenArbosState(statedb.Copy(), burn.NewSystemBurner(nil, false))
+	Require(t, err)
+
+	if diff := Diff(before, after); len(diff) != 0 {
+		Fail(t, "unexpected changes in identical states\n", diff)
+	}
+
+	Require(t, after.ScheduleArbOSUpgrade(100, 1<<62))
+	Require(t, after.L2PricingState().SetSpeedLimitPerSecond(1234567))
+	diff := Diff(before, after)
+
+	subspaces := diff.Subspaces()
+	if len(subspaces) != 2 || subspaces[0] != "root" || subspaces[1] != "l2Pricing" {
+		Fail(t, "unexpected subspaces changed", subspaces, "\n", diff)
+	}
+	root := diff.Subspace("root")
+	if len(root) != 2 {
+		Fail(t, "unexpected root changes\n", root)
+	}
+	for i, offset := range []Offset{upgradeVersionOffset, upgradeTimestampOffset} {
+		if root[i].Offset != uint64(offset) || root[i].Kind != StorageSlotAdded {
+			Fail(t, "unexpected root change", root[i])
+		}
+	}
+	if root[0].After != util.UintToHash(100) || root[1].After != util.UintToHash(1<<62) {
+		Fail(t, "unexpected upgrade values\n", root)
+	}
+	if pricing := diff.Subspace("l2Pricing"); len(pricing) != 1 || pricing[0].Kind != StorageSlotChanged {
+		Fail(t, "unexpected l2 pricing changes\n", pricing)
+	}
+
+	createdDB := statedb.Copy()
+	created, err := OpenArbosState(createdDB, burn.NewSystemBurner(nil, false))
+	Require(t, err)
+	id := common.BigToHash(big.NewInt(1))
+	to := testhelpers.RandomAddress()
+	calldata := testhelpers.RandomizeSlice(make([]byte, 40))
+	_, err = created.RetryableState().CreateRetryable(id, 1<<40, testhelpers.RandomAddress(), &to, big.NewInt(1), testhelpers.RandomAddress(), calldata)
+	Require(t, err)
+	diff = Diff(before, created)
+
+	ticket := "retryables/" + id.Hex()
+	subspaces = diff.Subspaces()
+	if len(subspaces) != 3 || subspaces[0] != "retryables/timeoutQueue" || subspaces[1] != ticket || subspaces[2] != ticket+"/calldata" {
+		Fail(t, "unexpected subspaces changed creating a retryable", subspaces, "\n", diff)
+	}
+	queue := diff.Subspace("retryables/timeoutQueue")
+	if len(queue) != 2 || queue[0].Offset != 0 || queue[0].Kind != StorageSlotChanged || queue[1].Kind != StorageSlotAdded || queue[1].After != id {
+		Fail(t, "unexpected timeout queue changes\n", queue)
+	}
+	// the length, then the two words of calldata
+	if changes := diff.Subspace(ticket + "/calldata"); len(changes) != 3 || changes[0].After != util.UintToHash(40) {
+		Fail(t, "unexpected calldata changes\n", changes)
+	}
+	for _, change := range append(diff.Subspace(ticket), diff.Subspace(ticket+"/calldata")...) {
+		if change.Kind != StorageSlotAdded {
+			Fail(t, "unexpected retryable change", change)
+		}
+	}
+
+	deletedDB := createdDB.Copy()
+	deleted, err := OpenArbosState(deletedDB, burn.NewSystemBurner(nil, false))
+	Require(t, err)
+	evm := vm.NewEVM(vm.BlockContext{}, vm.TxContext{}, deletedDB, &params.ChainConfig{}, vm.Config{})
+	found, err := deleted.RetryableState().DeleteRetryable(id, evm, util.TracingDuringEVM)
+	Require(t, err)
+	if !found {
+		Fail(t, "retryable not found")
+	}
+	diff = Diff(created, deleted)
+
+	// the timeout queue keeps the id until it's reaped
+	subspaces = diff.Subspaces()
+	if len(subspaces) != 2 || subspaces[0] != ticket || subspaces[1] != ticket+"/calldata" {
+		Fail(t, "unexpected subspaces changed deleting a retryable", subspaces, "\n", diff)
+	}
+	for _, change := range diff {
+		if change.Kind != StorageSlotRemoved {
+			Fail(t, "unexpected retryable change", change)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbosState
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/retryables"
+	"github.com/offchainlabs/nitro/arbos/storage"
+	"github.com/offchainlabs/nitro/arbos/util"
+)
+
+// subspacePageSize is the number of slots in a storage page, whose slots are contiguous in the flat KVS.
+const subspacePageSize = 256
+
+var diffedSubspaces = []struct {
+	name  string
+	id    SubspaceID
+	slots uint64
+}{
+	{"l1Pricing", l1PricingSubspace, subspacePageSize},
+	{"l2Pricing", l2PricingSubspace, subspacePageSize},
+	{"retryables", retryablesSubspace, subspacePageSize},
+	{"addressTable", addressTableSubspace, subspacePageSize},
+	{"chainOwners", chainOwnerSubspace, subspacePageSize},
+	{"sendMerkle", sendMerkleSubspace, subspacePageSize},
+	// the latest block's number is followed by a ring of 256 hashes
+	{"blockhashes", blockhashesSubspace, 1 + 256},
+	{"chainConfig", chainConfigSubspace, subspacePageSize},
+	{"programs", programsSubspace, subspacePageSize},
+}
+
+// PartlyDiffedSubspaces names the subspaces holding slots keyed by hashes Diff can't enumerate, and so doesn't
+// compare, along with what they hold there.
+var PartlyDiffedSubspaces = map[string]string{
+	"l1Pricing":    "each batch poster's funds due and payee",
+	"addressTable": "each address's index",
+	"programs":     "each program's parameters and module hash, keyed by code hash",
+}
+
+type StorageChangeKind string
+
+const (
+	StorageSlotAdded   StorageChangeKind = "added"
+	StorageSlotRemoved StorageChangeKind = "removed"
+	StorageSlotChanged StorageChangeKind = "changed"
+)
+
+// StorageChange describes a backing-storage slot whose value differs between two ArbOS states.
+// Nested storages are named by their path from the subspace holding them, such as "retryables/<ticket id>".
+type StorageChange struct {
+	Subspace string // "root" for ArbOS's top-level fields, otherwise the name of the subspace
+	Offset   uint64
+	// the slot's key, for slots keyed by a hash rather than an offset, whose Offset is left zero
+	Key    *common.Hash
+	Kind   StorageChangeKind
+	Before common.Hash
+	After  common.Hash
+}
+
+func (change StorageChange) String() string {
+	position := fmt.Sprint(change.Offset)
+	if change.Key != nil {
+		position = change.Key.Hex()
+	}
+	return fmt.Sprintf("%v[%v] %v: %v -> %v", change.Subspace, position, change.Kind, change.Before, change.After)
+}
+
+type StateDiff []StorageChange
+
+// Subspace returns the changes made within the named subspace.
+func (diff StateDiff) Subspace(name string) StateDiff {
+	var changes StateDiff
+	for _, change := range diff {
+		if change.Subspace == name {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// Subspaces returns the names of the subspaces with changes, in the order they were walked.
+func (diff StateDiff) Subspaces() []string {
+	var names []string
+	for _, change := range diff {
+		if len(names) == 0 || names[len(names)-1] != change.Subspace {
+			names = append(names, change.Subspace)
+		}
+	}
+	return names
+}
+
+func (diff StateDiff) String() string {
+	lines := make([]string, len(diff))
+	for i, change := range diff {
+		lines[i] = change.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Diff compares the fixed fields of ArbOS's top-level storage and of each of its known subspaces, which lie
+// in their first pages along with the start of any arrays they keep, and the nested storages that can be
+// enumerated: each retryable in the timeout queue of either state, with its calldata, and the chain owners.
+// The subspaces in PartlyDiffedSubspaces also hold slots keyed by hashes that can't be enumerated, which
+// aren't compared. Used by tests of upgrades and migrations, and to describe the changes blocks make to ArbOS.
+// Reads are free of gas.
+func Diff(a, b *ArbosState) StateDiff {
+	var diff StateDiff
+	compare := func(name string, offset uint64, key *common.Hash, beforeValue, afterValue common.Hash) {
+		if beforeValue == afterValue {
+			return
+		}
+		kind := StorageSlotChanged
+		if beforeValue == (common.Hash{}) {
+			kind = StorageSlotAdded
+		} else if afterValue == (common.Hash{}) {
+			kind = StorageSlotRemoved
+		}
+		diff = append(diff, StorageChange{Subspace: name, Offset: offset, Key: key, Kind: kind, Before: beforeValue, After: afterValue})
+	}
+	walk := func(name string, before, after *storage.Storage, from, to uint64) {
+		for offset := from; offset < to; offset++ {
+			key := util.UintToHash(offset)
+			compare(name, offset, nil, before.GetFree(key), after.GetFree(key))
+		}
+	}
+	walkKeys := func(name string, before, after *storage.Storage, keys []common.Hash) {
+		for i := range keys {
+			key := &keys[i]
+			compare(name, 0, key, before.GetFree(*key), after.GetFree(*key))
+		}
+	}
+	walk("root", a.backingStorage, b.backingStorage, 0, subspacePageSize)
+	for _, subspace := range diffedSubspaces {
+		walk(subspace.name, a.backingStorage.OpenSubStorage(subspace.id), b.backingStorage.OpenSubStorage(subspace.id), 0, subspace.slots)
+	}
+
+	// the timeout queue lists every retryable yet to be reaped, so together the queues list each one either state holds
+	beforeQueue, afterQueue := a.retryableState.TimeoutQueueStorage(), b.retryableState.TimeoutQueueStorage()
+	beforeFirst, beforeEnd := storage.OpenQueue(beforeQueue).OffsetsFree()
+	afterFirst, afterEnd := storage.OpenQueue(afterQueue).OffsetsFree()
+	walk("retryables/timeoutQueue", beforeQueue, afterQueue, 0, 2)
+	walk("retryables/timeoutQueue", beforeQueue, afterQueue, min(beforeFirst, afterFirst), max(beforeEnd, afterEnd))
+	var tickets []common.Hash
+	seen := make(map[common.Hash]struct{})
+	for _, queue := range []struct {
+		sto        *storage.Storage
+		first, end uint64
+	}{{beforeQueue, beforeFirst, beforeEnd}, {afterQueue, afterFirst, afterEnd}} {
+		for offset := queue.first; offset < queue.end; offset++ {
+			id := queue.sto.GetFree(util.UintToHash(offset))
+			if _, ok := seen[id]; ok || id == (common.Hash{}) {
+				continue
+			}
+			seen[id] = struct{}{}
+			tickets = append(tickets, id)
+		}
+	}
+	for _, id := range tickets {
+		name := "retryables/" + id.Hex()
+		beforeFields, beforeCalldata := a.retryableState.RetryableStorage(id)
+		afterFields, afterCalldata := b.retryableState.RetryableStorage(id)
+		walk(name, beforeFields, afterFields, 0, retryables.RetryableFieldCount)
+		// the calldata's length is followed by its words, the last of which is written even if empty
+		size := max(beforeCalldata.GetFree(util.UintToHash(0)).Big().Uint64(), afterCalldata.GetFree(util.UintToHash(0)).Big().Uint64())
+		walk(name+"/calldata", beforeCalldata, afterCalldata, 0, 2+size/32)
+	}
+
+	// owners listed past the first page, and the positions of those listed in either state
+	beforeOwners, afterOwners := a.chainOwners.MembersFree(), b.chainOwners.MembersFree()
+	ownersStorage := func(state *ArbosState) *storage.Storage {
+		return state.backingStorage.OpenSubStorage(chainOwnerSubspace)
+	}
+	walk("chainOwners", ownersStorage(a), ownersStorage(b), subspacePageSize, 1+uint64(max(len(beforeOwners), len(afterOwners))))
+	var ownerKeys []common.Hash
+	listed := make(map[common.Address]struct{})
+	for _, owner := range append(beforeOwners, afterOwners...) {
+		if _, ok := listed[owner]; ok {
+			continue
+		}
+		listed[owner] = struct{}{}
+		ownerKeys = append(ownerKeys, util.AddressToHash(owner))
+	}
+	walkKeys("chainOwners/positions", a.chainOwners.PositionsStorage(), b.chainOwners.PositionsStorage(), ownerKeys)
+	return diff
+}
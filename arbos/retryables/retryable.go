@@ -41,6 +41,21 @@ func OpenRetryableState(sto *storage.Storage, statedb vm.StateDB) *RetryableStat
 	}
 }
 
+// TimeoutQueueStorage returns the storage of the timeout queue, which holds the id of each retryable yet to be reaped.
+func (rs *RetryableState) TimeoutQueueStorage() *storage.Storage {
+	return rs.retryables.OpenSubStorage(timeoutQueueKey)
+}
+
+// RetryableFieldCount is the number of slots of a retryable's storage holding its fields.
+const RetryableFieldCount = timeoutWindowsLeftOffset + 1
+
+// RetryableStorage returns the storage holding a retryable's fields, and that holding its calldata,
+// whether or not the retryable exists. Used to compare states.
+func (rs *RetryableState) RetryableStorage(id common.Hash) (*storage.Storage, *storage.Storage) {
+	sto := rs.retryables.OpenSubStorage(id.Bytes())
+	return sto, sto.OpenSubStorage(calldataKey)
+}
+
 type Retryable struct {
 	id                 common.Hash // not backed by storage; this key determines where it lives in storage
 	backingStorage     *storage.Storage
@@ -101,6 +101,14 @@ func (q *Queue) Shift() (bool, error) {
 	return true, q.nextPutOffset.Set(2)
 }
 
+// OffsetsFree returns the offsets of the queue's first entry and of the one after its last, for free.
+// Entries lie at the offsets between them, which is used to compare states.
+func (q *Queue) OffsetsFree() (uint64, uint64) {
+	get := q.storage.GetFree(util.UintToHash(1))
+	put := q.storage.GetFree(util.UintToHash(0))
+	return get.Big().Uint64(), put.Big().Uint64()
+}
+
 // ForEach apply a closure on the enumerated elements element of the queue
 func (q *Queue) ForEach(closure func(uint64, common.Hash) (bool, error)) error {
 
@@ -0,0 +1,143 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package genericconf
+
+import (
+	"compress/zlib"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// InitCompression adds the negotiation of HTTP-RPC responses' compression to a custom hook in geth,
+// wrapping the handler already added to it, if any. Geth gzips responses for any client accepting it,
+// so the hook rewrites each request's Accept-Encoding header to name only the encoding chosen from
+// those configured, leaving gzip to geth and compressing with deflate itself. Without any compression
+// configured, it adds nothing, leaving geth to gzip responses as it does by default.
+//
+// Must be run before the go-ethereum stack is set up (ethereum/go-ethereum/node.New), and after
+// resourcemanager.Init, which replaces the hook.
+func (c *RpcConfig) InitCompression() {
+	if len(c.Compression) == 0 {
+		return
+	}
+	encodings := append([]string{}, c.Compression...)
+	wrap := node.WrapHTTPHandler
+	node.WrapHTTPHandler = func(srv http.Handler) (http.Handler, error) {
+		if wrap != nil {
+			var err error
+			if srv, err = wrap(srv); err != nil {
+				return nil, err
+			}
+		}
+		return &compressionHandler{inner: srv, encodings: encodings}, nil
+	}
+}
+
+// compressionHandler implements http.Handler and compresses the responses of inner
+// with the first of its encodings the client accepts. Websocket upgrades, which geth
+// serves on the same port when configured to, are passed through untouched, as their
+// connections are hijacked from the response writer.
+type compressionHandler struct {
+	inner     http.Handler
+	encodings []string
+}
+
+func (h *compressionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		h.inner.ServeHTTP(w, req)
+		return
+	}
+	encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"), h.encodings)
+	req.Header.Del("Accept-Encoding")
+	switch encoding {
+	case encodingGzip:
+		req.Header.Set("Accept-Encoding", encodingGzip)
+	case encodingDeflate:
+		w.Header().Set("Content-Encoding", encodingDeflate)
+		w.Header().Add("Vary", "Accept-Encoding")
+		deflated := &deflateResponseWriter{ResponseWriter: w, writer: zlib.NewWriter(w)}
+		defer deflated.close()
+		w = deflated
+	}
+	h.inner.ServeHTTP(w, req)
+}
+
+// negotiateEncoding returns the first of encodings that an Accept-Encoding header accepts,
+// or the empty string if it accepts none of them.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	accepted := make(map[string]bool)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		accepted[name] = weight > 0
+	}
+	for _, encoding := range encodings {
+		if acceptedEncoding, listed := accepted[encoding]; listed {
+			if acceptedEncoding {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// deflateResponseWriter compresses the body written to it with deflate, which HTTP defines as
+// the zlib format, before writing it to the underlying http.ResponseWriter.
+type deflateResponseWriter struct {
+	http.ResponseWriter
+	writer      *zlib.Writer
+	wroteHeader bool
+}
+
+func (w *deflateResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		// the length set is that of the uncompressed body
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *deflateResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.writer.Write(b)
+}
+
+func (w *deflateResponseWriter) Flush() {
+	if err := w.writer.Flush(); err != nil {
+		log.Debug("failed to flush deflated response", "err", err)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *deflateResponseWriter) close() {
+	if err := w.writer.Close(); err != nil {
+		log.Debug("failed to finish deflated response", "err", err)
+	}
+}
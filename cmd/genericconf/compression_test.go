@@ -0,0 +1,54 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package genericconf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/node"
+)
+
+func TestCompressionPassesWebsocketUpgradesThrough(t *testing.T) {
+	var served http.ResponseWriter
+	var acceptEncoding string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served = w
+		acceptEncoding = req.Header.Get("Accept-Encoding")
+	})
+	handler := &compressionHandler{inner: inner, encodings: []string{encodingDeflate}}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Accept-Encoding", "deflate")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	// the upgrade needs the server's own writer to hijack the connection from
+	if served != recorder {
+		t.Fatal("websocket upgrade was served through a wrapped response writer")
+	}
+	if acceptEncoding != "deflate" || recorder.Header().Get("Content-Encoding") != "" {
+		t.Fatal("websocket upgrade's encoding was negotiated, accepting", acceptEncoding, "and encoding with", recorder.Header().Get("Content-Encoding"))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if served == recorder || recorder.Header().Get("Content-Encoding") != encodingDeflate {
+		t.Fatal("expected a request accepting deflate to be deflated")
+	}
+}
+
+func TestInitCompressionWithoutEncodings(t *testing.T) {
+	defer func(wrap func(http.Handler) (http.Handler, error)) { node.WrapHTTPHandler = wrap }(node.WrapHTTPHandler)
+	node.WrapHTTPHandler = nil
+	config := DefaultRpcConfig
+	config.InitCompression()
+	if node.WrapHTTPHandler != nil {
+		t.Fatal("expected no handler to be added without compression configured")
+	}
+}
@@ -5,6 +5,7 @@ package genericconf
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"time"
 
@@ -108,13 +109,24 @@ func FileLoggingConfigAddOptions(prefix string, f *flag.FlagSet) {
 }
 
 type RpcConfig struct {
-	MaxBatchResponseSize int `koanf:"max-batch-response-size"`
-	BatchRequestLimit    int `koanf:"batch-request-limit"`
+	MaxBatchResponseSize int      `koanf:"max-batch-response-size"`
+	BatchRequestLimit    int      `koanf:"batch-request-limit"`
+	Compression          []string `koanf:"compression"`
 }
 
 var DefaultRpcConfig = RpcConfig{
 	MaxBatchResponseSize: 10_000_000, // 10MB
 	BatchRequestLimit:    node.DefaultConfig.BatchRequestLimit,
+	Compression:          []string{},
+}
+
+func (c *RpcConfig) Validate() error {
+	for _, encoding := range c.Compression {
+		if encoding != encodingGzip && encoding != encodingDeflate {
+			return fmt.Errorf("unsupported rpc compression \"%v\", expected %v or %v", encoding, encodingGzip, encodingDeflate)
+		}
+	}
+	return nil
 }
 
 func (c *RpcConfig) Apply(stackConf *node.Config) {
@@ -125,4 +137,5 @@ func (c *RpcConfig) Apply(stackConf *node.Config) {
 func RpcConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.Int(prefix+".max-batch-response-size", DefaultRpcConfig.MaxBatchResponseSize, "the maximum response size for a JSON-RPC request measured in bytes (0 means no limit)")
 	f.Int(prefix+".batch-request-limit", DefaultRpcConfig.BatchRequestLimit, "the maximum number of requests in a batch (0 means no limit)")
+	f.StringSlice(prefix+".compression", DefaultRpcConfig.Compression, "content encodings to compress HTTP-RPC responses with for clients accepting them, in order of preference (gzip, deflate; empty = leave it to geth, which gzips them for clients accepting it)")
 }
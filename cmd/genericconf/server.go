@@ -4,6 +4,8 @@
 package genericconf
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	flag "github.com/spf13/pflag"
@@ -48,6 +50,21 @@ var HTTPServerTimeoutConfigDefault = HTTPServerTimeoutConfig{
 	IdleTimeout:       120 * time.Second,
 }
 
+// GatedAPIs are namespaces too expensive to offer over HTTP or WS by accident, so they're only offered
+// when explicitly listed in the interface's api option. Geth offers every namespace for an empty list.
+var GatedAPIs = []string{"arbtrace", "trace"}
+
+func validateAPIs(iface string, enabled bool, api []string) error {
+	if enabled && len(api) == 0 {
+		return fmt.Errorf("an empty %v api list would offer every namespace, including %v; list the namespaces to offer explicitly", iface, strings.Join(GatedAPIs, ", "))
+	}
+	return nil
+}
+
+func (c HTTPConfig) Validate() error {
+	return validateAPIs("http", c.Addr != "", c.API)
+}
+
 func (c HTTPConfig) Apply(stackConf *node.Config) {
 	stackConf.HTTPHost = c.Addr
 	stackConf.HTTPPort = c.Port
@@ -97,6 +114,10 @@ var WSConfigDefault = WSConfig{
 	ExposeAll: node.DefaultConfig.WSExposeAll,
 }
 
+func (c WSConfig) Validate() error {
+	return validateAPIs("ws", c.Addr != "", c.API)
+}
+
 func (c WSConfig) Apply(stackConf *node.Config) {
 	stackConf.WSHost = c.Addr
 	stackConf.WSPort = c.Port
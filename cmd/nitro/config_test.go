@@ -62,6 +62,27 @@ func TestAggregatorConfig(t *testing.T) {
 	Require(t, err)
 }
 
+func TestGatedAPIsRequireExplicitLists(t *testing.T) {
+	config := NodeConfigDefault
+	config.HTTP.Addr = "0.0.0.0"
+	config.WS.Addr = "0.0.0.0"
+	Require(t, config.HTTP.Validate())
+	Require(t, config.WS.Validate())
+
+	config.HTTP.API = []string{}
+	if err := config.HTTP.Validate(); err == nil || !strings.Contains(err.Error(), "arbtrace") {
+		Fail(t, "expected an empty http api list to be rejected, got", err)
+	}
+	config.WS.API = []string{}
+	if err := config.WS.Validate(); err == nil || !strings.Contains(err.Error(), "arbtrace") {
+		Fail(t, "expected an empty ws api list to be rejected, got", err)
+	}
+
+	// the list doesn't matter for interfaces that aren't served
+	config.HTTP.Addr = ""
+	Require(t, config.HTTP.Validate())
+}
+
 func TestReloads(t *testing.T) {
 	var check func(node reflect.Value, cold bool, path string)
 	check = func(node reflect.Value, cold bool, path string) {
@@ -50,6 +50,7 @@ import (
 	"github.com/offchainlabs/nitro/cmd/genericconf"
 	"github.com/offchainlabs/nitro/cmd/util"
 	"github.com/offchainlabs/nitro/cmd/util/confighelpers"
+	"github.com/offchainlabs/nitro/execution"
 	"github.com/offchainlabs/nitro/execution/gethexec"
 	_ "github.com/offchainlabs/nitro/execution/nodeInterface"
 	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
@@ -397,6 +398,7 @@ func mainImpl() int {
 		flag.Usage()
 		log.Crit("Failed to start resource management module", "err", err)
 	}
+	nodeConfig.Rpc.InitCompression()
 
 	var sameProcessValidationNodeEnabled bool
 	if nodeConfig.Node.BlockValidator.Enable && (nodeConfig.Node.BlockValidator.ValidationServerConfigs[0].URL == "self" || nodeConfig.Node.BlockValidator.ValidationServerConfigs[0].URL == "self-auth") {
@@ -564,6 +566,30 @@ func mainImpl() int {
 		return 1
 	}
 
+	if nodeConfig.FeedReplayTracer.File != "" {
+		// traces the feed against the database's state, without starting the node
+		arbTraceAPI := gethexec.NewArbTraceAPI(
+			l2BlockChain,
+			chainDb,
+			execNode.Backend.APIBackend(),
+			nil,
+			nil,
+			execNode.ExecEngine,
+			func() *gethexec.ArbTraceConfig { return &liveNodeConfig.Get().Execution.ArbTrace },
+			nil,
+		)
+		// the execution engine is only given the consensus node once it starts, so batches are fetched from the node's inbox directly
+		var batchFetcher execution.BatchFetcher
+		if currentNode.InboxReader != nil {
+			batchFetcher = currentNode
+		}
+		if err := gethexec.TraceFeedReplay(ctx, arbTraceAPI, batchFetcher, &nodeConfig.FeedReplayTracer); err != nil {
+			log.Error("failed to trace feed replay", "err", err)
+			return 1
+		}
+		return 0
+	}
+
 	// Validate sequencer's MaxTxDataSize and batchPoster's MaxSize params.
 	// SequencerInbox's maxDataSize is defaulted to 117964 which is 90% of Geth's 128KB tx size limit, leaving ~13KB for proving.
 	seqInboxMaxDataSize := 117964
@@ -708,6 +734,7 @@ type NodeConfig struct {
 	Init             conf.InitConfig                 `koanf:"init"`
 	Rpc              genericconf.RpcConfig           `koanf:"rpc"`
 	BlocksReExecutor blocksreexecutor.Config         `koanf:"blocks-reexecutor"`
+	FeedReplayTracer gethexec.FeedReplayTracerConfig `koanf:"feed-replay-tracer"`
 }
 
 var NodeConfigDefault = NodeConfig{
@@ -734,6 +761,7 @@ var NodeConfigDefault = NodeConfig{
 	PProf:            false,
 	PprofCfg:         genericconf.PProfDefault,
 	BlocksReExecutor: blocksreexecutor.DefaultConfig,
+	FeedReplayTracer: gethexec.DefaultFeedReplayTracerConfig,
 }
 
 func NodeConfigAddOptions(f *flag.FlagSet) {
@@ -761,6 +789,7 @@ func NodeConfigAddOptions(f *flag.FlagSet) {
 	conf.InitConfigAddOptions("init", f)
 	genericconf.RpcConfigAddOptions("rpc", f)
 	blocksreexecutor.ConfigAddOptions("blocks-reexecutor", f)
+	gethexec.FeedReplayTracerConfigAddOptions("feed-replay-tracer", f)
 }
 
 func (c *NodeConfig) ResolveDirectoryNames() error {
@@ -831,6 +860,18 @@ func (c *NodeConfig) Validate() error {
 	if err := c.BlocksReExecutor.Validate(); err != nil {
 		return err
 	}
+	if err := c.FeedReplayTracer.Validate(); err != nil {
+		return err
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		return err
+	}
+	if err := c.Rpc.Validate(); err != nil {
+		return err
+	}
+	if err := c.WS.Validate(); err != nil {
+		return err
+	}
 	return c.Persistent.Validate()
 }
 
@@ -9,14 +9,17 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"sync"
-	"sync/atomic"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/ethereum/go-ethereum/arbitrum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/offchainlabs/nitro/arbos/arbosState"
 	"github.com/offchainlabs/nitro/arbos/retryables"
@@ -25,16 +28,85 @@ import (
 
 type ArbAPI struct {
 	txPublisher TransactionPublisher
+	blockchain  *core.BlockChain
 }
 
-func NewArbAPI(publisher TransactionPublisher) *ArbAPI {
-	return &ArbAPI{publisher}
+func NewArbAPI(publisher TransactionPublisher, blockchain *core.BlockChain) *ArbAPI {
+	return &ArbAPI{publisher, blockchain}
 }
 
 func (a *ArbAPI) CheckPublisherHealth(ctx context.Context) error {
 	return a.txPublisher.CheckHealth(ctx)
 }
 
+type ArbOSVersionInfo struct {
+	BlockNumber               uint64  `json:"blockNumber"`
+	Version                   uint64  `json:"version"`
+	ScheduledUpgrade          *uint64 `json:"scheduledUpgrade,omitempty"`
+	ScheduledUpgradeTimestamp *uint64 `json:"scheduledUpgradeTimestamp,omitempty"`
+}
+
+// GetArbOSVersion returns the ArbOS version in effect after the given block, along with
+// the upgrade the chain owner has scheduled to follow it, if any.
+func (a *ArbAPI) GetArbOSVersion(ctx context.Context, blockNum rpc.BlockNumber) (ArbOSVersionInfo, error) {
+	blockNum, _ = a.blockchain.ClipToPostNitroGenesis(blockNum)
+	info := ArbOSVersionInfo{BlockNumber: uint64(blockNum)}
+	state, _, err := stateAndHeader(a.blockchain, uint64(blockNum))
+	if err != nil {
+		return info, err
+	}
+	info.Version = state.ArbOSVersion()
+	upgradeVersion, upgradeTimestamp, err := state.GetScheduledUpgrade()
+	if err != nil {
+		return info, err
+	}
+	// the schedule isn't cleared once an upgrade happens
+	if upgradeVersion > info.Version {
+		info.ScheduledUpgrade = &upgradeVersion
+		info.ScheduledUpgradeTimestamp = &upgradeTimestamp
+	}
+	return info, nil
+}
+
+type L1PricingInfo struct {
+	BlockNumber          uint64   `json:"blockNumber"`
+	L1BaseFeeEstimate    *big.Int `json:"l1BaseFeeEstimate"`
+	Surplus              *big.Int `json:"surplus"`
+	CostPerByte          *big.Int `json:"costPerByte"`
+	PerBatchCost         int64    `json:"perBatchCost"`
+	AmortizedCostCapBips uint64   `json:"amortizedCostCapBips"`
+}
+
+// GetL1PricingInfo returns the parent chain pricing ArbOS charged transactions with after the given block.
+// A transaction's poster fee is the cost per byte times the size of its brotli-compressed encoding.
+func (a *ArbAPI) GetL1PricingInfo(ctx context.Context, blockNum rpc.BlockNumber) (L1PricingInfo, error) {
+	blockNum, _ = a.blockchain.ClipToPostNitroGenesis(blockNum)
+	info := L1PricingInfo{BlockNumber: uint64(blockNum)}
+	state, _, err := stateAndHeader(a.blockchain, uint64(blockNum))
+	if err != nil {
+		return info, err
+	}
+	l1Pricing := state.L1PricingState()
+	info.L1BaseFeeEstimate, err = l1Pricing.PricePerUnit()
+	if err != nil {
+		return info, err
+	}
+	info.Surplus, err = l1Pricing.GetL1PricingSurplus()
+	if err != nil {
+		return info, err
+	}
+	info.PerBatchCost, err = l1Pricing.PerBatchGasCost()
+	if err != nil {
+		return info, err
+	}
+	info.AmortizedCostCapBips, err = l1Pricing.AmortizedCostCapBips()
+	if err != nil {
+		return info, err
+	}
+	info.CostPerByte = arbmath.BigMulByUint(info.L1BaseFeeEstimate, params.TxDataNonZeroGasEIP2028)
+	return info, nil
+}
+
 type ArbDebugAPI struct {
 	blockchain        *core.BlockChain
 	blockRangeBound   uint64
@@ -265,6 +337,9 @@ func (api *ArbDebugAPI) TimeoutQueue(ctx context.Context, blockNum rpc.BlockNumb
 
 func stateAndHeader(blockchain *core.BlockChain, block uint64) (*arbosState.ArbosState, *types.Header, error) {
 	header := blockchain.GetHeaderByNumber(block)
+	if header == nil {
+		return nil, nil, fmt.Errorf("block %v not found", block)
+	}
 	if !blockchain.Config().IsArbitrumNitro(header.Number) {
 		return nil, nil, types.ErrUseFallback
 	}
@@ -276,65 +351,269 @@ func stateAndHeader(blockchain *core.BlockChain, block uint64) (*arbosState.Arbo
 	return state, header, err
 }
 
+// ErrClassicNodeUnavailable is returned when a request for classic history can't reach the classic node.
+var ErrClassicNodeUnavailable = errors.New("classic node unavailable")
+
+// ErrArbTraceNotEnabled is returned when a request for classic history arrives but no classic node is configured.
+var ErrArbTraceNotEnabled = errors.New("arbtrace requests for classic history require a classic node, which must be configured with --execution.rpc.classic-redirect or --execution.arbtrace.classic-redirect-routes")
+
+var (
+	classicRedirectActiveGauge = metrics.NewRegisteredGauge("arb/arbtrace/classic/connections/active", nil)
+	classicRedirectQueueGauge  = metrics.NewRegisteredGauge("arb/arbtrace/classic/queue", nil)
+)
+
 type ArbTraceForwarderAPI struct {
 	fallbackClientUrl     string
 	fallbackClientTimeout time.Duration
+	config                ArbTraceConfigFetcher
+	// the connections to each classic node requests are routed to, keyed by its URL
+	pools map[string]*classicClientPool
+}
 
-	initialized    atomic.Bool
-	mutex          sync.Mutex
-	fallbackClient types.FallbackClient
+// classicForwardedMethods are the arbtrace methods served by classic nodes, the only ones forwarded to them.
+var classicForwardedMethods = []string{
+	"arbtrace_call",
+	"arbtrace_callMany",
+	"arbtrace_rawTransaction",
+	"arbtrace_replayBlockTransactions",
+	"arbtrace_replayTransaction",
+	"arbtrace_transaction",
+	"arbtrace_get",
+	"arbtrace_block",
+	"arbtrace_filter",
 }
 
-func NewArbTraceForwarderAPI(fallbackClientUrl string, fallbackClientTimeout time.Duration) *ArbTraceForwarderAPI {
-	return &ArbTraceForwarderAPI{
+func NewArbTraceForwarderAPI(fallbackClientUrl string, fallbackClientTimeout time.Duration, config ArbTraceConfigFetcher) *ArbTraceForwarderAPI {
+	api := &ArbTraceForwarderAPI{
 		fallbackClientUrl:     fallbackClientUrl,
 		fallbackClientTimeout: fallbackClientTimeout,
+		config:                config,
+		pools:                 make(map[string]*classicClientPool),
+	}
+	initialConfig := config()
+	targets := []string{fallbackClientUrl}
+	for _, route := range initialConfig.classicRedirectRoutes {
+		targets = append(targets, route.target)
+	}
+	for _, target := range targets {
+		if _, ok := api.pools[target]; ok || target == "" {
+			continue
+		}
+		headers := initialConfig.classicRedirectHeaders
+		if len(headers) > 0 && !classicNodeTakesHeaders(target) {
+			log.Warn("classic redirect headers are only sent over HTTP and websockets, ignoring them for IPC", "target", target)
+			headers = nil
+		}
+		api.pools[target] = newClassicClientPool(target, initialConfig.ClassicRedirectMaxConns, headers)
+	}
+	return api
+}
+
+// route returns the classic node a method is forwarded to, which is the target of the route with the longest
+// prefix of the method's name, or the classic redirect if no route matches. An empty target keeps the method local.
+func (api *ArbTraceForwarderAPI) route(method string) string {
+	for _, route := range api.config().classicRedirectRoutes {
+		if strings.HasPrefix(method, route.prefix) {
+			return route.target
+		}
+	}
+	return api.fallbackClientUrl
+}
+
+// classicClientPool hands out connections to the classic node, dialing up to max of them as concurrent
+// forwards need them, so forwards beyond that wait for a connection to be released.
+type classicClientPool struct {
+	url        string
+	httpClient *http.Client
+	slots      chan struct{}
+	idle       chan *rpc.Client
+	// static headers sent with every request, which may hold credentials and so are never logged
+	headers http.Header
+}
+
+func newClassicClientPool(rawUrl string, max int, headers http.Header) *classicClientPool {
+	// HTTP connections share a transport, which keeps as many idle as the pool may use at once
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = max
+	transport.MaxConnsPerHost = max
+	return &classicClientPool{
+		url:        rawUrl,
+		httpClient: &http.Client{Transport: transport},
+		slots:      make(chan struct{}, max),
+		idle:       make(chan *rpc.Client, max),
+		headers:    headers,
 	}
 }
 
-func (api *ArbTraceForwarderAPI) getFallbackClient() (types.FallbackClient, error) {
-	if api.initialized.Load() {
-		return api.fallbackClient, nil
+// acquire returns an idle connection, dialing a new one if there's none and the pool isn't full.
+// Failures to dial aren't cached, so the next request will dial again.
+func (p *classicClientPool) acquire(ctx context.Context, dialTimeout time.Duration) (*rpc.Client, error) {
+	classicRedirectQueueGauge.Inc(1)
+	select {
+	case p.slots <- struct{}{}:
+		classicRedirectQueueGauge.Dec(1)
+	case <-ctx.Done():
+		classicRedirectQueueGauge.Dec(1)
+		return nil, ctx.Err()
 	}
-	api.mutex.Lock()
-	defer api.mutex.Unlock()
-	if api.initialized.Load() {
-		return api.fallbackClient, nil
+	select {
+	case client := <-p.idle:
+		classicRedirectActiveGauge.Inc(1)
+		return client, nil
+	default:
 	}
-	fallbackClient, err := arbitrum.CreateFallbackClient(api.fallbackClientUrl, api.fallbackClientTimeout)
+	if dialTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+	}
+	client, err := dialClassicNode(ctx, p.url, p.httpClient, p.headers)
 	if err != nil {
-		return nil, err
+		<-p.slots
+		return nil, fmt.Errorf("%w: %v", ErrClassicNodeUnavailable, err)
 	}
-	api.fallbackClient = fallbackClient
-	api.initialized.Store(true)
-	return api.fallbackClient, nil
+	classicRedirectActiveGauge.Inc(1)
+	return client, nil
 }
 
-func (api *ArbTraceForwarderAPI) forward(ctx context.Context, method string, args ...interface{}) (*json.RawMessage, error) {
-	fallbackClient, err := api.getFallbackClient()
-	if err != nil {
-		return nil, err
+// release returns a connection to the pool, closing it instead if it may be broken.
+func (p *classicClientPool) release(client *rpc.Client, healthy bool) {
+	classicRedirectActiveGauge.Dec(1)
+	if healthy {
+		p.idle <- client
+	} else {
+		client.Close()
 	}
-	if fallbackClient == nil {
-		return nil, errors.New("arbtrace calls forwarding not configured") // TODO(magic)
+	<-p.slots
+}
+
+// classicNodeScheme returns the lowercased scheme of the classic node's URL, or "" for IPC paths.
+func classicNodeScheme(rawUrl string) string {
+	if parsed, err := url.Parse(rawUrl); err == nil {
+		return strings.ToLower(parsed.Scheme)
 	}
-	var resp *json.RawMessage
-	err = fallbackClient.CallContext(ctx, &resp, method, args...)
+	return ""
+}
+
+// classicNodeTakesHeaders reports whether the classic node is reached over a transport that carries headers.
+func classicNodeTakesHeaders(rawUrl string) bool {
+	switch classicNodeScheme(rawUrl) {
+	case "http", "https", "ws", "wss":
+		return true
+	default:
+		return false
+	}
+}
+
+// dialClassicNode connects to the classic node using the transport named by the URL's scheme,
+// sending the given headers over HTTP and websockets. Anything without an HTTP or websocket
+// scheme is treated as an IPC path.
+func dialClassicNode(ctx context.Context, rawUrl string, httpClient *http.Client, headers http.Header) (*rpc.Client, error) {
+	switch classicNodeScheme(rawUrl) {
+	case "http", "https":
+		return rpc.DialOptions(ctx, rawUrl, rpc.WithHTTPClient(httpClient), rpc.WithHeaders(headers))
+	case "ws", "wss":
+		return rpc.DialOptions(ctx, rawUrl, rpc.WithHeaders(headers))
+	default:
+		return rpc.DialIPC(ctx, rawUrl)
+	}
+}
+
+// isTransientForwardingError reports whether a failed forward is worth retrying.
+// Errors reported by the classic node itself are final, while transport failures may not be.
+func isTransientForwardingError(err error) bool {
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// callFallbackClient makes one attempt at a forward on a pooled connection, recording its latency per method.
+func (api *ArbTraceForwarderAPI) callFallbackClient(ctx context.Context, pool *classicClientPool, timeout time.Duration, result interface{}, method string, args ...interface{}) error {
+	client, err := pool.acquire(ctx, api.fallbackClientTimeout)
 	if err != nil {
+		return err
+	}
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err = client.CallContext(ctx, result, method, args...)
+	metrics.GetOrRegisterTimer("arb/arbtrace/classic/latency/"+method, nil).UpdateSince(start)
+	pool.release(client, err == nil || !isTransientForwardingError(err))
+	return err
+}
+
+func (api *ArbTraceForwarderAPI) forward(ctx context.Context, method string, args ...interface{}) (*json.RawMessage, error) {
+	target := api.route(method)
+	pool := api.pools[target]
+	if pool == nil {
+		return nil, ErrArbTraceNotEnabled
+	}
+	// the classic node's traces can't be checked against the allowlist, so none are forwarded while it's set
+	if err := api.config().checkUnrestricted(method); err != nil {
 		return nil, err
 	}
-	return resp, nil
+	// requests made of this node carry an ID already, while those only served by the classic node don't
+	id, ok := traceRequestID(ctx)
+	if !ok {
+		var done func()
+		ctx, done = startTraceRequest(ctx, method)
+		defer done()
+		id, _ = traceRequestID(ctx)
+	}
+	config := api.config()
+	timeout, ok := config.classicRedirectTimeouts[method]
+	if !ok {
+		timeout = api.fallbackClientTimeout
+	}
+	delay := config.ClassicRedirectRetryDelay
+	for attempt := 0; ; attempt++ {
+		var resp *json.RawMessage
+		start := time.Now()
+		err := api.callFallbackClient(ctx, pool, timeout, &resp, method, args...)
+		log.Debug("forwarded arbtrace call", "id", id, "method", method, "target", target, "attempt", attempt, "elapsed", time.Since(start), "err", err)
+		if err == nil {
+			return resp, nil
+		}
+		if errors.Is(err, ErrClassicNodeUnavailable) || !isTransientForwardingError(err) {
+			return nil, err
+		}
+		if attempt >= config.ClassicRedirectRetries {
+			return nil, fmt.Errorf("%w: %v", ErrClassicNodeUnavailable, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
 }
 
 func (api *ArbTraceForwarderAPI) Call(ctx context.Context, callArgs json.RawMessage, traceTypes json.RawMessage, blockNum json.RawMessage) (*json.RawMessage, error) {
+	blockNum, err := normalizeBlockRef(blockNum)
+	if err != nil {
+		return nil, err
+	}
 	return api.forward(ctx, "arbtrace_call", callArgs, traceTypes, blockNum)
 }
 
 func (api *ArbTraceForwarderAPI) CallMany(ctx context.Context, calls json.RawMessage, blockNum json.RawMessage) (*json.RawMessage, error) {
+	blockNum, err := normalizeBlockRef(blockNum)
+	if err != nil {
+		return nil, err
+	}
 	return api.forward(ctx, "arbtrace_callMany", calls, blockNum)
 }
 
 func (api *ArbTraceForwarderAPI) ReplayBlockTransactions(ctx context.Context, blockNum json.RawMessage, traceTypes json.RawMessage) (*json.RawMessage, error) {
+	blockNum, err := normalizeBlockRef(blockNum)
+	if err != nil {
+		return nil, err
+	}
 	return api.forward(ctx, "arbtrace_replayBlockTransactions", blockNum, traceTypes)
 }
 
@@ -350,7 +629,13 @@ func (api *ArbTraceForwarderAPI) Get(ctx context.Context, txHash json.RawMessage
 	return api.forward(ctx, "arbtrace_get", txHash, path)
 }
 
+// Block forwards arbtrace_block, re-encoding the block reference canonically as the other
+// forwarded methods taking one do, since the classic node doesn't accept every form clients send.
 func (api *ArbTraceForwarderAPI) Block(ctx context.Context, blockNum json.RawMessage) (*json.RawMessage, error) {
+	blockNum, err := normalizeBlockRef(blockNum)
+	if err != nil {
+		return nil, err
+	}
 	return api.forward(ctx, "arbtrace_block", blockNum)
 }
 
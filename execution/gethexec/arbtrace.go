@@ -0,0 +1,1512 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/arbitrum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/holiman/uint256"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/arbmath"
+	flag "github.com/spf13/pflag"
+)
+
+type ArbTraceConfig struct {
+	FilterMaxRange            uint64        `koanf:"filter-max-range" reload:"hot"`
+	ClassicRedirectRetries    int           `koanf:"classic-redirect-retries" reload:"hot"`
+	ClassicRedirectRetryDelay time.Duration `koanf:"classic-redirect-retry-delay" reload:"hot"`
+	ClassicRedirectTimeouts   []string      `koanf:"classic-redirect-timeouts" reload:"hot"`
+	ClassicRedirectMaxConns   int           `koanf:"classic-redirect-max-connections"`
+	ClassicRedirectHeaders    []string      `koanf:"classic-redirect-headers"`
+	ClassicRedirectRoutes     []string      `koanf:"classic-redirect-routes"`
+	ReplayWorkers             int           `koanf:"replay-workers" reload:"hot"`
+	TraceCacheSize            int           `koanf:"trace-cache-size"`
+	MaxFrames                 int           `koanf:"max-frames" reload:"hot"`
+	CallManyMaxCalls          int           `koanf:"call-many-max-calls" reload:"hot"`
+	CallManyMaxResultSize     int           `koanf:"call-many-max-result-size" reload:"hot"`
+	TraceGasCap               uint64        `koanf:"trace-gas-cap" reload:"hot"`
+	VmTraceMaxSteps           int           `koanf:"vm-trace-max-steps" reload:"hot"`
+	TraceTimeout              time.Duration `koanf:"trace-timeout" reload:"hot"`
+	EnableTraceCompat         bool          `koanf:"enable-trace-compat"`
+	TraceAllowlist            []string      `koanf:"trace-allowlist" reload:"hot"`
+	AllowImpersonation        bool          `koanf:"allow-impersonation" reload:"hot"`
+	MaxSubscriptions          int           `koanf:"max-subscriptions" reload:"hot"`
+	SubscribeMaxLag           uint64        `koanf:"subscribe-max-lag" reload:"hot"`
+	ActivityIndexSize         int           `koanf:"activity-index-size"`
+
+	classicRedirectTimeouts map[string]time.Duration
+	classicRedirectHeaders  http.Header
+	traceAllowlist          map[common.Address]struct{}
+	// sorted by decreasing prefix length, so the first route matching a method is the most specific
+	classicRedirectRoutes []classicRedirectRoute
+}
+
+func (c *ArbTraceConfig) Validate() error {
+	if c.ClassicRedirectMaxConns < 1 {
+		return fmt.Errorf("classic redirect max connections must be at least 1, got %v", c.ClassicRedirectMaxConns)
+	}
+	c.classicRedirectTimeouts = make(map[string]time.Duration, len(c.ClassicRedirectTimeouts))
+	for _, entry := range c.ClassicRedirectTimeouts {
+		method, value, found := strings.Cut(entry, "=")
+		if !found {
+			return fmt.Errorf("classic redirect timeout \"%v\" isn't of the form method=duration", entry)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid classic redirect timeout for %v: %w", method, err)
+		}
+		c.classicRedirectTimeouts[method] = timeout
+	}
+	c.classicRedirectHeaders = make(http.Header, len(c.ClassicRedirectHeaders))
+	for i, entry := range c.ClassicRedirectHeaders {
+		// the entry isn't printed, as header values are often credentials
+		name, value, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("classic redirect header %v isn't of the form name=value", i)
+		}
+		c.classicRedirectHeaders.Add(strings.TrimSpace(name), value)
+	}
+	c.classicRedirectRoutes = make([]classicRedirectRoute, 0, len(c.ClassicRedirectRoutes))
+	routed := make(map[string]string, len(c.ClassicRedirectRoutes))
+	for _, entry := range c.ClassicRedirectRoutes {
+		prefix, target, found := strings.Cut(entry, "=")
+		if !found || prefix == "" {
+			return fmt.Errorf("classic redirect route \"%v\" isn't of the form prefix=target", entry)
+		}
+		if existing, ok := routed[prefix]; ok {
+			if existing != target {
+				return fmt.Errorf("conflicting classic redirect routes send methods prefixed %v to both \"%v\" and \"%v\"", prefix, existing, target)
+			}
+			continue
+		}
+		matched := false
+		for _, method := range classicForwardedMethods {
+			if strings.HasPrefix(method, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("classic redirect route prefix %v matches none of the methods forwarded to classic nodes: %v", prefix, strings.Join(classicForwardedMethods, ", "))
+		}
+		routed[prefix] = target
+		c.classicRedirectRoutes = append(c.classicRedirectRoutes, classicRedirectRoute{prefix: prefix, target: target})
+	}
+	sort.SliceStable(c.classicRedirectRoutes, func(i, j int) bool {
+		return len(c.classicRedirectRoutes[i].prefix) > len(c.classicRedirectRoutes[j].prefix)
+	})
+	c.traceAllowlist = make(map[common.Address]struct{}, len(c.TraceAllowlist))
+	for _, entry := range c.TraceAllowlist {
+		if !common.IsHexAddress(entry) {
+			return fmt.Errorf("trace allowlist entry \"%v\" isn't an address", entry)
+		}
+		c.traceAllowlist[common.HexToAddress(entry)] = struct{}{}
+	}
+	return nil
+}
+
+// classicRedirectRoute forwards the methods whose names start with prefix to the classic node at target,
+// or keeps them local if target is empty.
+type classicRedirectRoute struct {
+	prefix string
+	target string
+}
+
+type ArbTraceConfigFetcher func() *ArbTraceConfig
+
+var DefaultArbTraceConfig = ArbTraceConfig{
+	FilterMaxRange:            1000,
+	ClassicRedirectRetries:    2,
+	ClassicRedirectRetryDelay: 100 * time.Millisecond,
+	ClassicRedirectMaxConns:   16,
+	ReplayWorkers:             runtime.NumCPU(),
+	TraceCacheSize:            16,
+	MaxFrames:                 1_000_000,
+	CallManyMaxCalls:          100,
+	CallManyMaxResultSize:     32 * 1024 * 1024,
+	TraceGasCap:               50_000_000,
+	VmTraceMaxSteps:           1_000_000,
+	TraceTimeout:              time.Minute,
+	AllowImpersonation:        true,
+	MaxSubscriptions:          64,
+	SubscribeMaxLag:           128,
+	ActivityIndexSize:         4096,
+}
+
+func ArbTraceConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Uint64(prefix+".filter-max-range", DefaultArbTraceConfig.FilterMaxRange, "maximum number of blocks an arbtrace_filter request may span (0 = unlimited)")
+	f.Int(prefix+".classic-redirect-retries", DefaultArbTraceConfig.ClassicRedirectRetries, "number of times to retry a request forwarded to the classic node after a transport failure")
+	f.Duration(prefix+".classic-redirect-retry-delay", DefaultArbTraceConfig.ClassicRedirectRetryDelay, "delay before the first retry of a forwarded request, doubling with each subsequent retry")
+	f.StringSlice(prefix+".classic-redirect-timeouts", DefaultArbTraceConfig.ClassicRedirectTimeouts, "per-method overrides of the classic redirect timeout, as method=duration (e.g. arbtrace_replayBlockTransactions=5m)")
+	f.Int(prefix+".classic-redirect-max-connections", DefaultArbTraceConfig.ClassicRedirectMaxConns, "maximum number of connections to the classic node that forwarded requests may use at once, beyond which they wait for one to be free")
+	f.StringSlice(prefix+".classic-redirect-headers", DefaultArbTraceConfig.ClassicRedirectHeaders, "headers to send with requests forwarded to the classic node over HTTP or websockets, as name=value (e.g. Authorization=Bearer <token>)")
+	f.StringSlice(prefix+".classic-redirect-routes", DefaultArbTraceConfig.ClassicRedirectRoutes, "classic nodes to forward the methods starting with a prefix to instead of the classic redirect, as prefix=target, where the longest matching prefix wins and an empty target keeps the methods local (e.g. arbtrace_filter=http://archive:8547)")
+	f.Int(prefix+".replay-workers", DefaultArbTraceConfig.ReplayWorkers, "number of transactions to trace concurrently when replaying a block (1 = sequentially)")
+	f.Int(prefix+".trace-cache-size", DefaultArbTraceConfig.TraceCacheSize, "number of replayed blocks to keep traces of, per set of trace types (0 = disable)")
+	f.Int(prefix+".max-frames", DefaultArbTraceConfig.MaxFrames, "maximum number of frames a transaction's trace may hold, beyond which tracing it fails (0 = unlimited)")
+	f.Int(prefix+".call-many-max-calls", DefaultArbTraceConfig.CallManyMaxCalls, "maximum number of calls an arbtrace_callMany request may trace (0 = unlimited)")
+	f.Int(prefix+".call-many-max-result-size", DefaultArbTraceConfig.CallManyMaxResultSize, "maximum size in bytes of the JSON encoded traces an arbtrace_callMany request may return (0 = unlimited)")
+	f.Uint64(prefix+".trace-gas-cap", DefaultArbTraceConfig.TraceGasCap, "maximum gas a call traced by arbtrace_call or arbtrace_callMany may use, to which larger gas limits are clamped (0 = only apply the rpc gas cap)")
+	f.Duration(prefix+".trace-timeout", DefaultArbTraceConfig.TraceTimeout, "maximum time tracing a single transaction or call locally may take, beyond which it's aborted, leaving requests forwarded to the classic node to their own timeouts (0 = unlimited)")
+	f.Int(prefix+".vm-trace-max-steps", DefaultArbTraceConfig.VmTraceMaxSteps, "maximum number of operations a transaction's vmTrace may hold, beyond which it's truncated while its other outputs are still traced in full (0 = unlimited)")
+	f.StringSlice(prefix+".trace-allowlist", DefaultArbTraceConfig.TraceAllowlist, "addresses of the only contracts transactions and calls may be traced into, refusing those to other accounts along with methods tracing whole blocks (empty = allow all)")
+	f.Bool(prefix+".allow-impersonation", DefaultArbTraceConfig.AllowImpersonation, "let arbtrace_call and arbtrace_callMany trace calls from any sender without its signature, as eth_call does, rather than only from the default zero address")
+	f.Int(prefix+".max-subscriptions", DefaultArbTraceConfig.MaxSubscriptions, "maximum number of arbtrace_subscribe subscriptions open at once, beyond which new ones are refused (0 = unlimited)")
+	f.Uint64(prefix+".subscribe-max-lag", DefaultArbTraceConfig.SubscribeMaxLag, "maximum number of blocks an arbtrace_subscribe subscription's notifications may fall behind the head of the chain, beyond which it's ended (0 = unlimited)")
+	f.Int(prefix+".activity-index-size", DefaultArbTraceConfig.ActivityIndexSize, "number of traced blocks to keep the accounts their frames involve, letting arbtrace_addressActivity skip those not involving an account without tracing them again (0 = disable)")
+	f.Bool(prefix+".enable-trace-compat", DefaultArbTraceConfig.EnableTraceCompat, "also serve Parity's trace namespace, whose methods are aliases of their arbtrace counterparts without Arbitrum's extensions, for tools that only speak it")
+}
+
+var (
+	// ErrCallManyTooManyCalls is returned when an arbtrace_callMany request holds more calls than configured.
+	ErrCallManyTooManyCalls = errors.New("too many calls")
+	// ErrCallManyResultTooLarge is returned when an arbtrace_callMany request's traces exceed the configured size.
+	ErrCallManyResultTooLarge = errors.New("traces exceed the maximum result size")
+	// ErrReorgDuringTrace is returned when a block leaves the canonical chain while it's being traced,
+	// as a response spanning several blocks would otherwise mix blocks from either side of the reorg.
+	ErrReorgDuringTrace = errors.New("block was reorged while tracing")
+	// ErrTraceTimeout is returned when tracing a transaction or call takes longer than the configured trace timeout.
+	ErrTraceTimeout = errors.New("trace timed out")
+)
+
+// the number of blocks the backend may re-execute to regenerate historical state
+const arbTraceReexec = uint64(128)
+
+var (
+	traceCacheHitCounter  = metrics.NewRegisteredCounter("arb/arbtrace/cache/hit", nil)
+	traceCacheMissCounter = metrics.NewRegisteredCounter("arb/arbtrace/cache/miss", nil)
+	// the transactions whose traces replayed blocks were served from the cache or computed for
+	traceResultsCachedCounter   = metrics.NewRegisteredCounter("arb/arbtrace/results/cached", nil)
+	traceResultsComputedCounter = metrics.NewRegisteredCounter("arb/arbtrace/results/computed", nil)
+	// the blocks arbtrace_addressActivity skipped, as the activity index showed they don't involve the account
+	activityIndexSkippedCounter = metrics.NewRegisteredCounter("arb/arbtrace/activity/skipped", nil)
+)
+
+// traceCacheKey identifies a block's traces. Keying by hash means a reorged block's traces are
+// never served for its replacement.
+type traceCacheKey struct {
+	blockHash  common.Hash
+	traceTypes string
+}
+
+// ArbTraceAPI serves the arbtrace namespace. Requests concerning Nitro blocks are traced
+// locally, while those concerning pre-Nitro history are forwarded to the classic node.
+//
+// One instance serves every request concurrently, so it holds no per-request state: each request
+// replays on a state of its own, opened from the database, and the only state requests share is the
+// trace cache and the activity index, which are synchronized, and the entries in them, which are never
+// modified once added.
+type ArbTraceAPI struct {
+	*ArbTraceForwarderAPI
+	blockchain *core.BlockChain
+	chainDb    ethdb.Database
+	backend    *arbitrum.APIBackend
+	config     ArbTraceConfigFetcher
+	// recently replayed blocks' results, shared by every request that hits them, so they're read-only
+	traceCache *lru.Cache[traceCacheKey, []*traceResult]
+	// the accounts the frames of recently traced blocks involve, keyed by block hash, may be nil
+	activityIndex *lru.Cache[common.Hash, map[common.Address]struct{}]
+
+	// used to resolve parent chain submissions, may be nil
+	parentChain arbutil.L1Interface
+	// the transactions awaiting sequencing, which only sequencers have, may be nil
+	pendingTxs pendingTxSource
+	// used to locate the blocks of sequencer batches, may be nil
+	batches batchSource
+	// the number of arbtrace_subscribe subscriptions open
+	subscriptions atomic.Int64
+}
+
+func NewArbTraceAPI(
+	blockchain *core.BlockChain,
+	chainDb ethdb.Database,
+	backend *arbitrum.APIBackend,
+	parentChain arbutil.L1Interface,
+	pendingTxs pendingTxSource,
+	batches batchSource,
+	config ArbTraceConfigFetcher,
+	forwarder *ArbTraceForwarderAPI,
+) *ArbTraceAPI {
+	if parentChain != nil && reflect.ValueOf(parentChain).IsNil() {
+		parentChain = nil
+	}
+	if batches != nil && reflect.ValueOf(batches).IsNil() {
+		batches = nil
+	}
+	var traceCache *lru.Cache[traceCacheKey, []*traceResult]
+	if size := config().TraceCacheSize; size > 0 {
+		traceCache = lru.NewCache[traceCacheKey, []*traceResult](size)
+	}
+	var activityIndex *lru.Cache[common.Hash, map[common.Address]struct{}]
+	if size := config().ActivityIndexSize; size > 0 {
+		activityIndex = lru.NewCache[common.Hash, map[common.Address]struct{}](size)
+	}
+	return &ArbTraceAPI{
+		ArbTraceForwarderAPI: forwarder,
+		blockchain:           blockchain,
+		chainDb:              chainDb,
+		backend:              backend,
+		config:               config,
+		traceCache:           traceCache,
+		activityIndex:        activityIndex,
+		parentChain:          parentChain,
+		pendingTxs:           pendingTxs,
+		batches:              batches,
+	}
+}
+
+// blockByNumberOrHash resolves a block reference, returning nil if it refers to classic history.
+// An unspecified reference defaults to the latest block.
+func (api *ArbTraceAPI) blockByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*types.Block, error) {
+	number, isNumber := blockNrOrHash.Number()
+	_, isHash := blockNrOrHash.Hash()
+	if !isNumber && !isHash {
+		blockNrOrHash = rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	}
+	if isNumber && number >= 0 && !api.blockchain.Config().IsArbitrumNitro(big.NewInt(number.Int64())) {
+		return nil, nil
+	}
+	block, err := api.backend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		if isHash {
+			// the hash may belong to a classic block
+			return nil, nil
+		}
+		return nil, fmt.Errorf("block %v not found", blockNrOrHash.String())
+	}
+	if err := api.checkCanonical(blockNrOrHash, block.NumberU64()); err != nil {
+		return nil, err
+	}
+	if !api.blockchain.Config().IsArbitrumNitro(block.Number()) {
+		return nil, nil
+	}
+	return block, nil
+}
+
+// checkCanonical enforces EIP-1898's requireCanonical for a reference that resolved to the given block number.
+func (api *ArbTraceAPI) checkCanonical(blockNrOrHash rpc.BlockNumberOrHash, number uint64) error {
+	hash, isHash := blockNrOrHash.Hash()
+	if isHash && blockNrOrHash.RequireCanonical && api.blockchain.GetCanonicalHash(number) != hash {
+		return fmt.Errorf("block %v is not canonical", hash)
+	}
+	return nil
+}
+
+// checkStillCanonical verifies that a block traced by its number is still the canonical one at that number.
+func (api *ArbTraceAPI) checkStillCanonical(block *types.Block) error {
+	if api.blockchain.GetCanonicalHash(block.NumberU64()) != block.Hash() {
+		return fmt.Errorf("%w: block %v (%v)", ErrReorgDuringTrace, block.NumberU64(), block.Hash())
+	}
+	return nil
+}
+
+// transactionByHash looks up a transaction, returning nil if it isn't part of Nitro history.
+func (api *ArbTraceAPI) transactionByHash(txHash hexutil.Bytes) (*types.Transaction, *types.Block, uint64) {
+	if len(txHash) != common.HashLength {
+		return nil, nil, 0
+	}
+	tx, blockHash, blockNumber, index := rawdb.ReadTransaction(api.chainDb, common.BytesToHash(txHash))
+	if tx == nil {
+		return nil, nil, 0
+	}
+	block := api.blockchain.GetBlock(blockHash, blockNumber)
+	if block == nil {
+		return nil, nil, 0
+	}
+	return tx, block, index
+}
+
+// traceMessage applies msg to statedb with a tracer attached, collecting every requested output
+// from that one execution, so the outputs can't diverge from each other. The execution is aborted
+// if it outlasts the trace timeout.
+func (api *ArbTraceAPI) traceMessage(
+	ctx context.Context,
+	msg *core.Message,
+	header *types.Header,
+	blockCtx vm.BlockContext,
+	statedb *state.StateDB,
+	traceTypes traceTypeSet,
+	noBaseFee bool,
+) (*traceResult, error) {
+	return api.traceMessageWithChainConfig(ctx, msg, header, blockCtx, statedb, traceTypes, noBaseFee, nil)
+}
+
+// traceMessageWithChainConfig is like traceMessage, but executes msg under the given chain config
+// rather than the node's, unless it's nil.
+func (api *ArbTraceAPI) traceMessageWithChainConfig(
+	ctx context.Context,
+	msg *core.Message,
+	header *types.Header,
+	blockCtx vm.BlockContext,
+	statedb *state.StateDB,
+	traceTypes traceTypeSet,
+	noBaseFee bool,
+	chainConfig *params.ChainConfig,
+) (*traceResult, error) {
+	var pre *state.StateDB
+	if traceTypes[traceTypeStateDiff] {
+		pre = statedb.Copy()
+	}
+	var pricing *l2Pricing
+	if traceTypes[traceTypeL2Pricing] {
+		var err error
+		pricing, err = readL2Pricing(statedb, header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read L2 pricing: %w", err)
+		}
+	}
+	tracer := newParityTracer(traceTypes, api.config().MaxFrames)
+	tracer.vmMaxSteps = api.config().VmTraceMaxSteps
+	requestCtx := ctx
+	timeout := api.config().TraceTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	vmConfig := vm.Config{Tracer: tracer, NoBaseFee: noBaseFee}
+	var evm *vm.EVM
+	if chainConfig != nil {
+		evm = vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), statedb, chainConfig, vmConfig)
+	} else {
+		evm = api.backend.GetEVM(ctx, msg, statedb, header, &vmConfig, &blockCtx)
+	}
+	defer cancelOnDone(ctx, evm)()
+	// calls share a transaction context, so only logs past this point are the message's own
+	logsBefore := len(statedb.GetCurrentTxLogs())
+
+	gasPool := new(core.GasPool).AddGas(math.MaxUint64)
+	start := time.Now()
+	res, err := core.ApplyMessage(evm, msg, gasPool)
+	recordTraceTypes(traceTypes, time.Since(start))
+	if tracer.err != nil {
+		return nil, tracer.err
+	}
+	if evm.Cancelled() {
+		if requestCtx.Err() == nil {
+			return nil, fmt.Errorf("%w after %v", ErrTraceTimeout, timeout)
+		}
+		return nil, requestCtx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing failed: %w", err)
+	}
+	var refund *gasRefund
+	if traceTypes[traceTypeRefund] {
+		// finalising the state clears its refund counter
+		refund = tracer.gasRefund(evm, statedb, msg.GasLimit)
+	}
+	statedb.Finalise(evm.ChainConfig().IsEIP158(header.Number))
+
+	result := &traceResult{Output: res.ReturnData, execErr: res.Err, fees: tracer.fees}
+	if traceTypes[traceTypeTrace] {
+		result.Trace = tracer.frames()
+		annotateSubmitRetryable(msg, result.Trace)
+	}
+	if traceTypes[traceTypeStateDiff] {
+		result.StateDiff = tracer.stateDiff(pre, statedb)
+	}
+	if traceTypes[traceTypeVmTrace] {
+		result.VmTrace = tracer.vmTrace()
+	}
+	if traceTypes[traceTypeDestroyedContracts] {
+		destroyed := tracer.destroyedContracts()
+		result.DestroyedContracts = &destroyed
+	}
+	if traceTypes[traceTypeArbFees] {
+		result.ArbitrumFees = newArbitrumFees(evm, res)
+	}
+	if traceTypes[traceTypeGasProfile] {
+		result.GasProfile = tracer.gasProfile(msg.GasLimit, messagePosterGas(evm))
+	}
+	if traceTypes[traceTypeRetryable] {
+		logs := statedb.GetCurrentTxLogs()[logsBefore:]
+		frames := retryableFrames(msg, statedb, logs, res.Err, header.Time)
+		result.Retryable = &frames
+	}
+	if traceTypes[traceTypeAccessList] {
+		accessList := tracer.accessList()
+		result.AccessList = &accessList
+	}
+	if traceTypes[traceTypeOutbox] {
+		messages := outboxMessages(statedb, statedb.GetCurrentTxLogs()[logsBefore:])
+		result.Outbox = &messages
+	}
+	if traceTypes[traceTypeSummary] {
+		result.Summary = tracer.summary(res.UsedGas)
+	}
+	if traceTypes[traceTypeFeeInfo] {
+		result.FeeInfo = newFeeInfo(evm, msg, res)
+	}
+	if traceTypes[traceTypeAccessStats] {
+		result.AccessStats = tracer.accessStats()
+	}
+	if traceTypes[traceTypeTokenTransfers] {
+		transfers := tracer.tokenTransfers(statedb.GetCurrentTxLogs()[logsBefore:])
+		result.TokenTransfers = &transfers
+	}
+	result.Refund = refund
+	result.L2Pricing = pricing
+	return result, nil
+}
+
+// cancelOnDone aborts the EVM's execution once ctx is done, which the interpreter checks for
+// between operations. The returned function must be called once execution has finished.
+func cancelOnDone(ctx context.Context, evm *vm.EVM) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			evm.Cancel()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// messagePosterGas reads the gas ArbOS charged a message for posting it to the parent chain.
+func messagePosterGas(evm *vm.EVM) uint64 {
+	if txProcessor, ok := evm.ProcessingHook.(*arbos.TxProcessor); ok {
+		return txProcessor.NonrefundableGas()
+	}
+	return 0
+}
+
+// newArbitrumFees reads the fees ArbOS charged for a message from its transaction processor.
+func newArbitrumFees(evm *vm.EVM, res *core.ExecutionResult) *arbitrumFees {
+	baseFee := evm.Context.BaseFee
+	if baseFee == nil {
+		baseFee = new(big.Int)
+	}
+	posterGas := messagePosterGas(evm)
+	posterFee := new(big.Int)
+	if txProcessor, ok := evm.ProcessingHook.(*arbos.TxProcessor); ok {
+		posterFee = txProcessor.PosterFee
+	}
+	computeGas := arbmath.SaturatingUSub(res.UsedGas, posterGas)
+	return &arbitrumFees{
+		BaseFee:      (*hexutil.Big)(new(big.Int).Set(baseFee)),
+		GasUsed:      hexutil.Uint64(res.UsedGas),
+		GasUsedForL1: hexutil.Uint64(posterGas),
+		GasUsedForL2: hexutil.Uint64(computeGas),
+		L1Fee:        (*hexutil.Big)(new(big.Int).Set(posterFee)),
+		L2Fee:        (*hexutil.Big)(arbmath.BigMulByUint(baseFee, computeGas)),
+	}
+}
+
+func (api *ArbTraceAPI) traceCall(
+	ctx context.Context,
+	callArgs callTxArgs,
+	traceTypes traceTypeSet,
+	header *types.Header,
+	statedb *state.StateDB,
+) (*traceResult, error) {
+	gasCap := api.traceGasCap()
+	args, err := callArgs.transactionArgs(header, gasCap)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := args.ToMessage(gasCap, header, statedb, core.MessageEthcallMode)
+	if err != nil {
+		return nil, err
+	}
+	if callArgs.defaultsGasPrice() {
+		fundGas(statedb, msg)
+	}
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	result, err := api.traceMessage(ctx, msg, header, blockCtx, statedb, traceTypes, true)
+	if err != nil {
+		return nil, err
+	}
+	effectiveGas := hexutil.Uint64(msg.GasLimit)
+	result.EffectiveGas = &effectiveGas
+	result.GasCapped = callArgs.Gas != nil && gasCap != 0 && uint64(*callArgs.Gas) > gasCap
+	return result, nil
+}
+
+// fundGas credits a call's sender with whatever it lacks to pay for its gas at the gas price it defaulted to,
+// so that calls from unfunded accounts, such as the default zero address, still execute as Parity's trace_call
+// executes them. The credit is made before tracing, so state diffs start from the credited balance, and it
+// remains in the state later calls of an arbtrace_callMany see.
+func fundGas(statedb *state.StateDB, msg *core.Message) {
+	fee := new(uint256.Int).Mul(uint256.NewInt(msg.GasLimit), uint256.MustFromBig(msg.GasPrice))
+	if balance := statedb.GetBalance(msg.From); balance.Cmp(fee) < 0 {
+		statedb.AddBalance(msg.From, new(uint256.Int).Sub(fee, balance))
+	}
+}
+
+// traceGasCap returns the most gas a traced call may use, the lower of the trace gas cap and the rpc gas cap.
+// Calls without a gas limit are given this much gas, and calls asking for more are clamped to it.
+func (api *ArbTraceAPI) traceGasCap() uint64 {
+	gasCap := api.backend.RPCGasCap()
+	if traceGasCap := api.config().TraceGasCap; traceGasCap != 0 && (gasCap == 0 || traceGasCap < gasCap) {
+		gasCap = traceGasCap
+	}
+	return gasCap
+}
+
+// Call traces a call executed on top of the given block's state, optionally with parts of the block's
+// context or state overridden. On a sequencer, the pending block is the latest block followed by the
+// transactions awaiting sequencing; see applyPending for how closely it matches the block to come.
+func (api *ArbTraceAPI) Call(
+	ctx context.Context,
+	callArgs callTxArgs,
+	traceTypes []string,
+	blockNum TraceBlockRef,
+	blockOverrides *blockOverrides,
+	stateOverrides stateOverride,
+) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_call", "block", blockNum.String())
+	defer done()
+	if err := validateTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	if err := api.config().checkTraceAllowed(callArgs.To); err != nil {
+		return nil, err
+	}
+	if err := api.config().checkImpersonation(callArgs.From); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		if blockOverrides != nil || stateOverrides != nil {
+			return nil, errors.New("overrides are not supported for classic history")
+		}
+		return api.forward(ctx, "arbtrace_call", callArgs, traceTypes, blockNum)
+	}
+	statedb, header, err := api.backend.StateAndHeaderByNumberOrHash(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+	if err != nil {
+		return nil, err
+	}
+	if isPending(blockNum.BlockNumberOrHash) {
+		header = pendingHeader(header)
+		if err := api.applyPending(ctx, header, statedb); err != nil {
+			return nil, err
+		}
+	}
+	header, err = blockOverrides.apply(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := stateOverrides.apply(statedb); err != nil {
+		return nil, err
+	}
+	return api.traceCall(ctx, callArgs, newTraceTypeSet(traceTypes), header, statedb)
+}
+
+// CallMany traces a sequence of calls. By default each call executes on top of the state
+// left by the previous ones, as Parity's trace_callMany does, so multi-step interactions can
+// be simulated. With the independent option set, every call instead sees only the block's state.
+// Failed calls are traced like any other unless failOnRevert is set, in which case they abort the request.
+// The intermediateRoots option reports the state root after each call, so sequential execution can be
+// checked for determinism. The roots are of the in-memory state the calls build, which is never persisted.
+func (api *ArbTraceAPI) CallMany(ctx context.Context, calls callTraceRequests, blockNum TraceBlockRef, options *callManyOptions) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_callMany", "block", blockNum.String(), "calls", len(calls))
+	defer done()
+	config := api.config()
+	if config.CallManyMaxCalls > 0 && len(calls) > config.CallManyMaxCalls {
+		return nil, fmt.Errorf("%w: %d exceeds the limit of %d", ErrCallManyTooManyCalls, len(calls), config.CallManyMaxCalls)
+	}
+	for i, call := range calls {
+		if call == nil {
+			return nil, fmt.Errorf("call %d is missing", i)
+		}
+		if err := validateTraceTypes(call.traceTypes); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		if err := config.checkTraceAllowed(call.callArgs.To); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		if err := config.checkImpersonation(call.callArgs.From); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		if options != nil {
+			return api.forward(ctx, "arbtrace_callMany", calls, blockNum, options)
+		}
+		return api.forward(ctx, "arbtrace_callMany", calls, blockNum)
+	}
+	if options == nil {
+		options = &callManyOptions{}
+	}
+	statedb, header, err := api.backend.StateAndHeaderByNumberOrHash(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*traceResult, 0, len(calls))
+	resultSize := 0
+	for i, call := range calls {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		callState := statedb
+		if options.Independent {
+			callState = statedb.Copy()
+		}
+		result, err := api.traceCall(ctx, call.callArgs, newTraceTypeSet(call.traceTypes), header, callState)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		if options.FailOnRevert && result.execErr != nil {
+			return nil, fmt.Errorf("call %d failed: %w", i, result.execErr)
+		}
+		if options.IntermediateRoots {
+			root := callState.IntermediateRoot(api.blockchain.Config().IsEIP158(header.Number))
+			result.StateRoot = &root
+		}
+		if config.CallManyMaxResultSize > 0 {
+			encoded, err := json.Marshal(result)
+			if err != nil {
+				return nil, fmt.Errorf("call %d: %w", i, err)
+			}
+			resultSize += len(encoded)
+			if resultSize > config.CallManyMaxResultSize {
+				return nil, fmt.Errorf("%w of %d bytes at call %d", ErrCallManyResultTooLarge, config.CallManyMaxResultSize, i)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// RawTransaction traces a signed transaction as if it were executed on top of the given block's state.
+func (api *ArbTraceAPI) RawTransaction(ctx context.Context, rawTx hexutil.Bytes, traceTypes []string, blockNum TraceBlockRef) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_rawTransaction", "block", blockNum.String())
+	defer done()
+	if err := validateTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return api.forward(ctx, "arbtrace_rawTransaction", rawTx, traceTypes, blockNum)
+	}
+	statedb, header, err := api.backend.StateAndHeaderByNumberOrHash(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+	if err != nil {
+		return nil, err
+	}
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	statedb.SetTxContext(tx.Hash(), 0)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	return api.traceMessage(ctx, msg, header, blockCtx, statedb, newTraceTypeSet(traceTypes), false)
+}
+
+// executionHeader reconstructs the header a block's transactions executed with. Blocks are built
+// on a header carrying the parent's Arbitrum info, including its ArbOS version, which is only
+// replaced by the block's own once it's finalized. So transactions in the block an ArbOS upgrade
+// takes effect in still ran with the previous version's rules, and must be replayed the same way.
+func executionHeader(header, parent *types.Header) *types.Header {
+	execHeader := types.CopyHeader(header)
+	execHeader.Extra = common.CopyBytes(parent.Extra)
+	execHeader.MixDigest = parent.MixDigest
+	return execHeader
+}
+
+// stateAtParent returns the state a block executed on, along with the header its transactions
+// executed with. The returned function releases the state once it's no longer needed.
+func (api *ArbTraceAPI) stateAtParent(ctx context.Context, block *types.Block) (*state.StateDB, *types.Header, func(), error) {
+	if block.NumberU64() <= api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum {
+		return nil, nil, nil, errors.New("genesis is not traceable")
+	}
+	parent := api.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, nil, nil, fmt.Errorf("parent %v not found", block.ParentHash())
+	}
+	statedb, release, err := api.backend.StateAtBlock(ctx, parent, arbTraceReexec, nil, true, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return statedb, executionHeader(block.Header(), parent.Header()), func() { release() }, nil
+}
+
+// stateAtTransaction returns the state a block's transaction at index executed on, having re-executed
+// the transactions before it untraced, along with the context and message to execute it with.
+// The returned function releases the state once it's no longer needed.
+func (api *ArbTraceAPI) stateAtTransaction(ctx context.Context, block *types.Block, index uint64) (*state.StateDB, *types.Header, vm.BlockContext, *core.Message, func(), error) {
+	statedb, header, release, err := api.stateAtParent(ctx, block)
+	if err != nil {
+		return nil, nil, vm.BlockContext{}, nil, nil, err
+	}
+	fail := func(err error) (*state.StateDB, *types.Header, vm.BlockContext, *core.Message, func(), error) {
+		release()
+		return nil, nil, vm.BlockContext{}, nil, nil, err
+	}
+	txs := block.Transactions()
+	if index >= uint64(len(txs)) {
+		return fail(fmt.Errorf("block %v has no transaction %v", block.Hash(), index))
+	}
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	for i, prior := range txs[:index] {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		msg, err := core.TransactionToMessage(prior, signer, header.BaseFee)
+		if err != nil {
+			return fail(err)
+		}
+		statedb.SetTxContext(prior.Hash(), i)
+		if err := api.applyMessage(ctx, msg, header, blockCtx, statedb, nil); err != nil {
+			return fail(fmt.Errorf("transaction %v: %w", prior.Hash(), err))
+		}
+	}
+	msg, err := core.TransactionToMessage(txs[index], signer, header.BaseFee)
+	if err != nil {
+		return fail(err)
+	}
+	statedb.SetTxContext(txs[index].Hash(), int(index))
+	return statedb, header, blockCtx, msg, release, nil
+}
+
+// replayBlock traces every transaction in a block, reusing recently computed traces.
+// The results may be shared with other requests, so callers mustn't modify them.
+func (api *ArbTraceAPI) replayBlock(ctx context.Context, block *types.Block, traceTypes traceTypeSet) ([]*traceResult, error) {
+	key := traceCacheKey{block.Hash(), traceTypes.key()}
+	if api.traceCache != nil {
+		if results, ok := api.traceCache.Get(key); ok {
+			traceCacheHitCounter.Inc(1)
+			traceResultsCachedCounter.Inc(int64(len(results)))
+			return results, nil
+		}
+		traceCacheMissCounter.Inc(1)
+	}
+	results, err := api.replayTransactions(ctx, block, traceTypes, 0, len(block.Transactions())-1)
+	if err != nil {
+		return nil, err
+	}
+	traceResultsComputedCounter.Inc(int64(len(results)))
+	if api.traceCache != nil {
+		api.traceCache.Add(key, results)
+	}
+	return results, nil
+}
+
+// replayJob is a transaction awaiting a replay worker, along with the state it executes on top of.
+type replayJob struct {
+	index   int
+	txHash  common.Hash
+	msg     *core.Message
+	statedb *state.StateDB
+}
+
+// replayTransactions re-executes a block's transactions up to and including the one at index last,
+// tracing those from index first onward.
+func (api *ArbTraceAPI) replayTransactions(ctx context.Context, block *types.Block, traceTypes traceTypeSet, first, last int) ([]*traceResult, error) {
+	results := []*traceResult{}
+	err := api.replayEach(ctx, block, traceTypes, first, last, api.config().ReplayWorkers, func(_ int, result *traceResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// replayEach is like replayTransactions, but hands each result to emit in order rather than collecting them.
+// With multiple workers, the block is executed once untraced while each traced transaction is handed
+// to a worker with a copy of its pre-state, and the results are emitted once all have been traced.
+func (api *ArbTraceAPI) replayEach(
+	ctx context.Context,
+	block *types.Block,
+	traceTypes traceTypeSet,
+	first, last, workers int,
+	emit func(index int, result *traceResult) error,
+) error {
+	statedb, header, release, err := api.stateAtParent(ctx, block)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	txs := block.Transactions()
+	if last >= len(txs) {
+		last = len(txs) - 1
+	}
+	if last < first {
+		return nil
+	}
+	results := make([]*traceResult, last-first+1)
+	workers = arbmath.MinInt(workers, len(results))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan replayJob)
+	errs := make([]error, len(results))
+	var wg sync.WaitGroup
+	for w := 0; workers > 1 && w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the block context's hash lookups are cached, so each worker needs its own
+			blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+			for job := range jobs {
+				result, err := api.traceMessage(ctx, job.msg, header, blockCtx, job.statedb, traceTypes, false)
+				if err != nil {
+					errs[job.index-first] = fmt.Errorf("transaction %v: %w", job.txHash, err)
+					cancel()
+					continue
+				}
+				results[job.index-first] = result
+			}
+		}()
+	}
+
+	err = func() error {
+		for i, tx := range txs[:last+1] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+			if err != nil {
+				return err
+			}
+			statedb.SetTxContext(tx.Hash(), i)
+			if i >= first && workers <= 1 {
+				result, err := api.traceMessage(ctx, msg, header, blockCtx, statedb, traceTypes, false)
+				if err != nil {
+					return fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+				}
+				if err := emit(i, result); err != nil {
+					return err
+				}
+				continue
+			}
+			if i >= first {
+				select {
+				case jobs <- replayJob{i, tx.Hash(), msg, statedb.Copy()}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				if i == last {
+					break
+				}
+			}
+			if err := api.applyMessage(ctx, msg, header, blockCtx, statedb, nil); err != nil {
+				return fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+			}
+		}
+		return nil
+	}()
+	close(jobs)
+	wg.Wait()
+	// a worker's failure is more informative than the cancellation it caused
+	for _, workerErr := range errs {
+		if workerErr != nil {
+			return workerErr
+		}
+	}
+	if err != nil || workers <= 1 {
+		return err
+	}
+	for i, result := range results {
+		if err := emit(first+i, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMessage applies msg to statedb, informing the tracer if one is given.
+func (api *ArbTraceAPI) applyMessage(
+	ctx context.Context,
+	msg *core.Message,
+	header *types.Header,
+	blockCtx vm.BlockContext,
+	statedb *state.StateDB,
+	tracer vm.EVMLogger,
+) error {
+	evm := api.backend.GetEVM(ctx, msg, statedb, header, &vm.Config{Tracer: tracer}, &blockCtx)
+	defer cancelOnDone(ctx, evm)()
+	gasPool := new(core.GasPool).AddGas(math.MaxUint64)
+	_, err := core.ApplyMessage(evm, msg, gasPool)
+	if evm.Cancelled() {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	statedb.Finalise(evm.ChainConfig().IsEIP158(header.Number))
+	return nil
+}
+
+// BlockStateDiff returns the net change a block made to the state, including that made by ArbOS's
+// internal transactions. Changes to ArbOS's own storage are listed by subspace and offset,
+// as ArbOS keys its storage by hashes the tracer doesn't see.
+func (api *ArbTraceAPI) BlockStateDiff(ctx context.Context, blockNum TraceBlockRef) (*blockStateDiff, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_blockStateDiff", "block", blockNum.String())
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_blockStateDiff"); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("arbtrace_blockStateDiff doesn't support classic history")
+	}
+	statedb, header, release, err := api.stateAtParent(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	pre := statedb.Copy()
+	// the tracer accumulates the accounts and slots touched across every transaction it sees
+	tracer := newParityTracer(newTraceTypeSet([]string{traceTypeStateDiff}), api.config().MaxFrames)
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	for i, tx := range block.Transactions() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			return nil, err
+		}
+		statedb.SetTxContext(tx.Hash(), i)
+		err = api.applyMessage(ctx, msg, header, blockCtx, statedb, tracer)
+		if tracer.err != nil {
+			err = tracer.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+		}
+	}
+
+	preArbos, err := arbosState.OpenSystemArbosState(pre, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	postArbos, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return &blockStateDiff{
+		BlockHash:      block.Hash(),
+		BlockNumber:    hexutil.Uint64(block.NumberU64()),
+		StateDiff:      tracer.stateDiff(pre, statedb),
+		ArbOSStateDiff: newArbOSStateDiff(arbosState.Diff(preArbos, postArbos)),
+	}, nil
+}
+
+// ReplayBlockTransactions traces every transaction in a block.
+func (api *ArbTraceAPI) ReplayBlockTransactions(ctx context.Context, blockNum TraceBlockRef, traceTypes []string) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_replayBlockTransactions", "block", blockNum.String())
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_replayBlockTransactions"); err != nil {
+		return nil, err
+	}
+	if err := validateTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return api.forward(ctx, "arbtrace_replayBlockTransactions", blockNum, traceTypes)
+	}
+	results, err := api.replayBlock(ctx, block, newTraceTypeSet(traceTypes))
+	if err != nil {
+		return nil, err
+	}
+	// a block requested by its hash is traced as asked for, whether or not it remains canonical
+	if _, isNumber := blockNum.Number(); isNumber || blockNum.RequireCanonical {
+		if err := api.checkStillCanonical(block); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// blockFrames traces every transaction in a block, annotating each frame with its location.
+// This includes the internal transactions ArbOS starts each block with.
+func (api *ArbTraceAPI) blockFrames(ctx context.Context, block *types.Block) ([]traceFrame, error) {
+	frames, _, err := api.blockFramesAndFees(ctx, block)
+	return frames, err
+}
+
+func (api *ArbTraceAPI) blockFramesAndFees(ctx context.Context, block *types.Block) ([]traceFrame, []feeTransfer, error) {
+	if block.NumberU64() <= api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum {
+		// the genesis block has no transactions to trace
+		return []traceFrame{}, nil, nil
+	}
+	results, err := api.replayBlock(ctx, block, newTraceTypeSet([]string{traceTypeTrace}))
+	if err != nil {
+		return nil, nil, err
+	}
+	frames := []traceFrame{}
+	fees := []feeTransfer{}
+	for i, result := range results {
+		fees = append(fees, result.fees...)
+		frames = append(frames, locateFrames(result.Trace, block, block.Transactions()[i].Hash(), uint64(i))...)
+	}
+	api.indexActivity(block, frames)
+	return frames, fees, nil
+}
+
+// BlockStream traces a block like arbtrace_block, notifying the subscriber of each frame as its
+// transaction is traced rather than buffering the whole response. A null notification follows
+// the block's last frame.
+func (api *ArbTraceAPI) BlockStream(ctx context.Context, blockNum TraceBlockRef) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	if err := api.config().checkUnrestricted("arbtrace_blockStream"); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("arbtrace_blockStream doesn't support classic history")
+	}
+	rpcSub := notifier.CreateSubscription()
+	go func() {
+		// the request's context ends once the subscription is created, so trace until the subscriber leaves
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-rpcSub.Err():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if block.NumberU64() > api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum {
+			traceTypes := newTraceTypeSet([]string{traceTypeTrace})
+			txs := block.Transactions()
+			err := api.replayEach(ctx, block, traceTypes, 0, len(txs)-1, 1, func(i int, result *traceResult) error {
+				for _, frame := range locateFrames(result.Trace, block, txs[i].Hash(), uint64(i)) {
+					if err := notifier.Notify(rpcSub.ID, frame); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("arbtrace_blockStream failed", "block", block.NumberU64(), "err", err)
+				}
+				return
+			}
+		}
+		if err := notifier.Notify(rpcSub.ID, nil); err != nil {
+			log.Debug("arbtrace_blockStream subscriber left", "block", block.NumberU64(), "err", err)
+		}
+	}()
+	return rpcSub, nil
+}
+
+// locateFrames returns copies of a transaction's frames annotated with the block and position it executed at.
+func locateFrames(frames []traceFrame, block *types.Block, txHash common.Hash, position uint64) []traceFrame {
+	blockHash := block.Hash()
+	blockNumber := block.NumberU64()
+	located := make([]traceFrame, len(frames))
+	copy(located, frames)
+	for i := range located {
+		located[i].BlockHash = &blockHash
+		located[i].BlockNumber = &blockNumber
+		located[i].TransactionHash = &txHash
+		located[i].TransactionPosition = &position
+	}
+	return located
+}
+
+// rewardFrames summarizes the fees distributed in a block as one arbReward frame per recipient.
+func rewardFrames(block *types.Block, fees []feeTransfer) []traceFrame {
+	blockHash := block.Hash()
+	blockNumber := block.NumberU64()
+	frames := []traceFrame{}
+	type rewardKey struct {
+		recipient  common.Address
+		rewardType string
+	}
+	totals := make(map[rewardKey]*big.Int)
+	for _, fee := range fees {
+		key := rewardKey{fee.recipient, fee.rewardType}
+		if total, ok := totals[key]; ok {
+			total.Add(total, fee.value)
+			continue
+		}
+		total := new(big.Int).Set(fee.value)
+		totals[key] = total
+		recipient := fee.recipient
+		frames = append(frames, traceFrame{
+			Action: traceAction{
+				Author:     &recipient,
+				RewardType: fee.rewardType,
+				Value:      (*hexutil.Big)(total),
+			},
+			BlockHash:    &blockHash,
+			BlockNumber:  &blockNumber,
+			TraceAddress: []int{},
+			Type:         frameTypeArbReward,
+		})
+	}
+	return frames
+}
+
+// Block returns the frames of every transaction in a block, optionally followed by
+// arbReward frames describing where ArbOS distributed the block's fees.
+//
+// Frames are ordered by ascending transaction position, and within a transaction in depth-first
+// pre-order, so each frame's traceAddress follows its parent's and precedes its later siblings'.
+// ArbOS's internal transaction is always the block's first, so its arbInternal frame leads.
+// The reward frames that follow have no position, and are ordered by when each recipient was
+// first paid that type of fee.
+func (api *ArbTraceAPI) Block(ctx context.Context, blockNum TraceBlockRef, options *blockTraceOptions) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_block", "block", blockNum.String())
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_block"); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		if options != nil {
+			return api.forward(ctx, "arbtrace_block", blockNum, options)
+		}
+		return api.forward(ctx, "arbtrace_block", blockNum)
+	}
+	frames, fees, err := api.blockFramesAndFees(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+	if options != nil && options.Rewards {
+		frames = append(frames, rewardFrames(block, fees)...)
+	}
+	return frames, nil
+}
+
+// BlockRange traces a contiguous range of blocks, grouping the results by block.
+// The range is bounded like arbtrace_filter's, and fails with ErrReorgDuringTrace if any block in it
+// is reorged while tracing, so the blocks returned are always a consistent snapshot of one chain.
+// Requests carrying a progress token, which may be empty, mark each block with the token resuming after it,
+// letting an interrupted backfill pass the last one it processed to skip the blocks it already has.
+func (api *ArbTraceAPI) BlockRange(ctx context.Context, from, to TraceBlockRef, traceTypes []string, progress *string) ([]*blockTraces, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_blockRange", "from", from.String(), "to", to.String())
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_blockRange"); err != nil {
+		return nil, err
+	}
+	if err := validateTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	fromBlock, toBlock, err := api.blockRange(ctx, &from.BlockNumberOrHash, &to.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if fromBlock < api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum {
+		return nil, errors.New("arbtrace_blockRange doesn't support classic history")
+	}
+	if progress == nil {
+		return api.traceBlockRange(ctx, fromBlock, toBlock, newTraceTypeSet(traceTypes))
+	}
+	if *progress != "" {
+		fromBlock, err = api.resumeFrom(fromBlock, *progress)
+		if err != nil {
+			return nil, err
+		}
+		if fromBlock > toBlock {
+			return []*blockTraces{}, nil
+		}
+	}
+	blocks, err := api.traceBlockRange(ctx, fromBlock, toBlock, newTraceTypeSet(traceTypes))
+	if err != nil {
+		return nil, err
+	}
+	if err := setProgressTokens(blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// traceBlockRange traces the Nitro blocks from fromBlock to toBlock inclusive, as arbtrace_blockRange does.
+func (api *ArbTraceAPI) traceBlockRange(ctx context.Context, fromBlock, toBlock uint64, requested traceTypeSet) ([]*blockTraces, error) {
+	wantFrames := requested[traceTypeTrace]
+	wantResults := len(requested) > 1 || (len(requested) == 1 && !wantFrames)
+	ranges := make([]*blockTraces, 0, toBlock-fromBlock+1)
+	traced := make([]*types.Block, 0, toBlock-fromBlock+1)
+	checkTraced := func() error {
+		for _, block := range traced {
+			if err := api.checkStillCanonical(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for number := fromBlock; number <= toBlock; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := api.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			// the chain may have been reorged to a shorter one since the range was resolved
+			if err := checkTraced(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("block %v not found", number)
+		}
+		traces := &blockTraces{
+			BlockHash:   block.Hash(),
+			BlockNumber: hexutil.Uint64(number),
+			Traces:      []traceFrame{},
+		}
+		if wantResults {
+			traces.Results = &[]*traceResult{}
+		}
+		if number > api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum {
+			results, err := api.replayBlock(ctx, block, requested)
+			if err != nil {
+				return nil, err
+			}
+			for i, result := range results {
+				if wantFrames {
+					traces.Traces = append(traces.Traces, locateFrames(result.Trace, block, block.Transactions()[i].Hash(), uint64(i))...)
+				}
+				if wantResults {
+					// the frames are listed with the block, so leave them out of the shared result
+					listed := *result
+					if wantFrames {
+						listed.Trace = nil
+					}
+					*traces.Results = append(*traces.Results, &listed)
+				}
+			}
+		}
+		ranges = append(ranges, traces)
+		traced = append(traced, block)
+		// stop as soon as this block is dropped rather than tracing the rest of an outdated range
+		if err := api.checkStillCanonical(block); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTraced(); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+func (api *ArbTraceAPI) replayTransaction(ctx context.Context, tx *types.Transaction, block *types.Block, index uint64, traceTypes traceTypeSet) (*traceResult, error) {
+	results, err := api.replayTransactions(ctx, block, traceTypes, int(index), int(index))
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("transaction %v not found in block %v", tx.Hash(), block.Hash())
+	}
+	return results[0], nil
+}
+
+// ReplayTransaction traces a single transaction as it was executed in its block.
+// However many outputs are requested, the transaction is executed once to produce them all.
+// Given a chain config override, the transaction is instead executed under the forks it schedules,
+// showing how it would have behaved under those rules. The transactions before it still execute
+// under the chain's own rules, and the node's chain config is never changed.
+func (api *ArbTraceAPI) ReplayTransaction(ctx context.Context, txHash hexutil.Bytes, traceTypes []string, chainConfig *chainConfigOverride) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_replayTransaction", "tx", txHash)
+	defer done()
+	if err := validateTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	var overridden *params.ChainConfig
+	if chainConfig != nil {
+		var err error
+		overridden, err = chainConfig.apply(api.blockchain.Config())
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx, block, index := api.transactionByHash(txHash)
+	if tx == nil {
+		if overridden != nil {
+			return nil, errors.New("chain config overrides are not supported for classic history")
+		}
+		return api.forward(ctx, "arbtrace_replayTransaction", txHash, traceTypes)
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	if overridden == nil {
+		return api.replayTransaction(ctx, tx, block, index, newTraceTypeSet(traceTypes))
+	}
+	statedb, header, blockCtx, msg, release, err := api.stateAtTransaction(ctx, block, index)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	result, err := api.traceMessageWithChainConfig(ctx, msg, header, blockCtx, statedb, newTraceTypeSet(traceTypes), false, overridden)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+	}
+	return result, nil
+}
+
+// Transaction returns the frames of a transaction, located within its block. A transaction that failed
+// still has the frames of everything it executed, up to and including the frame that failed, which
+// carries the error as do the frames it reverted along with it. Passing the "summary" trace type instead
+// returns only a rollup of the frames, which is all the method's trace types offer.
+func (api *ArbTraceAPI) Transaction(ctx context.Context, txHash hexutil.Bytes, traceTypes *[]string) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_transaction", "tx", txHash)
+	defer done()
+	summarize := false
+	if traceTypes != nil {
+		for _, traceType := range *traceTypes {
+			if traceType != traceTypeSummary {
+				return nil, fmt.Errorf("%w %q, arbtrace_transaction only supports %q", ErrUnknownTraceType, traceType, traceTypeSummary)
+			}
+			summarize = true
+		}
+	}
+	tx, block, index := api.transactionByHash(txHash)
+	if tx == nil {
+		if summarize {
+			return nil, errors.New("arbtrace_transaction doesn't support summaries of classic history")
+		}
+		return api.forward(ctx, "arbtrace_transaction", txHash)
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	if summarize {
+		result, err := api.replayTransaction(ctx, tx, block, index, newTraceTypeSet([]string{traceTypeSummary}))
+		if err != nil {
+			return nil, err
+		}
+		return result.Summary, nil
+	}
+	result, err := api.replayTransaction(ctx, tx, block, index, newTraceTypeSet([]string{traceTypeTrace}))
+	if err != nil {
+		return nil, err
+	}
+	return locateFrames(result.Trace, block, tx.Hash(), index), nil
+}
+
+// Get returns the frame of a transaction at the given trace address. Given a maxDepth, it instead
+// returns the subtree rooted there, listing the frame and then its descendants up to maxDepth levels
+// below it depth-first, so clients can load deep transactions level by level. Frames whose children
+// were pruned still count them in their subtraces.
+func (api *ArbTraceAPI) Get(ctx context.Context, txHash hexutil.Bytes, path []hexutil.Uint64, maxDepth *hexutil.Uint64) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_get", "tx", txHash)
+	defer done()
+	if len(path) > int(params.CallCreateDepth) {
+		return nil, fmt.Errorf("path of length %v exceeds the maximum call depth of %v", len(path), params.CallCreateDepth)
+	}
+	tx, block, index := api.transactionByHash(txHash)
+	if tx == nil {
+		if maxDepth != nil {
+			return nil, errors.New("arbtrace_get doesn't support maxDepth for classic history")
+		}
+		return api.forward(ctx, "arbtrace_get", txHash, path)
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	result, err := api.replayTransaction(ctx, tx, block, index, newTraceTypeSet([]string{traceTypeTrace}))
+	if err != nil {
+		return nil, err
+	}
+	frames := locateFrames(result.Trace, block, tx.Hash(), index)
+	if len(frames) == 0 {
+		return nil, errors.New("transaction has no frames")
+	}
+	// frames are listed depth-first, so each frame's subtraces follow it
+	current := 0
+	for depth, step := range path {
+		if uint64(step) >= uint64(frames[current].Subtraces) {
+			return nil, fmt.Errorf("path index %v out of range at depth %v, which has %v subtraces", step, depth, frames[current].Subtraces)
+		}
+		address := frames[current].TraceAddress
+		next := -1
+		for i := current + 1; i < len(frames); i++ {
+			candidate := frames[i].TraceAddress
+			if len(candidate) == depth+1 && compareTraceAddress(candidate[:depth], address) == 0 && uint64(candidate[depth]) == uint64(step) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("frame at depth %v not found", depth)
+		}
+		current = next
+	}
+	if maxDepth == nil {
+		return &frames[current], nil
+	}
+	root := frames[current].TraceAddress
+	subtree := []traceFrame{frames[current]}
+	for _, frame := range frames[current+1:] {
+		address := frame.TraceAddress
+		if len(address) <= len(root) || compareTraceAddress(address[:len(root)], root) != 0 {
+			// the subtree's frames are contiguous, so the first frame outside it ends it
+			break
+		}
+		if uint64(len(address)-len(root)) <= uint64(*maxDepth) {
+			subtree = append(subtree, frame)
+		}
+	}
+	return subtree, nil
+}
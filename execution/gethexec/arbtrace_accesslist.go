@@ -0,0 +1,117 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// accessSet records the accounts and storage slots a transaction accessed, from which an
+// EIP-2930 access list can be derived. As with geth's access list tracer, the sender, the
+// recipient, and the precompiles active in the traced block are always warm and so are only
+// listed for their slots.
+type accessSet struct {
+	accessed map[common.Address]map[common.Hash]struct{}
+	excluded map[common.Address]struct{}
+}
+
+func newAccessSet(from, to common.Address, precompiles []common.Address) *accessSet {
+	excluded := map[common.Address]struct{}{from: {}, to: {}}
+	for _, addr := range precompiles {
+		excluded[addr] = struct{}{}
+	}
+	return &accessSet{
+		accessed: make(map[common.Address]map[common.Hash]struct{}),
+		excluded: excluded,
+	}
+}
+
+func (set *accessSet) addAddress(addr common.Address) {
+	if _, ok := set.excluded[addr]; ok {
+		return
+	}
+	if _, ok := set.accessed[addr]; !ok {
+		set.accessed[addr] = make(map[common.Hash]struct{})
+	}
+}
+
+func (set *accessSet) addSlot(addr common.Address, slot common.Hash) {
+	slots, ok := set.accessed[addr]
+	if !ok {
+		slots = make(map[common.Hash]struct{})
+		set.accessed[addr] = slots
+	}
+	slots[slot] = struct{}{}
+}
+
+// accessList returns the accesses as an access list, sorted by address and then slot.
+func (set *accessSet) accessList() types.AccessList {
+	list := make(types.AccessList, 0, len(set.accessed))
+	for addr, slots := range set.accessed {
+		keys := make([]common.Hash, 0, len(slots))
+		for slot := range slots {
+			keys = append(keys, slot)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+		list = append(list, types.AccessTuple{Address: addr, StorageKeys: keys})
+	}
+	sort.Slice(list, func(i, j int) bool { return bytes.Compare(list[i].Address[:], list[j].Address[:]) < 0 })
+	return list
+}
+
+// recordAccess notes the account or slot an EVM operation accesses.
+func (set *accessSet) recordAccess(op vm.OpCode, cost uint64, scope *vm.ScopeContext) {
+	stack := scope.Stack.Data()
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		// ArbOS reports its own storage accesses as free operations, which no access list can warm
+		if cost == 0 || len(stack) < 1 {
+			return
+		}
+		set.addSlot(scope.Contract.Address(), common.Hash(stack[len(stack)-1].Bytes32()))
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.EXTCODEHASH, vm.SELFDESTRUCT:
+		if len(stack) < 1 {
+			return
+		}
+		set.addAddress(common.Address(stack[len(stack)-1].Bytes20()))
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if len(stack) < 2 {
+			return
+		}
+		set.addAddress(common.Address(stack[len(stack)-2].Bytes20()))
+	}
+}
+
+// recordHostioAccess notes the account or slot a Stylus program's hostio accesses.
+// The calls programs make are recorded as they enter their frames.
+func (set *accessSet) recordHostioAccess(program common.Address, name string, args []byte) {
+	switch name {
+	case "storage_load_bytes32", "storage_cache_bytes32":
+		if len(args) >= common.HashLength {
+			set.addSlot(program, common.BytesToHash(args[:common.HashLength]))
+		}
+	case "account_balance", "account_code", "account_code_size", "account_codehash":
+		if len(args) >= common.AddressLength {
+			set.addAddress(common.BytesToAddress(args[:common.AddressLength]))
+		}
+	}
+}
+
+// storageAddress returns the account whose storage a frame executes against,
+// which for delegatecalls and callcodes is the caller's.
+func (c *parityCall) storageAddress() *common.Address {
+	if c.frameType == frameTypeCreate {
+		return &c.created
+	}
+	switch c.action.CallType {
+	case "delegatecall", "callcode":
+		return c.action.From
+	}
+	return c.action.To
+}
@@ -0,0 +1,141 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// accessStats counts the distinct accounts and storage slots a transaction touched, which bound the
+// state it made the node read and write. The totals count each account or slot once, however many
+// frames accessed it, so they may be less than the sum of the frames'.
+type accessStats struct {
+	Accounts     hexutil.Uint64     `json:"accounts"`
+	SlotsRead    hexutil.Uint64     `json:"slotsRead"`
+	SlotsWritten hexutil.Uint64     `json:"slotsWritten"`
+	Frames       []accessStatsFrame `json:"frames"`
+}
+
+// accessStatsFrame counts the distinct accounts and slots a frame touched itself, excluding its subcalls.
+// A frame touches its caller and callee, along with the accounts its operations inspect or call.
+type accessStatsFrame struct {
+	TraceAddress []int          `json:"traceAddress"`
+	Accounts     hexutil.Uint64 `json:"accounts"`
+	SlotsRead    hexutil.Uint64 `json:"slotsRead"`
+	SlotsWritten hexutil.Uint64 `json:"slotsWritten"`
+}
+
+type storageSlot struct {
+	address common.Address
+	slot    common.Hash
+}
+
+// frameAccessTally records the accounts and slots a frame's operations touched.
+type frameAccessTally struct {
+	accounts map[common.Address]struct{}
+	read     map[storageSlot]struct{}
+	written  map[storageSlot]struct{}
+}
+
+func newFrameAccessTally(from, to common.Address) *frameAccessTally {
+	return &frameAccessTally{
+		accounts: map[common.Address]struct{}{from: {}, to: {}},
+		read:     make(map[storageSlot]struct{}),
+		written:  make(map[storageSlot]struct{}),
+	}
+}
+
+// countAccess notes the account or slot an EVM operation touches. Unlike access lists, ArbOS's
+// own storage accesses are counted, as they're state the node reads and writes all the same.
+func (tally *frameAccessTally) countAccess(op vm.OpCode, scope *vm.ScopeContext) {
+	stack := scope.Stack.Data()
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		if len(stack) < 1 {
+			return
+		}
+		slot := storageSlot{scope.Contract.Address(), common.Hash(stack[len(stack)-1].Bytes32())}
+		tally.accounts[slot.address] = struct{}{}
+		if op == vm.SLOAD {
+			tally.read[slot] = struct{}{}
+		} else {
+			tally.written[slot] = struct{}{}
+		}
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.EXTCODEHASH, vm.SELFDESTRUCT:
+		if len(stack) >= 1 {
+			tally.accounts[common.Address(stack[len(stack)-1].Bytes20())] = struct{}{}
+		}
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if len(stack) >= 2 {
+			tally.accounts[common.Address(stack[len(stack)-2].Bytes20())] = struct{}{}
+		}
+	}
+}
+
+// countHostioAccess notes the account or slot a Stylus program's hostio touches.
+func (tally *frameAccessTally) countHostioAccess(program common.Address, name string, args []byte) {
+	switch name {
+	case "storage_load_bytes32", "storage_cache_bytes32":
+		if len(args) < common.HashLength {
+			return
+		}
+		slot := storageSlot{program, common.BytesToHash(args[:common.HashLength])}
+		tally.accounts[program] = struct{}{}
+		if name == "storage_load_bytes32" {
+			tally.read[slot] = struct{}{}
+		} else {
+			tally.written[slot] = struct{}{}
+		}
+	case "account_balance", "account_code", "account_code_size", "account_codehash":
+		if len(args) >= common.AddressLength {
+			tally.accounts[common.BytesToAddress(args[:common.AddressLength])] = struct{}{}
+		}
+	}
+}
+
+// accessStats counts what the traced transaction touched, frame by frame and in total.
+func (t *parityTracer) accessStats() *accessStats {
+	stats := &accessStats{Frames: []accessStatsFrame{}}
+	if t.root == nil {
+		return stats
+	}
+	total := &frameAccessTally{
+		accounts: make(map[common.Address]struct{}),
+		read:     make(map[storageSlot]struct{}),
+		written:  make(map[storageSlot]struct{}),
+	}
+	stats.Frames = countParityCall(t.root, []int{}, total, stats.Frames)
+	stats.Accounts = hexutil.Uint64(len(total.accounts))
+	stats.SlotsRead = hexutil.Uint64(len(total.read))
+	stats.SlotsWritten = hexutil.Uint64(len(total.written))
+	return stats
+}
+
+func countParityCall(call *parityCall, traceAddress []int, total *frameAccessTally, frames []accessStatsFrame) []accessStatsFrame {
+	frame := accessStatsFrame{TraceAddress: traceAddress}
+	if tally := call.accessTally; tally != nil {
+		for addr := range tally.accounts {
+			total.accounts[addr] = struct{}{}
+		}
+		for slot := range tally.read {
+			total.read[slot] = struct{}{}
+		}
+		for slot := range tally.written {
+			total.written[slot] = struct{}{}
+		}
+		frame.Accounts = hexutil.Uint64(len(tally.accounts))
+		frame.SlotsRead = hexutil.Uint64(len(tally.read))
+		frame.SlotsWritten = hexutil.Uint64(len(tally.written))
+	}
+	frames = append(frames, frame)
+	for i, child := range call.calls {
+		childAddress := make([]int, len(traceAddress)+1)
+		copy(childAddress, traceAddress)
+		childAddress[len(traceAddress)] = i
+		frames = countParityCall(child, childAddress, total, frames)
+	}
+	return frames
+}
@@ -0,0 +1,79 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+)
+
+// addressTableEntry is an address a call referenced by its index in ArbOS's address table.
+type addressTableEntry struct {
+	Index hexutil.Uint64 `json:"index"`
+	// nil if no address was registered at the index
+	Address *common.Address `json:"address"`
+}
+
+// resolveAddressTableCall resolves the index a call to ArbAddressTable's lookupIndex or decompress refers to,
+// returning nil for other calls and for compressed addresses that are given in full. The table is read as the
+// call found it, so indices registered earlier in the same transaction resolve, and later ones don't.
+func resolveAddressTableCall(statedb vm.StateDB, to common.Address, input []byte) *addressTableEntry {
+	if to != types.ArbAddressTableAddress || len(input) < 4 {
+		return nil
+	}
+	precompile := arbosPrecompiles[types.ArbAddressTableAddress]
+	method, err := precompile.abi.MethodById(input[:4])
+	if err != nil {
+		return nil
+	}
+	args, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil
+	}
+	var index uint64
+	switch method.Name {
+	case "lookupIndex":
+		value, _ := args[0].(*big.Int)
+		if value == nil || !value.IsUint64() {
+			return nil
+		}
+		index = value.Uint64()
+	case "decompress":
+		buf, _ := args[0].([]byte)
+		offset, _ := args[1].(*big.Int)
+		if offset == nil || !offset.IsUint64() || offset.Uint64() > uint64(len(buf)) {
+			return nil
+		}
+		buf = buf[offset.Uint64():]
+		if full, err := rlp.NewStream(bytes.NewReader(buf), 21).Bytes(); err != nil || len(full) == 20 {
+			return nil
+		}
+		index, err = rlp.NewStream(bytes.NewReader(buf), 9).Uint64()
+		if err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	state, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		return nil
+	}
+	entry := &addressTableEntry{Index: hexutil.Uint64(index)}
+	addr, exists, err := state.AddressTable().LookupIndex(index)
+	if err != nil {
+		return nil
+	}
+	if exists {
+		entry.Address = &addr
+	}
+	return entry
+}
@@ -0,0 +1,56 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// ErrTraceNotAllowed is returned when the trace allowlist doesn't permit a request.
+	ErrTraceNotAllowed = errors.New("tracing not allowed")
+	// ErrImpersonationNotAllowed is returned when a traced call names its sender while impersonation is disabled.
+	ErrImpersonationNotAllowed = errors.New("impersonation not allowed")
+)
+
+// restricted reports whether an allowlist limits which contracts may be traced.
+func (c *ArbTraceConfig) restricted() bool {
+	return len(c.traceAllowlist) > 0
+}
+
+// checkTraceAllowed permits tracing a transaction or call whose top-level frame is to the given account,
+// which must be on the allowlist if there is one. Contract creations have no such account, so they're refused.
+func (c *ArbTraceConfig) checkTraceAllowed(to *common.Address) error {
+	if !c.restricted() {
+		return nil
+	}
+	if to == nil {
+		return fmt.Errorf("%w: contract creations can't be traced while a trace allowlist is set", ErrTraceNotAllowed)
+	}
+	if _, ok := c.traceAllowlist[*to]; !ok {
+		return fmt.Errorf("%w: %v isn't on the trace allowlist", ErrTraceNotAllowed, *to)
+	}
+	return nil
+}
+
+// checkUnrestricted refuses methods that trace transactions to arbitrary accounts, such as those tracing
+// whole blocks, while an allowlist is set.
+func (c *ArbTraceConfig) checkUnrestricted(method string) error {
+	if c.restricted() {
+		return fmt.Errorf("%w: %v traces accounts beyond those on the trace allowlist", ErrTraceNotAllowed, method)
+	}
+	return nil
+}
+
+// checkImpersonation permits tracing a call from the given sender. Calls aren't signed, so naming a sender
+// impersonates it, which is allowed unless disabled. The zero address is the default sender, so it's always allowed.
+func (c *ArbTraceConfig) checkImpersonation(from *common.Address) error {
+	if c.AllowImpersonation || from == nil || *from == (common.Address{}) {
+		return nil
+	}
+	return fmt.Errorf("%w: calls can't name their sender, %v, while impersonation is disabled", ErrImpersonationNotAllowed, *from)
+}
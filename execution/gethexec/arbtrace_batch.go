@@ -0,0 +1,107 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+var (
+	// ErrBatchNotFound is returned when tracing a sequencer batch that hasn't been posted, or that the node hasn't read yet.
+	ErrBatchNotFound = errors.New("batch not found")
+	// ErrBatchNotExecuted is returned when tracing a sequencer batch whose blocks this node hasn't produced,
+	// either because it's still catching up or because they've been pruned.
+	ErrBatchNotExecuted = errors.New("batch blocks not available")
+)
+
+// batchSource locates the sequencer batches messages were posted in, which the consensus node tracks.
+type batchSource interface {
+	GetBatchFetcher() execution.BatchFetcher
+	MessageIndexToBlockNumber(messageNum arbutil.MessageIndex) uint64
+}
+
+// batchTraces holds the traces of the blocks a sequencer batch's messages produced.
+type batchTraces struct {
+	BatchNumber hexutil.Uint64 `json:"batchNumber"`
+	FromBlock   hexutil.Uint64 `json:"fromBlock"`
+	ToBlock     hexutil.Uint64 `json:"toBlock"`
+	Blocks      []*blockTraces `json:"blocks"`
+}
+
+// batchBlockRange returns the first and last blocks produced by a batch's messages.
+func (api *ArbTraceAPI) batchBlockRange(batchNum uint64) (uint64, uint64, error) {
+	var fetcher execution.BatchFetcher
+	if api.batches != nil {
+		fetcher = api.batches.GetBatchFetcher()
+	}
+	if fetcher == nil {
+		return 0, 0, errors.New("arbtrace_batch requires the node to track the sequencer inbox")
+	}
+	count, err := fetcher.GetBatchCount()
+	if err != nil {
+		return 0, 0, err
+	}
+	if batchNum >= count {
+		return 0, 0, fmt.Errorf("%w: batch %v, of %v batches read", ErrBatchNotFound, batchNum, count)
+	}
+	var firstMessage arbutil.MessageIndex
+	if batchNum > 0 {
+		firstMessage, err = fetcher.GetBatchMessageCount(batchNum - 1)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	endMessage, err := fetcher.GetBatchMessageCount(batchNum)
+	if err != nil {
+		return 0, 0, err
+	}
+	if endMessage <= firstMessage {
+		return 0, 0, fmt.Errorf("batch %v holds no messages", batchNum)
+	}
+	return api.batches.MessageIndexToBlockNumber(firstMessage), api.batches.MessageIndexToBlockNumber(endMessage - 1), nil
+}
+
+// Batch traces the blocks produced by the messages of a sequencer batch, grouping the results by block like
+// arbtrace_blockRange, whose range bound batches are held to. Blocks produced from delayed messages the batch
+// read are included, as they're part of its contents.
+func (api *ArbTraceAPI) Batch(ctx context.Context, batchNum hexutil.Uint64, traceTypes []string) (*batchTraces, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_batch", "batch", uint64(batchNum))
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_batch"); err != nil {
+		return nil, err
+	}
+	if err := validateTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	fromBlock, toBlock, err := api.batchBlockRange(uint64(batchNum))
+	if err != nil {
+		return nil, err
+	}
+	if head := api.blockchain.CurrentBlock(); head == nil || head.Number.Uint64() < toBlock {
+		return nil, fmt.Errorf("%w: batch %v produces blocks %v to %v, which haven't been executed yet", ErrBatchNotExecuted, batchNum, fromBlock, toBlock)
+	}
+	if api.blockchain.GetBlockByNumber(fromBlock) == nil {
+		return nil, fmt.Errorf("%w: batch %v produces blocks %v to %v, which have been pruned", ErrBatchNotExecuted, batchNum, fromBlock, toBlock)
+	}
+	maxRange := api.config().FilterMaxRange
+	if maxRange != 0 && toBlock-fromBlock >= maxRange {
+		return nil, fmt.Errorf("batch %v produces %v blocks, exceeding the limit of %v; trace it in parts with arbtrace_blockRange from %v to %v", batchNum, toBlock-fromBlock+1, maxRange, fromBlock, toBlock)
+	}
+	blocks, err := api.traceBlockRange(ctx, fromBlock, toBlock, newTraceTypeSet(traceTypes))
+	if err != nil {
+		return nil, err
+	}
+	return &batchTraces{
+		BatchNumber: batchNum,
+		FromBlock:   hexutil.Uint64(fromBlock),
+		ToBlock:     hexutil.Uint64(toBlock),
+		Blocks:      blocks,
+	}, nil
+}
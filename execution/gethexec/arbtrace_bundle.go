@@ -0,0 +1,143 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// bundleResult describes the simulated execution of a bundle of transactions.
+type bundleResult struct {
+	Results      []*bundleTxResult `json:"results"`
+	TotalGasUsed hexutil.Uint64    `json:"totalGasUsed"`
+	// the change in the coinbase's balance, which on Arbitrum only grows by direct payments
+	CoinbaseDiff *hexutil.Big `json:"coinbaseDiff"`
+	// the fees ArbOS distributed to each fee account for the bundle's transactions
+	FeeAccountDiffs map[common.Address]*hexutil.Big `json:"feeAccountDiffs"`
+}
+
+type bundleTxResult struct {
+	TxHash       common.Hash     `json:"txHash"`
+	From         common.Address  `json:"from"`
+	To           *common.Address `json:"to"`
+	Success      bool            `json:"success"`
+	ReturnData   hexutil.Bytes   `json:"returnData"`
+	Error        *string         `json:"error,omitempty"`
+	RevertReason *string         `json:"revertReason,omitempty"`
+	Fees         *arbitrumFees   `json:"fees"`
+	Logs         []*types.Log    `json:"logs"`
+}
+
+// SimulateBundle applies a bundle of signed transactions in order on top of the given block's state,
+// optionally with parts of the block's context or the starting state overridden, as eth_callBundle does.
+// Transactions that revert are reported like any other, while those that can't be applied at all,
+// such as ones with a wrong nonce, fail the whole bundle. Bundles are limited like arbtrace_callMany's calls.
+func (api *ArbTraceAPI) SimulateBundle(
+	ctx context.Context,
+	rawTxs []hexutil.Bytes,
+	blockNum TraceBlockRef,
+	blockOverrides *blockOverrides,
+	stateOverrides stateOverride,
+) (*bundleResult, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_simulateBundle", "block", blockNum.String(), "txs", len(rawTxs))
+	defer done()
+	if len(rawTxs) == 0 {
+		return nil, errors.New("bundle is empty")
+	}
+	config := api.config()
+	if config.CallManyMaxCalls > 0 && len(rawTxs) > config.CallManyMaxCalls {
+		return nil, fmt.Errorf("%w: %d exceeds the limit of %d", ErrCallManyTooManyCalls, len(rawTxs), config.CallManyMaxCalls)
+	}
+	txs := make([]*types.Transaction, 0, len(rawTxs))
+	for i, rawTx := range rawTxs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(rawTx); err != nil {
+			return nil, fmt.Errorf("invalid raw transaction %d: %w", i, err)
+		}
+		if err := config.checkTraceAllowed(tx.To()); err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		txs = append(txs, tx)
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("arbtrace_simulateBundle doesn't support classic history")
+	}
+	statedb, header, err := api.backend.StateAndHeaderByNumberOrHash(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+	if err != nil {
+		return nil, err
+	}
+	header, err = blockOverrides.apply(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := stateOverrides.apply(statedb); err != nil {
+		return nil, err
+	}
+
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	coinbaseBefore := statedb.GetBalance(header.Coinbase).ToBig()
+	result := &bundleResult{
+		Results:         make([]*bundleTxResult, 0, len(txs)),
+		FeeAccountDiffs: make(map[common.Address]*hexutil.Big),
+	}
+	for i, tx := range txs {
+		msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("invalid raw transaction %d: %w", i, err)
+		}
+		statedb.SetTxContext(tx.Hash(), i)
+		traced, err := api.traceMessage(ctx, msg, header, blockCtx, statedb, newTraceTypeSet([]string{traceTypeArbFees}), false)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		txResult := &bundleTxResult{
+			TxHash:     tx.Hash(),
+			From:       msg.From,
+			To:         msg.To,
+			Success:    traced.execErr == nil,
+			ReturnData: traced.Output,
+			Fees:       traced.ArbitrumFees,
+			Logs:       statedb.GetLogs(tx.Hash(), header.Number.Uint64(), common.Hash{}),
+		}
+		if traced.execErr != nil {
+			message := traced.execErr.Error()
+			txResult.Error = &message
+			if reason, err := abi.UnpackRevert(traced.Output); err == nil {
+				txResult.RevertReason = &reason
+			}
+		}
+		if txResult.Logs == nil {
+			txResult.Logs = []*types.Log{}
+		}
+		for _, fee := range traced.fees {
+			total, ok := result.FeeAccountDiffs[fee.recipient]
+			if !ok {
+				total = (*hexutil.Big)(new(big.Int))
+				result.FeeAccountDiffs[fee.recipient] = total
+			}
+			total.ToInt().Add(total.ToInt(), fee.value)
+		}
+		result.TotalGasUsed += traced.ArbitrumFees.GasUsed
+		result.Results = append(result.Results, txResult)
+	}
+	coinbaseDiff := arbmath.BigSub(statedb.GetBalance(header.Coinbase).ToBig(), coinbaseBefore)
+	result.CoinbaseDiff = (*hexutil.Big)(coinbaseDiff)
+	return result, nil
+}
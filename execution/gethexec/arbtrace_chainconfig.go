@@ -0,0 +1,72 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ErrInvalidChainConfigOverride is returned when a chain config override names an unknown fork or
+// schedules forks in a way no chain could.
+var ErrInvalidChainConfigOverride = errors.New("invalid chain config override")
+
+// chainConfigOverride reschedules the block-numbered forks a replay executes under, keyed by fork name.
+// A block activates the fork from that block on, while null disables it.
+type chainConfigOverride map[string]*hexutil.Uint64
+
+// overridableForks are the forks scheduled by block number, along with the fields scheduling them.
+var overridableForks = map[string]func(*params.ChainConfig) **big.Int{
+	"homestead":      func(c *params.ChainConfig) **big.Int { return &c.HomesteadBlock },
+	"eip150":         func(c *params.ChainConfig) **big.Int { return &c.EIP150Block },
+	"eip155":         func(c *params.ChainConfig) **big.Int { return &c.EIP155Block },
+	"eip158":         func(c *params.ChainConfig) **big.Int { return &c.EIP158Block },
+	"byzantium":      func(c *params.ChainConfig) **big.Int { return &c.ByzantiumBlock },
+	"constantinople": func(c *params.ChainConfig) **big.Int { return &c.ConstantinopleBlock },
+	"petersburg":     func(c *params.ChainConfig) **big.Int { return &c.PetersburgBlock },
+	"istanbul":       func(c *params.ChainConfig) **big.Int { return &c.IstanbulBlock },
+	"berlin":         func(c *params.ChainConfig) **big.Int { return &c.BerlinBlock },
+	"london":         func(c *params.ChainConfig) **big.Int { return &c.LondonBlock },
+}
+
+// arbosGatedForks are the forks Arbitrum chains activate with ArbOS upgrades rather than by their schedule.
+var arbosGatedForks = []string{"shanghai", "cancun"}
+
+// apply returns a copy of config with the override's forks rescheduled, leaving config itself untouched.
+// The copy shares config's other fields, which must not be modified through it. Overrides scheduling forks
+// out of order are refused, such as enabling London while disabling Berlin, which London builds on.
+func (o chainConfigOverride) apply(config *params.ChainConfig) (*params.ChainConfig, error) {
+	override := *config
+	for name, block := range o {
+		field, ok := overridableForks[name]
+		if !ok {
+			for _, gated := range arbosGatedForks {
+				if name == gated {
+					return nil, fmt.Errorf("%w: %v is activated by ArbOS upgrades, not its schedule", ErrInvalidChainConfigOverride, name)
+				}
+			}
+			names := make([]string, 0, len(overridableForks))
+			for fork := range overridableForks {
+				names = append(names, fork)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("%w: unknown fork %q, expected one of: %v", ErrInvalidChainConfigOverride, name, strings.Join(names, ", "))
+		}
+		if block == nil {
+			*field(&override) = nil
+			continue
+		}
+		*field(&override) = new(big.Int).SetUint64(uint64(*block))
+	}
+	if err := override.CheckConfigForkOrder(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChainConfigOverride, err)
+	}
+	return &override, nil
+}
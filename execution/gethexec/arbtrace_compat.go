@@ -0,0 +1,106 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// parityTraceTypes are the trace types Parity's trace namespace offers.
+var parityTraceTypes = []string{traceTypeTrace, traceTypeVmTrace, traceTypeStateDiff}
+
+// TraceCompatAPI serves Parity's trace namespace for tools that only speak it, dispatching each method to
+// its arbtrace counterpart so both namespaces return the same results. Only Parity's methods, arguments,
+// and trace types are offered, leaving Arbitrum's extensions to arbtrace.
+type TraceCompatAPI struct {
+	api *ArbTraceAPI
+}
+
+func NewTraceCompatAPI(api *ArbTraceAPI) *TraceCompatAPI {
+	return &TraceCompatAPI{api: api}
+}
+
+// validateParityTraceTypes rejects trace types beyond Parity's, which are only offered by arbtrace.
+func validateParityTraceTypes(traceTypes []string) error {
+	for _, traceType := range traceTypes {
+		supported := false
+		for _, candidate := range parityTraceTypes {
+			if traceType == candidate {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("%w %q, expected one of: %v (Arbitrum's trace types are only offered by arbtrace)", ErrUnknownTraceType, traceType, strings.Join(parityTraceTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// latestIfUnset defaults an omitted block reference to the latest block, as Parity does.
+func latestIfUnset(blockNum *TraceBlockRef) TraceBlockRef {
+	if blockNum == nil {
+		return TraceBlockRef{BlockNumberOrHash: rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)}
+	}
+	return *blockNum
+}
+
+func (api *TraceCompatAPI) Call(ctx context.Context, callArgs callTxArgs, traceTypes []string, blockNum *TraceBlockRef) (interface{}, error) {
+	if err := validateParityTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	return api.api.Call(ctx, callArgs, traceTypes, latestIfUnset(blockNum), nil, nil)
+}
+
+func (api *TraceCompatAPI) CallMany(ctx context.Context, calls callTraceRequests, blockNum *TraceBlockRef) (interface{}, error) {
+	for i, call := range calls {
+		if err := validateParityTraceTypes(call.traceTypes); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+	}
+	return api.api.CallMany(ctx, calls, latestIfUnset(blockNum), nil)
+}
+
+// RawTransaction traces a signed transaction on top of the latest block, as Parity's takes no block.
+func (api *TraceCompatAPI) RawTransaction(ctx context.Context, rawTx hexutil.Bytes, traceTypes []string) (interface{}, error) {
+	if err := validateParityTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	return api.api.RawTransaction(ctx, rawTx, traceTypes, latestIfUnset(nil))
+}
+
+func (api *TraceCompatAPI) ReplayBlockTransactions(ctx context.Context, blockNum TraceBlockRef, traceTypes []string) (interface{}, error) {
+	if err := validateParityTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	return api.api.ReplayBlockTransactions(ctx, blockNum, traceTypes)
+}
+
+func (api *TraceCompatAPI) ReplayTransaction(ctx context.Context, txHash hexutil.Bytes, traceTypes []string) (interface{}, error) {
+	if err := validateParityTraceTypes(traceTypes); err != nil {
+		return nil, err
+	}
+	return api.api.ReplayTransaction(ctx, txHash, traceTypes, nil)
+}
+
+func (api *TraceCompatAPI) Transaction(ctx context.Context, txHash hexutil.Bytes) (interface{}, error) {
+	return api.api.Transaction(ctx, txHash, nil)
+}
+
+func (api *TraceCompatAPI) Get(ctx context.Context, txHash hexutil.Bytes, path []hexutil.Uint64) (interface{}, error) {
+	return api.api.Get(ctx, txHash, path, nil)
+}
+
+func (api *TraceCompatAPI) Block(ctx context.Context, blockNum TraceBlockRef) (interface{}, error) {
+	return api.api.Block(ctx, blockNum, nil)
+}
+
+func (api *TraceCompatAPI) Filter(ctx context.Context, filter *filterRequest) (interface{}, error) {
+	return api.api.Filter(ctx, filter)
+}
@@ -0,0 +1,122 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+var (
+	// ErrDelayedMessageNotFound is returned when tracing a delayed message the node hasn't read from the parent chain yet.
+	ErrDelayedMessageNotFound = errors.New("delayed message not found")
+	// ErrDelayedMessageNotSequenced is returned when tracing a delayed message that's been read, but not yet
+	// sequenced into a block, either by the sequencer or by force inclusion.
+	ErrDelayedMessageNotSequenced = errors.New("delayed message not sequenced")
+)
+
+// delayedMessageTraces describes how a delayed message was sequenced, and traces the transactions it created.
+type delayedMessageTraces struct {
+	MessageIndex hexutil.Uint64 `json:"messageIndex"`
+	// the position of the message among all messages, sequencer and delayed alike
+	MessageNumber hexutil.Uint64 `json:"messageNumber"`
+	BlockNumber   hexutil.Uint64 `json:"blockNumber"`
+	BlockHash     common.Hash    `json:"blockHash"`
+	// the sequencer batch that read the message, unset until one has been posted and read
+	Batch        *hexutil.Uint64      `json:"batch,omitempty"`
+	Transactions []parentChainTxTrace `json:"transactions"`
+}
+
+// delayedMessageBlock returns the block a delayed message was sequenced into. Each delayed message
+// produces a block of its own, the first whose nonce, the count of delayed messages read, exceeds its index.
+func (api *ArbTraceAPI) delayedMessageBlock(index uint64, fetcher execution.BatchFetcher) (*types.Header, error) {
+	head := api.blockchain.CurrentBlock()
+	if head == nil || head.Nonce.Uint64() <= index {
+		delayedCount, err := fetcher.GetDelayedCount()
+		if err != nil {
+			return nil, err
+		}
+		if index >= delayedCount {
+			return nil, fmt.Errorf("%w: message %v, of %v delayed messages read", ErrDelayedMessageNotFound, index, delayedCount)
+		}
+		return nil, fmt.Errorf("%w: message %v has been read, but no block has sequenced it yet", ErrDelayedMessageNotSequenced, index)
+	}
+	genesis := api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum
+	var lookupErr error
+	offset := sort.Search(int(head.Number.Uint64()-genesis+1), func(i int) bool {
+		header := api.blockchain.GetHeaderByNumber(genesis + uint64(i))
+		if header == nil {
+			lookupErr = fmt.Errorf("block %v not found", genesis+uint64(i))
+			return true
+		}
+		return header.Nonce.Uint64() > index
+	})
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	return api.blockchain.GetHeaderByNumber(genesis + uint64(offset)), nil
+}
+
+// DelayedMessage traces the transactions a delayed inbox message created, along with where it was sequenced:
+// the block it produced, its position among all messages, and the batch that read it. This shows the order
+// delayed messages were sequenced in relative to the sequencer's, whether they were read by the sequencer
+// or force included. Messages ArbOS couldn't parse produce a block without transactions.
+func (api *ArbTraceAPI) DelayedMessage(ctx context.Context, index hexutil.Uint64) (*delayedMessageTraces, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_delayedMessage", "index", uint64(index))
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_delayedMessage"); err != nil {
+		return nil, err
+	}
+	var fetcher execution.BatchFetcher
+	if api.batches != nil {
+		fetcher = api.batches.GetBatchFetcher()
+	}
+	if fetcher == nil {
+		return nil, errors.New("arbtrace_delayedMessage requires the node to track the delayed inbox")
+	}
+	header, err := api.delayedMessageBlock(uint64(index), fetcher)
+	if err != nil {
+		return nil, err
+	}
+	block := api.blockchain.GetBlock(header.Hash(), header.Number.Uint64())
+	if block == nil {
+		return nil, fmt.Errorf("%w: delayed message %v produced block %v, which has been pruned", ErrBatchNotExecuted, index, header.Number)
+	}
+	genesis := api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum
+	messageNumber := arbutil.BlockNumberToMessageCount(block.NumberU64(), genesis) - 1
+	result := &delayedMessageTraces{
+		MessageIndex:  index,
+		MessageNumber: hexutil.Uint64(messageNumber),
+		BlockNumber:   hexutil.Uint64(block.NumberU64()),
+		BlockHash:     block.Hash(),
+		Transactions:  []parentChainTxTrace{},
+	}
+	batch, found, err := fetcher.FindInboxBatchContainingMessage(messageNumber)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		result.Batch = (*hexutil.Uint64)(&batch)
+	}
+	for i, tx := range block.Transactions() {
+		// every block starts with ArbOS's internal transaction, which the message didn't create
+		if tx.Type() == types.ArbitrumInternalTxType {
+			continue
+		}
+		traced, err := api.parentChainTxTrace(ctx, tx, block, uint64(i), index)
+		if err != nil {
+			return nil, err
+		}
+		result.Transactions = append(result.Transactions, *traced)
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+// the trace types an explanation is assembled from, none of which need the trace's frames flattened
+var explainTraceTypes = newTraceTypeSet([]string{traceTypeSummary, traceTypeTokenTransfers, traceTypeOutbox, traceTypeFeeInfo})
+
+// txExplanation is a single view of what a transaction did, for support tooling that would otherwise
+// piece it together from several requests. Parts that couldn't be produced are left unset, and listed
+// in Unavailable along with why.
+type txExplanation struct {
+	TransactionHash     common.Hash    `json:"transactionHash"`
+	BlockHash           common.Hash    `json:"blockHash"`
+	BlockNumber         hexutil.Uint64 `json:"blockNumber"`
+	TransactionPosition hexutil.Uint64 `json:"transactionPosition"`
+	Call                *explainedCall `json:"call"`
+	// whether the transaction succeeded, with its revert reason if it didn't
+	Outcome        *txOutcome       `json:"outcome"`
+	Summary        *traceSummary    `json:"summary,omitempty"`
+	TokenTransfers *[]tokenTransfer `json:"tokenTransfers,omitempty"`
+	Outbox         *[]outboxMessage `json:"outbox,omitempty"`
+	FeeInfo        *feeInfo         `json:"feeInfo,omitempty"`
+	// the parts missing or incomplete, keyed by field name
+	Unavailable map[string]string `json:"unavailable,omitempty"`
+}
+
+// explainedCall is a transaction's top-level call, decoded as far as possible without the callee's ABI:
+// calls to ArbOS precompiles are decoded in full, while other calls only name the method selected.
+type explainedCall struct {
+	Type       string          `json:"type"`
+	From       common.Address  `json:"from"`
+	To         *common.Address `json:"to,omitempty"`
+	Value      *hexutil.Big    `json:"value"`
+	Input      hexutil.Bytes   `json:"input"`
+	Selector   *methodSelector `json:"selector,omitempty"`
+	Precompile *precompileCall `json:"precompile,omitempty"`
+}
+
+func newExplainedCall(msg *core.Message) *explainedCall {
+	input := hexutil.Bytes(common.CopyBytes(msg.Data))
+	if input == nil {
+		input = hexutil.Bytes{}
+	}
+	call := &explainedCall{
+		Type:  frameTypeCall,
+		From:  msg.From,
+		To:    msg.To,
+		Value: (*hexutil.Big)(msg.Value),
+		Input: input,
+	}
+	if msg.To == nil {
+		call.Type = frameTypeCreate
+		return call
+	}
+	if len(input) >= len(methodSelector{}) {
+		var selector methodSelector
+		copy(selector[:], input)
+		call.Selector = &selector
+	}
+	call.Precompile = decodePrecompileCall(msg.To, &input)
+	return call
+}
+
+// Explain describes what a transaction did in a single response: its decoded top-level call, its outcome and
+// revert reason, a summary of its frames, the transfers it made, the messages it sent to the parent chain, and
+// what it paid. Should tracing the transaction fail, such as by exceeding the frame limit, it's re-executed
+// untraced instead, which still yields all but the summary and the native transfers its frames made.
+func (api *ArbTraceAPI) Explain(ctx context.Context, txHash hexutil.Bytes) (*txExplanation, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_explain", "tx", txHash)
+	defer done()
+	tx, block, index := api.transactionByHash(txHash)
+	if tx == nil {
+		return nil, errors.New("arbtrace_explain doesn't support classic history")
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	statedb, header, blockCtx, msg, release, err := api.stateAtTransaction(ctx, block, index)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	explanation := &txExplanation{
+		TransactionHash:     tx.Hash(),
+		BlockHash:           block.Hash(),
+		BlockNumber:         hexutil.Uint64(block.NumberU64()),
+		TransactionPosition: hexutil.Uint64(index),
+		Call:                newExplainedCall(msg),
+	}
+	// traced on a copy, leaving the state untouched to re-execute on should tracing fail
+	traced, traceErr := api.traceMessage(ctx, msg, header, blockCtx, statedb.Copy(), explainTraceTypes, false)
+	if traceErr == nil {
+		res := &core.ExecutionResult{UsedGas: uint64(traced.Summary.GasUsed), Err: traced.execErr, ReturnData: traced.Output}
+		explanation.Outcome = newTxOutcome(msg, res)
+		explanation.Summary = traced.Summary
+		explanation.TokenTransfers = traced.TokenTransfers
+		explanation.Outbox = traced.Outbox
+		explanation.FeeInfo = traced.FeeInfo
+		return explanation, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res, evm, err := api.applyTimedMessage(ctx, msg, header, blockCtx, statedb, nil)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+	}
+	statedb.Finalise(evm.ChainConfig().IsEIP158(header.Number))
+	logs := statedb.GetCurrentTxLogs()
+	transfers := []tokenTransfer{}
+	for i, log := range logs {
+		transfers = append(transfers, logTransfers(log, i)...)
+	}
+	messages := outboxMessages(statedb, logs)
+	explanation.Outcome = newTxOutcome(msg, res)
+	explanation.TokenTransfers = &transfers
+	explanation.Outbox = &messages
+	explanation.FeeInfo = newFeeInfo(evm, msg, res)
+	reason := fmt.Sprintf("tracing failed: %v", traceErr)
+	explanation.Unavailable = map[string]string{
+		"summary": reason,
+		// the token transfers come from the logs, which don't need tracing
+		"tokenTransfers": fmt.Sprintf("native transfers are missing, %v", reason),
+	}
+	return explanation, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+)
+
+// feeAccounts are the accounts ArbOS pays the L2 fees of a block's transactions to, which chain owners may change.
+type feeAccounts struct {
+	BlockHash         common.Hash    `json:"blockHash"`
+	BlockNumber       hexutil.Uint64 `json:"blockNumber"`
+	NetworkFeeAccount common.Address `json:"networkFeeAccount"`
+	InfraFeeAccount   common.Address `json:"infraFeeAccount"`
+	// whether the infrastructure fee account is paid its share, which requires it be set on ArbOS 5 or later
+	InfraFeeEnabled bool `json:"infraFeeEnabled"`
+}
+
+// FeeAccounts returns the fee accounts as of the end of the given block, which are the ones the
+// networkFee and infraFee rewards of its transactions are paid to unless the block changed them.
+func (api *ArbTraceAPI) FeeAccounts(ctx context.Context, blockNum TraceBlockRef) (*feeAccounts, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_feeAccounts", "block", blockNum.String())
+	defer done()
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("arbtrace_feeAccounts doesn't support classic history")
+	}
+	statedb, _, err := api.backend.StateAndHeaderByNumberOrHash(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+	if err != nil {
+		return nil, err
+	}
+	state, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	networkFeeAccount, err := state.NetworkFeeAccount()
+	if err != nil {
+		return nil, err
+	}
+	infraFeeAccount, err := state.InfraFeeAccount()
+	if err != nil {
+		return nil, err
+	}
+	return &feeAccounts{
+		BlockHash:         block.Hash(),
+		BlockNumber:       hexutil.Uint64(block.NumberU64()),
+		NetworkFeeAccount: networkFeeAccount,
+		InfraFeeAccount:   infraFeeAccount,
+		InfraFeeEnabled:   state.ArbOSVersion() > 4 && infraFeeAccount != (common.Address{}),
+	}, nil
+}
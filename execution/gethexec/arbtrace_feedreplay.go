@@ -0,0 +1,214 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/execution"
+	flag "github.com/spf13/pflag"
+)
+
+// FeedReplayTracerConfig configures the tool mode tracing the messages of a recorded sequencer feed,
+// rather than running the node.
+type FeedReplayTracerConfig struct {
+	File       string   `koanf:"file"`
+	Output     string   `koanf:"output"`
+	TraceTypes []string `koanf:"trace-types"`
+}
+
+var DefaultFeedReplayTracerConfig = FeedReplayTracerConfig{
+	TraceTypes: []string{traceTypeTrace},
+}
+
+func FeedReplayTracerConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.String(prefix+".file", DefaultFeedReplayTracerConfig.File, "if set, instead of running the node, replay the sequencer feed messages recorded in this file on top of the database's state and trace the transactions of the blocks they produce, then exit")
+	f.String(prefix+".output", DefaultFeedReplayTracerConfig.Output, "file to write the traces to, one JSON object per transaction (empty = stdout)")
+	f.StringSlice(prefix+".trace-types", DefaultFeedReplayTracerConfig.TraceTypes, "trace types to trace each transaction with, as arbtrace_replayTransaction takes them ("+strings.Join(supportedTraceTypes, ", ")+")")
+}
+
+func (c *FeedReplayTracerConfig) Validate() error {
+	if c.File == "" {
+		return nil
+	}
+	if err := validateTraceTypes(c.TraceTypes); err != nil {
+		return fmt.Errorf("invalid feed replay trace types: %w", err)
+	}
+	return nil
+}
+
+// feedReplayTrace is the trace of a transaction of a block a feed message produced, written as a line of output.
+type feedReplayTrace struct {
+	SequenceNumber      hexutil.Uint64 `json:"sequenceNumber"`
+	BlockNumber         hexutil.Uint64 `json:"blockNumber"`
+	BlockHash           common.Hash    `json:"blockHash"`
+	TransactionHash     common.Hash    `json:"transactionHash"`
+	TransactionPosition hexutil.Uint64 `json:"transactionPosition"`
+	Result              *traceResult   `json:"result"`
+}
+
+// TraceFeedReplay traces the messages of the sequencer feed recorded in the configured file, writing each resulting
+// transaction's trace to the configured output. It's a package function rather than a method, so that it isn't
+// served over RPC.
+//
+// The batches that messages reporting batch postings need are fetched with batchFetcher, which may be nil if the
+// node doesn't track the parent chain's inbox. It's passed apart from the API's batches, as the tool runs without
+// starting the node, and only starting it gives the execution engine the consensus node those come from.
+func TraceFeedReplay(ctx context.Context, api *ArbTraceAPI, batchFetcher execution.BatchFetcher, config *FeedReplayTracerConfig) error {
+	feed, err := os.Open(config.File)
+	if err != nil {
+		return err
+	}
+	defer feed.Close()
+	out := io.Writer(os.Stdout)
+	if config.Output != "" {
+		file, err := os.Create(config.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	buffered := bufio.NewWriter(out)
+	if err := api.traceFeed(ctx, feed, buffered, batchFetcher, newTraceTypeSet(config.TraceTypes)); err != nil {
+		// the traces written so far are kept, as they lead up to the failure
+		if flushErr := buffered.Flush(); flushErr != nil {
+			log.Warn("failed to write feed replay traces", "err", flushErr)
+		}
+		return err
+	}
+	return buffered.Flush()
+}
+
+// traceFeed replays the messages of a recorded sequencer feed, which holds the feed's broadcast messages one after
+// another, as the feed sent them. The messages must follow on from each other, starting from any message whose
+// parent block the database has, but needn't have been executed by the node: each produces a block as the
+// sequencer's would have, without the block being written, whose transactions are then traced on the state the
+// previous messages left. Messages the feed resent, as it does after reconnecting, are only replayed once.
+//
+// Blocks are produced against the database's chain, so BLOCKHASH can't look up blocks the feed produced that the
+// node never executed, and messages reporting batch postings need batchFetcher to have the batches they report.
+func (api *ArbTraceAPI) traceFeed(ctx context.Context, feed io.Reader, out io.Writer, batchFetcher execution.BatchFetcher, traceTypes traceTypeSet) error {
+	genesis := api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum
+	decoder := json.NewDecoder(feed)
+	encoder := json.NewEncoder(out)
+	var statedb *state.StateDB
+	var parent *types.Header
+	var next arbutil.MessageIndex
+	for {
+		var broadcast message.BroadcastMessage
+		if err := decoder.Decode(&broadcast); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode feed: %w", err)
+		}
+		for _, feedMessage := range broadcast.Messages {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			seqNum := feedMessage.SequenceNumber
+			if statedb == nil {
+				parent = api.blockchain.GetHeaderByNumber(uint64(arbutil.MessageCountToBlockNumber(seqNum, genesis)))
+				if parent == nil {
+					return fmt.Errorf("the database has no parent block for the feed's first message %v", seqNum)
+				}
+				parentBlock := api.blockchain.GetBlock(parent.Hash(), parent.Number.Uint64())
+				initial, release, err := api.backend.StateAtBlock(ctx, parentBlock, arbTraceReexec, nil, true, false)
+				if err != nil {
+					return fmt.Errorf("state before the feed's first message %v: %w", seqNum, err)
+				}
+				defer release()
+				statedb = initial
+				next = seqNum
+			}
+			if seqNum < next {
+				continue
+			}
+			if seqNum > next {
+				return fmt.Errorf("the feed skips from message %v to message %v", next, seqNum)
+			}
+			block, err := api.traceFeedMessage(ctx, feedMessage, parent, statedb, encoder, batchFetcher, traceTypes)
+			if err != nil {
+				return fmt.Errorf("message %v: %w", seqNum, err)
+			}
+			if canonical := api.blockchain.GetCanonicalHash(block.NumberU64()); canonical != (common.Hash{}) && canonical != block.Hash() {
+				log.Warn("feed message produced a different block than the node's", "message", seqNum, "block", block.NumberU64(), "produced", block.Hash(), "canonical", canonical)
+			}
+			parent = block.Header()
+			next++
+		}
+	}
+}
+
+// traceFeedMessage produces the block a feed message would, then traces the block's transactions on statedb,
+// leaving it as the block left it.
+func (api *ArbTraceAPI) traceFeedMessage(
+	ctx context.Context,
+	feedMessage *message.BroadcastFeedMessage,
+	parent *types.Header,
+	statedb *state.StateDB,
+	encoder *json.Encoder,
+	batchFetcher execution.BatchFetcher,
+	traceTypes traceTypeSet,
+) (*types.Block, error) {
+	fetchBatch := func(batchNum uint64) ([]byte, error) {
+		if batchFetcher == nil {
+			return nil, fmt.Errorf("batch %v can't be fetched without the node tracking the parent chain's inbox", batchNum)
+		}
+		data, _, err := batchFetcher.FetchBatch(ctx, batchNum)
+		return data, err
+	}
+	msg := feedMessage.Message
+	block, _, err := arbos.ProduceBlock(msg.Message, msg.DelayedMessagesRead, parent, statedb.Copy(), api.blockchain, api.blockchain.Config(), fetchBatch, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to produce block: %w", err)
+	}
+	header := executionHeader(block.Header(), parent)
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	for i, tx := range block.Transactions() {
+		txMsg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+		}
+		statedb.SetTxContext(tx.Hash(), i)
+		result, err := api.traceMessage(ctx, txMsg, header, blockCtx, statedb, traceTypes, false)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+		}
+		err = encoder.Encode(&feedReplayTrace{
+			SequenceNumber:      hexutil.Uint64(feedMessage.SequenceNumber),
+			BlockNumber:         hexutil.Uint64(block.NumberU64()),
+			BlockHash:           block.Hash(),
+			TransactionHash:     tx.Hash(),
+			TransactionPosition: hexutil.Uint64(i),
+			Result:              result,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// tracing replays the transactions apart from producing the block, so check the two agree
+	if root := statedb.IntermediateRoot(api.blockchain.Config().IsEIP158(header.Number)); root != block.Root() {
+		return nil, fmt.Errorf("tracing block %v reached state root %v, but producing it reached %v", block.NumberU64(), root, block.Root())
+	}
+	return block, nil
+}
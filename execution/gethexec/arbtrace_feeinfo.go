@@ -0,0 +1,71 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// feeInfo describes the gas price a traced message paid, as ArbOS charged it during execution. ArbOS drops
+// tips, charging only the base fee, on every ArbOS version but 9, and for messages from the delayed inbox.
+type feeInfo struct {
+	EffectiveGasPrice *hexutil.Big `json:"effectiveGasPrice"`
+	BaseFee           *hexutil.Big `json:"baseFee"`
+	// the tip per gas the message offered, capped by its fee cap's excess over the base fee
+	PriorityFeePerGas *hexutil.Big `json:"priorityFeePerGas"`
+	// the tip per gas the message paid, which is zero when ArbOS dropped it
+	EffectivePriorityFee *hexutil.Big   `json:"effectivePriorityFee"`
+	TipDropped           bool           `json:"tipDropped"`
+	GasUsed              hexutil.Uint64 `json:"gasUsed"`
+	// the part of the gas used that paid for posting the message to the parent chain, and what it cost
+	GasUsedForL1 hexutil.Uint64 `json:"gasUsedForL1"`
+	L1Fee        *hexutil.Big   `json:"l1Fee"`
+	TotalFee     *hexutil.Big   `json:"totalFee"`
+}
+
+// newFeeInfo reads the gas price a message paid from its transaction processor, which priced its execution.
+func newFeeInfo(evm *vm.EVM, msg *core.Message, res *core.ExecutionResult) *feeInfo {
+	baseFee := evm.Context.BaseFee
+	if baseFee == nil {
+		baseFee = new(big.Int)
+	}
+	offeredTip := new(big.Int)
+	if msg.GasTipCap != nil && msg.GasFeeCap != nil {
+		offeredTip = new(big.Int).Set(arbmath.BigMin(msg.GasTipCap, arbmath.BigSub(msg.GasFeeCap, baseFee)))
+		if offeredTip.Sign() < 0 {
+			offeredTip = new(big.Int)
+		}
+	}
+	paidPrice := evm.GasPrice
+	tipDropped := false
+	posterFee := new(big.Int)
+	if txProcessor, ok := evm.ProcessingHook.(*arbos.TxProcessor); ok {
+		paidPrice = txProcessor.GetPaidGasPrice()
+		tipDropped = txProcessor.DropTip()
+		if txProcessor.PosterFee != nil {
+			posterFee = txProcessor.PosterFee
+		}
+	}
+	paidTip := arbmath.BigSub(paidPrice, baseFee)
+	if tipDropped || paidTip.Sign() < 0 {
+		paidTip = new(big.Int)
+	}
+	return &feeInfo{
+		EffectiveGasPrice:    (*hexutil.Big)(new(big.Int).Set(paidPrice)),
+		BaseFee:              (*hexutil.Big)(new(big.Int).Set(baseFee)),
+		PriorityFeePerGas:    (*hexutil.Big)(offeredTip),
+		EffectivePriorityFee: (*hexutil.Big)(paidTip),
+		TipDropped:           tipDropped,
+		GasUsed:              hexutil.Uint64(res.UsedGas),
+		GasUsedForL1:         hexutil.Uint64(messagePosterGas(evm)),
+		L1Fee:                (*hexutil.Big)(new(big.Int).Set(posterFee)),
+		TotalFee:             (*hexutil.Big)(arbmath.BigMulByUint(paidPrice, res.UsedGas)),
+	}
+}
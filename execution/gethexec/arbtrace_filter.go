@@ -0,0 +1,656 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// filterCursor marks the last frame an arbtrace_filter page returned, letting the next
+// page resume from a fixed position rather than an offset that drifts as the chain grows.
+type filterCursor struct {
+	BlockNumber         uint64      `json:"blockNumber"`
+	BlockHash           common.Hash `json:"blockHash"`
+	TransactionPosition uint64      `json:"transactionPosition"`
+	TraceAddress        []int       `json:"traceAddress"`
+}
+
+func newFilterCursor(frame *traceFrame) *filterCursor {
+	return &filterCursor{
+		BlockNumber:         *frame.BlockNumber,
+		BlockHash:           *frame.BlockHash,
+		TransactionPosition: *frame.TransactionPosition,
+		TraceAddress:        frame.TraceAddress,
+	}
+}
+
+func (c *filterCursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeFilterCursor(encoded string) (*filterCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	cursor := &filterCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// compareTraceAddress orders trace addresses as they appear in a depth-first list of frames.
+func compareTraceAddress(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// precedes reports whether the cursor's position comes before the given frame.
+func (c *filterCursor) precedes(frame *traceFrame) bool {
+	if c.BlockNumber != *frame.BlockNumber {
+		return c.BlockNumber < *frame.BlockNumber
+	}
+	if c.TransactionPosition != *frame.TransactionPosition {
+		return c.TransactionPosition < *frame.TransactionPosition
+	}
+	return compareTraceAddress(c.TraceAddress, frame.TraceAddress) < 0
+}
+
+func containsAddress(addresses []common.Address, addr *common.Address) bool {
+	if addr == nil {
+		return false
+	}
+	for _, candidate := range addresses {
+		if candidate == *addr {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCallType reports whether a frame is of one of the given kinds. Call frames are matched
+// by their call type, such as "delegatecall", and create frames by either their frame type or
+// their creation method, such as "create2".
+func matchesCallType(callTypes []string, frame *traceFrame) bool {
+	for _, callType := range callTypes {
+		switch frame.Type {
+		case frameTypeCall:
+			if callType == frame.Action.CallType {
+				return true
+			}
+		case frameTypeCreate:
+			if callType == frame.Type || callType == frame.Action.CreationMethod {
+				return true
+			}
+		default:
+			if callType == frame.Type {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// frameParticipants returns the account a frame came from and the one it went to. Creations go to the
+// contract they created, if any, and self-destructs from the destroyed contract to its beneficiary.
+func frameParticipants(frame *traceFrame) (*common.Address, *common.Address) {
+	from, to := frame.Action.From, frame.Action.To
+	switch frame.Type {
+	case frameTypeCreate:
+		if frame.Result != nil {
+			to = frame.Result.Address
+		}
+	case frameTypeSuicide:
+		from, to = frame.Action.Address, frame.Action.RefundAddress
+	}
+	return from, to
+}
+
+// matchesSelector reports whether a call's input begins with one of the given method selectors.
+// Creations, whose code takes no input, and calls with less than a selector's worth of input never match.
+func matchesSelector(selectors []methodSelector, frame *traceFrame) bool {
+	input := frame.Action.Input
+	if input == nil || len(*input) < len(methodSelector{}) {
+		return false
+	}
+	for _, selector := range selectors {
+		if bytes.Equal((*input)[:len(selector)], selector[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a frame satisfies the request's address, call type, selector, and value constraints.
+// Value bounds are inclusive, and frames without a value never satisfy them.
+func (filter *filterRequest) matches(frame *traceFrame) bool {
+	from, to := frameParticipants(frame)
+	if filter.FromAddress != nil && len(*filter.FromAddress) > 0 && !containsAddress(*filter.FromAddress, from) {
+		return false
+	}
+	if filter.ToAddress != nil && len(*filter.ToAddress) > 0 && !containsAddress(*filter.ToAddress, to) {
+		return false
+	}
+	if filter.CallTypes != nil && len(*filter.CallTypes) > 0 && !matchesCallType(*filter.CallTypes, frame) {
+		return false
+	}
+	if filter.Selectors != nil && len(*filter.Selectors) > 0 && !matchesSelector(*filter.Selectors, frame) {
+		return false
+	}
+	if filter.MinValue != nil || filter.MaxValue != nil {
+		value := frame.Action.Value
+		if value == nil {
+			return false
+		}
+		if filter.MinValue != nil && value.ToInt().Cmp(filter.MinValue.ToInt()) < 0 {
+			return false
+		}
+		if filter.MaxValue != nil && value.ToInt().Cmp(filter.MaxValue.ToInt()) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterBlockNumber resolves one end of a filter's block range, defaulting to the latest block.
+func (api *ArbTraceAPI) filterBlockNumber(ctx context.Context, ref *rpc.BlockNumberOrHash) (uint64, error) {
+	if ref == nil {
+		return api.blockchain.CurrentBlock().Number.Uint64(), nil
+	}
+	if number, ok := ref.Number(); ok {
+		var header *types.Header
+		switch number {
+		case rpc.LatestBlockNumber, rpc.PendingBlockNumber:
+			// blocks are produced as soon as transactions are sequenced, so pending is latest
+			header = api.blockchain.CurrentBlock()
+		case rpc.SafeBlockNumber:
+			header = api.blockchain.CurrentSafeBlock()
+		case rpc.FinalizedBlockNumber:
+			header = api.blockchain.CurrentFinalBlock()
+		default:
+			if number < 0 {
+				return 0, fmt.Errorf("unsupported block tag %v", number)
+			}
+			return uint64(number), nil
+		}
+		if header == nil {
+			return 0, fmt.Errorf("%v block not found", number)
+		}
+		return header.Number.Uint64(), nil
+	}
+	header, err := api.backend.HeaderByNumberOrHash(ctx, *ref)
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, fmt.Errorf("block %v not found", ref.String())
+	}
+	if err := api.checkCanonical(*ref, header.Number.Uint64()); err != nil {
+		return 0, err
+	}
+	return header.Number.Uint64(), nil
+}
+
+// blockRange resolves and bounds the ends of a range of blocks to trace.
+func (api *ArbTraceAPI) blockRange(ctx context.Context, from, to *rpc.BlockNumberOrHash) (uint64, uint64, error) {
+	fromBlock, err := api.filterBlockNumber(ctx, from)
+	if err != nil {
+		return 0, 0, err
+	}
+	toBlock, err := api.filterBlockNumber(ctx, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fromBlock > toBlock {
+		return 0, 0, fmt.Errorf("invalid block range: %v to %v", fromBlock, toBlock)
+	}
+	maxRange := api.config().FilterMaxRange
+	if maxRange != 0 && toBlock-fromBlock >= maxRange {
+		return 0, 0, fmt.Errorf("block range %v to %v spans %v blocks, exceeding the limit of %v", fromBlock, toBlock, toBlock-fromBlock+1, maxRange)
+	}
+	return fromBlock, toBlock, nil
+}
+
+// Filter returns the frames within a block range that match the given addresses.
+// Requests carrying a cursor are answered with a page of frames and the cursor of the next page.
+// Like arbtrace_blockRange, it fails with ErrReorgDuringTrace if any block it traced is reorged meanwhile.
+func (api *ArbTraceAPI) Filter(ctx context.Context, filter *filterRequest) (interface{}, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_filter")
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_filter"); err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = &filterRequest{}
+	}
+	fromBlock, toBlock, err := api.blockRange(ctx, filter.FromBlock.blockNumberOrHash(), filter.ToBlock.blockNumberOrHash())
+	if err != nil {
+		return nil, err
+	}
+	genesis := api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum
+	if toBlock < genesis {
+		if filter.Cursor != nil {
+			return nil, errors.New("cursors are not supported for classic history")
+		}
+		return api.forward(ctx, "arbtrace_filter", filter)
+	}
+	if fromBlock < genesis {
+		return nil, fmt.Errorf("block range %v to %v spans classic and Nitro history, split it at block %v", fromBlock, toBlock, genesis)
+	}
+
+	var cursor *filterCursor
+	if filter.Cursor != nil && *filter.Cursor != "" {
+		cursor, err = decodeFilterCursor(*filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if api.blockchain.GetCanonicalHash(cursor.BlockNumber) != cursor.BlockHash {
+			return nil, fmt.Errorf("cursor references block %v (%v), which is no longer canonical", cursor.BlockNumber, cursor.BlockHash)
+		}
+		fromBlock = arbmath.MaxInt(fromBlock, cursor.BlockNumber)
+	}
+
+	var skip uint64
+	if filter.After != nil {
+		skip = *filter.After
+	}
+	full := func(frames []traceFrame) bool {
+		return filter.Count != nil && uint64(len(frames)) >= *filter.Count
+	}
+	frames := []traceFrame{}
+	last := cursor
+	var traced []*types.Block
+	checkTraced := func() error {
+		for _, block := range traced {
+			if err := api.checkStillCanonical(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for number := fromBlock; number <= toBlock && !full(frames); number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := api.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			if err := checkTraced(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("block %v not found", number)
+		}
+		blockFrames, err := api.blockFrames(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		traced = append(traced, block)
+		for i := range blockFrames {
+			frame := &blockFrames[i]
+			if cursor != nil && !cursor.precedes(frame) {
+				continue
+			}
+			if !filter.matches(frame) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			frames = append(frames, *frame)
+			last = newFilterCursor(frame)
+			if full(frames) {
+				break
+			}
+		}
+	}
+
+	if err := checkTraced(); err != nil {
+		return nil, err
+	}
+
+	if filter.Cursor == nil {
+		return frames, nil
+	}
+	result := &filterResult{Traces: frames}
+	if last != nil {
+		next, err := last.encode()
+		if err != nil {
+			return nil, err
+		}
+		result.NextCursor = &next
+	}
+	return result, nil
+}
+
+// AddressActivity returns the frames within a block range that an account made or received, in the order
+// arbtrace_filter would list them. Unlike a filter on both fromAddress and toAddress, which requires both
+// to match, each frame the account appears in is returned once, whichever side it's on. The range is
+// bounded like arbtrace_filter's, and the blocks are traced through the same cache.
+//
+// Blocks the activity index shows don't involve the account are skipped without being traced. Frames
+// involve accounts their transactions and receipts needn't mention, so only blocks traced before, by any
+// request, are indexed. Like arbtrace_filter, it fails with ErrReorgDuringTrace if any block it traced or
+// skipped is reorged meanwhile.
+func (api *ArbTraceAPI) AddressActivity(ctx context.Context, address common.Address, fromBlock, toBlock *TraceBlockRef) ([]traceFrame, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_addressActivity", "address", address)
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_addressActivity"); err != nil {
+		return nil, err
+	}
+	first, last, err := api.blockRange(ctx, fromBlock.blockNumberOrHash(), toBlock.blockNumberOrHash())
+	if err != nil {
+		return nil, err
+	}
+	if first < api.blockchain.Config().ArbitrumChainParams.GenesisBlockNum {
+		return nil, errors.New("arbtrace_addressActivity doesn't support classic history")
+	}
+	frames := []traceFrame{}
+	var traced []*types.Block
+	checkTraced := func() error {
+		for _, block := range traced {
+			if err := api.checkStillCanonical(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for number := first; number <= last; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := api.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			if err := checkTraced(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("block %v not found", number)
+		}
+		traced = append(traced, block)
+		if api.activityIndex != nil {
+			if accounts, indexed := api.activityIndex.Get(block.Hash()); indexed {
+				if _, involved := accounts[address]; !involved {
+					activityIndexSkippedCounter.Inc(1)
+					continue
+				}
+			}
+		}
+		blockFrames, err := api.blockFrames(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range blockFrames {
+			from, to := frameParticipants(&frame)
+			if (from != nil && *from == address) || (to != nil && *to == address) {
+				frames = append(frames, frame)
+			}
+		}
+	}
+	if err := checkTraced(); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// indexActivity records the accounts a traced block's frames involve in the activity index, if enabled.
+func (api *ArbTraceAPI) indexActivity(block *types.Block, frames []traceFrame) {
+	if api.activityIndex == nil {
+		return
+	}
+	accounts := make(map[common.Address]struct{})
+	for i := range frames {
+		from, to := frameParticipants(&frames[i])
+		if from != nil {
+			accounts[*from] = struct{}{}
+		}
+		if to != nil {
+			accounts[*to] = struct{}{}
+		}
+	}
+	api.activityIndex.Add(block.Hash(), accounts)
+}
+
+var (
+	// ErrTooManySubscriptions is returned when an arbtrace_subscribe request would exceed the configured number of subscriptions.
+	ErrTooManySubscriptions = errors.New("too many subscriptions")
+	// ErrSubscriptionLagging ends a subscription whose notifications fall further behind the head of the chain than configured.
+	ErrSubscriptionLagging = errors.New("subscription fell too far behind the head of the chain")
+)
+
+// subscriptionEnd is the last notification of a subscription the node ended, as the subscription
+// protocol has no way of ending one with an error.
+type subscriptionEnd struct {
+	Error string `json:"subscriptionError"`
+}
+
+// Subscribe notifies the subscriber of the frames matching a filter as blocks join the canonical chain.
+// When a reorg drops blocks, their matching frames are sent again with removed set, latest first,
+// before those of the blocks replacing them.
+//
+// Blocks are traced apart from receiving the chain's new heads, so a slow subscriber never holds up
+// block production: heads arriving while blocks are traced are coalesced into the latest. Should the
+// subscriber fall too far behind, or tracing fail, the subscription is ended, with a last notification
+// holding only a subscriptionError field saying why.
+func (api *ArbTraceAPI) Subscribe(ctx context.Context, filter *filterRequest) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	config := api.config()
+	if err := config.checkUnrestricted("arbtrace_subscribe"); err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = &filterRequest{}
+	}
+	if filter.FromBlock != nil || filter.ToBlock != nil {
+		return nil, errors.New("arbtrace_subscribe follows the head of the chain and doesn't accept a block range")
+	}
+	if filter.After != nil || filter.Count != nil || filter.Cursor != nil {
+		return nil, errors.New("arbtrace_subscribe doesn't support pagination")
+	}
+	if open := api.subscriptions.Add(1); config.MaxSubscriptions > 0 && open > int64(config.MaxSubscriptions) {
+		api.subscriptions.Add(-1)
+		return nil, fmt.Errorf("%w: %v are open, the maximum", ErrTooManySubscriptions, config.MaxSubscriptions)
+	}
+	rpcSub := notifier.CreateSubscription()
+	heads := make(chan core.ChainHeadEvent, 16)
+	headSub := api.blockchain.SubscribeChainHeadEvent(heads)
+	last := api.blockchain.CurrentBlock()
+	// the request's context ends once the subscription is created, so trace until the subscriber leaves
+	ctx, cancel := context.WithCancel(context.Background())
+	// the latest head the worker hasn't reached, and the number of the block it's notified up to
+	var latestMutex sync.Mutex
+	var latest *types.Header
+	var notified atomic.Uint64
+	notified.Store(last.Number.Uint64())
+	wake := make(chan struct{}, 1)
+	// either goroutine may end the subscription, but the subscriber is only told the first reason
+	var endOnce sync.Once
+	end := func(err error) {
+		endOnce.Do(func() {
+			log.Warn("arbtrace_subscribe ended", "err", err)
+			if notifyErr := notifier.Notify(rpcSub.ID, &subscriptionEnd{Error: err.Error()}); notifyErr != nil {
+				log.Debug("failed to notify arbtrace_subscribe of its end", "err", notifyErr)
+			}
+			cancel()
+		})
+	}
+	checkLag := func(head *types.Header) error {
+		maxLag := api.config().SubscribeMaxLag
+		if number, reached := head.Number.Uint64(), notified.Load(); maxLag > 0 && number > reached+maxLag {
+			return fmt.Errorf("%w: notified up to block %v with the head at %v", ErrSubscriptionLagging, reached, number)
+		}
+		return nil
+	}
+	// receives the chain's heads, never waiting on the worker, so as not to block the chain's feed
+	go func() {
+		defer api.subscriptions.Add(-1)
+		defer headSub.Unsubscribe()
+		defer cancel()
+		for {
+			select {
+			case head := <-heads:
+				header := head.Block.Header()
+				if err := checkLag(header); err != nil {
+					end(err)
+					return
+				}
+				latestMutex.Lock()
+				latest = header
+				latestMutex.Unlock()
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			case <-rpcSub.Err():
+				return
+			case <-headSub.Err():
+				end(errors.New("the chain's head feed closed"))
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// traces from the last head notified up to the latest received
+	go func() {
+		for {
+			select {
+			case <-wake:
+			case <-ctx.Done():
+				return
+			}
+			latestMutex.Lock()
+			head := latest
+			latest = nil
+			latestMutex.Unlock()
+			if head == nil {
+				continue
+			}
+			next, err := api.notifyChainUpdate(ctx, notifier, rpcSub.ID, filter, last, head)
+			if err != nil {
+				if ctx.Err() == nil {
+					end(fmt.Errorf("failed to trace up to block %v: %w", head.Number, err))
+				}
+				return
+			}
+			last = next
+			notified.Store(last.Number.Uint64())
+		}
+	}()
+	return rpcSub, nil
+}
+
+// notifyChainUpdate sends the matching frames of the blocks a head change removed and added,
+// returning the header notifications have been sent up to.
+func (api *ArbTraceAPI) notifyChainUpdate(
+	ctx context.Context,
+	notifier *rpc.Notifier,
+	id rpc.ID,
+	filter *filterRequest,
+	oldHead, newHead *types.Header,
+) (*types.Header, error) {
+	removed, added, err := api.chainUpdate(oldHead, newHead)
+	if err != nil {
+		return nil, err
+	}
+	notify := func(block *types.Block, wasRemoved bool) error {
+		frames, err := api.blockFrames(ctx, block)
+		if err != nil {
+			return err
+		}
+		for i := range frames {
+			frame := &frames[i]
+			if !filter.matches(frame) {
+				continue
+			}
+			frame.Removed = wasRemoved
+			if err := notifier.Notify(id, frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, block := range removed {
+		if err := notify(block, true); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(added) - 1; i >= 0; i-- {
+		if err := notify(added[i], false); err != nil {
+			return nil, err
+		}
+	}
+	return newHead, nil
+}
+
+// chainUpdate walks back from two heads to their common ancestor, returning the blocks only the old head
+// descends from and those only the new head descends from, each latest first.
+func (api *ArbTraceAPI) chainUpdate(oldHead, newHead *types.Header) ([]*types.Block, []*types.Block, error) {
+	getBlock := func(hash common.Hash, number uint64) (*types.Block, error) {
+		block := api.blockchain.GetBlock(hash, number)
+		if block == nil {
+			return nil, fmt.Errorf("block %v (%v) not found", number, hash)
+		}
+		return block, nil
+	}
+	oldBlock, err := getBlock(oldHead.Hash(), oldHead.Number.Uint64())
+	if err != nil {
+		return nil, nil, err
+	}
+	newBlock, err := getBlock(newHead.Hash(), newHead.Number.Uint64())
+	if err != nil {
+		return nil, nil, err
+	}
+	var removed, added []*types.Block
+	for newBlock.NumberU64() > oldBlock.NumberU64() {
+		added = append(added, newBlock)
+		if newBlock, err = getBlock(newBlock.ParentHash(), newBlock.NumberU64()-1); err != nil {
+			return nil, nil, err
+		}
+	}
+	for oldBlock.NumberU64() > newBlock.NumberU64() {
+		removed = append(removed, oldBlock)
+		if oldBlock, err = getBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1); err != nil {
+			return nil, nil, err
+		}
+	}
+	for oldBlock.Hash() != newBlock.Hash() {
+		removed = append(removed, oldBlock)
+		added = append(added, newBlock)
+		if oldBlock, err = getBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1); err != nil {
+			return nil, nil, err
+		}
+		if newBlock, err = getBlock(newBlock.ParentHash(), newBlock.NumberU64()-1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return removed, added, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/offchainlabs/nitro/arbos/storage"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// gasProfile attributes the gas a transaction used to what it was spent on.
+// Gas spent before execution is split between the intrinsic cost of the transaction,
+// which covers its calldata, and the gas charged for posting it to the parent chain.
+type gasProfile struct {
+	Intrinsic hexutil.Uint64    `json:"intrinsic"`
+	L1Posting hexutil.Uint64    `json:"l1Posting"`
+	Frames    []gasProfileFrame `json:"frames"`
+}
+
+// gasProfileFrame splits the gas a frame used itself, excluding that of its subcalls,
+// between storage accesses, memory expansion, and everything else. Stylus programs
+// aren't metered per opcode, so what they spend outside of subcalls counts as computation.
+type gasProfileFrame struct {
+	TraceAddress []int          `json:"traceAddress"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	Computation  hexutil.Uint64 `json:"computation"`
+	Storage      hexutil.Uint64 `json:"storage"`
+	Memory       hexutil.Uint64 `json:"memory"`
+}
+
+// frameGasTally accumulates the gas a frame's operations spent on storage and memory.
+type frameGasTally struct {
+	storage    uint64
+	memory     uint64
+	memorySize uint64
+}
+
+// memoryGasCost is the total cost of expanding memory to the given size, which the EVM keeps word-aligned.
+func memoryGasCost(size uint64) uint64 {
+	words := arbmath.DivCeil(size, 32)
+	return words*params.MemoryGas + words*words/params.QuadCoeffDiv
+}
+
+// profileStep attributes an operation's cost to the frame executing it. Memory expansion is
+// observed once the memory has grown, so growth from a frame's final operation goes unnoticed.
+func (t *parityTracer) profileStep(op vm.OpCode, cost uint64, scope *vm.ScopeContext) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	tally := &t.callstack[len(t.callstack)-1].gasTally
+	if size := uint64(scope.Memory.Len()); size > tally.memorySize {
+		tally.memory += memoryGasCost(size) - memoryGasCost(tally.memorySize)
+		tally.memorySize = size
+	}
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		if cost != 0 {
+			tally.storage += cost
+			return
+		}
+		// ArbOS reports its own storage accesses as free operations, having burnt their cost itself
+		if op == vm.SLOAD {
+			tally.storage += storage.StorageReadCost
+			return
+		}
+		stack := scope.Stack.Data()
+		if len(stack) < 2 || stack[len(stack)-2].IsZero() {
+			tally.storage += storage.StorageWriteZeroCost
+		} else {
+			tally.storage += storage.StorageWriteCost
+		}
+	}
+}
+
+// gasProfile attributes the gas of the traced transaction given its gas limit and the gas
+// ArbOS charged it for posting to the parent chain.
+func (t *parityTracer) gasProfile(gasLimit uint64, posterGas uint64) *gasProfile {
+	profile := &gasProfile{
+		L1Posting: hexutil.Uint64(posterGas),
+		Frames:    []gasProfileFrame{},
+	}
+	if t.root == nil {
+		return profile
+	}
+	if t.root.action.Gas != nil {
+		executionGas := uint64(*t.root.action.Gas)
+		profile.Intrinsic = hexutil.Uint64(arbmath.SaturatingUSub(gasLimit, arbmath.SaturatingUAdd(executionGas, posterGas)))
+	}
+	profile.Frames = profileParityCall(t.root, []int{}, profile.Frames)
+	return profile
+}
+
+func profileParityCall(call *parityCall, traceAddress []int, frames []gasProfileFrame) []gasProfileFrame {
+	ownGas := call.gasUsed
+	for _, child := range call.calls {
+		ownGas = arbmath.SaturatingUSub(ownGas, child.gasUsed)
+	}
+	tally := call.gasTally
+	frames = append(frames, gasProfileFrame{
+		TraceAddress: traceAddress,
+		GasUsed:      hexutil.Uint64(call.gasUsed),
+		Computation:  hexutil.Uint64(arbmath.SaturatingUSub(ownGas, tally.storage+tally.memory)),
+		Storage:      hexutil.Uint64(tally.storage),
+		Memory:       hexutil.Uint64(tally.memory),
+	})
+	for i, child := range call.calls {
+		childAddress := make([]int, len(traceAddress)+1)
+		copy(childAddress, traceAddress)
+		childAddress[len(traceAddress)] = i
+		frames = profileParityCall(child, childAddress, frames)
+	}
+	return frames
+}
+
+// gasRefund describes the refund a transaction earned by clearing storage, and how much of it
+// was applied. Refunds are capped to a fraction of the gas used, excluding that charged for
+// posting to the parent chain, which ArbOS doesn't refund.
+type gasRefund struct {
+	Total   hexutil.Uint64 `json:"total"`
+	Cap     hexutil.Uint64 `json:"cap"`
+	Applied hexutil.Uint64 `json:"applied"`
+}
+
+// gasRefund reconstructs the refund the state transition applied to the traced transaction, given
+// its gas limit and the state it left, which must not have been finalised yet.
+func (t *parityTracer) gasRefund(evm *vm.EVM, statedb *state.StateDB, gasLimit uint64) *gasRefund {
+	refund := &gasRefund{Total: hexutil.Uint64(statedb.GetRefund())}
+	if t.root == nil || t.root.action.Gas == nil {
+		return refund
+	}
+	quotient := params.RefundQuotient
+	if evm.ChainConfig().IsLondon(evm.Context.BlockNumber) {
+		quotient = params.RefundQuotientEIP3529
+	}
+	// the gas ArbOS held back from execution is returned before the refund is computed
+	gasLeft := uint64(*t.root.action.Gas) - t.root.gasUsed + evm.ProcessingHook.ForceRefundGas()
+	gasUsed := arbmath.SaturatingUSub(gasLimit, gasLeft)
+	refund.Cap = hexutil.Uint64(arbmath.SaturatingUSub(gasUsed, evm.ProcessingHook.NonrefundableGas()) / quotient)
+	refund.Applied = arbmath.MinInt(refund.Total, refund.Cap)
+	return refund
+}
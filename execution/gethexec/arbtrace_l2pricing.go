@@ -0,0 +1,68 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+)
+
+// l2Pricing describes ArbOS's L2 pricing model as a traced message found it. The base fee is the one
+// the block charges, which ArbOS derived at the start of the block from the backlog of gas beyond the
+// speed limit, rising exponentially with the backlog in excess of the tolerance at a rate set by the inertia.
+type l2Pricing struct {
+	BaseFee             *hexutil.Big   `json:"baseFee"`
+	MinBaseFee          *hexutil.Big   `json:"minBaseFee"`
+	SpeedLimitPerSecond hexutil.Uint64 `json:"speedLimitPerSecond"`
+	PerBlockGasLimit    hexutil.Uint64 `json:"perBlockGasLimit"`
+	GasBacklog          hexutil.Uint64 `json:"gasBacklog"`
+	BacklogTolerance    hexutil.Uint64 `json:"backlogTolerance"`
+	PricingInertia      hexutil.Uint64 `json:"pricingInertia"`
+}
+
+// readL2Pricing reads the L2 pricing model from the state a message is about to execute on.
+func readL2Pricing(statedb *state.StateDB, header *types.Header) (*l2Pricing, error) {
+	arbState, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	pricing := arbState.L2PricingState()
+	minBaseFee, err := pricing.MinBaseFeeWei()
+	if err != nil {
+		return nil, err
+	}
+	speedLimit, err := pricing.SpeedLimitPerSecond()
+	if err != nil {
+		return nil, err
+	}
+	blockGasLimit, err := pricing.PerBlockGasLimit()
+	if err != nil {
+		return nil, err
+	}
+	backlog, err := pricing.GasBacklog()
+	if err != nil {
+		return nil, err
+	}
+	tolerance, err := pricing.BacklogTolerance()
+	if err != nil {
+		return nil, err
+	}
+	inertia, err := pricing.PricingInertia()
+	if err != nil {
+		return nil, err
+	}
+	return &l2Pricing{
+		BaseFee:             (*hexutil.Big)(new(big.Int).Set(header.BaseFee)),
+		MinBaseFee:          (*hexutil.Big)(minBaseFee),
+		SpeedLimitPerSecond: hexutil.Uint64(speedLimit),
+		PerBlockGasLimit:    hexutil.Uint64(blockGasLimit),
+		GasBacklog:          hexutil.Uint64(backlog),
+		BacklogTolerance:    hexutil.Uint64(tolerance),
+		PricingInertia:      hexutil.Uint64(inertia),
+	}, nil
+}
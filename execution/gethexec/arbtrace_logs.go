@@ -0,0 +1,94 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// traceLog is a log emitted by a frame, by a LOG operation, a Stylus program, or an ArbOS precompile.
+type traceLog struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+	Data    hexutil.Bytes  `json:"data"`
+	// the log's position among those the transaction emitted, matching its place in the receipt
+	Index hexutil.Uint64 `json:"index"`
+	// set for logs emitted by ArbOS precompiles, whose events are known
+	Event *precompileEvent `json:"event,omitempty"`
+}
+
+// precompileEvent is a log emitted by an ArbOS precompile, decoded with its ABI.
+type precompileEvent struct {
+	Name  string                 `json:"name"`
+	Event string                 `json:"event"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+func newTraceLog(log *types.Log, index int) traceLog {
+	return traceLog{
+		Address: log.Address,
+		Topics:  append([]common.Hash{}, log.Topics...),
+		Data:    common.CopyBytes(log.Data),
+		Index:   hexutil.Uint64(index),
+		Event:   decodePrecompileEvent(log),
+	}
+}
+
+// decodePrecompileEvent decodes a log emitted by an ArbOS precompile, returning nil for logs emitted by
+// other accounts and those not matching one of the precompile's events. The arguments are left unset for
+// logs they can't be decoded from.
+func decodePrecompileEvent(log *types.Log) *precompileEvent {
+	precompile, ok := arbosPrecompiles[log.Address]
+	if !ok || len(log.Topics) == 0 {
+		return nil
+	}
+	event, err := precompile.abi.EventByID(log.Topics[0])
+	if err != nil {
+		return nil
+	}
+	decoded := &precompileEvent{Name: precompile.name, Event: event.Sig}
+	args := make(map[string]interface{})
+	if err := event.Inputs.UnpackIntoMap(args, log.Data); err != nil {
+		return decoded
+	}
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if err := abi.ParseTopicsIntoMap(args, indexed, log.Topics[1:]); err != nil {
+		return decoded
+	}
+	for name, value := range args {
+		args[name] = precompileArgJSON(value)
+	}
+	decoded.Args = args
+	return decoded
+}
+
+// collectLogs attributes the logs emitted since the last frame boundary to the frame that was executing,
+// which is the only one that can have emitted them. Reverts remove logs from the end of the transaction's,
+// so those emitted since remain at its end.
+func (t *parityTracer) collectLogs(call *parityCall) {
+	logs := t.env.StateDB.GetCurrentTxLogs()
+	if len(logs) < t.logsSeen {
+		t.logsSeen = len(logs)
+	}
+	for i := t.logsSeen; i < len(logs); i++ {
+		call.logs = append(call.logs, newTraceLog(logs[i], i-t.logsBase))
+	}
+	t.logsSeen = len(logs)
+}
+
+// dropLogs discards the logs of a frame that failed and of its subcalls, which its failure reverted.
+func dropLogs(call *parityCall) {
+	call.logs = []traceLog{}
+	for _, sub := range call.calls {
+		dropLogs(sub)
+	}
+}
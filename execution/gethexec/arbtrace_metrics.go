@@ -0,0 +1,29 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// recordTraceRequest counts a request to an arbtrace method and records how long it took to serve.
+func recordTraceRequest(method string, elapsed time.Duration) {
+	metrics.GetOrRegisterCounter("arb/arbtrace/requests/"+method, nil).Inc(1)
+	metrics.GetOrRegisterHistogram("arb/arbtrace/requests/"+method+"/duration", nil, metrics.NewBoundedHistogramSample()).Update(elapsed.Nanoseconds())
+}
+
+// recordTraceTypes counts an execution traced for the given trace types and records how long it took
+// against each of them. The types share one execution, so a type's durations include the cost of
+// whichever types it was requested alongside.
+func recordTraceTypes(traceTypes traceTypeSet, elapsed time.Duration) {
+	for traceType, requested := range traceTypes {
+		if !requested {
+			continue
+		}
+		metrics.GetOrRegisterCounter("arb/arbtrace/tracetypes/"+traceType, nil).Inc(1)
+		metrics.GetOrRegisterHistogram("arb/arbtrace/tracetypes/"+traceType+"/duration", nil, metrics.NewBoundedHistogramSample()).Update(elapsed.Nanoseconds())
+	}
+}
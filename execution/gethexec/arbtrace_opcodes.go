@@ -0,0 +1,146 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// opcodeStats is a histogram of the EVM operations a block executed, keyed by opcode name.
+type opcodeStats struct {
+	BlockHash   common.Hash            `json:"blockHash"`
+	BlockNumber hexutil.Uint64         `json:"blockNumber"`
+	Opcodes     map[string]*opcodeStat `json:"opcodes"`
+}
+
+type opcodeStat struct {
+	Count hexutil.Uint64 `json:"count"`
+	Gas   hexutil.Uint64 `json:"gas"`
+}
+
+// opcodeFrame tracks the operation awaiting its gas within a call frame, which like a vmTrace
+// operation's effects only becomes known at the next step of the same frame.
+type opcodeFrame struct {
+	pending   *opcodeStat
+	cost      uint64
+	gasBefore uint64
+	// the gas the pending operation's callee used, which is attributed to the callee's operations
+	calleeGas uint64
+}
+
+// opcodeTally aggregates operations as they execute, so its memory is bounded by the number of opcodes.
+type opcodeTally struct {
+	stats  map[vm.OpCode]*opcodeStat
+	frames []*opcodeFrame
+}
+
+func newOpcodeTally() *opcodeTally {
+	return &opcodeTally{stats: make(map[vm.OpCode]*opcodeStat)}
+}
+
+func (t *opcodeTally) enter() {
+	t.frames = append(t.frames, &opcodeFrame{})
+}
+
+// exit settles the frame's final operation at its cost, as nothing follows it to observe.
+func (t *opcodeTally) exit(gasUsed uint64) {
+	if len(t.frames) == 0 {
+		return
+	}
+	frame := t.frames[len(t.frames)-1]
+	if frame.pending != nil {
+		frame.pending.Gas += hexutil.Uint64(frame.cost)
+	}
+	t.frames = t.frames[:len(t.frames)-1]
+	if len(t.frames) > 0 {
+		t.frames[len(t.frames)-1].calleeGas += gasUsed
+	}
+}
+
+// step counts a new operation, first settling the one before it.
+func (t *opcodeTally) step(op vm.OpCode, gas, cost uint64) {
+	if len(t.frames) == 0 {
+		return
+	}
+	frame := t.frames[len(t.frames)-1]
+	if frame.pending != nil {
+		used := arbmath.SaturatingUSub(frame.gasBefore, gas)
+		frame.pending.Gas += hexutil.Uint64(arbmath.SaturatingUSub(used, frame.calleeGas))
+	}
+	stat, ok := t.stats[op]
+	if !ok {
+		stat = &opcodeStat{}
+		t.stats[op] = stat
+	}
+	stat.Count++
+	frame.pending = stat
+	frame.cost = cost
+	frame.gasBefore = gas
+	frame.calleeGas = 0
+}
+
+// OpcodeStats returns how many times each opcode executed across a block's transactions, and the gas
+// spent on them. The gas a call or creation forwards is attributed to its callee's operations rather
+// than the operation making it, so precompiles and Stylus programs, which execute no EVM operations,
+// leave the gas they use unattributed.
+func (api *ArbTraceAPI) OpcodeStats(ctx context.Context, blockNum TraceBlockRef) (*opcodeStats, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_opcodeStats", "block", blockNum.String())
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_opcodeStats"); err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("arbtrace_opcodeStats doesn't support classic history")
+	}
+	statedb, header, release, err := api.stateAtParent(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	tracer := newParityTracer(traceTypeSet{}, api.config().MaxFrames)
+	tracer.opcodes = newOpcodeTally()
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	for i, tx := range block.Transactions() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			return nil, err
+		}
+		statedb.SetTxContext(tx.Hash(), i)
+		err = api.applyMessage(ctx, msg, header, blockCtx, statedb, tracer)
+		if tracer.err != nil {
+			err = tracer.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+		}
+	}
+
+	stats := &opcodeStats{
+		BlockHash:   block.Hash(),
+		BlockNumber: hexutil.Uint64(block.NumberU64()),
+		Opcodes:     make(map[string]*opcodeStat, len(tracer.opcodes.stats)),
+	}
+	for op, stat := range tracer.opcodes.stats {
+		stats.Opcodes[op.String()] = stat
+	}
+	return stats, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+	"github.com/offchainlabs/nitro/arbos/util"
+)
+
+// outboxMessage describes an L2-to-L1 message sent through ArbSys, which can be executed on
+// the parent chain's outbox once an assertion including it is confirmed.
+type outboxMessage struct {
+	Caller      common.Address `json:"caller"`
+	Destination common.Address `json:"destination"`
+	CallValue   *hexutil.Big   `json:"callValue"`
+	Data        hexutil.Bytes  `json:"data"`
+	ArbBlockNum hexutil.Uint64 `json:"arbBlockNum"`
+	EthBlockNum hexutil.Uint64 `json:"ethBlockNum"`
+	Timestamp   hexutil.Uint64 `json:"timestamp"`
+
+	// the message's leaf in the send Merkle tree, against which outbox proofs are constructed
+	LeafHash  common.Hash    `json:"leafHash"`
+	LeafIndex hexutil.Uint64 `json:"leafIndex"`
+}
+
+// outboxMessages describes the L2-to-L1 messages a message sent, reading them from the L2ToL1Tx logs
+// ArbSys emitted as it appended each to the send Merkle accumulator. The leaves are checked against
+// the accumulator in the state the message left, so its last message must be the accumulator's last leaf.
+func outboxMessages(statedb *state.StateDB, logs []*types.Log) []outboxMessage {
+	messages := []outboxMessage{}
+	for _, entry := range logs {
+		if entry.Address != types.ArbSysAddress || len(entry.Topics) == 0 || entry.Topics[0] != arbos.L2ToL1TxEventID {
+			continue
+		}
+		event, err := util.ParseL2ToL1TxLog(entry)
+		if err != nil {
+			log.Warn("failed to parse L2ToL1Tx log", "err", err)
+			continue
+		}
+		messages = append(messages, outboxMessage{
+			Caller:      event.Caller,
+			Destination: event.Destination,
+			CallValue:   (*hexutil.Big)(event.Callvalue),
+			Data:        event.Data,
+			ArbBlockNum: hexutil.Uint64(event.ArbBlockNum.Uint64()),
+			EthBlockNum: hexutil.Uint64(event.EthBlockNum.Uint64()),
+			Timestamp:   hexutil.Uint64(event.Timestamp.Uint64()),
+			LeafHash:    common.BigToHash(event.Hash),
+			LeafIndex:   hexutil.Uint64(event.Position.Uint64()),
+		})
+	}
+	if len(messages) == 0 {
+		return messages
+	}
+	arbState, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		log.Warn("failed to open ArbOS state for outbox tracing", "err", err)
+		return messages
+	}
+	size, err := arbState.SendMerkleAccumulator().Size()
+	if err != nil {
+		log.Warn("failed to read the send Merkle accumulator's size", "err", err)
+		return messages
+	}
+	if last := messages[len(messages)-1].LeafIndex; uint64(last)+1 != size {
+		log.Warn("L2ToL1Tx logs disagree with the send Merkle accumulator", "lastLeaf", last, "size", size)
+	}
+	return messages
+}
@@ -0,0 +1,232 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbos/util"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
+)
+
+var messageDeliveredID common.Hash
+var inboxMessageDeliveredID common.Hash
+var inboxMessageFromOriginID common.Hash
+var l2MessageFromOriginCallABI abi.Method
+
+func init() {
+	parsedIBridgeABI, err := bridgegen.IBridgeMetaData.GetAbi()
+	if err != nil {
+		panic(err)
+	}
+	messageDeliveredID = parsedIBridgeABI.Events["MessageDelivered"].ID
+
+	parsedIMessageProviderABI, err := bridgegen.IDelayedMessageProviderMetaData.GetAbi()
+	if err != nil {
+		panic(err)
+	}
+	inboxMessageDeliveredID = parsedIMessageProviderABI.Events["InboxMessageDelivered"].ID
+	inboxMessageFromOriginID = parsedIMessageProviderABI.Events["InboxMessageDeliveredFromOrigin"].ID
+
+	parsedIInboxABI, err := bridgegen.IInboxMetaData.GetAbi()
+	if err != nil {
+		panic(err)
+	}
+	l2MessageFromOriginCallABI = parsedIInboxABI.Methods["sendL2MessageFromOrigin"]
+}
+
+// parentChainTxTraces lists the transactions created by the delayed messages a parent chain transaction submitted.
+type parentChainTxTraces struct {
+	// false until every message has been read into a block, in which case no transactions are listed
+	Processed    bool                 `json:"processed"`
+	Transactions []parentChainTxTrace `json:"transactions"`
+}
+
+type parentChainTxTrace struct {
+	MessageIndex    hexutil.Uint64 `json:"messageIndex"`
+	TransactionHash common.Hash    `json:"transactionHash"`
+	Trace           []traceFrame   `json:"trace"`
+}
+
+// ByParentChainTx traces the transactions created by a parent chain transaction's submissions to the
+// delayed inbox, such as deposits and retryables, followed by any redeems ArbOS scheduled for them.
+// Messages the parent chain transaction delivered to other chains' bridges create no transactions here.
+func (api *ArbTraceAPI) ByParentChainTx(ctx context.Context, l1TxHash common.Hash) (*parentChainTxTraces, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_byParentChainTx", "parentChainTx", l1TxHash)
+	defer done()
+	if err := api.config().checkUnrestricted("arbtrace_byParentChainTx"); err != nil {
+		return nil, err
+	}
+	if api.parentChain == nil {
+		return nil, errors.New("arbtrace_byParentChainTx requires a parent chain connection")
+	}
+	receipt, err := api.parentChain.TransactionReceipt(ctx, l1TxHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, fmt.Errorf("parent chain transaction %v not found", l1TxHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages, err := api.deliveredMessages(ctx, receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	// each block's nonce is the number of delayed messages read by the end of it
+	delayedMessagesRead := api.blockchain.CurrentBlock().Nonce.Uint64()
+	for _, message := range messages {
+		if message.Header.RequestId.Big().Uint64() >= delayedMessagesRead {
+			return &parentChainTxTraces{Processed: false, Transactions: []parentChainTxTrace{}}, nil
+		}
+	}
+
+	result := &parentChainTxTraces{Processed: true, Transactions: []parentChainTxTrace{}}
+	chainId := api.blockchain.Config().ChainID
+	for _, message := range messages {
+		messageIndex := hexutil.Uint64(message.Header.RequestId.Big().Uint64())
+		txs, err := arbos.ParseL2Transactions(message, chainId, nil)
+		if err != nil {
+			// ArbOS skips messages it can't parse, which create no transactions
+			log.Debug("skipping unparseable delayed message", "index", messageIndex, "err", err)
+			continue
+		}
+		for _, expected := range txs {
+			// signed transactions may have been rejected, and messages for other chains never match
+			tx, block, index := api.transactionByHash(expected.Hash().Bytes())
+			if tx == nil {
+				continue
+			}
+			traced, err := api.parentChainTxTrace(ctx, tx, block, index, messageIndex)
+			if err != nil {
+				return nil, err
+			}
+			result.Transactions = append(result.Transactions, *traced)
+			if tx.Type() != types.ArbitrumSubmitRetryableTxType {
+				continue
+			}
+			receipts := api.blockchain.GetReceiptsByHash(block.Hash())
+			if index >= uint64(len(receipts)) {
+				return nil, fmt.Errorf("receipt of transaction %v not found", tx.Hash())
+			}
+			for _, entry := range receipts[index].Logs {
+				if entry.Address != types.ArbRetryableTxAddress || len(entry.Topics) == 0 || entry.Topics[0] != arbos.RedeemScheduledEventID {
+					continue
+				}
+				event, err := util.ParseRedeemScheduledLog(entry)
+				if err != nil {
+					return nil, err
+				}
+				retryTx, retryBlock, retryIndex := api.transactionByHash(event.RetryTxHash[:])
+				if retryTx == nil {
+					continue
+				}
+				traced, err := api.parentChainTxTrace(ctx, retryTx, retryBlock, retryIndex, messageIndex)
+				if err != nil {
+					return nil, err
+				}
+				result.Transactions = append(result.Transactions, *traced)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (api *ArbTraceAPI) parentChainTxTrace(ctx context.Context, tx *types.Transaction, block *types.Block, index uint64, messageIndex hexutil.Uint64) (*parentChainTxTrace, error) {
+	result, err := api.replayTransaction(ctx, tx, block, index, newTraceTypeSet([]string{traceTypeTrace}))
+	if err != nil {
+		return nil, err
+	}
+	return &parentChainTxTrace{
+		MessageIndex:    messageIndex,
+		TransactionHash: tx.Hash(),
+		Trace:           locateFrames(result.Trace, block, tx.Hash(), index),
+	}, nil
+}
+
+// deliveredMessages reconstructs the delayed messages delivered by a parent chain transaction from the
+// bridge and inbox logs it emitted. Batch posting reports are skipped, as they describe sequencer batches.
+// The messages' parent chain block numbers are left unset, since the transactions they create don't depend on them.
+func (api *ArbTraceAPI) deliveredMessages(ctx context.Context, receipt *types.Receipt) ([]*arbostypes.L1IncomingMessage, error) {
+	var messages []*arbostypes.L1IncomingMessage
+	for _, ethLog := range receipt.Logs {
+		if len(ethLog.Topics) == 0 || ethLog.Topics[0] != messageDeliveredID {
+			continue
+		}
+		bridge, err := bridgegen.NewIBridgeFilterer(ethLog.Address, nil)
+		if err != nil {
+			return nil, err
+		}
+		delivered, err := bridge.ParseMessageDelivered(*ethLog)
+		if err != nil {
+			return nil, err
+		}
+		if delivered.Kind == arbostypes.L1MessageType_BatchPostingReport {
+			continue
+		}
+		requestId := common.BigToHash(delivered.MessageIndex)
+		data, err := api.deliveredMessageData(ctx, receipt, delivered.Inbox, requestId)
+		if err != nil {
+			return nil, err
+		}
+		if crypto.Keccak256Hash(data) != delivered.MessageDataHash {
+			return nil, fmt.Errorf("found message %v data with mismatched hash", delivered.MessageIndex)
+		}
+		messages = append(messages, &arbostypes.L1IncomingMessage{
+			Header: &arbostypes.L1IncomingMessageHeader{
+				Kind:      delivered.Kind,
+				Poster:    delivered.Sender,
+				Timestamp: delivered.Timestamp,
+				RequestId: &requestId,
+				L1BaseFee: delivered.BaseFeeL1,
+			},
+			L2msg: data,
+		})
+	}
+	return messages, nil
+}
+
+// deliveredMessageData finds the data of a delayed message among the logs its inbox emitted.
+func (api *ArbTraceAPI) deliveredMessageData(ctx context.Context, receipt *types.Receipt, inbox common.Address, requestId common.Hash) ([]byte, error) {
+	for _, ethLog := range receipt.Logs {
+		if ethLog.Address != inbox || len(ethLog.Topics) < 2 || ethLog.Topics[1] != requestId {
+			continue
+		}
+		provider, err := bridgegen.NewIDelayedMessageProviderFilterer(ethLog.Address, nil)
+		if err != nil {
+			return nil, err
+		}
+		switch ethLog.Topics[0] {
+		case inboxMessageDeliveredID:
+			parsedLog, err := provider.ParseInboxMessageDelivered(*ethLog)
+			if err != nil {
+				return nil, err
+			}
+			return parsedLog.Data, nil
+		case inboxMessageFromOriginID:
+			// the message is the calldata of the parent chain transaction
+			data, err := arbutil.GetLogEmitterTxData(ctx, api.parentChain, *ethLog)
+			if err != nil {
+				return nil, err
+			}
+			args := make(map[string]interface{})
+			if err := l2MessageFromOriginCallABI.Inputs.UnpackIntoMap(args, data[4:]); err != nil {
+				return nil, err
+			}
+			return args["messageData"].([]byte), nil
+		}
+	}
+	return nil, fmt.Errorf("data of message %v not found", requestId.Big())
+}
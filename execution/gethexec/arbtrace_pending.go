@@ -0,0 +1,73 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// pendingTxSource provides the transactions a sequencer has yet to sequence.
+type pendingTxSource interface {
+	PendingTransactions() []*types.Transaction
+}
+
+// isPending reports whether a block reference asks for the pending block.
+func isPending(blockNrOrHash rpc.BlockNumberOrHash) bool {
+	number, isNumber := blockNrOrHash.Number()
+	return isNumber && number == rpc.PendingBlockNumber
+}
+
+// pendingHeader returns the header of the block the sequencer would build on top of latest.
+// ArbOS's per-block updates, such as the parent chain block number and pricing, are left as they were.
+func pendingHeader(latest *types.Header) *types.Header {
+	header := types.CopyHeader(latest)
+	header.ParentHash = latest.Hash()
+	header.Number = new(big.Int).Add(latest.Number, common.Big1)
+	header.Time = arbmath.MaxInt(latest.Time, uint64(time.Now().Unix()))
+	header.GasUsed = 0
+	return header
+}
+
+// applyPending applies the sequencer's pending transactions in the order they were submitted, skipping any
+// that can't be applied, such as those with nonce gaps. The result only approximates the block the sequencer
+// will build, which may order the transactions differently, reject some, or include ones submitted meanwhile,
+// and is outdated as soon as the transactions are sequenced. Nodes that aren't sequencers have no pending
+// transactions, so their pending state is the latest block's.
+func (api *ArbTraceAPI) applyPending(ctx context.Context, header *types.Header, statedb *state.StateDB) error {
+	if api.pendingTxs == nil {
+		return nil
+	}
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	for i, tx := range api.pendingTxs.PendingTransactions() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			log.Debug("skipping invalid pending transaction", "tx", tx.Hash(), "err", err)
+			continue
+		}
+		snapshot := statedb.Snapshot()
+		statedb.SetTxContext(tx.Hash(), i)
+		if err := api.applyMessage(ctx, msg, header, blockCtx, statedb, nil); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Debug("skipping pending transaction that can't be applied", "tx", tx.Hash(), "err", err)
+			statedb.RevertToSnapshot(snapshot)
+		}
+	}
+	return nil
+}
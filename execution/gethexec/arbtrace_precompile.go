@@ -0,0 +1,113 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/offchainlabs/nitro/solgen/go/precompilesgen"
+)
+
+// arbosPrecompile is an ArbOS precompile whose calls the tracer decodes.
+type arbosPrecompile struct {
+	name string
+	abi  *abi.ABI
+}
+
+var arbosPrecompiles map[common.Address]arbosPrecompile
+
+func init() {
+	metadata := map[common.Address]struct {
+		name     string
+		metadata *bind.MetaData
+	}{
+		types.ArbSysAddress:           {"ArbSys", precompilesgen.ArbSysMetaData},
+		types.ArbInfoAddress:          {"ArbInfo", precompilesgen.ArbInfoMetaData},
+		types.ArbAddressTableAddress:  {"ArbAddressTable", precompilesgen.ArbAddressTableMetaData},
+		types.ArbBLSAddress:           {"ArbBLS", precompilesgen.ArbBLSMetaData},
+		types.ArbFunctionTableAddress: {"ArbFunctionTable", precompilesgen.ArbFunctionTableMetaData},
+		types.ArbosTestAddress:        {"ArbosTest", precompilesgen.ArbosTestMetaData},
+		types.ArbGasInfoAddress:       {"ArbGasInfo", precompilesgen.ArbGasInfoMetaData},
+		types.ArbAggregatorAddress:    {"ArbAggregator", precompilesgen.ArbAggregatorMetaData},
+		types.ArbStatisticsAddress:    {"ArbStatistics", precompilesgen.ArbStatisticsMetaData},
+		types.ArbOwnerPublicAddress:   {"ArbOwnerPublic", precompilesgen.ArbOwnerPublicMetaData},
+		types.ArbWasmAddress:          {"ArbWasm", precompilesgen.ArbWasmMetaData},
+		types.ArbWasmCacheAddress:     {"ArbWasmCache", precompilesgen.ArbWasmCacheMetaData},
+		types.ArbRetryableTxAddress:   {"ArbRetryableTx", precompilesgen.ArbRetryableTxMetaData},
+		types.ArbOwnerAddress:         {"ArbOwner", precompilesgen.ArbOwnerMetaData},
+		types.ArbDebugAddress:         {"ArbDebug", precompilesgen.ArbDebugMetaData},
+		types.ArbosAddress:            {"ArbosActs", precompilesgen.ArbosActsMetaData},
+	}
+	arbosPrecompiles = make(map[common.Address]arbosPrecompile, len(metadata))
+	for address, precompile := range metadata {
+		parsed, err := precompile.metadata.GetAbi()
+		if err != nil {
+			panic(err)
+		}
+		arbosPrecompiles[address] = arbosPrecompile{precompile.name, parsed}
+	}
+}
+
+// precompileCall describes a call to an ArbOS precompile, decoded with its ABI.
+type precompileCall struct {
+	Name   string                 `json:"name"`
+	Method string                 `json:"method,omitempty"`
+	Args   map[string]interface{} `json:"args,omitempty"`
+
+	// the address an ArbAddressTable call's index referred to
+	AddressTable *addressTableEntry `json:"addressTable,omitempty"`
+}
+
+// decodePrecompileCall decodes a call to an ArbOS precompile, returning nil for calls to other accounts.
+// The method is left unset for calldata that doesn't select one of the precompile's methods, and the
+// arguments for calldata they can't be decoded from, as such calls fail without reaching ArbOS.
+func decodePrecompileCall(to *common.Address, input *hexutil.Bytes) *precompileCall {
+	if to == nil {
+		return nil
+	}
+	precompile, ok := arbosPrecompiles[*to]
+	if !ok {
+		return nil
+	}
+	call := &precompileCall{Name: precompile.name}
+	if input == nil || len(*input) < 4 {
+		return call
+	}
+	method, err := precompile.abi.MethodById((*input)[:4])
+	if err != nil {
+		return call
+	}
+	call.Method = method.Sig
+	args := make(map[string]interface{})
+	if err := method.Inputs.UnpackIntoMap(args, (*input)[4:]); err != nil {
+		return call
+	}
+	for name, value := range args {
+		args[name] = precompileArgJSON(value)
+	}
+	call.Args = args
+	return call
+}
+
+// precompileArgJSON converts a decoded argument to the hex encodings used throughout traces.
+func precompileArgJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *big.Int:
+		return (*hexutil.Big)(value)
+	case []byte:
+		return hexutil.Bytes(value)
+	case [32]byte:
+		return common.Hash(value)
+	case [4]byte:
+		return hexutil.Bytes(value[:])
+	case uint64:
+		return hexutil.Uint64(value)
+	}
+	return value
+}
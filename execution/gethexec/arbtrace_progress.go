@@ -0,0 +1,71 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrStaleProgressToken is returned when resuming an arbtrace_blockRange from a block that's no longer canonical.
+var ErrStaleProgressToken = errors.New("progress token is no longer canonical")
+
+// rangeProgress marks the last block an arbtrace_blockRange traced, letting an interrupted
+// backfill resume after it rather than starting the range over.
+type rangeProgress struct {
+	BlockNumber uint64      `json:"blockNumber"`
+	BlockHash   common.Hash `json:"blockHash"`
+}
+
+func (p *rangeProgress) encode() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeRangeProgress(encoded string) (*rangeProgress, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid progress token: %w", err)
+	}
+	progress := &rangeProgress{}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, fmt.Errorf("invalid progress token: %w", err)
+	}
+	return progress, nil
+}
+
+// resumeFrom returns the block a range resumes from given a progress token, which must still be canonical.
+func (api *ArbTraceAPI) resumeFrom(fromBlock uint64, token string) (uint64, error) {
+	progress, err := decodeRangeProgress(token)
+	if err != nil {
+		return 0, err
+	}
+	if api.blockchain.GetCanonicalHash(progress.BlockNumber) != progress.BlockHash {
+		return 0, fmt.Errorf("%w: block %v (%v) has been reorged, resume from an earlier block", ErrStaleProgressToken, progress.BlockNumber, progress.BlockHash)
+	}
+	if progress.BlockNumber >= fromBlock {
+		return progress.BlockNumber + 1, nil
+	}
+	return fromBlock, nil
+}
+
+// setProgressTokens marks each block of a range with the token resuming after it.
+func setProgressTokens(blocks []*blockTraces) error {
+	for _, block := range blocks {
+		progress := &rangeProgress{BlockNumber: uint64(block.BlockNumber), BlockHash: block.BlockHash}
+		token, err := progress.encode()
+		if err != nil {
+			return err
+		}
+		block.ProgressToken = &token
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type traceRequestIDKey struct{}
+
+// startTraceRequest assigns a request an ID, logging its start along with the given key/value pairs,
+// and returns a context carrying the ID to everything the request does, including forwarding it to the
+// classic node. The returned function logs the request's end and records its duration, and must be called once it's done.
+func startTraceRequest(ctx context.Context, method string, ctxKeyvals ...interface{}) (context.Context, func()) {
+	id := rpc.NewID()
+	ctx = context.WithValue(ctx, traceRequestIDKey{}, id)
+	keyvals := append([]interface{}{"id", id, "method", method}, ctxKeyvals...)
+	log.Debug("arbtrace request started", keyvals...)
+	start := time.Now()
+	return ctx, func() {
+		elapsed := time.Since(start)
+		recordTraceRequest(method, elapsed)
+		log.Debug("arbtrace request finished", append(keyvals, "elapsed", elapsed)...)
+	}
+}
+
+// traceRequestID returns the ID of the request a context belongs to, if it was assigned one.
+func traceRequestID(ctx context.Context) (rpc.ID, bool) {
+	id, ok := ctx.Value(traceRequestIDKey{}).(rpc.ID)
+	return id, ok
+}
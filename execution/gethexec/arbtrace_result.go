@@ -0,0 +1,116 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// txOutcome is the outcome of a transaction's top-level frame, reported by arbtrace_result. Its gasUsed
+// is the transaction's, as its receipt reports, rather than the frame's, which excludes intrinsic gas.
+type txOutcome struct {
+	Success      bool            `json:"success"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	Output       hexutil.Bytes   `json:"output"`
+	Address      *common.Address `json:"address,omitempty"`
+	Error        *string         `json:"error,omitempty"`
+	RevertReason *string         `json:"revertReason,omitempty"`
+	ErrorReason  *string         `json:"errorReason,omitempty"`
+}
+
+func newTxOutcome(msg *core.Message, res *core.ExecutionResult) *txOutcome {
+	outcome := &txOutcome{
+		Success: res.Err == nil,
+		GasUsed: hexutil.Uint64(res.UsedGas),
+		Output:  common.CopyBytes(res.ReturnData),
+	}
+	if outcome.Output == nil {
+		outcome.Output = hexutil.Bytes{}
+	}
+	if res.Err == nil {
+		if msg.To == nil {
+			created := crypto.CreateAddress(msg.From, msg.Nonce)
+			outcome.Address = &created
+		}
+		return outcome
+	}
+	message := parityErrorString(res.Err)
+	outcome.Error = &message
+	if errors.Is(res.Err, vm.ErrExecutionReverted) {
+		if reason, err := abi.UnpackRevert(res.ReturnData); err == nil {
+			outcome.RevertReason = &reason
+		}
+		reason := revertErrorReason(res.ReturnData)
+		outcome.ErrorReason = &reason
+	}
+	return outcome
+}
+
+// Result returns only the outcome of a transaction, which it learns by re-executing the transaction without a
+// tracer, skipping the work of recording its subcalls. This makes it much cheaper than arbtrace_transaction when
+// all that's needed is whether the transaction succeeded. The transactions before it in its block are re-executed
+// untraced too, as with every replay.
+func (api *ArbTraceAPI) Result(ctx context.Context, txHash hexutil.Bytes) (*txOutcome, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_result", "tx", txHash)
+	defer done()
+	tx, block, index := api.transactionByHash(txHash)
+	if tx == nil {
+		return nil, errors.New("arbtrace_result doesn't support classic history")
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	statedb, header, blockCtx, msg, release, err := api.stateAtTransaction(ctx, block, index)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	res, _, err := api.applyTimedMessage(ctx, msg, header, blockCtx, statedb, nil)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+	}
+	return newTxOutcome(msg, res), nil
+}
+
+// applyTimedMessage executes a message with the given tracer, which may be nil, returning the EVM it executed
+// in for reading what ArbOS charged. As with tracing, only the message itself is held to the trace timeout,
+// not the replay of the state it executes on.
+func (api *ArbTraceAPI) applyTimedMessage(
+	ctx context.Context,
+	msg *core.Message,
+	header *types.Header,
+	blockCtx vm.BlockContext,
+	statedb *state.StateDB,
+	tracer vm.EVMLogger,
+) (*core.ExecutionResult, *vm.EVM, error) {
+	execCtx := ctx
+	timeout := api.config().TraceTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	evm := api.backend.GetEVM(execCtx, msg, statedb, header, &vm.Config{Tracer: tracer}, &blockCtx)
+	defer cancelOnDone(execCtx, evm)()
+	res, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+	if evm.Cancelled() {
+		if ctx.Err() == nil {
+			return nil, nil, fmt.Errorf("%w after %v", ErrTraceTimeout, timeout)
+		}
+		return nil, nil, ctx.Err()
+	}
+	return res, evm, err
+}
@@ -0,0 +1,288 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+	"github.com/offchainlabs/nitro/arbos/retryables"
+	"github.com/offchainlabs/nitro/arbos/util"
+)
+
+const (
+	retryableFrameCreate         = "create"
+	retryableFrameScheduleRedeem = "scheduleRedeem"
+	retryableFrameRedeem         = "redeem"
+)
+
+const (
+	// redeems ArbOS scheduled when the ticket was submitted
+	redeemTypeAuto = "auto"
+	// redeems scheduled by a call to ArbRetryableTx's redeem method
+	redeemTypeManual = "manual"
+)
+
+// manualRedeemMaxRefund is the refund limit of manually scheduled redeems, which are refunded in full.
+var manualRedeemMaxRefund = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 256), common.Big1)
+
+// retryableFrame describes a step of a retryable ticket's lifecycle taken by a transaction.
+type retryableFrame struct {
+	Type     string      `json:"type"`
+	TicketId common.Hash `json:"ticketId"`
+
+	// the ticket's parameters, for creations
+	Beneficiary       *common.Address `json:"beneficiary,omitempty"`
+	CallValue         *hexutil.Big    `json:"callValue,omitempty"`
+	MaxSubmissionCost *hexutil.Big    `json:"maxSubmissionCost,omitempty"`
+	Timeout           *hexutil.Uint64 `json:"timeout,omitempty"`
+
+	// the redeem attempt, for scheduled redeems and redemptions
+	RedeemType  string          `json:"redeemType,omitempty"`
+	RetryTxHash *common.Hash    `json:"retryTxHash,omitempty"`
+	SequenceNum *hexutil.Uint64 `json:"sequenceNum,omitempty"`
+	DonatedGas  *hexutil.Uint64 `json:"donatedGas,omitempty"`
+
+	// the outcome, for redemptions
+	Success      *bool `json:"success,omitempty"`
+	TicketClosed *bool `json:"ticketClosed,omitempty"`
+}
+
+// submitRetryableAction holds the parameters of a retryable's submission, as its transaction carries them.
+type submitRetryableAction struct {
+	RequestId        common.Hash     `json:"requestId"`
+	L1BaseFee        *hexutil.Big    `json:"l1BaseFee"`
+	DepositValue     *hexutil.Big    `json:"depositValue"`
+	GasFeeCap        *hexutil.Big    `json:"gasFeeCap"`
+	Gas              hexutil.Uint64  `json:"gas"`
+	RetryTo          *common.Address `json:"retryTo"`
+	RetryValue       *hexutil.Big    `json:"retryValue"`
+	Beneficiary      common.Address  `json:"beneficiary"`
+	MaxSubmissionFee *hexutil.Big    `json:"maxSubmissionFee"`
+	FeeRefundAddr    common.Address  `json:"feeRefundAddr"`
+	RetryData        hexutil.Bytes   `json:"retryData"`
+}
+
+// annotateSubmitRetryable describes a retryable's submission in its top-level frame, which ArbOS otherwise
+// shows as an opaque call from the submitter to ArbRetryableTx.
+func annotateSubmitRetryable(msg *core.Message, frames []traceFrame) {
+	if msg.Tx == nil || len(frames) == 0 {
+		return
+	}
+	inner, ok := msg.Tx.GetInner().(*types.ArbitrumSubmitRetryableTx)
+	if !ok {
+		return
+	}
+	frames[0].Action.SubmitRetryable = &submitRetryableAction{
+		RequestId:        inner.RequestId,
+		L1BaseFee:        (*hexutil.Big)(new(big.Int).Set(inner.L1BaseFee)),
+		DepositValue:     (*hexutil.Big)(new(big.Int).Set(inner.DepositValue)),
+		GasFeeCap:        (*hexutil.Big)(new(big.Int).Set(inner.GasFeeCap)),
+		Gas:              hexutil.Uint64(inner.Gas),
+		RetryTo:          inner.RetryTo,
+		RetryValue:       (*hexutil.Big)(new(big.Int).Set(inner.RetryValue)),
+		Beneficiary:      inner.Beneficiary,
+		MaxSubmissionFee: (*hexutil.Big)(new(big.Int).Set(inner.MaxSubmissionFee)),
+		FeeRefundAddr:    inner.FeeRefundAddr,
+		RetryData:        common.CopyBytes(inner.RetryData),
+	}
+}
+
+// retryableFrames describes the retryable tickets a message created, scheduled redeems of, or redeemed,
+// reading the tickets from the state the message left and the redeems from the logs it emitted.
+// Whether a redemption was scheduled automatically is inferred from its refund limit.
+func retryableFrames(msg *core.Message, statedb *state.StateDB, logs []*types.Log, execErr error, timestamp uint64) []retryableFrame {
+	frames := []retryableFrame{}
+	arbState, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		log.Warn("failed to open ArbOS state for retryable tracing", "err", err)
+		return frames
+	}
+	retryableState := arbState.RetryableState()
+	openTicket := func(ticketId common.Hash) *retryables.Retryable {
+		ticket, err := retryableState.OpenRetryable(ticketId, timestamp)
+		if err != nil {
+			return nil
+		}
+		return ticket
+	}
+
+	submitted := false
+	if msg.Tx != nil {
+		switch inner := msg.Tx.GetInner().(type) {
+		case *types.ArbitrumSubmitRetryableTx:
+			submitted = true
+			ticketId := msg.Tx.Hash()
+			// tickets whose submission failed were never created
+			if ticket := openTicket(ticketId); ticket != nil {
+				frame := retryableFrame{
+					Type:              retryableFrameCreate,
+					TicketId:          ticketId,
+					Beneficiary:       &inner.Beneficiary,
+					CallValue:         (*hexutil.Big)(new(big.Int).Set(inner.RetryValue)),
+					MaxSubmissionCost: (*hexutil.Big)(new(big.Int).Set(inner.MaxSubmissionFee)),
+				}
+				if timeout, err := ticket.CalculateTimeout(); err == nil {
+					frame.Timeout = (*hexutil.Uint64)(&timeout)
+				}
+				frames = append(frames, frame)
+			}
+		case *types.ArbitrumRetryTx:
+			redeemType := redeemTypeAuto
+			if inner.MaxRefund.Cmp(manualRedeemMaxRefund) == 0 {
+				redeemType = redeemTypeManual
+			}
+			txHash := msg.Tx.Hash()
+			sequenceNum := hexutil.Uint64(inner.Nonce)
+			success := execErr == nil
+			closed := openTicket(inner.TicketId) == nil
+			frames = append(frames, retryableFrame{
+				Type:         retryableFrameRedeem,
+				TicketId:     inner.TicketId,
+				RedeemType:   redeemType,
+				RetryTxHash:  &txHash,
+				SequenceNum:  &sequenceNum,
+				Success:      &success,
+				TicketClosed: &closed,
+			})
+		}
+	}
+
+	for _, entry := range logs {
+		if entry.Address != types.ArbRetryableTxAddress || len(entry.Topics) == 0 || entry.Topics[0] != arbos.RedeemScheduledEventID {
+			continue
+		}
+		event, err := util.ParseRedeemScheduledLog(entry)
+		if err != nil {
+			log.Warn("failed to parse RedeemScheduled log", "err", err)
+			continue
+		}
+		redeemType := redeemTypeManual
+		if submitted {
+			redeemType = redeemTypeAuto
+		}
+		retryTxHash := common.Hash(event.RetryTxHash)
+		sequenceNum := hexutil.Uint64(event.SequenceNum)
+		donatedGas := hexutil.Uint64(event.DonatedGas)
+		frames = append(frames, retryableFrame{
+			Type:        retryableFrameScheduleRedeem,
+			TicketId:    event.TicketId,
+			RedeemType:  redeemType,
+			RetryTxHash: &retryTxHash,
+			SequenceNum: &sequenceNum,
+			DonatedGas:  &donatedGas,
+		})
+	}
+	return frames
+}
+
+var (
+	// ErrRetryableNotFound is returned when simulating the redeem of a retryable ticket that doesn't exist,
+	// which includes tickets that were already redeemed.
+	ErrRetryableNotFound = errors.New("retryable ticket not found")
+	// ErrRetryableExpired is returned when simulating the redeem of a retryable ticket past its timeout.
+	ErrRetryableExpired = errors.New("retryable ticket expired")
+)
+
+// redeemSimulation is the outcome of redeeming a retryable ticket without committing to the redeem.
+type redeemSimulation struct {
+	TicketId     common.Hash   `json:"ticketId"`
+	Success      bool          `json:"success"`
+	Error        *string       `json:"error,omitempty"`
+	RevertReason *string       `json:"revertReason,omitempty"`
+	Output       hexutil.Bytes `json:"output"`
+	Trace        []traceFrame  `json:"trace"`
+}
+
+// SimulateRedeem traces a redeem of a retryable ticket executed on top of the given block's state, as the
+// retry transaction a redeem scheduled there would be. The redeem is given the trace gas cap rather than
+// whatever gas a real redeem would donate, so success means the ticket can be redeemed with enough gas,
+// and the trace's gas used suggests how much. Refunds go to the ticket's beneficiary.
+func (api *ArbTraceAPI) SimulateRedeem(ctx context.Context, ticketId common.Hash, blockNum TraceBlockRef) (*redeemSimulation, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_simulateRedeem", "ticket", ticketId, "block", blockNum.String())
+	defer done()
+	block, err := api.blockByNumberOrHash(ctx, blockNum.BlockNumberOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("arbtrace_simulateRedeem doesn't support classic history")
+	}
+	statedb, header, err := api.backend.StateAndHeaderByNumberOrHash(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+	if err != nil {
+		return nil, err
+	}
+	arbState, err := arbosState.OpenSystemArbosState(statedb, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	retryableState := arbState.RetryableState()
+	ticket, err := retryableState.OpenRetryable(ticketId, header.Time)
+	if err != nil {
+		return nil, err
+	}
+	if ticket == nil {
+		// expired tickets remain in storage until they're reaped
+		if expired, err := retryableState.OpenRetryable(ticketId, 0); err == nil && expired != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRetryableExpired, ticketId)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrRetryableNotFound, ticketId)
+	}
+	nonce, err := ticket.NumTries()
+	if err != nil {
+		return nil, err
+	}
+	beneficiary, err := ticket.Beneficiary()
+	if err != nil {
+		return nil, err
+	}
+	gas := api.traceGasCap()
+	if gas == 0 {
+		gas = header.GasLimit
+	}
+	retryTx, err := ticket.MakeTx(api.blockchain.Config().ChainID, nonce, header.BaseFee, gas, ticketId, beneficiary, manualRedeemMaxRefund, common.Big0)
+	if err != nil {
+		return nil, err
+	}
+	tx := types.NewTx(retryTx)
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	signer := types.MakeSigner(api.blockchain.Config(), header.Number, header.Time)
+	msg, err := core.TransactionToMessage(tx, signer, header.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+	statedb.SetTxContext(tx.Hash(), 0)
+	blockCtx := core.NewEVMBlockContext(header, api.blockchain, nil)
+	result, err := api.traceMessage(ctx, msg, header, blockCtx, statedb, newTraceTypeSet([]string{traceTypeTrace}), false)
+	if err != nil {
+		return nil, err
+	}
+	simulation := &redeemSimulation{
+		TicketId: ticketId,
+		Success:  result.execErr == nil,
+		Output:   result.Output,
+		Trace:    result.Trace,
+	}
+	if result.execErr != nil {
+		message := parityErrorString(result.execErr)
+		simulation.Error = &message
+		if len(result.Trace) > 0 {
+			simulation.RevertReason = result.Trace[0].RevertReason
+		}
+	}
+	return simulation, nil
+}
@@ -0,0 +1,320 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// jsonSchema is the subset of JSON Schema that trace schemas are written in, all of which validateSchema checks.
+type jsonSchema struct {
+	Schema     string                 `json:"$schema,omitempty"`
+	Ref        string                 `json:"$ref,omitempty"`
+	Type       []string               `json:"type,omitempty"`
+	Enum       []interface{}          `json:"enum,omitempty"`
+	AnyOf      []*jsonSchema          `json:"anyOf,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	// either false, forbidding properties beyond those listed, or the schema they must match
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOverrides are the schemas of types marshalling themselves into shapes their fields don't describe.
+// Other such types, which come from outside the package, are left unconstrained.
+var schemaOverrides = map[reflect.Type]*jsonSchema{
+	reflect.TypeOf(diffValue{}): {AnyOf: []*jsonSchema{
+		{Type: []string{"string"}, Enum: []interface{}{diffSame}},
+		{Type: []string{"object"}},
+	}},
+}
+
+// schemaGenerator derives schemas from the types encoding/json marshals, collecting named structs
+// into definitions so they're described once and may refer to themselves.
+type schemaGenerator struct {
+	defs map[string]*jsonSchema
+}
+
+func generateSchema(t reflect.Type) *jsonSchema {
+	g := &schemaGenerator{defs: make(map[string]*jsonSchema)}
+	root := g.schemaOf(t)
+	root.Schema = "https://json-schema.org/draft/2020-12/schema"
+	root.Defs = g.defs
+	return root
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) *jsonSchema {
+	if override, ok := schemaOverrides[t]; ok {
+		return override
+	}
+	if t.Kind() == reflect.Pointer {
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: []string{"null"}}, g.schemaOf(t.Elem())}}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return &jsonSchema{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return &jsonSchema{Type: []string{"string"}}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: []string{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: []string{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: []string{"number"}}
+	case reflect.String:
+		return &jsonSchema{Type: []string{"string"}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoded in base64
+			return &jsonSchema{Type: []string{"string", "null"}}
+		}
+		return &jsonSchema{Type: []string{"array", "null"}, Items: g.schemaOf(t.Elem())}
+	case reflect.Array:
+		return &jsonSchema{Type: []string{"array"}, Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: []string{"object", "null"}, AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		ref := &jsonSchema{Ref: "#/$defs/" + t.Name()}
+		if _, ok := g.defs[t.Name()]; ok {
+			return ref
+		}
+		// defined before its fields are, so those referring back to it find it
+		def := &jsonSchema{}
+		g.defs[t.Name()] = def
+		*def = *g.structSchema(t)
+		return ref
+	default:
+		return &jsonSchema{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{
+		Type:                 []string{"object"},
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: false,
+	}
+	g.addFields(schema, t)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// addFields adds the properties a struct encodes to, including those of its embedded structs
+// that fields nearer the surface don't shadow, as encoding/json does.
+func (g *schemaGenerator) addFields(schema *jsonSchema, t reflect.Type) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			embedded = append(embedded, fieldType)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if _, ok := schema.Properties[name]; ok {
+			continue
+		}
+		schema.Properties[name] = g.schemaOf(field.Type)
+		if !strings.Contains(","+options+",", ",omitempty,") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	for _, inner := range embedded {
+		g.addFields(schema, inner)
+	}
+}
+
+var (
+	traceSchemasOnce  sync.Once
+	traceResultSchema *jsonSchema
+	traceFrameSchema  *jsonSchema
+)
+
+func traceSchemas() (*jsonSchema, *jsonSchema) {
+	traceSchemasOnce.Do(func() {
+		traceResultSchema = generateSchema(reflect.TypeOf(traceResultJSON{}))
+		traceFrameSchema = generateSchema(reflect.TypeOf(traceFrame{}))
+	})
+	return traceResultSchema, traceFrameSchema
+}
+
+// TraceResultSchema returns the JSON schema of the results the arbtrace replay and call methods return,
+// generated from the types they're encoded from, so consumers can pin the shape of responses.
+func TraceResultSchema() json.RawMessage {
+	schema, _ := traceSchemas()
+	encoded, err := json.Marshal(schema)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode the trace result schema: %v", err))
+	}
+	return encoded
+}
+
+// TraceFrameSchema returns the JSON schema of one of the frames the arbtrace methods return.
+func TraceFrameSchema() json.RawMessage {
+	_, schema := traceSchemas()
+	encoded, err := json.Marshal(schema)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode the trace frame schema: %v", err))
+	}
+	return encoded
+}
+
+// ValidateTraceResult checks that an encoded trace result matches TraceResultSchema,
+// returning an error naming the first field found to differ.
+func ValidateTraceResult(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid trace result: %w", err)
+	}
+	schema, _ := traceSchemas()
+	return validateSchema(schema, schema, value, "result")
+}
+
+func validateSchema(root, schema *jsonSchema, value interface{}, path string) error {
+	if schema.Ref != "" {
+		def, ok := root.Defs[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+		if !ok {
+			return fmt.Errorf("%v: unknown schema %v", path, schema.Ref)
+		}
+		return validateSchema(root, def, value, path)
+	}
+	if len(schema.AnyOf) > 0 {
+		var errs []string
+		for _, option := range schema.AnyOf {
+			err := validateSchema(root, option, value, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%v matches none of its schemas (%v)", path, strings.Join(errs, "; "))
+	}
+	if len(schema.Type) > 0 && !schemaTypeMatches(schema.Type, value) {
+		return fmt.Errorf("%v is %v rather than %v", path, jsonTypeOf(value), strings.Join(schema.Type, " or "))
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%v is %v, which isn't one of %v", path, value, schema.Enum)
+		}
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				return fmt.Errorf("%v is missing %v", path, name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fieldPath := path + "." + name
+			if property, ok := schema.Properties[name]; ok {
+				if err := validateSchema(root, property, value[name], fieldPath); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%v isn't in the schema", fieldPath)
+				}
+			case *jsonSchema:
+				if err := validateSchema(root, additional, value[name], fieldPath); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range value {
+				if err := validateSchema(root, schema.Items, item, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func schemaTypeMatches(allowed []string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, kind := range allowed {
+		if kind == actual || (kind == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf names the JSON Schema type of a value decoded with numbers preserved.
+func jsonTypeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		if !strings.ContainsAny(value.String(), ".eE") {
+			// too large for an int64, but still whole
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ErrStepOutOfRange is returned when asking for a step past the last a transaction executed,
+// or past the limit on the operations a vmTrace may hold.
+var ErrStepOutOfRange = errors.New("step out of range")
+
+// vmStep is the state of the EVM as an operation is about to execute. Steps are numbered in execution
+// order across every frame, which is the order a vmTrace lists its operations in, each call's operations
+// following the operation making it.
+type vmStep struct {
+	Step    hexutil.Uint64 `json:"step"`
+	Depth   int            `json:"depth"`
+	Address common.Address `json:"address"`
+	Pc      uint64         `json:"pc"`
+	Op      string         `json:"op"`
+	Gas     hexutil.Uint64 `json:"gas"`
+	GasCost hexutil.Uint64 `json:"gasCost"`
+	// from the bottom of the stack to its top
+	Stack  []*hexutil.Big `json:"stack"`
+	Memory hexutil.Bytes  `json:"memory"`
+	// the slots of the executing account read or written so far, with their values as of the step
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// stepCapture records the state of the EVM at a single step, tracking the storage
+// each account's operations touched until then.
+type stepCapture struct {
+	target   uint64
+	steps    uint64
+	storage  map[common.Address]map[common.Hash]common.Hash
+	snapshot *vmStep
+}
+
+func newStepCapture(target uint64) *stepCapture {
+	return &stepCapture{
+		target:  target,
+		storage: make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+func (c *stepCapture) step(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int) {
+	index := c.steps
+	c.steps++
+	if index > c.target {
+		return
+	}
+	address := scope.Contract.Address()
+	stack := scope.Stack.Data()
+	if (op == vm.SLOAD || op == vm.SSTORE) && len(stack) >= 1 {
+		slots, ok := c.storage[address]
+		if !ok {
+			slots = make(map[common.Hash]common.Hash)
+			c.storage[address] = slots
+		}
+		slot := common.Hash(stack[len(stack)-1].Bytes32())
+		if op == vm.SLOAD {
+			slots[slot] = env.StateDB.GetState(address, slot)
+		} else if len(stack) >= 2 {
+			// as with Geth's struct logger, a write is reported with the value it stores
+			slots[slot] = common.Hash(stack[len(stack)-2].Bytes32())
+		}
+	}
+	if index != c.target {
+		return
+	}
+	snapshot := &vmStep{
+		Step:    hexutil.Uint64(index),
+		Depth:   depth,
+		Address: address,
+		Pc:      pc,
+		Op:      op.String(),
+		Gas:     hexutil.Uint64(gas),
+		GasCost: hexutil.Uint64(cost),
+		Stack:   make([]*hexutil.Big, len(stack)),
+		Memory:  common.CopyBytes(scope.Memory.Data()),
+		Storage: make(map[common.Hash]common.Hash, len(c.storage[address])),
+	}
+	if snapshot.Memory == nil {
+		snapshot.Memory = hexutil.Bytes{}
+	}
+	for i := range stack {
+		snapshot.Stack[i] = uint256ToHex(&stack[i])
+	}
+	for slot, value := range c.storage[address] {
+		snapshot.Storage[slot] = value
+	}
+	c.snapshot = snapshot
+}
+
+// GetStep returns the state of the EVM at a single step of a transaction, as a debugger would show it when
+// stopped there, without transferring the transaction's entire vmTrace. The transaction is re-executed in
+// full to count its steps, so that asking for a step past its last is an error naming how many it took.
+func (api *ArbTraceAPI) GetStep(ctx context.Context, txHash hexutil.Bytes, step hexutil.Uint64) (*vmStep, error) {
+	ctx, done := startTraceRequest(ctx, "arbtrace_getStep", "tx", txHash, "step", uint64(step))
+	defer done()
+	if maxSteps := api.config().VmTraceMaxSteps; maxSteps > 0 && uint64(step) >= uint64(maxSteps) {
+		return nil, fmt.Errorf("%w: step %v exceeds the limit of %v a vmTrace may hold", ErrStepOutOfRange, step, maxSteps)
+	}
+	tx, block, index := api.transactionByHash(txHash)
+	if tx == nil {
+		return nil, errors.New("arbtrace_getStep doesn't support classic history")
+	}
+	if err := api.config().checkTraceAllowed(tx.To()); err != nil {
+		return nil, err
+	}
+	statedb, header, blockCtx, msg, release, err := api.stateAtTransaction(ctx, block, index)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	tracer := newParityTracer(traceTypeSet{}, api.config().MaxFrames)
+	tracer.stepCapture = newStepCapture(uint64(step))
+	if _, _, err := api.applyTimedMessage(ctx, msg, header, blockCtx, statedb, tracer); err != nil {
+		return nil, fmt.Errorf("transaction %v: %w", tx.Hash(), err)
+	}
+	if tracer.err != nil {
+		return nil, tracer.err
+	}
+	if tracer.stepCapture.snapshot == nil {
+		return nil, fmt.Errorf("%w: transaction %v executed %v steps", ErrStepOutOfRange, tx.Hash(), tracer.stepCapture.steps)
+	}
+	return tracer.stepCapture.snapshot, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// traceSummary rolls a transaction's call tree up into a few totals, for callers that don't need its frames.
+type traceSummary struct {
+	Frames hexutil.Uint64 `json:"frames"`
+	// the length of the longest trace address, which is 0 for transactions that make no subcalls
+	MaxDepth hexutil.Uint64 `json:"maxDepth"`
+	// the distinct accounts that made, received, or were created by a call
+	AddressesTouched hexutil.Uint64 `json:"addressesTouched"`
+	// the value transferred by calls, creations, and self-destructs, excluding delegatecalls, which
+	// reuse their caller's value, and callcodes, which transfer to themselves
+	ValueMoved *hexutil.Big   `json:"valueMoved"`
+	GasUsed    hexutil.Uint64 `json:"gasUsed"`
+	// whether any frame failed, whether or not the transaction as a whole did
+	Reverted bool `json:"reverted"`
+}
+
+// summary rolls up the call tree the tracer recorded.
+func (t *parityTracer) summary(gasUsed uint64) *traceSummary {
+	summary := &traceSummary{GasUsed: hexutil.Uint64(gasUsed)}
+	value := new(big.Int)
+	addresses := make(map[common.Address]struct{})
+	if t.root != nil {
+		summarizeParityCall(t.root, 0, summary, value, addresses)
+	}
+	summary.AddressesTouched = hexutil.Uint64(len(addresses))
+	summary.ValueMoved = (*hexutil.Big)(value)
+	return summary
+}
+
+func summarizeParityCall(call *parityCall, depth uint64, summary *traceSummary, value *big.Int, addresses map[common.Address]struct{}) {
+	summary.Frames++
+	if depth > uint64(summary.MaxDepth) {
+		summary.MaxDepth = hexutil.Uint64(depth)
+	}
+	if call.err != nil {
+		summary.Reverted = true
+	}
+	action := call.action
+	for _, addr := range []*common.Address{action.From, action.To, action.Address, action.RefundAddress} {
+		if addr != nil {
+			addresses[*addr] = struct{}{}
+		}
+	}
+	switch call.frameType {
+	case frameTypeCreate:
+		addresses[call.created] = struct{}{}
+		value.Add(value, action.Value.ToInt())
+	case frameTypeSuicide:
+		value.Add(value, action.Balance.ToInt())
+	case frameTypeCall:
+		if action.CallType == "call" && action.Value != nil {
+			value.Add(value, action.Value.ToInt())
+		}
+	}
+	for _, sub := range call.calls {
+		summarizeParityCall(sub, depth+1, summary, value, addresses)
+	}
+}
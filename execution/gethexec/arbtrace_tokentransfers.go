@@ -0,0 +1,175 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	tokenStandardNative  = "native"
+	tokenStandardERC20   = "erc20"
+	tokenStandardERC721  = "erc721"
+	tokenStandardERC1155 = "erc1155"
+)
+
+var (
+	transferEventID       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	transferSingleEventID = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	transferBatchEventID  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+)
+
+// tokenTransfer is a movement of value in a transaction, either of the chain's native currency by a call,
+// creation, or self destruct, or of a token by the event its contract emitted. Native transfers name the
+// frame making them, while token transfers name their log's position among the transaction's.
+type tokenTransfer struct {
+	Standard string          `json:"standard"`
+	Token    *common.Address `json:"token,omitempty"`
+	From     common.Address  `json:"from"`
+	To       common.Address  `json:"to"`
+	// the amount moved, unset for ERC-721 tokens, which are moved one at a time
+	Value    *hexutil.Big    `json:"value,omitempty"`
+	TokenId  *hexutil.Big    `json:"tokenId,omitempty"`
+	Operator *common.Address `json:"operator,omitempty"`
+
+	TraceAddress *[]int          `json:"traceAddress,omitempty"`
+	LogIndex     *hexutil.Uint64 `json:"logIndex,omitempty"`
+}
+
+// tokenTransfers lists the native transfers of the frames that succeeded in trace order, followed by the
+// token transfers the transaction's logs record in the order they were emitted. Logs reverted along with their
+// frames are gone by the time the transaction ends, so only transfers that took effect are listed.
+// Tokens are classified by their events' signatures, which contracts may emit without following the standard.
+func (t *parityTracer) tokenTransfers(logs []*types.Log) []tokenTransfer {
+	transfers := []tokenTransfer{}
+	if t.root != nil {
+		transfers = nativeTransfers(t.root, []int{}, transfers)
+	}
+	for i, log := range logs {
+		transfers = append(transfers, logTransfers(log, i)...)
+	}
+	return transfers
+}
+
+func nativeTransfers(call *parityCall, traceAddress []int, transfers []tokenTransfer) []tokenTransfer {
+	if call.err != nil {
+		return transfers
+	}
+	transfer := tokenTransfer{Standard: tokenStandardNative, TraceAddress: &traceAddress}
+	action := call.action
+	switch {
+	case call.frameType == frameTypeCall && action.CallType == "call" && action.From != nil && action.To != nil:
+		transfer.From, transfer.To, transfer.Value = *action.From, *action.To, action.Value
+	case call.frameType == frameTypeCreate && action.From != nil:
+		transfer.From, transfer.To, transfer.Value = *action.From, call.created, action.Value
+	case call.frameType == frameTypeSuicide && action.Address != nil && action.RefundAddress != nil:
+		transfer.From, transfer.To, transfer.Value = *action.Address, *action.RefundAddress, action.Balance
+	}
+	if transfer.Value != nil && transfer.Value.ToInt().Sign() > 0 {
+		transfers = append(transfers, transfer)
+	}
+	for i, sub := range call.calls {
+		subAddress := make([]int, len(traceAddress)+1)
+		copy(subAddress, traceAddress)
+		subAddress[len(traceAddress)] = i
+		transfers = nativeTransfers(sub, subAddress, transfers)
+	}
+	return transfers
+}
+
+// logTransfers returns the token transfers a log records, of which ERC-1155 batches record several.
+// Logs that don't match a transfer event's layout are ignored.
+func logTransfers(log *types.Log, index int) []tokenTransfer {
+	if len(log.Topics) == 0 {
+		return nil
+	}
+	token := log.Address
+	logIndex := hexutil.Uint64(index)
+	newTransfer := func(standard string, from, to common.Hash) tokenTransfer {
+		return tokenTransfer{
+			Standard: standard,
+			Token:    &token,
+			From:     common.BytesToAddress(from[:]),
+			To:       common.BytesToAddress(to[:]),
+			LogIndex: &logIndex,
+		}
+	}
+	word := func(data []byte, i int) *hexutil.Big {
+		return (*hexutil.Big)(new(big.Int).SetBytes(data[i*32 : (i+1)*32]))
+	}
+	switch log.Topics[0] {
+	case transferEventID:
+		// the standards share the event, but ERC-721 indexes the token id where ERC-20 logs the amount
+		if len(log.Topics) == 3 && len(log.Data) == 32 {
+			transfer := newTransfer(tokenStandardERC20, log.Topics[1], log.Topics[2])
+			transfer.Value = word(log.Data, 0)
+			return []tokenTransfer{transfer}
+		}
+		if len(log.Topics) == 4 && len(log.Data) == 0 {
+			transfer := newTransfer(tokenStandardERC721, log.Topics[1], log.Topics[2])
+			transfer.TokenId = (*hexutil.Big)(log.Topics[3].Big())
+			return []tokenTransfer{transfer}
+		}
+	case transferSingleEventID:
+		if len(log.Topics) == 4 && len(log.Data) == 64 {
+			transfer := newTransfer(tokenStandardERC1155, log.Topics[2], log.Topics[3])
+			operator := common.BytesToAddress(log.Topics[1][:])
+			transfer.Operator = &operator
+			transfer.TokenId = word(log.Data, 0)
+			transfer.Value = word(log.Data, 1)
+			return []tokenTransfer{transfer}
+		}
+	case transferBatchEventID:
+		if len(log.Topics) != 4 {
+			return nil
+		}
+		ids, ok := abiUintArray(log.Data, 0)
+		if !ok {
+			return nil
+		}
+		values, ok := abiUintArray(log.Data, 1)
+		if !ok || len(values) != len(ids) {
+			return nil
+		}
+		operator := common.BytesToAddress(log.Topics[1][:])
+		transfers := make([]tokenTransfer, 0, len(ids))
+		for i := range ids {
+			transfer := newTransfer(tokenStandardERC1155, log.Topics[2], log.Topics[3])
+			transfer.Operator = &operator
+			transfer.TokenId = ids[i]
+			transfer.Value = values[i]
+			transfers = append(transfers, transfer)
+		}
+		return transfers
+	}
+	return nil
+}
+
+// abiUintArray decodes the ABI-encoded uint256[] that's the nth argument of data.
+func abiUintArray(data []byte, n int) ([]*hexutil.Big, bool) {
+	if len(data) < (n+1)*32 {
+		return nil, false
+	}
+	offset := new(big.Int).SetBytes(data[n*32 : (n+1)*32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(data)) || uint64(len(data))-offset.Uint64() < 32 {
+		return nil, false
+	}
+	start := offset.Uint64()
+	length := new(big.Int).SetBytes(data[start : start+32])
+	remaining := uint64(len(data)) - start - 32
+	if !length.IsUint64() || length.Uint64() > remaining/32 {
+		return nil, false
+	}
+	values := make([]*hexutil.Big, length.Uint64())
+	for i := range values {
+		at := start + 32 + uint64(i)*32
+		values[i] = (*hexutil.Big)(new(big.Int).SetBytes(data[at : at+32]))
+	}
+	return values, true
+}
@@ -0,0 +1,844 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+)
+
+const (
+	frameTypeCall      = "call"
+	frameTypeCreate    = "create"
+	frameTypeSuicide   = "suicide"
+	frameTypeArbReward = "arbReward"
+
+	// ArbOS's own internal transactions, which update its state outside of the EVM
+	frameTypeArbInternal = "arbInternal"
+)
+
+const (
+	rewardTypeNetworkFee = "networkFee"
+	rewardTypeInfraFee   = "infraFee"
+	rewardTypeL1Fee      = "l1Fee"
+)
+
+// ErrTraceFrameLimit is returned when a transaction's trace would exceed the configured maximum number of frames.
+var ErrTraceFrameLimit = errors.New("trace exceeded the maximum number of frames")
+
+// feeTransfer records fees ArbOS minted to one of the accounts it distributes them to.
+type feeTransfer struct {
+	recipient  common.Address
+	rewardType string
+	value      *big.Int
+}
+
+// parityCall is a node in the call tree built by the parityTracer.
+type parityCall struct {
+	frameType string
+	action    traceAction
+	created   common.Address
+	result    *traceCallResult
+	err       error
+	revert    []byte
+	calls     []*parityCall
+	gasUsed   uint64
+	gasTally  frameGasTally
+
+	// the address table entry a call to ArbAddressTable referenced, resolved when the call was made
+	addressTable *addressTableEntry
+	// the logs the call itself emitted, which are only recorded when requested
+	logs []traceLog
+	// the accounts and slots the call itself touched, which are only counted when requested
+	accessTally *frameAccessTally
+	// the gas the call had when it was entered and when it returned, which is only reported when requested
+	gasLeft *frameGasLeft
+}
+
+type frameGasLeft struct {
+	entry uint64
+	exit  uint64
+}
+
+// parityTracer is a vm.EVMLogger that records a Parity-style call tree along with
+// the accounts and storage slots a transaction may have modified.
+type parityTracer struct {
+	env       *vm.EVM
+	root      *parityCall
+	callstack []*parityCall
+	touched   map[common.Address]map[common.Hash]struct{}
+	fees      []feeTransfer
+
+	// the number of frames the current transaction's trace may hold (0 = unlimited),
+	// beyond which the execution is aborted with err set
+	maxFrames  int
+	frameCount int
+	err        error
+
+	// the ArbOS state an internal transaction started from
+	internalBefore *arbInternalState
+
+	// per-frame gas attribution, which is only done when requested
+	profileGas bool
+
+	// the accounts and slots accessed, which are only recorded when requested
+	traceAccessList bool
+	access          *accessSet
+
+	// per-frame counts of the accounts and slots touched, which are only kept when requested
+	countAccess bool
+
+	// per-frame gas left at entry and exit, which is only reported when requested
+	gasFrames bool
+
+	// whether frames executing against another account's storage name that account,
+	// which is only done when storage is reported
+	annotateStorage bool
+
+	// per-frame logs, which are only recorded when requested, along with the number of the
+	// transaction's logs emitted before the traced execution and before the last frame boundary
+	traceLogs bool
+	logsBase  int
+	logsSeen  int
+
+	// opcode-level tracing, which is only done when requested
+	traceVm  bool
+	vmRoot   *vmTraceFrame
+	vmFrames []*vmTraceFrame
+	// the number of operations the opcode trace may hold (0 = unlimited), beyond which it's truncated
+	vmMaxSteps int
+	vmSteps    int
+
+	// opcode counts aggregated across every transaction the tracer sees, which is only done for arbtrace_opcodeStats
+	opcodes *opcodeTally
+
+	// the salt of the CREATE2 operation about to enter its frame, which the frame isn't told
+	create2Salt *common.Hash
+
+	// the state of the EVM at a single step, which is only recorded for arbtrace_getStep
+	stepCapture *stepCapture
+}
+
+func newParityTracer(traceTypes traceTypeSet, maxFrames int) *parityTracer {
+	return &parityTracer{
+		touched:    make(map[common.Address]map[common.Hash]struct{}),
+		maxFrames:  maxFrames,
+		profileGas: traceTypes[traceTypeGasProfile],
+		traceVm:    traceTypes[traceTypeVmTrace],
+
+		traceAccessList: traceTypes[traceTypeAccessList],
+		annotateStorage: traceTypes[traceTypeStateDiff] || traceTypes[traceTypeAccessList],
+		traceLogs:       traceTypes[traceTypeLogs],
+		countAccess:     traceTypes[traceTypeAccessStats],
+		gasFrames:       traceTypes[traceTypeGasFrames],
+	}
+}
+
+// enterVmFrame starts the opcode trace of a new call frame, nesting it under the
+// operation that made the call.
+func (t *parityTracer) enterVmFrame(typ vm.OpCode, to common.Address, input []byte) {
+	code := input
+	if typ != vm.CREATE && typ != vm.CREATE2 {
+		code = t.env.StateDB.GetCode(to)
+	}
+	frame := newVmTraceFrame(code)
+	if len(t.vmFrames) == 0 {
+		t.vmRoot = frame
+	} else if parent := t.vmFrames[len(t.vmFrames)-1]; parent.pending != nil {
+		parent.pending.Sub = frame.trace
+	}
+	t.vmFrames = append(t.vmFrames, frame)
+}
+
+func (t *parityTracer) exitVmFrame() {
+	if len(t.vmFrames) == 0 {
+		return
+	}
+	t.vmFrames[len(t.vmFrames)-1].finish()
+	t.vmFrames = t.vmFrames[:len(t.vmFrames)-1]
+}
+
+// truncateVmTrace stops the opcode trace, settling the operations in progress as if their frames had ended.
+// The rest of the trace is unaffected, as it's recorded independently.
+func (t *parityTracer) truncateVmTrace() {
+	for i := len(t.vmFrames) - 1; i >= 0; i-- {
+		t.vmFrames[i].finish()
+	}
+	t.vmFrames = nil
+	t.traceVm = false
+	if t.vmRoot != nil {
+		t.vmRoot.trace.Truncated = true
+	}
+}
+
+// vmTrace returns the opcode trace of the outermost call frame, which is empty if no frame was entered.
+func (t *parityTracer) vmTrace() *vmTrace {
+	if t.vmRoot == nil {
+		return newVmTraceFrame(nil).trace
+	}
+	return t.vmRoot.trace
+}
+
+func (t *parityTracer) touchAccount(addr common.Address) map[common.Hash]struct{} {
+	slots, ok := t.touched[addr]
+	if !ok {
+		slots = make(map[common.Hash]struct{})
+		t.touched[addr] = slots
+	}
+	return slots
+}
+
+func (t *parityTracer) touchSlot(addr common.Address, slot common.Hash) {
+	t.touchAccount(addr)[slot] = struct{}{}
+}
+
+func newParityCall(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) *parityCall {
+	if value == nil {
+		value = new(big.Int)
+	}
+	gasHex := hexutil.Uint64(gas)
+	valueHex := (*hexutil.Big)(new(big.Int).Set(value))
+	switch typ {
+	case vm.CREATE, vm.CREATE2:
+		var initCodeHash *common.Hash
+		if typ == vm.CREATE2 {
+			hash := crypto.Keccak256Hash(input)
+			initCodeHash = &hash
+		}
+		return &parityCall{
+			frameType: frameTypeCreate,
+			created:   to,
+			action: traceAction{
+				CreationMethod: strings.ToLower(typ.String()),
+				InitCodeHash:   initCodeHash,
+				From:           &from,
+				Gas:            &gasHex,
+				Init:           common.CopyBytes(input),
+				Value:          valueHex,
+			},
+		}
+	case vm.SELFDESTRUCT:
+		return &parityCall{
+			frameType: frameTypeSuicide,
+			action: traceAction{
+				Address:       &from,
+				RefundAddress: &to,
+				Balance:       valueHex,
+			},
+		}
+	}
+	callType := strings.ToLower(typ.String())
+	if typ == vm.INVALID {
+		// ArbOS represents its own transfers as calls made outside of the EVM
+		callType = frameTypeCall
+	}
+	data := hexutil.Bytes(common.CopyBytes(input))
+	return &parityCall{
+		frameType: frameTypeCall,
+		action: traceAction{
+			CallType: callType,
+			From:     &from,
+			Gas:      &gasHex,
+			Input:    &data,
+			To:       &to,
+			Value:    valueHex,
+		},
+	}
+}
+
+func (c *parityCall) finish(output []byte, gasUsed uint64, err error) {
+	c.gasUsed = gasUsed
+	if err != nil {
+		c.err = err
+		if errors.Is(err, vm.ErrExecutionReverted) {
+			c.revert = common.CopyBytes(output)
+		}
+		return
+	}
+	// creations report the deployed contract while calls report what they returned
+	switch c.frameType {
+	case frameTypeCreate:
+		code := hexutil.Bytes(common.CopyBytes(output))
+		c.result = &traceCallResult{
+			Address: &c.created,
+			Code:    &code,
+			GasUsed: hexutil.Uint64(gasUsed),
+		}
+	case frameTypeCall:
+		data := hexutil.Bytes(common.CopyBytes(output))
+		c.result = &traceCallResult{
+			GasUsed: hexutil.Uint64(gasUsed),
+			Output:  &data,
+		}
+	}
+}
+
+// recordGasLeft notes the gas the finished call was entered with and returned to its caller, which is none if it
+// ran out. Self destructs are given no gas, so they have none to report.
+func (c *parityCall) recordGasLeft() {
+	if c.frameType == frameTypeSuicide || c.action.Gas == nil {
+		return
+	}
+	entry := uint64(*c.action.Gas)
+	exit := uint64(0)
+	if c.gasUsed < entry {
+		exit = entry - c.gasUsed
+	}
+	c.gasLeft = &frameGasLeft{entry: entry, exit: exit}
+}
+
+func (t *parityTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.touchAccount(from)
+	t.touchAccount(to)
+	t.touchAccount(env.Context.Coinbase)
+	t.root = newParityCall(typ, from, to, input, gas, value)
+	if !create {
+		t.root.addressTable = resolveAddressTableCall(env.StateDB, to, input)
+	}
+	t.callstack = []*parityCall{t.root}
+	t.frameCount = 1
+	if t.traceLogs {
+		// calls traced on top of others share their transaction's logs
+		t.root.logs = []traceLog{}
+		t.logsBase = len(env.StateDB.GetCurrentTxLogs())
+		t.logsSeen = t.logsBase
+	}
+	if t.traceAccessList {
+		// the precompiles depend on the traced block's ArbOS version
+		rules := env.ChainConfig().Rules(env.Context.BlockNumber, env.Context.Random != nil, env.Context.Time, env.Context.ArbOSVersion)
+		t.access = newAccessSet(from, to, vm.ActivePrecompiles(rules))
+	}
+	if t.countAccess {
+		t.root.accessTally = newFrameAccessTally(from, to)
+	}
+	if !create && from == types.ArbosAddress && to == types.ArbosAddress {
+		t.root.frameType = frameTypeArbInternal
+		t.root.action.CallType = ""
+		t.root.action.Method = internalTxMethod(input)
+		t.internalBefore = readArbInternalState(env)
+	}
+	if t.traceVm {
+		t.enterVmFrame(typ, to, input)
+	}
+	if t.opcodes != nil {
+		t.opcodes.enter()
+	}
+}
+
+func (t *parityTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	if t.root != nil {
+		if t.traceLogs {
+			t.collectLogs(t.root)
+			if err != nil {
+				dropLogs(t.root)
+			}
+		}
+		t.root.finish(output, gasUsed, err)
+		if t.gasFrames {
+			t.root.recordGasLeft()
+		}
+		if t.root.frameType == frameTypeArbInternal && t.internalBefore != nil {
+			if after := readArbInternalState(t.env); after != nil {
+				t.root.action.Update = newArbInternalUpdate(t.internalBefore, after)
+			}
+		}
+	}
+	t.callstack = nil
+	if t.traceVm {
+		t.exitVmFrame()
+	}
+	if t.opcodes != nil {
+		t.opcodes.exit(gasUsed)
+	}
+}
+
+func (t *parityTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	t.frameCount++
+	if t.maxFrames > 0 && t.frameCount > t.maxFrames {
+		// stop recording, leaving the partial trace for the caller to discard
+		t.err = fmt.Errorf("%w of %v", ErrTraceFrameLimit, t.maxFrames)
+		t.callstack = nil
+		t.traceVm = false
+		t.env.Cancel()
+		return
+	}
+	t.touchAccount(from)
+	t.touchAccount(to)
+	if t.access != nil && typ != vm.CREATE && typ != vm.CREATE2 && typ != vm.INVALID {
+		t.access.addAddress(to)
+	}
+	call := newParityCall(typ, from, to, input, gas, value)
+	if typ == vm.CREATE2 {
+		call.action.Salt = t.create2Salt
+		t.create2Salt = nil
+	}
+	if call.frameType == frameTypeCall {
+		call.addressTable = resolveAddressTableCall(t.env.StateDB, to, input)
+	}
+	if t.countAccess {
+		call.accessTally = newFrameAccessTally(from, to)
+	}
+	parent := t.callstack[len(t.callstack)-1]
+	if t.traceLogs {
+		t.collectLogs(parent)
+		call.logs = []traceLog{}
+	}
+	parent.calls = append(parent.calls, call)
+	t.callstack = append(t.callstack, call)
+	if t.traceVm && typ != vm.SELFDESTRUCT {
+		t.enterVmFrame(typ, to, input)
+	}
+	if t.opcodes != nil && typ != vm.SELFDESTRUCT {
+		t.opcodes.enter()
+	}
+}
+
+func (t *parityTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.callstack) <= 1 {
+		return
+	}
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+	if t.traceLogs {
+		t.collectLogs(call)
+		if err != nil {
+			dropLogs(call)
+		}
+	}
+	call.finish(output, gasUsed, err)
+	if t.gasFrames {
+		call.recordGasLeft()
+	}
+	if t.traceVm && call.frameType != frameTypeSuicide {
+		t.exitVmFrame()
+	}
+	if t.opcodes != nil && call.frameType != frameTypeSuicide {
+		t.opcodes.exit(gasUsed)
+	}
+}
+
+func (t *parityTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil {
+		return
+	}
+	if t.traceVm && len(t.vmFrames) > 0 {
+		if t.vmMaxSteps > 0 && t.vmSteps >= t.vmMaxSteps {
+			t.truncateVmTrace()
+		} else {
+			t.vmSteps++
+			t.vmFrames[len(t.vmFrames)-1].step(pc, op, gas, cost, scope)
+		}
+	}
+	if t.profileGas {
+		t.profileStep(op, cost, scope)
+	}
+	if t.opcodes != nil {
+		t.opcodes.step(op, gas, cost)
+	}
+	if t.stepCapture != nil {
+		t.stepCapture.step(t.env, pc, op, gas, cost, scope, depth)
+	}
+	if t.access != nil {
+		t.access.recordAccess(op, cost, scope)
+	}
+	if t.countAccess && len(t.callstack) > 0 {
+		t.callstack[len(t.callstack)-1].accessTally.countAccess(op, scope)
+	}
+	stack := scope.Stack.Data()
+	// a CREATE2 that fails before entering its frame leaves its salt to be replaced by the next operation
+	t.create2Salt = nil
+	if op == vm.CREATE2 && len(stack) >= 4 {
+		// the salt follows the value, offset, and size of the init code
+		salt := common.Hash(stack[len(stack)-4].Bytes32())
+		t.create2Salt = &salt
+	}
+	if op != vm.SSTORE {
+		return
+	}
+	if len(stack) == 0 {
+		return
+	}
+	// the storage written belongs to the executing context, which for delegatecalls is the caller
+	t.touchSlot(scope.Contract.Address(), common.Hash(stack[len(stack)-1].Bytes32()))
+}
+
+func (t *parityTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (t *parityTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (t *parityTracer) CaptureTxEnd(restGas uint64) {}
+
+func (t *parityTracer) CaptureArbitrumTransfer(env *vm.EVM, from, to *common.Address, value *big.Int, before bool, purpose string) {
+	if from != nil {
+		t.touchAccount(*from)
+	}
+	if to != nil {
+		t.touchAccount(*to)
+	}
+	// ArbOS mints the fees a transaction paid to their recipients once it ends
+	if purpose == "feeCollection" && from == nil && to != nil && value.Sign() > 0 {
+		t.fees = append(t.fees, feeTransfer{
+			recipient:  *to,
+			rewardType: feeRewardType(env, *to),
+			value:      new(big.Int).Set(value),
+		})
+	}
+}
+
+// feeRewardType classifies a fee recipient against the accounts configured in ArbOS.
+func feeRewardType(env *vm.EVM, recipient common.Address) string {
+	state, err := arbosState.OpenSystemArbosState(env.StateDB, nil, true)
+	if err != nil {
+		return rewardTypeL1Fee
+	}
+	if networkFeeAccount, err := state.NetworkFeeAccount(); err == nil && recipient == networkFeeAccount {
+		return rewardTypeNetworkFee
+	}
+	if infraFeeAccount, err := state.InfraFeeAccount(); err == nil && recipient == infraFeeAccount {
+		return rewardTypeInfraFee
+	}
+	return rewardTypeL1Fee
+}
+
+// arbInternalState holds the parts of ArbOS's state that its internal transactions update.
+type arbInternalState struct {
+	l1BlockNumber  uint64
+	baseFee        *big.Int
+	l1PricePerUnit *big.Int
+	arbosVersion   uint64
+}
+
+// readArbInternalState reads ArbOS's state without charging for it, returning nil if it can't be read.
+func readArbInternalState(env *vm.EVM) *arbInternalState {
+	state, err := arbosState.OpenSystemArbosState(env.StateDB, nil, true)
+	if err != nil {
+		return nil
+	}
+	l1BlockNumber, err := state.Blockhashes().L1BlockNumber()
+	if err != nil {
+		return nil
+	}
+	baseFee, err := state.L2PricingState().BaseFeeWei()
+	if err != nil {
+		return nil
+	}
+	l1PricePerUnit, err := state.L1PricingState().PricePerUnit()
+	if err != nil {
+		return nil
+	}
+	return &arbInternalState{
+		l1BlockNumber:  l1BlockNumber,
+		baseFee:        baseFee,
+		l1PricePerUnit: l1PricePerUnit,
+		arbosVersion:   state.ArbOSVersion(),
+	}
+}
+
+func newArbInternalUpdate(before, after *arbInternalState) *arbInternalUpdate {
+	diffUint := func(from, to uint64) *diffValue {
+		return newDiffValue(true, true, hexutil.Uint64(from), hexutil.Uint64(to), from == to)
+	}
+	diffBig := func(from, to *big.Int) *diffValue {
+		return newDiffValue(true, true, (*hexutil.Big)(from), (*hexutil.Big)(to), from.Cmp(to) == 0)
+	}
+	return &arbInternalUpdate{
+		L1BlockNumber:  diffUint(before.l1BlockNumber, after.l1BlockNumber),
+		BaseFee:        diffBig(before.baseFee, after.baseFee),
+		L1PricePerUnit: diffBig(before.l1PricePerUnit, after.l1PricePerUnit),
+		ArbOSVersion:   diffUint(before.arbosVersion, after.arbosVersion),
+	}
+}
+
+// internalTxMethod names the update an internal transaction's calldata selects.
+func internalTxMethod(input []byte) string {
+	if len(input) < 4 {
+		return ""
+	}
+	switch *(*[4]byte)(input[:4]) {
+	case arbos.InternalTxStartBlockMethodID:
+		return "startBlock"
+	case arbos.InternalTxBatchPostingReportMethodID:
+		return "batchPostingReport"
+	}
+	return ""
+}
+
+// ArbOS storage accesses are reported with subspace-relative keys, so they can't be
+// attributed to concrete slots of the ArbOS account and are left out of the diff.
+func (t *parityTracer) CaptureArbitrumStorageGet(key common.Hash, depth int, before bool) {}
+
+func (t *parityTracer) CaptureArbitrumStorageSet(key, value common.Hash, depth int, before bool) {}
+
+func (t *parityTracer) CaptureStylusHostio(name string, args, outs []byte, startInk, endInk uint64) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	caller := t.callstack[len(t.callstack)-1]
+	if name == "create2" {
+		t.annotateStylusCreate2(caller, args)
+	}
+	program := caller.storageAddress()
+	if program == nil {
+		return
+	}
+	// as with SSTORE, the storage written belongs to the executing context
+	if name == "storage_cache_bytes32" && len(args) >= common.HashLength {
+		t.touchSlot(*program, common.BytesToHash(args[:common.HashLength]))
+	}
+	if t.access != nil {
+		t.access.recordHostioAccess(*program, name, args)
+	}
+	if t.countAccess {
+		caller.accessTally.countHostioAccess(*program, name, args)
+	}
+}
+
+// annotateStylusCreate2 records the salt of a program's create2, whose hostio is only reported once its
+// frame has exited, so the frame is the program's latest creation. The hostio's arguments are the value
+// the creation was endowed with, then the salt, then the init code.
+func (t *parityTracer) annotateStylusCreate2(program *parityCall, args []byte) {
+	if len(args) < 2*common.HashLength || len(program.calls) == 0 {
+		return
+	}
+	created := program.calls[len(program.calls)-1]
+	if created.action.CreationMethod != "create2" || created.action.Salt != nil {
+		return
+	}
+	salt := common.BytesToHash(args[common.HashLength : 2*common.HashLength])
+	created.action.Salt = &salt
+}
+
+// accessList returns the access list of the traced transaction.
+func (t *parityTracer) accessList() types.AccessList {
+	if t.access == nil {
+		return types.AccessList{}
+	}
+	return t.access.accessList()
+}
+
+// frames flattens the call tree into Parity's depth-first list of frames, visiting each call
+// before its subcalls and the subcalls in the order they were made.
+func (t *parityTracer) frames() []traceFrame {
+	frames := []traceFrame{}
+	if t.root == nil {
+		return frames
+	}
+	frames = flattenParityCall(t.root, []int{}, frames)
+	if t.annotateStorage {
+		for i := range frames {
+			switch frames[i].Action.CallType {
+			case "delegatecall", "callcode":
+				// the caller's storage, which a delegatecall chain passes down unchanged
+				frames[i].StorageAddress = frames[i].Action.From
+			}
+		}
+	}
+	return frames
+}
+
+func flattenParityCall(call *parityCall, traceAddress []int, frames []traceFrame) []traceFrame {
+	frame := traceFrame{
+		Action:       call.action,
+		Result:       call.result,
+		Subtraces:    len(call.calls),
+		TraceAddress: traceAddress,
+		Type:         call.frameType,
+	}
+	if call.logs != nil {
+		logs := call.logs
+		frame.Logs = &logs
+	}
+	if call.gasLeft != nil {
+		entry, exit := hexutil.Uint64(call.gasLeft.entry), hexutil.Uint64(call.gasLeft.exit)
+		frame.GasLeftAtEntry = &entry
+		frame.GasLeftAtExit = &exit
+	}
+	if call.frameType == frameTypeCall {
+		frame.Precompile = decodePrecompileCall(call.action.To, call.action.Input)
+		if frame.Precompile != nil {
+			frame.Precompile.AddressTable = call.addressTable
+		}
+	}
+	if call.err != nil {
+		message := parityErrorString(call.err)
+		frame.Error = &message
+		if reason, err := abi.UnpackRevert(call.revert); err == nil {
+			frame.RevertReason = &reason
+		}
+		if errors.Is(call.err, vm.ErrExecutionReverted) {
+			reason := revertErrorReason(call.revert)
+			frame.ErrorReason = &reason
+		}
+	}
+	frames = append(frames, frame)
+	for i, sub := range call.calls {
+		subAddress := make([]int, len(traceAddress), len(traceAddress)+1)
+		copy(subAddress, traceAddress)
+		frames = flattenParityCall(sub, append(subAddress, i), frames)
+	}
+	return frames
+}
+
+// destroyedContracts lists the contracts that self-destructed in frames that weren't reverted.
+func (t *parityTracer) destroyedContracts() []common.Address {
+	destroyed := []common.Address{}
+	if t.root == nil {
+		return destroyed
+	}
+	seen := make(map[common.Address]struct{})
+	var walk func(call *parityCall)
+	walk = func(call *parityCall) {
+		if call.err != nil {
+			return
+		}
+		if call.frameType == frameTypeSuicide {
+			addr := *call.action.Address
+			if _, ok := seen[addr]; !ok {
+				seen[addr] = struct{}{}
+				destroyed = append(destroyed, addr)
+			}
+		}
+		for _, sub := range call.calls {
+			walk(sub)
+		}
+	}
+	walk(t.root)
+	return destroyed
+}
+
+var (
+	revertErrorSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+	revertPanicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+)
+
+// the conditions Solidity reports with Panic(uint256)
+var panicDescriptions = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum conversion out of range",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to uninitialized function",
+}
+
+// revertErrorReason describes a frame's revert data, decoding Solidity's Error(string) and
+// Panic(uint256) and falling back to the raw data otherwise.
+func revertErrorReason(data []byte) string {
+	switch {
+	case len(data) == 0:
+		return "execution reverted"
+	case bytes.HasPrefix(data, revertErrorSelector):
+		if reason, err := abi.UnpackRevert(data); err == nil {
+			return "execution reverted: " + reason
+		}
+	case bytes.HasPrefix(data, revertPanicSelector) && len(data) == 4+common.HashLength:
+		code := new(big.Int).SetBytes(data[4:])
+		description, ok := panicDescriptions[code.Uint64()]
+		if !code.IsUint64() || !ok {
+			description = "unknown panic code"
+		}
+		return fmt.Sprintf("panic: %v (%#x)", description, code)
+	}
+	return "execution reverted: " + hexutil.Encode(data)
+}
+
+// parityErrorString translates geth's execution errors into the messages Parity reports.
+func parityErrorString(err error) string {
+	switch {
+	case errors.Is(err, vm.ErrExecutionReverted):
+		return "Reverted"
+	case errors.Is(err, vm.ErrOutOfGas),
+		errors.Is(err, vm.ErrCodeStoreOutOfGas),
+		errors.Is(err, vm.ErrGasUintOverflow),
+		errors.Is(err, vm.ErrMaxCodeSizeExceeded):
+		return "Out of gas"
+	case errors.Is(err, vm.ErrInvalidJump):
+		return "Bad jump destination"
+	case errors.Is(err, vm.ErrReturnDataOutOfBounds):
+		return "Out of bounds"
+	case errors.Is(err, vm.ErrDepth):
+		return "Out of stack"
+	case errors.Is(err, vm.ErrWriteProtection):
+		return "Mutable call in static context"
+	}
+	message := err.Error()
+	switch {
+	case strings.HasPrefix(message, "invalid opcode"):
+		return "Bad instruction"
+	case strings.HasPrefix(message, "stack underflow"), strings.HasPrefix(message, "stack limit reached"):
+		return "Out of stack"
+	}
+	return message
+}
+
+// stateDiff compares the accounts touched during execution between the pre- and post-states.
+func (t *parityTracer) stateDiff(pre, post *state.StateDB) stateDiff {
+	diff := make(stateDiff)
+	for addr, slots := range t.touched {
+		preExists, postExists := pre.Exist(addr), post.Exist(addr)
+		if !preExists && !postExists {
+			continue
+		}
+		preBalance, postBalance := pre.GetBalance(addr), post.GetBalance(addr)
+		preNonce, postNonce := pre.GetNonce(addr), post.GetNonce(addr)
+		preCode, postCode := pre.GetCode(addr), post.GetCode(addr)
+		account := &accountDiff{
+			Balance: newDiffValue(
+				preExists, postExists,
+				(*hexutil.Big)(preBalance.ToBig()), (*hexutil.Big)(postBalance.ToBig()),
+				preBalance.Eq(postBalance),
+			),
+			Nonce: newDiffValue(
+				preExists, postExists,
+				hexutil.Uint64(preNonce), hexutil.Uint64(postNonce),
+				preNonce == postNonce,
+			),
+			Code: newDiffValue(
+				preExists, postExists,
+				hexutil.Bytes(preCode), hexutil.Bytes(postCode),
+				bytes.Equal(preCode, postCode),
+			),
+			Storage: make(map[common.Hash]*diffValue),
+		}
+		for slot := range slots {
+			preValue, postValue := pre.GetState(addr, slot), post.GetState(addr, slot)
+			if preValue == postValue {
+				continue
+			}
+			account.Storage[slot] = newDiffValue(preExists, postExists, preValue, postValue, false)
+		}
+		changed := len(account.Storage) > 0
+		changed = changed || !account.Balance.unchanged() || !account.Nonce.unchanged() || !account.Code.unchanged()
+		if changed {
+			diff[addr] = account
+		}
+	}
+	return diff
+}
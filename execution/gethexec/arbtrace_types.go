@@ -0,0 +1,677 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/arbitrum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/holiman/uint256"
+	"github.com/offchainlabs/nitro/arbos/arbosState"
+)
+
+// The types in this file mirror the wire format of the classic arbtrace API,
+// which itself follows Parity's trace_* conventions.
+
+// callTxArgs are the arguments of a traced call. Calls aren't signed, so they execute as if sent by whichever
+// account From names, as eth_call's do, with the sender's nonce unchecked and contracts allowed as senders. This
+// simulates what an account such as a whale would experience without its key, unless impersonation is disabled.
+type callTxArgs struct {
+	From                 *common.Address   `json:"from"`
+	To                   *common.Address   `json:"to"`
+	Gas                  *hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big      `json:"value"`
+	Data                 *hexutil.Bytes    `json:"data"`
+	AccessList           *types.AccessList `json:"accessList"`
+	Aggregator           *common.Address   `json:"aggregator"`
+}
+
+var errConflictingFeeArgs = errors.New("both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified")
+
+// transactionArgs converts the call into the arguments of a message executed on top of header. Access lists
+// are warmed before execution, just as they would be for a transaction carrying them. Omitted arguments default
+// as follows:
+//   - from is the zero address
+//   - to is absent, making the call a contract creation with data as its init code
+//   - gas is gasCap, or the message's own default if there's no cap
+//   - with none of gasPrice, maxFeePerGas, and maxPriorityFeePerGas given, gasPrice is header's base fee
+func (args *callTxArgs) transactionArgs(header *types.Header, gasCap uint64) (arbitrum.TransactionArgs, error) {
+	if args.GasPrice != nil && (args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil) {
+		return arbitrum.TransactionArgs{}, errConflictingFeeArgs
+	}
+	from := args.From
+	if from == nil {
+		from = &common.Address{}
+	}
+	gas := args.Gas
+	if gas == nil && gasCap != 0 {
+		gas = (*hexutil.Uint64)(&gasCap)
+	}
+	gasPrice := args.GasPrice
+	if args.defaultsGasPrice() && header.BaseFee != nil {
+		gasPrice = (*hexutil.Big)(new(big.Int).Set(header.BaseFee))
+	}
+	return arbitrum.TransactionArgs{
+		From:                 from,
+		To:                   args.To,
+		Gas:                  gas,
+		GasPrice:             gasPrice,
+		MaxFeePerGas:         args.MaxFeePerGas,
+		MaxPriorityFeePerGas: args.MaxPriorityFeePerGas,
+		Value:                args.Value,
+		Data:                 args.Data,
+		AccessList:           args.AccessList,
+	}, nil
+}
+
+// defaultsGasPrice reports whether the call leaves its gas price to default to the base fee.
+func (args *callTxArgs) defaultsGasPrice() bool {
+	return args.GasPrice == nil && args.MaxFeePerGas == nil && args.MaxPriorityFeePerGas == nil
+}
+
+type traceAction struct {
+	CallType       string             `json:"callType,omitempty"`
+	CreationMethod string             `json:"creationMethod,omitempty"`
+	From           *common.Address    `json:"from,omitempty"`
+	Gas            *hexutil.Uint64    `json:"gas,omitempty"`
+	Input          *hexutil.Bytes     `json:"input,omitempty"`
+	Init           hexutil.Bytes      `json:"init,omitempty"`
+	To             *common.Address    `json:"to,omitempty"`
+	Value          *hexutil.Big       `json:"value,omitempty"`
+	Address        *common.Address    `json:"address,omitempty"`
+	RefundAddress  *common.Address    `json:"refundAddress,omitempty"`
+	Balance        *hexutil.Big       `json:"balance,omitempty"`
+	Author         *common.Address    `json:"author,omitempty"`
+	RewardType     string             `json:"rewardType,omitempty"`
+	Method         string             `json:"method,omitempty"`
+	Update         *arbInternalUpdate `json:"update,omitempty"`
+	// set on the top-level frame of a retryable's submission, whose parameters its call doesn't show
+	SubmitRetryable *submitRetryableAction `json:"submitRetryable,omitempty"`
+	// set on create2 frames, whose address can be predicted from these and the creator's
+	Salt         *common.Hash `json:"salt,omitempty"`
+	InitCodeHash *common.Hash `json:"initCodeHash,omitempty"`
+}
+
+// arbInternalUpdate describes how an internal transaction changed ArbOS's state,
+// with each field marked like a state diff.
+type arbInternalUpdate struct {
+	L1BlockNumber  *diffValue `json:"l1BlockNumber"`
+	BaseFee        *diffValue `json:"baseFee"`
+	L1PricePerUnit *diffValue `json:"l1PricePerUnit"`
+	ArbOSVersion   *diffValue `json:"arbosVersion"`
+}
+
+type traceCallResult struct {
+	Address *common.Address `json:"address,omitempty"`
+	Code    *hexutil.Bytes  `json:"code,omitempty"`
+	GasUsed hexutil.Uint64  `json:"gasUsed"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+}
+
+type traceFrame struct {
+	Action              traceAction      `json:"action"`
+	BlockHash           *common.Hash     `json:"blockHash,omitempty"`
+	BlockNumber         *uint64          `json:"blockNumber,omitempty"`
+	Result              *traceCallResult `json:"result,omitempty"`
+	Error               *string          `json:"error,omitempty"`
+	RevertReason        *string          `json:"revertReason,omitempty"`
+	ErrorReason         *string          `json:"errorReason,omitempty"`
+	Subtraces           int              `json:"subtraces"`
+	TraceAddress        []int            `json:"traceAddress"`
+	TransactionHash     *common.Hash     `json:"transactionHash,omitempty"`
+	TransactionPosition *uint64          `json:"transactionPosition,omitempty"`
+	Type                string           `json:"type"`
+	Precompile          *precompileCall  `json:"precompile,omitempty"`
+	// the account whose storage a delegatecall or callcode frame executes against, which is its caller's,
+	// set when the trace is requested with a stateDiff or accessList
+	StorageAddress *common.Address `json:"storageAddress,omitempty"`
+	// the logs the frame itself emitted in the order it emitted them, set when logs are requested,
+	// and left empty for frames that failed, as their logs were reverted
+	Logs *[]traceLog `json:"logs,omitempty"`
+	// the gas the frame had when it was entered and the gas it returned to its caller, set when gasFrames are
+	// requested. Unlike the result's gasUsed, these are reported for failed frames too, so frames that ran out
+	// of gas are those that failed returning none.
+	GasLeftAtEntry *hexutil.Uint64 `json:"gasLeftAtEntry,omitempty"`
+	GasLeftAtExit  *hexutil.Uint64 `json:"gasLeftAtExit,omitempty"`
+
+	// set on frames arbtrace_subscribe re-sends when their block is reorged out
+	Removed bool `json:"removed,omitempty"`
+}
+
+// traceResult holds the outputs of one traced transaction or call. Outputs that weren't requested are
+// left nil and omitted, while requested outputs are always present, rendering as an empty object or array
+// when there's nothing to report. Fields within Parity's formats keep Parity's nulls, such as a vmTrace
+// operation's sub when it made no call.
+type traceResult struct {
+	Output             hexutil.Bytes     `json:"output"`
+	StateDiff          stateDiff         `json:"stateDiff"`
+	Trace              []traceFrame      `json:"trace"`
+	VmTrace            *vmTrace          `json:"vmTrace,omitempty"`
+	DestroyedContracts *[]common.Address `json:"destroyedContracts,omitempty"`
+	ArbitrumFees       *arbitrumFees     `json:"arbitrumFees,omitempty"`
+	GasProfile         *gasProfile       `json:"gasProfile,omitempty"`
+	Retryable          *[]retryableFrame `json:"retryable,omitempty"`
+	AccessList         *types.AccessList `json:"accessList,omitempty"`
+	Refund             *gasRefund        `json:"refund,omitempty"`
+	Outbox             *[]outboxMessage  `json:"outbox,omitempty"`
+	L2Pricing          *l2Pricing        `json:"l2Pricing,omitempty"`
+	Summary            *traceSummary     `json:"summary,omitempty"`
+	FeeInfo            *feeInfo          `json:"feeInfo,omitempty"`
+	AccessStats        *accessStats      `json:"accessStats,omitempty"`
+	TokenTransfers     *[]tokenTransfer  `json:"tokenTransfers,omitempty"`
+	// the gas an arbtrace_call ran with, and whether the gas it asked for was clamped to the trace gas cap
+	EffectiveGas *hexutil.Uint64 `json:"effectiveGas,omitempty"`
+	GasCapped    bool            `json:"gasCapped,omitempty"`
+	// the root of the state an arbtrace_callMany call left, when intermediate roots were requested
+	StateRoot *common.Hash `json:"stateRoot,omitempty"`
+
+	// the error the traced execution failed with, if any
+	execErr error
+	// the fees ArbOS distributed once execution ended
+	fees []feeTransfer
+}
+
+type plainTraceResult traceResult
+
+// traceResultJSON is the form a traceResult is encoded in, which its schema is generated from.
+// omitempty would also drop requested outputs that are empty, so only nil outputs are omitted.
+type traceResultJSON struct {
+	plainTraceResult
+	StateDiff *stateDiff    `json:"stateDiff,omitempty"`
+	Trace     *[]traceFrame `json:"trace,omitempty"`
+}
+
+func (r traceResult) MarshalJSON() ([]byte, error) {
+	result := traceResultJSON{plainTraceResult: plainTraceResult(r)}
+	if r.StateDiff != nil {
+		result.StateDiff = &r.StateDiff
+	}
+	if r.Trace != nil {
+		result.Trace = &r.Trace
+	}
+	return json.Marshal(result)
+}
+
+// arbitrumFees splits the gas a transaction paid for between L2 execution and the L1 cost
+// of posting its calldata. Nitro charges no aggregator surcharge beyond the poster's L1 fee.
+//
+// The L1 fee is the one ArbOS charged when the transaction executed, from its estimate of the L1 price
+// per unit of compressed data. Batch posting reports correct that estimate after the fact, with the cost
+// of each batch whether it was posted as calldata or in blobs, but never revise the fees already charged.
+// Replaying on the state the transaction executed on therefore reconciles the fee with the receipt in
+// either era, without knowing how the transaction's own batch was posted, which the L2 state doesn't record.
+type arbitrumFees struct {
+	BaseFee      *hexutil.Big   `json:"baseFee"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	GasUsedForL1 hexutil.Uint64 `json:"gasUsedForL1"`
+	GasUsedForL2 hexutil.Uint64 `json:"gasUsedForL2"`
+	L1Fee        *hexutil.Big   `json:"l1Fee"`
+	L2Fee        *hexutil.Big   `json:"l2Fee"`
+}
+
+// stateDiff maps each account changed by a transaction to the changes made to it.
+type stateDiff map[common.Address]*accountDiff
+
+type accountDiff struct {
+	Balance *diffValue                 `json:"balance"`
+	Nonce   *diffValue                 `json:"nonce"`
+	Code    *diffValue                 `json:"code"`
+	Storage map[common.Hash]*diffValue `json:"storage"`
+}
+
+const (
+	diffSame    = "="
+	diffBorn    = "+"
+	diffDied    = "-"
+	diffChanged = "*"
+)
+
+// diffValue is a Parity-style change marker, rendered as "=" when unchanged,
+// {"+": to} when born, {"-": from} when died, and {"*": {"from": from, "to": to}} otherwise.
+type diffValue struct {
+	kind string
+	from interface{}
+	to   interface{}
+}
+
+func newDiffValue(preExists, postExists bool, from, to interface{}, equal bool) *diffValue {
+	switch {
+	case !preExists:
+		return &diffValue{kind: diffBorn, to: to}
+	case !postExists:
+		return &diffValue{kind: diffDied, from: from}
+	case equal:
+		return &diffValue{kind: diffSame}
+	default:
+		return &diffValue{kind: diffChanged, from: from, to: to}
+	}
+}
+
+func (d *diffValue) unchanged() bool {
+	return d.kind == diffSame
+}
+
+func (d *diffValue) MarshalJSON() ([]byte, error) {
+	switch d.kind {
+	case diffSame:
+		return json.Marshal(diffSame)
+	case diffBorn:
+		return json.Marshal(map[string]interface{}{diffBorn: d.to})
+	case diffDied:
+		return json.Marshal(map[string]interface{}{diffDied: d.from})
+	case diffChanged:
+		return json.Marshal(map[string]interface{}{diffChanged: map[string]interface{}{"from": d.from, "to": d.to}})
+	}
+	return nil, errors.New("unknown state diff kind")
+}
+
+// blockStateDiff is the net change a block made to the state.
+type blockStateDiff struct {
+	BlockHash      common.Hash    `json:"blockHash"`
+	BlockNumber    hexutil.Uint64 `json:"blockNumber"`
+	StateDiff      stateDiff      `json:"stateDiff"`
+	ArbOSStateDiff arbOSStateDiff `json:"arbosStateDiff"`
+}
+
+// arbOSStateDiff maps each ArbOS subspace changed by a block to the offsets changed within it.
+type arbOSStateDiff map[string]map[hexutil.Uint64]*diffValue
+
+func newArbOSStateDiff(changes arbosState.StateDiff) arbOSStateDiff {
+	diff := make(arbOSStateDiff)
+	for _, change := range changes {
+		subspace, ok := diff[change.Subspace]
+		if !ok {
+			subspace = make(map[hexutil.Uint64]*diffValue)
+			diff[change.Subspace] = subspace
+		}
+		subspace[hexutil.Uint64(change.Offset)] = newDiffValue(true, true, change.Before, change.After, false)
+	}
+	return diff
+}
+
+// callManyOptions adjusts how arbtrace_callMany executes its calls.
+type callManyOptions struct {
+	// execute each call on top of the block's state rather than the state left by previous calls
+	Independent bool `json:"independent"`
+	// abort with an error as soon as any call fails
+	FailOnRevert bool `json:"failOnRevert"`
+	// report the state root after each call, which means hashing the state each call leaves
+	IntermediateRoots bool `json:"intermediateRoots"`
+}
+
+// blockOverrides replaces parts of the context a traced call executes in, leaving the rest
+// as the base block's. The number is the L2 block number, as reported by ArbSys, since the
+// NUMBER opcode reports the parent chain's block number. The coinbase is the batch poster
+// ArbOS charges the call's L1 costs on behalf of.
+type blockOverrides struct {
+	Number   *hexutil.Big    `json:"number"`
+	Time     *hexutil.Uint64 `json:"time"`
+	BaseFee  *hexutil.Big    `json:"baseFee"`
+	Coinbase *common.Address `json:"coinbase"`
+}
+
+// apply returns a copy of the header with the overrides applied, which mustn't precede it.
+func (o *blockOverrides) apply(header *types.Header) (*types.Header, error) {
+	if o == nil {
+		return header, nil
+	}
+	header = types.CopyHeader(header)
+	if o.Number != nil {
+		if o.Number.ToInt().Cmp(header.Number) < 0 {
+			return nil, fmt.Errorf("block number override %v precedes the base block %v", o.Number.ToInt(), header.Number)
+		}
+		header.Number = new(big.Int).Set(o.Number.ToInt())
+	}
+	if o.Time != nil {
+		if uint64(*o.Time) < header.Time {
+			return nil, fmt.Errorf("block time override %v precedes the base block's time %v", uint64(*o.Time), header.Time)
+		}
+		header.Time = uint64(*o.Time)
+	}
+	if o.BaseFee != nil {
+		header.BaseFee = new(big.Int).Set(o.BaseFee.ToInt())
+	}
+	if o.Coinbase != nil {
+		header.Coinbase = *o.Coinbase
+	}
+	return header, nil
+}
+
+// stateOverride replaces parts of the state a traced call executes on, keyed by account.
+type stateOverride map[common.Address]overrideAccount
+
+// overrideAccount replaces an account's fields. State replaces its storage entirely,
+// while StateDiff only replaces the given slots.
+type overrideAccount struct {
+	Nonce     *hexutil.Uint64              `json:"nonce"`
+	Code      *hexutil.Bytes               `json:"code"`
+	Balance   *hexutil.Big                 `json:"balance"`
+	State     *map[common.Hash]common.Hash `json:"state"`
+	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
+}
+
+// apply writes the overrides to the state, which must be discarded after the call.
+func (diff stateOverride) apply(statedb *state.StateDB) error {
+	for addr, account := range diff {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %v has both state and stateDiff overrides", addr)
+		}
+		if account.Nonce != nil {
+			statedb.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			statedb.SetCode(addr, *account.Code)
+		}
+		if account.Balance != nil {
+			balance, overflow := uint256.FromBig(account.Balance.ToInt())
+			if overflow {
+				return fmt.Errorf("balance override of account %v overflows", addr)
+			}
+			statedb.SetBalance(addr, balance)
+		}
+		if account.State != nil {
+			statedb.SetStorage(addr, *account.State)
+		}
+		if account.StateDiff != nil {
+			for key, value := range *account.StateDiff {
+				statedb.SetState(addr, key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// blockTraceOptions adjusts which frames arbtrace_block returns.
+type blockTraceOptions struct {
+	// append arbReward frames describing where the block's fees were distributed
+	Rewards bool `json:"rewards"`
+}
+
+// TraceBlockRef is a block reference as the trace APIs accept it. Besides the forms rpc.BlockNumberOrHash
+// decodes, block numbers may be given in decimal, as JSON numbers or strings, and tags in any case.
+// References are encoded canonically, as tags, hex numbers, or hashes, so forwarded requests are consistent.
+type TraceBlockRef struct {
+	rpc.BlockNumberOrHash
+}
+
+func (ref *TraceBlockRef) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return ref.BlockNumberOrHash.UnmarshalJSON(data)
+	}
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		// JSON numbers are decimal block numbers, while objects name a block by number or hash
+		var number json.Number
+		if json.Unmarshal(data, &number) == nil {
+			return ref.setDecimal(number.String())
+		}
+		return ref.BlockNumberOrHash.UnmarshalJSON(data)
+	}
+	input = strings.ToLower(strings.TrimSpace(input))
+	if input != "" && strings.Trim(input, "0123456789") == "" {
+		return ref.setDecimal(input)
+	}
+	quoted, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	return ref.BlockNumberOrHash.UnmarshalJSON(quoted)
+}
+
+func (ref *TraceBlockRef) setDecimal(input string) error {
+	// block numbers are signed, with negative values reserved for tags
+	number, err := strconv.ParseUint(input, 10, 63)
+	if err != nil {
+		return fmt.Errorf("invalid block number %v: %w", input, err)
+	}
+	ref.BlockNumberOrHash = rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number))
+	return nil
+}
+
+func (ref TraceBlockRef) MarshalJSON() ([]byte, error) {
+	if number, ok := ref.Number(); ok {
+		return json.Marshal(number.String())
+	}
+	if hash, ok := ref.Hash(); ok && !ref.RequireCanonical {
+		return json.Marshal(hash)
+	}
+	return json.Marshal(ref.BlockNumberOrHash)
+}
+
+// blockNumberOrHash returns the reference, or nil if it wasn't given.
+func (ref *TraceBlockRef) blockNumberOrHash() *rpc.BlockNumberOrHash {
+	if ref == nil {
+		return nil
+	}
+	return &ref.BlockNumberOrHash
+}
+
+// normalizeBlockRef re-encodes a block reference in its canonical form.
+func normalizeBlockRef(raw json.RawMessage) (json.RawMessage, error) {
+	var ref TraceBlockRef
+	if err := json.Unmarshal(raw, &ref); err != nil {
+		return nil, err
+	}
+	return json.Marshal(ref)
+}
+
+type callTraceRequest struct {
+	callArgs   callTxArgs
+	traceTypes []string
+}
+
+// UnmarshalJSON decodes a call as Parity's [callArgs, traceTypes] pair. Unknown call arguments are
+// rejected rather than ignored, so misspelt or misplaced fields don't silently change what's traced,
+// as are calls of any other shape, including those whose arguments or trace types are null.
+func (at *callTraceRequest) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf("expected two arguments per call, got %d", len(fields))
+	}
+	if isJSONNull(fields[0]) {
+		return errors.New("invalid call arguments: expected an object, got null")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(fields[0]))
+	decoder.DisallowUnknownFields()
+	var callArgs callTxArgs
+	if err := decoder.Decode(&callArgs); err != nil {
+		return fmt.Errorf("invalid call arguments: %w", err)
+	}
+	if isJSONNull(fields[1]) {
+		return errors.New("invalid trace types: expected a list, got null")
+	}
+	var traceTypes []string
+	if err := json.Unmarshal(fields[1], &traceTypes); err != nil {
+		return fmt.Errorf("invalid trace types: %w", err)
+	}
+	at.callArgs = callArgs
+	at.traceTypes = traceTypes
+	return nil
+}
+
+// MarshalJSON encodes a call as the pair UnmarshalJSON decodes, listing no trace types as an empty list.
+func (at *callTraceRequest) MarshalJSON() ([]byte, error) {
+	traceTypes := at.traceTypes
+	if traceTypes == nil {
+		traceTypes = []string{}
+	}
+	return json.Marshal([2]interface{}{&at.callArgs, traceTypes})
+}
+
+func isJSONNull(data json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+}
+
+// callTraceRequests is an arbtrace_callMany batch, whose decoding errors name the offending call.
+type callTraceRequests []*callTraceRequest
+
+func (calls *callTraceRequests) UnmarshalJSON(b []byte) error {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(b, &elements); err != nil {
+		return err
+	}
+	decoded := make(callTraceRequests, len(elements))
+	for i, element := range elements {
+		// decoding null would leave the call unset rather than reach its UnmarshalJSON
+		if isJSONNull(element) {
+			return fmt.Errorf("call %d: expected a call, got null", i)
+		}
+		decoded[i] = &callTraceRequest{}
+		if err := json.Unmarshal(element, decoded[i]); err != nil {
+			return fmt.Errorf("call %d: %w", i, err)
+		}
+	}
+	*calls = decoded
+	return nil
+}
+
+type filterRequest struct {
+	FromBlock   *TraceBlockRef    `json:"fromBlock"`
+	ToBlock     *TraceBlockRef    `json:"toBlock"`
+	FromAddress *[]common.Address `json:"fromAddress"`
+	ToAddress   *[]common.Address `json:"toAddress"`
+	CallTypes   *[]string         `json:"callTypes"`
+	MinValue    *hexutil.Big      `json:"minValue"`
+	MaxValue    *hexutil.Big      `json:"maxValue"`
+	Selectors   *[]methodSelector `json:"selectors"`
+	After       *uint64           `json:"after"`
+	Count       *uint64           `json:"count"`
+	Cursor      *string           `json:"cursor,omitempty"`
+}
+
+// methodSelector is the 4-byte method selector calldata begins with, encoded as hex.
+type methodSelector [4]byte
+
+func (s methodSelector) MarshalText() ([]byte, error) {
+	return hexutil.Bytes(s[:]).MarshalText()
+}
+
+func (s *methodSelector) UnmarshalText(input []byte) error {
+	return hexutil.UnmarshalFixedText("methodSelector", input, s[:])
+}
+
+// filterResult is returned by arbtrace_filter in place of a bare list of frames when
+// the request is paginated with a cursor.
+type filterResult struct {
+	Traces     []traceFrame `json:"traces"`
+	NextCursor *string      `json:"nextCursor"`
+}
+
+// blockTraces holds the traces of one block of an arbtrace_blockRange response.
+// Outputs other than frames are listed per transaction, and omitted when only frames were requested.
+type blockTraces struct {
+	BlockHash   common.Hash     `json:"blockHash"`
+	BlockNumber hexutil.Uint64  `json:"blockNumber"`
+	Traces      []traceFrame    `json:"traces"`
+	Results     *[]*traceResult `json:"results,omitempty"`
+	// the token resuming the range after this block, set when a progress token was passed
+	ProgressToken *string `json:"progressToken,omitempty"`
+}
+
+const (
+	traceTypeTrace     = "trace"
+	traceTypeStateDiff = "stateDiff"
+	traceTypeVmTrace   = "vmTrace"
+
+	traceTypeDestroyedContracts = "destroyedContracts"
+	traceTypeArbFees            = "arbFees"
+	traceTypeGasProfile         = "gasProfile"
+	traceTypeRetryable          = "retryable"
+	traceTypeAccessList         = "accessList"
+	traceTypeRefund             = "refund"
+	traceTypeOutbox             = "outbox"
+	traceTypeL2Pricing          = "l2Pricing"
+	traceTypeSummary            = "summary"
+	traceTypeLogs               = "logs"
+	traceTypeFeeInfo            = "feeInfo"
+	traceTypeAccessStats        = "accessStats"
+	traceTypeTokenTransfers     = "tokenTransfers"
+	traceTypeGasFrames          = "gasFrames"
+)
+
+// supportedTraceTypes lists the trace types requests may ask for, in the order errors list them.
+var supportedTraceTypes = []string{
+	traceTypeTrace,
+	traceTypeStateDiff,
+	traceTypeVmTrace,
+	traceTypeDestroyedContracts,
+	traceTypeArbFees,
+	traceTypeGasProfile,
+	traceTypeRetryable,
+	traceTypeAccessList,
+	traceTypeRefund,
+	traceTypeOutbox,
+	traceTypeL2Pricing,
+	traceTypeSummary,
+	traceTypeLogs,
+	traceTypeFeeInfo,
+	traceTypeAccessStats,
+	traceTypeTokenTransfers,
+	traceTypeGasFrames,
+}
+
+// ErrUnknownTraceType is returned when a request asks for a trace type that isn't supported.
+var ErrUnknownTraceType = errors.New("unknown trace type")
+
+// validateTraceTypes rejects requested trace types that aren't supported, listing those that are.
+func validateTraceTypes(traceTypes []string) error {
+	for _, traceType := range traceTypes {
+		supported := false
+		for _, candidate := range supportedTraceTypes {
+			if traceType == candidate {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("%w %q, expected one of: %v", ErrUnknownTraceType, traceType, strings.Join(supportedTraceTypes, ", "))
+		}
+	}
+	set := newTraceTypeSet(traceTypes)
+	for _, annotation := range []string{traceTypeLogs, traceTypeGasFrames} {
+		if set[annotation] && !set[traceTypeTrace] {
+			return fmt.Errorf("the %q trace type annotates frames, so requires the %q trace type", annotation, traceTypeTrace)
+		}
+	}
+	return nil
+}
+
+// traceTypeSet records which of the requested outputs a trace should produce.
+type traceTypeSet map[string]bool
+
+func newTraceTypeSet(traceTypes []string) traceTypeSet {
+	set := make(traceTypeSet, len(traceTypes))
+	for _, traceType := range traceTypes {
+		set[traceType] = true
+	}
+	return set
+}
+
+// key returns a canonical encoding of the set's trace types.
+func (set traceTypeSet) key() string {
+	traceTypes := make([]string, 0, len(set))
+	for traceType := range set {
+		traceTypes = append(traceTypes, traceType)
+	}
+	sort.Strings(traceTypes)
+	return strings.Join(traceTypes, ",")
+}
@@ -0,0 +1,102 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCallTraceRequestRoundTrip(t *testing.T) {
+	calls := []string{
+		`[{}, []]`,
+		`[{"to": "0x0000000000000000000000000000000000000002"}, ["trace"]]`,
+		`[{"from": "0x0000000000000000000000000000000000000001", "to": "0x0000000000000000000000000000000000000002",
+		  "gas": "0x5208", "maxFeePerGas": "0x3b9aca00", "maxPriorityFeePerGas": "0x0", "value": "0x1", "data": "0xabcd"},
+		  ["trace", "stateDiff", "vmTrace"]]`,
+		`[{"gasPrice": "0x1", "accessList": [{"address": "0x0000000000000000000000000000000000000003",
+		  "storageKeys": ["0x0000000000000000000000000000000000000000000000000000000000000007"]}]}, ["accessList"]]`,
+	}
+	for _, call := range calls {
+		var decoded callTraceRequest
+		if err := json.Unmarshal([]byte(call), &decoded); err != nil {
+			t.Fatalf("failed to decode %v: %v", call, err)
+		}
+		encoded, err := json.Marshal(&decoded)
+		if err != nil {
+			t.Fatalf("failed to encode %v: %v", call, err)
+		}
+		var redecoded callTraceRequest
+		if err := json.Unmarshal(encoded, &redecoded); err != nil {
+			t.Fatalf("failed to decode %v, the encoding of %v: %v", string(encoded), call, err)
+		}
+		if !reflect.DeepEqual(decoded, redecoded) {
+			t.Fatalf("%v decoded to %+v, but its encoding %v decoded to %+v", call, decoded, string(encoded), redecoded)
+		}
+		reencoded, err := json.Marshal(&redecoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("%v encoded as %v, then as %v", call, string(encoded), string(reencoded))
+		}
+	}
+
+	// no trace types encode as an empty list, which decodes back to one
+	encoded, err := json.Marshal(&callTraceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded callTraceRequest
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to decode %v: %v", string(encoded), err)
+	}
+	if decoded.traceTypes == nil || len(decoded.traceTypes) != 0 {
+		t.Fatalf("%v decoded trace types %#v", string(encoded), decoded.traceTypes)
+	}
+}
+
+func TestCallTraceRequestRejectsMalformedCalls(t *testing.T) {
+	cases := []struct {
+		call     string
+		expected string
+	}{
+		{`[{}]`, "expected two arguments per call, got 1"},
+		{`[{}, [], []]`, "expected two arguments per call, got 3"},
+		{`[]`, "expected two arguments per call, got 0"},
+		{`null`, "expected two arguments per call, got 0"},
+		{`{"callArgs": {}, "traceTypes": []}`, "cannot unmarshal"},
+		{`[null, ["trace"]]`, "invalid call arguments"},
+		{`[{}, null]`, "invalid trace types"},
+		{`[{}, "trace"]`, "invalid trace types"},
+		{`[{"too": "0x0000000000000000000000000000000000000002"}, []]`, `unknown field "too"`},
+		{`[[], ["trace"]]`, "invalid call arguments"},
+	}
+	for _, test := range cases {
+		var decoded callTraceRequest
+		err := json.Unmarshal([]byte(test.call), &decoded)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("expected %v to be rejected with %q, got %v", test.call, test.expected, err)
+		}
+	}
+
+	batches := []struct {
+		batch    string
+		expected string
+	}{
+		{`[[{}, []], null]`, "call 1: expected a call, got null"},
+		{`[[{}, []], [{}]]`, "call 1: expected two arguments per call"},
+		{`[[{}, ["trace"], ["trace"]]]`, "call 0: expected two arguments per call"},
+	}
+	for _, test := range batches {
+		var decoded callTraceRequests
+		err := json.Unmarshal([]byte(test.batch), &decoded)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("expected %v to be rejected with %q, got %v", test.batch, test.expected, err)
+		}
+	}
+}
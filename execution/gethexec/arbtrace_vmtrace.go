@@ -0,0 +1,182 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// vmTrace is Parity's opcode-level trace of a single call frame.
+type vmTrace struct {
+	Code hexutil.Bytes  `json:"code"`
+	Ops  []*vmOperation `json:"ops"`
+	// set on the outermost frame when the trace reached the configured limit on operations,
+	// leaving out every operation past it
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type vmOperation struct {
+	Cost uint64               `json:"cost"`
+	Ex   *vmExecutedOperation `json:"ex"`
+	Pc   uint64               `json:"pc"`
+	Sub  *vmTrace             `json:"sub"`
+}
+
+// vmExecutedOperation describes the effects of an operation once it has executed.
+type vmExecutedOperation struct {
+	Mem   *vmMemoryDiff  `json:"mem"`
+	Push  []*hexutil.Big `json:"push"`
+	Store *vmStoreDiff   `json:"store"`
+	Used  uint64         `json:"used"`
+}
+
+type vmMemoryDiff struct {
+	Off  uint64        `json:"off"`
+	Data hexutil.Bytes `json:"data"`
+}
+
+type vmStoreDiff struct {
+	Key *hexutil.Big `json:"key"`
+	Val *hexutil.Big `json:"val"`
+}
+
+// vmTraceFrame tracks the operation awaiting its effects within a call frame.
+// An operation's effects only become visible at the next step of the same frame.
+type vmTraceFrame struct {
+	trace    *vmTrace
+	pending  *vmOperation
+	gasAfter uint64
+	pushes   int
+	memory   *vmMemoryDiff
+	memSize  uint64
+	store    *vmStoreDiff
+}
+
+func newVmTraceFrame(code []byte) *vmTraceFrame {
+	return &vmTraceFrame{
+		trace: &vmTrace{
+			Code: common.CopyBytes(code),
+			Ops:  []*vmOperation{},
+		},
+	}
+}
+
+// stackBack returns the nth element from the top of the stack, or zero if there isn't one.
+func stackBack(stack []uint256.Int, n int) *uint256.Int {
+	if n >= len(stack) {
+		return new(uint256.Int)
+	}
+	return &stack[len(stack)-1-n]
+}
+
+func uint256ToHex(value *uint256.Int) *hexutil.Big {
+	return (*hexutil.Big)(value.ToBig())
+}
+
+// stackPushes counts the items an opcode leaves on the stack, following Parity in
+// reporting the full affected range for DUPs and SWAPs.
+func stackPushes(op vm.OpCode) int {
+	switch {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	}
+	switch op {
+	case vm.STOP, vm.JUMP, vm.JUMPI, vm.JUMPDEST, vm.POP,
+		vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.TSTORE, vm.MCOPY,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4,
+		vm.RETURN, vm.REVERT, vm.INVALID, vm.SELFDESTRUCT:
+		return 0
+	}
+	return 1
+}
+
+// memoryWritten returns the region of memory an opcode is about to write.
+func memoryWritten(op vm.OpCode, stack []uint256.Int) (uint64, uint64, bool) {
+	var offset, size *uint256.Int
+	switch op {
+	case vm.MSTORE:
+		offset, size = stackBack(stack, 0), uint256.NewInt(32)
+	case vm.MSTORE8:
+		offset, size = stackBack(stack, 0), uint256.NewInt(1)
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY, vm.MCOPY:
+		offset, size = stackBack(stack, 0), stackBack(stack, 2)
+	case vm.EXTCODECOPY:
+		offset, size = stackBack(stack, 1), stackBack(stack, 3)
+	case vm.CALL, vm.CALLCODE:
+		offset, size = stackBack(stack, 5), stackBack(stack, 6)
+	case vm.DELEGATECALL, vm.STATICCALL:
+		offset, size = stackBack(stack, 4), stackBack(stack, 5)
+	default:
+		return 0, 0, false
+	}
+	if !offset.IsUint64() || !size.IsUint64() || size.IsZero() {
+		return 0, 0, false
+	}
+	return offset.Uint64(), size.Uint64(), true
+}
+
+// step records a new operation, first settling the one before it.
+func (f *vmTraceFrame) step(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext) {
+	stack := scope.Stack.Data()
+	f.settle(gas, stack, scope.Memory.Data())
+
+	operation := &vmOperation{Pc: pc, Cost: cost}
+	f.trace.Ops = append(f.trace.Ops, operation)
+	f.pending = operation
+	f.gasAfter = gas
+	if cost <= gas {
+		f.gasAfter = gas - cost
+	}
+	f.pushes = stackPushes(op)
+	f.memory = nil
+	if offset, size, ok := memoryWritten(op, stack); ok {
+		f.memory = &vmMemoryDiff{Off: offset}
+		f.memSize = size
+	}
+	f.store = nil
+	if op == vm.SSTORE {
+		f.store = &vmStoreDiff{
+			Key: uint256ToHex(stackBack(stack, 0)),
+			Val: uint256ToHex(stackBack(stack, 1)),
+		}
+	}
+}
+
+// settle fills in the effects of the pending operation from the frame's current state.
+func (f *vmTraceFrame) settle(gasLeft uint64, stack []uint256.Int, memory []byte) {
+	if f.pending == nil {
+		return
+	}
+	executed := &vmExecutedOperation{
+		Push:  []*hexutil.Big{},
+		Store: f.store,
+		Used:  gasLeft,
+	}
+	pushes := f.pushes
+	if pushes > len(stack) {
+		pushes = len(stack)
+	}
+	for i := len(stack) - pushes; i < len(stack); i++ {
+		executed.Push = append(executed.Push, uint256ToHex(&stack[i]))
+	}
+	if f.memory != nil && f.memSize <= uint64(len(memory)) && f.memory.Off <= uint64(len(memory))-f.memSize {
+		f.memory.Data = common.CopyBytes(memory[f.memory.Off : f.memory.Off+f.memSize])
+		executed.Mem = f.memory
+	}
+	f.pending.Ex = executed
+	f.pending = nil
+}
+
+// finish settles the frame's final operation, which leaves nothing behind to observe.
+func (f *vmTraceFrame) finish() {
+	f.pushes = 0
+	f.memory = nil
+	f.settle(f.gasAfter, nil, nil)
+}
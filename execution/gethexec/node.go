@@ -52,6 +52,7 @@ type Config struct {
 	Dangerous                 DangerousConfig                  `koanf:"dangerous"`
 	EnablePrefetchBlock       bool                             `koanf:"enable-prefetch-block"`
 	SyncMonitor               SyncMonitorConfig                `koanf:"sync-monitor"`
+	ArbTrace                  ArbTraceConfig                   `koanf:"arbtrace" reload:"hot"`
 
 	forwardingTarget string
 }
@@ -60,6 +61,9 @@ func (c *Config) Validate() error {
 	if err := c.Sequencer.Validate(); err != nil {
 		return err
 	}
+	if err := c.ArbTrace.Validate(); err != nil {
+		return err
+	}
 	if !c.Sequencer.Enable && c.ForwardingTarget == "" {
 		return errors.New("ForwardingTarget not set and not sequencer (can use \"null\")")
 	}
@@ -85,6 +89,7 @@ func ConfigAddOptions(prefix string, f *flag.FlagSet) {
 	TxPreCheckerConfigAddOptions(prefix+".tx-pre-checker", f)
 	CachingConfigAddOptions(prefix+".caching", f)
 	SyncMonitorConfigAddOptions(prefix+".sync-monitor", f)
+	ArbTraceConfigAddOptions(prefix+".arbtrace", f)
 	f.Uint64(prefix+".tx-lookup-limit", ConfigDefault.TxLookupLimit, "retain the ability to lookup transactions by hash for the past N blocks (0 = all blocks)")
 	DangerousConfigAddOptions(prefix+".dangerous", f)
 	f.Bool(prefix+".enable-prefetch-block", ConfigDefault.EnablePrefetchBlock, "enable prefetching of blocks")
@@ -103,6 +108,7 @@ var ConfigDefault = Config{
 	Dangerous:                 DefaultDangerousConfig,
 	Forwarder:                 DefaultNodeForwarderConfig,
 	EnablePrefetchBlock:       true,
+	ArbTrace:                  DefaultArbTraceConfig,
 }
 
 func ConfigDefaultNonSequencerTest() *Config {
@@ -228,7 +234,7 @@ func CreateExecutionNode(
 	apis := []rpc.API{{
 		Namespace: "arb",
 		Version:   "1.0",
-		Service:   NewArbAPI(txPublisher),
+		Service:   NewArbAPI(txPublisher, l2BlockChain),
 		Public:    false,
 	}}
 	apis = append(apis, rpc.API{
@@ -241,15 +247,41 @@ func CreateExecutionNode(
 		),
 		Public: false,
 	})
-	apis = append(apis, rpc.API{
-		Namespace: "arbtrace",
-		Version:   "1.0",
-		Service: NewArbTraceForwarderAPI(
+	arbTraceConfigFetcher := func() *ArbTraceConfig { return &configFetcher().ArbTrace }
+	var pendingTxs pendingTxSource
+	if sequencer != nil {
+		pendingTxs = sequencer
+	}
+	// geth ignores Public, so arbtrace is only kept off HTTP and WS by leaving it out of their api
+	// lists, which the node's config validation insists are explicit (see genericconf.GatedAPIs)
+	arbTraceAPI := NewArbTraceAPI(
+		l2BlockChain,
+		chainDB,
+		backend.APIBackend(),
+		l1client,
+		pendingTxs,
+		execEngine,
+		arbTraceConfigFetcher,
+		NewArbTraceForwarderAPI(
 			config.RPC.ClassicRedirect,
 			config.RPC.ClassicRedirectTimeout,
+			arbTraceConfigFetcher,
 		),
-		Public: false,
+	)
+	apis = append(apis, rpc.API{
+		Namespace: "arbtrace",
+		Version:   "1.0",
+		Service:   arbTraceAPI,
+		Public:    false,
 	})
+	if config.ArbTrace.EnableTraceCompat {
+		apis = append(apis, rpc.API{
+			Namespace: "trace",
+			Version:   "1.0",
+			Service:   NewTraceCompatAPI(arbTraceAPI),
+			Public:    false,
+		})
+	}
 	apis = append(apis, rpc.API{
 		Namespace: "debug",
 		Service:   eth.NewDebugAPI(eth.NewArbEthereum(l2BlockChain, chainDB)),
@@ -10,6 +10,7 @@ import (
 	"math"
 	"math/big"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -327,6 +328,16 @@ type Sequencer struct {
 	expectedSurplusMutex   sync.RWMutex
 	expectedSurplus        int64
 	expectedSurplusUpdated bool
+
+	// transactions submitted but not yet sequenced or rejected, keyed by hash with their submission order
+	pendingTxsMutex sync.Mutex
+	pendingTxs      map[common.Hash]pendingTx
+	pendingTxsCount uint64
+}
+
+type pendingTx struct {
+	tx    *types.Transaction
+	order uint64
 }
 
 func NewSequencer(execEngine *ExecutionEngine, l1Reader *headerreader.HeaderReader, configFetcher SequencerConfigFetcher) (*Sequencer, error) {
@@ -345,6 +356,7 @@ func NewSequencer(execEngine *ExecutionEngine, l1Reader *headerreader.HeaderRead
 	s := &Sequencer{
 		execEngine:      execEngine,
 		txQueue:         make(chan txQueueItem, config.QueueSize),
+		pendingTxs:      make(map[common.Hash]pendingTx),
 		l1Reader:        l1Reader,
 		config:          configFetcher,
 		senderWhitelist: senderWhitelist,
@@ -446,6 +458,9 @@ func (s *Sequencer) PublishTransaction(parentCtx context.Context, tx *types.Tran
 	abortCtx, cancel := ctxWithTimeout(parentCtx, queueTimeout*2)
 	defer cancel()
 
+	s.addPendingTx(tx)
+	defer s.removePendingTx(tx)
+
 	resultChan := make(chan error, 1)
 	queueItem := txQueueItem{
 		tx,
@@ -476,6 +491,36 @@ func (s *Sequencer) PublishTransaction(parentCtx context.Context, tx *types.Tran
 	}
 }
 
+func (s *Sequencer) addPendingTx(tx *types.Transaction) {
+	s.pendingTxsMutex.Lock()
+	defer s.pendingTxsMutex.Unlock()
+	s.pendingTxs[tx.Hash()] = pendingTx{tx, s.pendingTxsCount}
+	s.pendingTxsCount++
+}
+
+func (s *Sequencer) removePendingTx(tx *types.Transaction) {
+	s.pendingTxsMutex.Lock()
+	defer s.pendingTxsMutex.Unlock()
+	delete(s.pendingTxs, tx.Hash())
+}
+
+// PendingTransactions returns the transactions awaiting sequencing, in the order they were submitted.
+// Transactions are only pending until they're sequenced or rejected, so the result is soon outdated.
+func (s *Sequencer) PendingTransactions() []*types.Transaction {
+	s.pendingTxsMutex.Lock()
+	pending := make([]pendingTx, 0, len(s.pendingTxs))
+	for _, entry := range s.pendingTxs {
+		pending = append(pending, entry)
+	}
+	s.pendingTxsMutex.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].order < pending[j].order })
+	txs := make([]*types.Transaction, 0, len(pending))
+	for _, entry := range pending {
+		txs = append(txs, entry.tx)
+	}
+	return txs
+}
+
 func (s *Sequencer) preTxFilter(_ *params.ChainConfig, header *types.Header, statedb *state.StateDB, _ *arbosState.ArbosState, tx *types.Transaction, options *arbitrum_types.ConditionalOptions, sender common.Address, l1Info *arbos.L1Info) error {
 	if s.nonceCache.Caching() {
 		stateNonce := s.nonceCache.Get(header, statedb, sender)
@@ -78,6 +78,9 @@ type BatchFetcher interface {
 	FetchBatch(ctx context.Context, batchNum uint64) ([]byte, common.Hash, error)
 	FindInboxBatchContainingMessage(message arbutil.MessageIndex) (uint64, bool, error)
 	GetBatchParentChainBlock(seqNum uint64) (uint64, error)
+	GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error)
+	GetBatchCount() (uint64, error)
+	GetDelayedCount() (uint64, error)
 }
 
 type ConsensusInfo interface {
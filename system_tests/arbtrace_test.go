@@ -1,34 +1,95 @@
 package arbtest
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/arbitrum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/offchainlabs/nitro/arbnode"
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbos/l1pricing"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/cmd/genericconf"
+	"github.com/offchainlabs/nitro/execution/gethexec"
+	"github.com/offchainlabs/nitro/solgen/go/precompilesgen"
+	"github.com/offchainlabs/nitro/util/arbmath"
+	"github.com/offchainlabs/nitro/util/testhelpers"
 )
 
 type callTxArgs struct {
-	From       *common.Address `json:"from"`
-	To         *common.Address `json:"to"`
-	Gas        *hexutil.Uint64 `json:"gas"`
-	GasPrice   *hexutil.Big    `json:"gasPrice"`
-	Value      *hexutil.Big    `json:"value"`
-	Data       *hexutil.Bytes  `json:"data"`
-	Aggregator *common.Address `json:"aggregator"`
+	From                 *common.Address   `json:"from"`
+	To                   *common.Address   `json:"to"`
+	Gas                  *hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big      `json:"value"`
+	Data                 *hexutil.Bytes    `json:"data"`
+	AccessList           *types.AccessList `json:"accessList"`
+	Aggregator           *common.Address   `json:"aggregator"`
 }
 type traceAction struct {
-	CallType string          `json:"callType,omitempty"`
-	From     common.Address  `json:"from"`
-	Gas      hexutil.Uint64  `json:"gas"`
-	Input    *hexutil.Bytes  `json:"input,omitempty"`
-	Init     hexutil.Bytes   `json:"init,omitempty"`
-	To       *common.Address `json:"to,omitempty"`
-	Value    *hexutil.Big    `json:"value"`
+	CallType       string                     `json:"callType,omitempty"`
+	CreationMethod string                     `json:"creationMethod,omitempty"`
+	From           common.Address             `json:"from"`
+	Gas            hexutil.Uint64             `json:"gas"`
+	Input          *hexutil.Bytes             `json:"input,omitempty"`
+	Init           hexutil.Bytes              `json:"init,omitempty"`
+	To             *common.Address            `json:"to,omitempty"`
+	Value          *hexutil.Big               `json:"value"`
+	Author         *common.Address            `json:"author,omitempty"`
+	RewardType     string                     `json:"rewardType,omitempty"`
+	Method         string                     `json:"method,omitempty"`
+	Update         map[string]json.RawMessage `json:"update,omitempty"`
+	// set on the top-level frame of a retryable's submission
+	SubmitRetryable *submitRetryableAction `json:"submitRetryable,omitempty"`
+	// set on create2 frames
+	Salt         *common.Hash `json:"salt,omitempty"`
+	InitCodeHash *common.Hash `json:"initCodeHash,omitempty"`
+}
+
+type submitRetryableAction struct {
+	RequestId        common.Hash     `json:"requestId"`
+	L1BaseFee        *hexutil.Big    `json:"l1BaseFee"`
+	DepositValue     *hexutil.Big    `json:"depositValue"`
+	GasFeeCap        *hexutil.Big    `json:"gasFeeCap"`
+	Gas              hexutil.Uint64  `json:"gas"`
+	RetryTo          *common.Address `json:"retryTo"`
+	RetryValue       *hexutil.Big    `json:"retryValue"`
+	Beneficiary      common.Address  `json:"beneficiary"`
+	MaxSubmissionFee *hexutil.Big    `json:"maxSubmissionFee"`
+	FeeRefundAddr    common.Address  `json:"feeRefundAddr"`
+	RetryData        hexutil.Bytes   `json:"retryData"`
 }
 
 type traceCallResult struct {
@@ -44,19 +105,209 @@ type traceFrame struct {
 	BlockNumber         *uint64          `json:"blockNumber,omitempty"`
 	Result              *traceCallResult `json:"result,omitempty"`
 	Error               *string          `json:"error,omitempty"`
+	RevertReason        *string          `json:"revertReason,omitempty"`
+	ErrorReason         *string          `json:"errorReason,omitempty"`
 	Subtraces           int              `json:"subtraces"`
 	TraceAddress        []int            `json:"traceAddress"`
 	TransactionHash     *hexutil.Bytes   `json:"transactionHash,omitempty"`
 	TransactionPosition *uint64          `json:"transactionPosition,omitempty"`
 	Type                string           `json:"type"`
+	Precompile          *precompileCall  `json:"precompile,omitempty"`
+	StorageAddress      *common.Address  `json:"storageAddress,omitempty"`
+	Logs                *[]traceLog      `json:"logs,omitempty"`
+	GasLeftAtEntry      *hexutil.Uint64  `json:"gasLeftAtEntry,omitempty"`
+	GasLeftAtExit       *hexutil.Uint64  `json:"gasLeftAtExit,omitempty"`
+	Removed             bool             `json:"removed,omitempty"`
+}
+
+type traceLog struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+	Data    hexutil.Bytes  `json:"data"`
+	Index   hexutil.Uint64 `json:"index"`
+	Event   *struct {
+		Name  string                 `json:"name"`
+		Event string                 `json:"event"`
+		Args  map[string]interface{} `json:"args"`
+	} `json:"event"`
+}
+
+type precompileCall struct {
+	Name         string                 `json:"name"`
+	Method       string                 `json:"method"`
+	Args         map[string]interface{} `json:"args"`
+	AddressTable *addressTableEntry     `json:"addressTable"`
+}
+
+type addressTableEntry struct {
+	Index   hexutil.Uint64  `json:"index"`
+	Address *common.Address `json:"address"`
+}
+
+type accountDiff struct {
+	Balance json.RawMessage                 `json:"balance"`
+	Nonce   json.RawMessage                 `json:"nonce"`
+	Code    json.RawMessage                 `json:"code"`
+	Storage map[common.Hash]json.RawMessage `json:"storage"`
+}
+
+type vmStoreDiff struct {
+	Key *hexutil.Big `json:"key"`
+	Val *hexutil.Big `json:"val"`
+}
+
+type vmExecutedOperation struct {
+	Push  []*hexutil.Big `json:"push"`
+	Store *vmStoreDiff   `json:"store"`
+	Used  uint64         `json:"used"`
+}
+
+type vmOperation struct {
+	Cost uint64               `json:"cost"`
+	Ex   *vmExecutedOperation `json:"ex"`
+	Pc   uint64               `json:"pc"`
+	Sub  *vmTrace             `json:"sub"`
+}
+
+type vmTrace struct {
+	Code      hexutil.Bytes  `json:"code"`
+	Ops       []*vmOperation `json:"ops"`
+	Truncated bool           `json:"truncated"`
+}
+
+type feeInfo struct {
+	EffectiveGasPrice    *hexutil.Big   `json:"effectiveGasPrice"`
+	BaseFee              *hexutil.Big   `json:"baseFee"`
+	PriorityFeePerGas    *hexutil.Big   `json:"priorityFeePerGas"`
+	EffectivePriorityFee *hexutil.Big   `json:"effectivePriorityFee"`
+	TipDropped           bool           `json:"tipDropped"`
+	GasUsed              hexutil.Uint64 `json:"gasUsed"`
+	GasUsedForL1         hexutil.Uint64 `json:"gasUsedForL1"`
+	L1Fee                *hexutil.Big   `json:"l1Fee"`
+	TotalFee             *hexutil.Big   `json:"totalFee"`
 }
 
 type traceResult struct {
-	Output             hexutil.Bytes     `json:"output"`
-	StateDiff          *int              `json:"stateDiff"`
-	Trace              []traceFrame      `json:"trace"`
-	VmTrace            *int              `json:"vmTrace"`
-	DestroyedContracts *[]common.Address `json:"destroyedContracts"`
+	Output             hexutil.Bytes                   `json:"output"`
+	StateDiff          map[common.Address]*accountDiff `json:"stateDiff"`
+	Trace              []traceFrame                    `json:"trace"`
+	VmTrace            *vmTrace                        `json:"vmTrace"`
+	DestroyedContracts *[]common.Address               `json:"destroyedContracts"`
+	ArbitrumFees       *arbitrumFees                   `json:"arbitrumFees"`
+	GasProfile         *gasProfile                     `json:"gasProfile"`
+	Retryable          *[]retryableFrame               `json:"retryable"`
+	AccessList         *types.AccessList               `json:"accessList"`
+	Refund             *gasRefund                      `json:"refund"`
+	Outbox             *[]outboxMessage                `json:"outbox"`
+	L2Pricing          *l2Pricing                      `json:"l2Pricing"`
+	Summary            *traceSummary                   `json:"summary"`
+	FeeInfo            *feeInfo                        `json:"feeInfo"`
+	AccessStats        *accessStats                    `json:"accessStats"`
+	TokenTransfers     *[]tokenTransfer                `json:"tokenTransfers"`
+	EffectiveGas       *hexutil.Uint64                 `json:"effectiveGas"`
+	GasCapped          bool                            `json:"gasCapped"`
+	StateRoot          *common.Hash                    `json:"stateRoot"`
+}
+
+type l2Pricing struct {
+	BaseFee             *hexutil.Big   `json:"baseFee"`
+	MinBaseFee          *hexutil.Big   `json:"minBaseFee"`
+	SpeedLimitPerSecond hexutil.Uint64 `json:"speedLimitPerSecond"`
+	GasBacklog          hexutil.Uint64 `json:"gasBacklog"`
+	BacklogTolerance    hexutil.Uint64 `json:"backlogTolerance"`
+	PricingInertia      hexutil.Uint64 `json:"pricingInertia"`
+}
+
+type traceSummary struct {
+	Frames           hexutil.Uint64 `json:"frames"`
+	MaxDepth         hexutil.Uint64 `json:"maxDepth"`
+	AddressesTouched hexutil.Uint64 `json:"addressesTouched"`
+	ValueMoved       *hexutil.Big   `json:"valueMoved"`
+	GasUsed          hexutil.Uint64 `json:"gasUsed"`
+	Reverted         bool           `json:"reverted"`
+}
+
+type outboxMessage struct {
+	Caller      common.Address `json:"caller"`
+	Destination common.Address `json:"destination"`
+	CallValue   *hexutil.Big   `json:"callValue"`
+	Data        hexutil.Bytes  `json:"data"`
+	ArbBlockNum hexutil.Uint64 `json:"arbBlockNum"`
+	LeafHash    common.Hash    `json:"leafHash"`
+	LeafIndex   hexutil.Uint64 `json:"leafIndex"`
+}
+
+type gasRefund struct {
+	Total   hexutil.Uint64 `json:"total"`
+	Cap     hexutil.Uint64 `json:"cap"`
+	Applied hexutil.Uint64 `json:"applied"`
+}
+
+type retryableFrame struct {
+	Type              string          `json:"type"`
+	TicketId          common.Hash     `json:"ticketId"`
+	Beneficiary       *common.Address `json:"beneficiary"`
+	CallValue         *hexutil.Big    `json:"callValue"`
+	MaxSubmissionCost *hexutil.Big    `json:"maxSubmissionCost"`
+	Timeout           *hexutil.Uint64 `json:"timeout"`
+	RedeemType        string          `json:"redeemType"`
+	RetryTxHash       *common.Hash    `json:"retryTxHash"`
+	SequenceNum       *hexutil.Uint64 `json:"sequenceNum"`
+	Success           *bool           `json:"success"`
+	TicketClosed      *bool           `json:"ticketClosed"`
+}
+
+type parentChainTxTrace struct {
+	MessageIndex    hexutil.Uint64 `json:"messageIndex"`
+	TransactionHash common.Hash    `json:"transactionHash"`
+	Trace           []traceFrame   `json:"trace"`
+}
+
+type parentChainTxTraces struct {
+	Processed    bool                 `json:"processed"`
+	Transactions []parentChainTxTrace `json:"transactions"`
+}
+
+type delayedMessageTraces struct {
+	MessageIndex  hexutil.Uint64       `json:"messageIndex"`
+	MessageNumber hexutil.Uint64       `json:"messageNumber"`
+	BlockNumber   hexutil.Uint64       `json:"blockNumber"`
+	BlockHash     common.Hash          `json:"blockHash"`
+	Batch         *hexutil.Uint64      `json:"batch"`
+	Transactions  []parentChainTxTrace `json:"transactions"`
+}
+
+type accessStats struct {
+	Accounts     hexutil.Uint64 `json:"accounts"`
+	SlotsRead    hexutil.Uint64 `json:"slotsRead"`
+	SlotsWritten hexutil.Uint64 `json:"slotsWritten"`
+	Frames       []struct {
+		TraceAddress []int          `json:"traceAddress"`
+		Accounts     hexutil.Uint64 `json:"accounts"`
+		SlotsRead    hexutil.Uint64 `json:"slotsRead"`
+		SlotsWritten hexutil.Uint64 `json:"slotsWritten"`
+	} `json:"frames"`
+}
+
+type gasProfile struct {
+	Intrinsic hexutil.Uint64 `json:"intrinsic"`
+	L1Posting hexutil.Uint64 `json:"l1Posting"`
+	Frames    []struct {
+		TraceAddress []int          `json:"traceAddress"`
+		GasUsed      hexutil.Uint64 `json:"gasUsed"`
+		Computation  hexutil.Uint64 `json:"computation"`
+		Storage      hexutil.Uint64 `json:"storage"`
+		Memory       hexutil.Uint64 `json:"memory"`
+	} `json:"frames"`
+}
+
+type arbitrumFees struct {
+	BaseFee      *hexutil.Big   `json:"baseFee"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	GasUsedForL1 hexutil.Uint64 `json:"gasUsedForL1"`
+	GasUsedForL2 hexutil.Uint64 `json:"gasUsedForL2"`
+	L1Fee        *hexutil.Big   `json:"l1Fee"`
+	L2Fee        *hexutil.Big   `json:"l2Fee"`
 }
 
 type callTraceRequest struct {
@@ -86,8 +337,18 @@ type filterRequest struct {
 	ToBlock     *rpc.BlockNumberOrHash `json:"toBlock"`
 	FromAddress *[]common.Address      `json:"fromAddress"`
 	ToAddress   *[]common.Address      `json:"toAddress"`
+	CallTypes   *[]string              `json:"callTypes"`
+	MinValue    *hexutil.Big           `json:"minValue"`
+	MaxValue    *hexutil.Big           `json:"maxValue"`
+	Selectors   *[]hexutil.Bytes       `json:"selectors"`
 	After       *uint64                `json:"after"`
 	Count       *uint64                `json:"count"`
+	Cursor      *string                `json:"cursor,omitempty"`
+}
+
+type filterResult struct {
+	Traces     []traceFrame `json:"traces"`
+	NextCursor *string      `json:"nextCursor"`
 }
 
 type ArbTraceAPIStub struct {
@@ -178,3 +439,5665 @@ func TestArbTraceForwarding(t *testing.T) {
 	err = l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filter)
 	Require(t, err)
 }
+
+// blockRefStub records the block references forwarded to it.
+type blockRefStub struct {
+	blockNums chan json.RawMessage
+}
+
+func (s *blockRefStub) Block(ctx context.Context, blockNum json.RawMessage) ([]traceFrame, error) {
+	s.blockNums <- blockNum
+	return []traceFrame{}, nil
+}
+
+func TestArbTraceForwardingNormalizesBlockRefs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stub := &blockRefStub{blockNums: make(chan json.RawMessage, 1)}
+	srv := rpc.NewServer()
+	Require(t, srv.RegisterName("arbtrace", stub))
+	defer srv.Stop()
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	config := gethexec.DefaultArbTraceConfig
+	Require(t, config.Validate())
+	forwarder := gethexec.NewArbTraceForwarderAPI(httpSrv.URL, time.Second, func() *gethexec.ArbTraceConfig { return &config })
+	hash := common.HexToHash("0x1234")
+	forms := map[string]string{
+		`100`:                                `"0x64"`,
+		`"100"`:                              `"0x64"`,
+		`"0x64"`:                             `"0x64"`,
+		`"0X64"`:                             `"0x64"`,
+		`{"blockNumber":"0x64"}`:             `"0x64"`,
+		`"earliest"`:                         `"earliest"`,
+		`"latest"`:                           `"latest"`,
+		`"LATEST"`:                           `"latest"`,
+		`"pending"`:                          `"pending"`,
+		`"safe"`:                             `"safe"`,
+		`"finalized"`:                        `"finalized"`,
+		`"` + hash.Hex() + `"`:               `"` + hash.Hex() + `"`,
+		`{"blockHash":"` + hash.Hex() + `"}`: `"` + hash.Hex() + `"`,
+	}
+	for form, expected := range forms {
+		_, err := forwarder.Block(ctx, json.RawMessage(form))
+		Require(t, err, "forwarding", form)
+		if forwarded := string(<-stub.blockNums); forwarded != expected {
+			Fatal(t, "block reference", form, "was forwarded as", forwarded, "rather than", expected)
+		}
+	}
+	for _, invalid := range []string{`-1`, `1.5`, `"12ab"`, `"9223372036854775808"`} {
+		if _, err := forwarder.Block(ctx, json.RawMessage(invalid)); err == nil {
+			Fatal(t, "block reference", invalid, "should have been rejected")
+		}
+	}
+}
+
+func TestArbTraceForwardingRoutes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startStub := func() (*blockRefStub, string) {
+		stub := &blockRefStub{blockNums: make(chan json.RawMessage, 1)}
+		srv := rpc.NewServer()
+		Require(t, srv.RegisterName("arbtrace", stub))
+		t.Cleanup(srv.Stop)
+		httpSrv := httptest.NewServer(srv)
+		t.Cleanup(httpSrv.Close)
+		return stub, httpSrv.URL
+	}
+	defaultStub, defaultUrl := startStub()
+	routedStub, routedUrl := startStub()
+	forwarder := func(routes ...string) *gethexec.ArbTraceForwarderAPI {
+		config := gethexec.DefaultArbTraceConfig
+		config.ClassicRedirectRoutes = routes
+		Require(t, config.Validate())
+		return gethexec.NewArbTraceForwarderAPI(defaultUrl, time.Second, func() *gethexec.ArbTraceConfig { return &config })
+	}
+	expectForwardedTo := func(forwarder *gethexec.ArbTraceForwarderAPI, expected *blockRefStub, other *blockRefStub) {
+		t.Helper()
+		_, err := forwarder.Block(ctx, json.RawMessage(`"latest"`))
+		Require(t, err)
+		select {
+		case <-expected.blockNums:
+		default:
+			Fatal(t, "arbtrace_block wasn't forwarded to the classic node it was routed to")
+		}
+		select {
+		case <-other.blockNums:
+			Fatal(t, "arbtrace_block was forwarded to a classic node it wasn't routed to")
+		default:
+		}
+	}
+
+	expectForwardedTo(forwarder(), defaultStub, routedStub)
+	expectForwardedTo(forwarder("arbtrace_block="+routedUrl), routedStub, defaultStub)
+	expectForwardedTo(forwarder("arbtrace_filter="+routedUrl), defaultStub, routedStub)
+	// the longest prefix wins, whatever the order of the routes
+	expectForwardedTo(forwarder("arbtrace_block="+defaultUrl, "arbtrace_="+routedUrl), defaultStub, routedStub)
+	expectForwardedTo(forwarder("arbtrace_="+routedUrl, "arbtrace_b="+defaultUrl), defaultStub, routedStub)
+
+	// an empty target keeps the methods local, which can't serve classic history
+	_, err := forwarder("arbtrace_block=").Block(ctx, json.RawMessage(`"latest"`))
+	if err == nil || err.Error() != gethexec.ErrArbTraceNotEnabled.Error() {
+		Fatal(t, "expected", gethexec.ErrArbTraceNotEnabled, "but got", err)
+	}
+
+	for _, routes := range [][]string{
+		{"arbtrace_block"},
+		{"=" + routedUrl},
+		{"eth_=" + routedUrl},
+		{"arbtrace_block=" + routedUrl, "arbtrace_block=" + defaultUrl},
+	} {
+		config := gethexec.DefaultArbTraceConfig
+		config.ClassicRedirectRoutes = routes
+		if err := config.Validate(); err == nil {
+			Fatal(t, "expected routes", routes, "to be rejected")
+		}
+	}
+	// repeating a route isn't a conflict
+	config := gethexec.DefaultArbTraceConfig
+	config.ClassicRedirectRoutes = []string{"arbtrace_block=" + routedUrl, "arbtrace_block=" + routedUrl}
+	Require(t, config.Validate())
+}
+
+func TestArbTraceDecimalBlockNumbers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	number := receipt.BlockNumber.Uint64()
+	var expected json.RawMessage
+	Require(t, l2rpc.CallContext(ctx, &expected, "arbtrace_block", hexutil.Uint64(number)))
+	for _, form := range []interface{}{number, fmt.Sprint(number)} {
+		var frames json.RawMessage
+		Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_block", form))
+		if !bytes.Equal(expected, frames) {
+			Fatal(t, "block", form, "traced differently than its hex form\n", string(expected), "\n", string(frames))
+		}
+	}
+}
+
+func TestArbTraceForwardingHTTPAndWS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := rpc.NewServer()
+	Require(t, srv.RegisterName("arbtrace", &ArbTraceAPIStub{t: t}))
+	defer srv.Stop()
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+	wsSrv := httptest.NewServer(srv.WebsocketHandler([]string{"*"}))
+	defer wsSrv.Close()
+
+	targets := []string{httpSrv.URL, "ws://" + strings.TrimPrefix(wsSrv.URL, "http://")}
+	for _, target := range targets {
+		builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+		builder.execConfig.RPC.ClassicRedirect = target
+		builder.execConfig.RPC.ClassicRedirectTimeout = time.Second
+		cleanup := builder.Build(t)
+
+		l2rpc := builder.L2.Stack.Attach()
+		var result traceResult
+		err := l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", hexutil.Bytes{}, []string{"trace"})
+		Require(t, err, "forwarding to", target)
+		var frames []traceFrame
+		err = l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", hexutil.Bytes{})
+		Require(t, err, "forwarding to", target)
+		cleanup()
+	}
+}
+
+type slowArbTraceStub struct {
+	delay time.Duration
+}
+
+func (s *slowArbTraceStub) ReplayTransaction(ctx context.Context, txHash hexutil.Bytes, traceTypes []string) (*traceResult, error) {
+	time.Sleep(s.delay)
+	return &traceResult{}, nil
+}
+
+func (s *slowArbTraceStub) Transaction(ctx context.Context, txHash hexutil.Bytes) ([]traceFrame, error) {
+	time.Sleep(s.delay)
+	return []traceFrame{}, nil
+}
+
+// concurrentArbTraceStub records how many forwarded requests it served at once.
+type concurrentArbTraceStub struct {
+	delay    time.Duration
+	inFlight atomic.Int64
+	maxSeen  atomic.Int64
+}
+
+func (s *concurrentArbTraceStub) Transaction(ctx context.Context, txHash hexutil.Bytes) ([]traceFrame, error) {
+	current := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		seen := s.maxSeen.Load()
+		if current <= seen || s.maxSeen.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	return []traceFrame{}, nil
+}
+
+func TestArbTraceRequestLogging(t *testing.T) {
+	logHandler := testhelpers.InitTestLog(t, log.LevelDebug)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stub := &concurrentArbTraceStub{}
+	srv := rpc.NewServer()
+	Require(t, srv.RegisterName("arbtrace", stub))
+	defer srv.Stop()
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	config := gethexec.DefaultArbTraceConfig
+	Require(t, config.Validate())
+	forwarder := gethexec.NewArbTraceForwarderAPI(httpSrv.URL, 10*time.Second, func() *gethexec.ArbTraceConfig { return &config })
+	_, err := forwarder.Transaction(ctx, json.RawMessage(`"0x"`))
+	Require(t, err)
+	for _, message := range []string{"arbtrace request started", "forwarded arbtrace call", "arbtrace request finished"} {
+		if !logHandler.WasLogged(message) {
+			Fatal(t, "expected", message, "to be logged")
+		}
+	}
+}
+
+func TestArbTraceForwardingConcurrently(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stub := &concurrentArbTraceStub{delay: 200 * time.Millisecond}
+	srv := rpc.NewServer()
+	Require(t, srv.RegisterName("arbtrace", stub))
+	defer srv.Stop()
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+	wsSrv := httptest.NewServer(srv.WebsocketHandler([]string{"*"}))
+	defer wsSrv.Close()
+
+	const maxConns = 4
+	config := gethexec.DefaultArbTraceConfig
+	config.ClassicRedirectMaxConns = maxConns
+	Require(t, config.Validate())
+	for _, target := range []string{httpSrv.URL, "ws://" + strings.TrimPrefix(wsSrv.URL, "http://")} {
+		stub.maxSeen.Store(0)
+		forwarder := gethexec.NewArbTraceForwarderAPI(target, 10*time.Second, func() *gethexec.ArbTraceConfig { return &config })
+		errs := make(chan error, 3*maxConns)
+		for i := 0; i < cap(errs); i++ {
+			go func() {
+				_, err := forwarder.Transaction(ctx, json.RawMessage(`"0x"`))
+				errs <- err
+			}()
+		}
+		for i := 0; i < cap(errs); i++ {
+			Require(t, <-errs, "forwarding to", target)
+		}
+		if seen := stub.maxSeen.Load(); seen < 2 || seen > maxConns {
+			Fatal(t, "forwarding to", target, "served", seen, "requests at once rather than up to", maxConns)
+		}
+	}
+}
+
+func TestArbTraceForwardingHeaders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := rpc.NewServer()
+	Require(t, srv.RegisterName("arbtrace", &concurrentArbTraceStub{}))
+	defer srv.Stop()
+	const token = "Bearer classic-secret"
+	// the classic node sits behind a proxy rejecting requests without the token
+	authorized := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != token || r.Header.Get("X-Api-Key") != "key" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	httpSrv := httptest.NewServer(authorized(srv))
+	defer httpSrv.Close()
+	wsSrv := httptest.NewServer(authorized(srv.WebsocketHandler([]string{"*"})))
+	defer wsSrv.Close()
+
+	for _, target := range []string{httpSrv.URL, "ws://" + strings.TrimPrefix(wsSrv.URL, "http://")} {
+		unauthorized := gethexec.DefaultArbTraceConfig
+		Require(t, unauthorized.Validate())
+		forwarder := gethexec.NewArbTraceForwarderAPI(target, time.Second, func() *gethexec.ArbTraceConfig { return &unauthorized })
+		if _, err := forwarder.Transaction(ctx, json.RawMessage(`"0x"`)); err == nil {
+			Fatal(t, "forwarding to", target, "without the token should have failed")
+		}
+
+		config := gethexec.DefaultArbTraceConfig
+		config.ClassicRedirectHeaders = []string{"Authorization=" + token, "X-Api-Key=key"}
+		Require(t, config.Validate())
+		forwarder = gethexec.NewArbTraceForwarderAPI(target, time.Second, func() *gethexec.ArbTraceConfig { return &config })
+		_, err := forwarder.Transaction(ctx, json.RawMessage(`"0x"`))
+		Require(t, err, "forwarding to", target)
+	}
+
+	// malformed headers are rejected without echoing their values
+	config := gethexec.DefaultArbTraceConfig
+	config.ClassicRedirectHeaders = []string{"Authorization " + token}
+	err := config.Validate()
+	if err == nil || strings.Contains(err.Error(), token) {
+		Fatal(t, "unexpected error validating a malformed header", err)
+	}
+}
+
+func TestArbTraceForwardingUnavailable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	// nothing listens on this path
+	builder.execConfig.RPC.ClassicRedirect = tmpPath(t, "missing.ipc")
+	builder.execConfig.RPC.ClassicRedirectTimeout = 10 * time.Second
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	start := time.Now()
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", hexutil.Bytes{}, []string{"trace"})
+	if err == nil {
+		Fatal(t, "expected forwarding to an unreachable classic node to fail")
+	}
+	if !strings.Contains(err.Error(), "classic node unavailable") {
+		Fatal(t, "unexpected error", err)
+	}
+	if elapsed := time.Since(start); elapsed >= builder.execConfig.RPC.ClassicRedirectTimeout {
+		Fatal(t, "failing to dial took", elapsed)
+	}
+}
+
+func TestArbTraceNotEnabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.RPC.ClassicRedirect = ""
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	l2rpc := builder.L2.Stack.Attach()
+	var frames []traceFrame
+	err := l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", hexutil.Bytes{})
+	if err == nil || err.Error() != gethexec.ErrArbTraceNotEnabled.Error() {
+		Fatal(t, "expected", gethexec.ErrArbTraceNotEnabled, "but got", err)
+	}
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", common.Hash{}, []string{"trace"})
+	if err == nil || err.Error() != gethexec.ErrArbTraceNotEnabled.Error() {
+		Fatal(t, "expected", gethexec.ErrArbTraceNotEnabled, "but got", err)
+	}
+}
+
+func TestArbTraceForwardingSlow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ipcPath := tmpPath(t, "slow.ipc")
+	apis := []rpc.API{{
+		Namespace: "arbtrace",
+		Version:   "1.0",
+		Service:   &slowArbTraceStub{delay: 2 * time.Second},
+		Public:    false,
+	}}
+	listener, srv, err := rpc.StartIPCEndpoint(ipcPath, apis)
+	Require(t, err)
+	defer srv.Stop()
+	defer listener.Close()
+
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.RPC.ClassicRedirect = ipcPath
+	builder.execConfig.RPC.ClassicRedirectTimeout = 100 * time.Millisecond
+	builder.execConfig.ArbTrace.ClassicRedirectRetries = 1
+	builder.execConfig.ArbTrace.ClassicRedirectRetryDelay = 10 * time.Millisecond
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", hexutil.Bytes{}, []string{"trace"})
+	if err == nil {
+		Fatal(t, "expected a classic node slower than the timeout to fail")
+	}
+	if !strings.Contains(err.Error(), "classic node unavailable") {
+		Fatal(t, "unexpected error", err)
+	}
+}
+
+func TestArbTraceForwardingMethodTimeouts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ipcPath := tmpPath(t, "slow.ipc")
+	apis := []rpc.API{{
+		Namespace: "arbtrace",
+		Version:   "1.0",
+		Service:   &slowArbTraceStub{delay: 500 * time.Millisecond},
+		Public:    false,
+	}}
+	listener, srv, err := rpc.StartIPCEndpoint(ipcPath, apis)
+	Require(t, err)
+	defer srv.Stop()
+	defer listener.Close()
+
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.RPC.ClassicRedirect = ipcPath
+	builder.execConfig.RPC.ClassicRedirectTimeout = 10 * time.Second
+	builder.execConfig.ArbTrace.ClassicRedirectTimeouts = []string{"arbtrace_replayTransaction=100ms"}
+	builder.execConfig.ArbTrace.ClassicRedirectRetries = 0
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", hexutil.Bytes{}, []string{"trace"})
+	if err == nil || !strings.Contains(err.Error(), "classic node unavailable") {
+		Fatal(t, "expected arbtrace_replayTransaction to time out under its override, got", err)
+	}
+	var frames []traceFrame
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", hexutil.Bytes{})
+	Require(t, err, "arbtrace_transaction should use the default timeout")
+}
+
+// diffKind returns the Parity marker ("=", "+", "-" or "*") of a state diff entry
+func diffKind(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var same string
+	if err := json.Unmarshal(raw, &same); err == nil {
+		return same
+	}
+	var changed map[string]json.RawMessage
+	Require(t, json.Unmarshal(raw, &changed))
+	if len(changed) != 1 {
+		Fatal(t, "malformed state diff entry", string(raw))
+	}
+	for kind := range changed {
+		return kind
+	}
+	return ""
+}
+
+func TestArbTraceStateDiff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	builder.L2Info.GenerateAccount("User3")
+	owner := builder.L2Info.GetAddress("Owner")
+	user2 := builder.L2Info.GetAddress("User2")
+	user3 := builder.L2Info.GetAddress("User3")
+
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "stateDiff"})
+	Require(t, err)
+	ownerDiff := result.StateDiff[owner]
+	if ownerDiff == nil {
+		Fatal(t, "sender missing from state diff")
+	}
+	if kind := diffKind(t, ownerDiff.Nonce); kind != "*" {
+		Fatal(t, "unexpected sender nonce diff", kind)
+	}
+	if kind := diffKind(t, ownerDiff.Balance); kind != "*" {
+		Fatal(t, "unexpected sender balance diff", kind)
+	}
+	user2Diff := result.StateDiff[user2]
+	if user2Diff == nil {
+		Fatal(t, "recipient missing from state diff")
+	}
+	if kind := diffKind(t, user2Diff.Balance); kind != "+" {
+		Fatal(t, "unexpected recipient balance diff", kind)
+	}
+
+	var withoutDiff traceResult
+	err = l2rpc.CallContext(ctx, &withoutDiff, "arbtrace_replayTransaction", tx.Hash(), []string{"trace"})
+	Require(t, err)
+	if withoutDiff.StateDiff != nil {
+		Fatal(t, "state diff returned without being requested")
+	}
+
+	value := (*hexutil.Big)(big.NewInt(1e9))
+	callArgs := callTxArgs{From: &owner, To: &user3, Value: value}
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", callArgs, []string{"stateDiff"}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+	Require(t, err)
+	user3Diff := result.StateDiff[user3]
+	if user3Diff == nil {
+		Fatal(t, "call recipient missing from state diff")
+	}
+	if kind := diffKind(t, user3Diff.Balance); kind != "+" {
+		Fatal(t, "unexpected call recipient balance diff", kind)
+	}
+}
+
+func TestArbTraceVmTrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// stores 0x2a in slot 0
+	code := []byte{byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	owner := builder.L2Info.GetAddress("Owner")
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	callArgs := callTxArgs{From: &owner, To: &contract}
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callArgs, []string{"vmTrace"}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+	Require(t, err)
+	if result.VmTrace == nil {
+		Fatal(t, "vmTrace missing")
+	}
+	if result.Trace != nil {
+		Fatal(t, "trace returned without being requested")
+	}
+	ops := result.VmTrace.Ops
+	if len(ops) != 4 {
+		Fatal(t, "unexpected number of operations", len(ops))
+	}
+	for i, op := range ops {
+		if op.Ex == nil {
+			Fatal(t, "operation", i, "missing its effects")
+		}
+	}
+	if len(ops[0].Ex.Push) != 1 || ops[0].Ex.Push[0].ToInt().Uint64() != 0x2a {
+		Fatal(t, "unexpected push of first operation", ops[0].Ex.Push)
+	}
+	store := ops[2].Ex.Store
+	if store == nil || store.Key.ToInt().Sign() != 0 || store.Val.ToInt().Uint64() != 0x2a {
+		Fatal(t, "unexpected store of third operation", store)
+	}
+}
+
+func TestArbTraceDestroyedContracts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// self-destructs, refunding the caller
+	code := []byte{byte(vm.CALLER), byte(vm.SELFDESTRUCT)}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	owner := builder.L2Info.GetAddress("Owner")
+	builder.L2Info.GenerateAccount("User2")
+	user2 := builder.L2Info.GetAddress("User2")
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	var result traceResult
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, To: &contract}, []string{"destroyedContracts"}, latest)
+	Require(t, err)
+	if result.DestroyedContracts == nil {
+		Fatal(t, "destroyedContracts missing")
+	}
+	if len(*result.DestroyedContracts) != 1 || (*result.DestroyedContracts)[0] != contract {
+		Fatal(t, "unexpected destroyed contracts", *result.DestroyedContracts)
+	}
+
+	var nothingDestroyed traceResult
+	err = l2rpc.CallContext(ctx, &nothingDestroyed, "arbtrace_call", callTxArgs{From: &owner, To: &user2}, []string{"destroyedContracts"}, latest)
+	Require(t, err)
+	if nothingDestroyed.DestroyedContracts == nil || len(*nothingDestroyed.DestroyedContracts) != 0 {
+		Fatal(t, "expected an empty list of destroyed contracts", nothingDestroyed.DestroyedContracts)
+	}
+
+	var notRequested traceResult
+	err = l2rpc.CallContext(ctx, &notRequested, "arbtrace_call", callTxArgs{From: &owner, To: &contract}, []string{"trace"}, latest)
+	Require(t, err)
+	if notRequested.DestroyedContracts != nil {
+		Fatal(t, "destroyedContracts returned without being requested")
+	}
+}
+
+func TestArbTraceFilterCursor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	user2 := builder.L2Info.GetAddress("User2")
+	var txHashes []common.Hash
+	for i := 0; i < 3; i++ {
+		tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		txHashes = append(txHashes, tx.Hash())
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	fromBlock := rpc.BlockNumberOrHashWithNumber(1)
+	count := uint64(1)
+	cursor := ""
+	for i, txHash := range txHashes {
+		filter := filterRequest{FromBlock: &fromBlock, ToAddress: &[]common.Address{user2}, Count: &count, Cursor: &cursor}
+		var page filterResult
+		Require(t, l2rpc.CallContext(ctx, &page, "arbtrace_filter", filter))
+		if len(page.Traces) != 1 {
+			Fatal(t, "page", i, "has", len(page.Traces), "frames")
+		}
+		if page.Traces[0].TransactionHash == nil || *page.Traces[0].TransactionHash != txHash {
+			Fatal(t, "page", i, "returned the wrong transaction")
+		}
+		if page.NextCursor == nil {
+			Fatal(t, "page", i, "missing its next cursor")
+		}
+		cursor = *page.NextCursor
+	}
+
+	filter := filterRequest{FromBlock: &fromBlock, ToAddress: &[]common.Address{user2}, Count: &count, Cursor: &cursor}
+	var page filterResult
+	Require(t, l2rpc.CallContext(ctx, &page, "arbtrace_filter", filter))
+	if len(page.Traces) != 0 {
+		Fatal(t, "expected the results to be exhausted", len(page.Traces))
+	}
+	if page.NextCursor == nil || *page.NextCursor != cursor {
+		Fatal(t, "an empty page should hand back the same cursor")
+	}
+
+	invalid := "not a cursor"
+	filter.Cursor = &invalid
+	if err := l2rpc.CallContext(ctx, &page, "arbtrace_filter", filter); err == nil {
+		Fatal(t, "expected an invalid cursor to be rejected")
+	}
+}
+
+func TestArbTraceFilterCallTypes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest + 1))
+
+	// deploys an empty contract via CREATE2 whenever called
+	factoryCode := []byte{
+		byte(vm.PUSH1), 0, // salt
+		byte(vm.PUSH1), 0, // size
+		byte(vm.PUSH1), 0, // offset
+		byte(vm.PUSH1), 0, // value
+		byte(vm.CREATE2),
+		byte(vm.STOP),
+	}
+	factory := deployContract(t, ctx, auth, builder.L2.Client, factoryCode)
+	tx := builder.L2Info.PrepareTxTo("Owner", &factory, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	filterFrames := func(callTypes ...string) []traceFrame {
+		t.Helper()
+		var frames []traceFrame
+		filter := filterRequest{FromBlock: &fromBlock, CallTypes: &callTypes}
+		Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filter))
+		return frames
+	}
+
+	created := filterFrames("create2")
+	if len(created) != 1 {
+		Fatal(t, "expected one CREATE2 deployment but found", len(created))
+	}
+	if created[0].Type != "create" || created[0].Action.CreationMethod != "create2" || created[0].Action.From != factory {
+		Fatal(t, "unexpected CREATE2 frame", created[0].Type, created[0].Action.CreationMethod, created[0].Action.From)
+	}
+	creates := filterFrames("create")
+	if len(creates) != 2 {
+		Fatal(t, "expected the factory's deployment and its CREATE2 but found", len(creates))
+	}
+	for _, frame := range filterFrames("call") {
+		if frame.Type != "call" || frame.Action.CallType != "call" {
+			Fatal(t, "matched a frame that isn't a call", frame.Type, frame.Action.CallType)
+		}
+	}
+	if all := filterFrames(); len(all) <= len(creates) {
+		Fatal(t, "an empty call type list should match every frame")
+	}
+}
+
+func TestArbTraceFilterValue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	builder.L2Info.GenerateAccount("User3")
+	user2 := builder.L2Info.GetAddress("User2")
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest + 1))
+	transfers := []struct {
+		to    string
+		value int64
+	}{{"User2", 1e12}, {"User2", 2e12}, {"User3", 2e12}, {"User2", 3e12}}
+	var txHashes []common.Hash
+	for _, transfer := range transfers {
+		tx := builder.L2Info.PrepareTx("Owner", transfer.to, builder.L2Info.TransferGas, big.NewInt(transfer.value), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		txHashes = append(txHashes, tx.Hash())
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	minValue, maxValue := (*hexutil.Big)(big.NewInt(2e12)), (*hexutil.Big)(big.NewInt(3e12))
+	var frames []traceFrame
+	filter := filterRequest{FromBlock: &fromBlock, MinValue: minValue, MaxValue: maxValue}
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filter))
+	if len(frames) != 3 {
+		Fatal(t, "expected the bounds to be inclusive, found", len(frames), "frames")
+	}
+
+	filter = filterRequest{FromBlock: &fromBlock, ToAddress: &[]common.Address{user2}, MinValue: minValue, MaxValue: minValue}
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filter))
+	if len(frames) != 1 || frames[0].TransactionHash == nil || *frames[0].TransactionHash != txHashes[1] {
+		Fatal(t, "value bounds didn't compose with the address filter", len(frames))
+	}
+}
+
+func TestArbTraceFilterSelectors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	builder.L2Info.GenerateAccount("User3")
+	user3 := builder.L2Info.GetAddress("User3")
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest + 1))
+	transferSelector := crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
+	approveSelector := crypto.Keccak256([]byte("approve(address,uint256)"))[:4]
+	withArgs := func(selector []byte) []byte {
+		return append(common.CopyBytes(selector), make([]byte, 64)...)
+	}
+	calls := []struct {
+		to   string
+		data []byte
+	}{
+		{"User2", withArgs(transferSelector)},
+		{"User3", withArgs(transferSelector)},
+		{"User2", withArgs(approveSelector)},
+		// too short to hold a selector, despite beginning like one
+		{"User2", transferSelector[:2]},
+		{"User2", nil},
+	}
+	var txHashes []common.Hash
+	for _, call := range calls {
+		tx := builder.L2Info.PrepareTx("Owner", call.to, builder.L2Info.TransferGas*2, big.NewInt(1), call.data)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		txHashes = append(txHashes, tx.Hash())
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	filterBy := func(toAddress *[]common.Address, selectors ...[]byte) []traceFrame {
+		t.Helper()
+		hexSelectors := []hexutil.Bytes{}
+		for _, selector := range selectors {
+			hexSelectors = append(hexSelectors, selector)
+		}
+		var frames []traceFrame
+		filter := filterRequest{FromBlock: &fromBlock, ToAddress: toAddress, Selectors: &hexSelectors}
+		Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filter))
+		return frames
+	}
+	expectTxs := func(frames []traceFrame, expected ...common.Hash) {
+		t.Helper()
+		if len(frames) != len(expected) {
+			Fatal(t, "expected", len(expected), "frames, found", len(frames))
+		}
+		for i, frame := range frames {
+			if frame.TransactionHash == nil || common.BytesToHash(*frame.TransactionHash) != expected[i] {
+				Fatal(t, "unexpected frame", i, frame.TransactionHash)
+			}
+		}
+	}
+	expectTxs(filterBy(nil, transferSelector), txHashes[0], txHashes[1])
+	expectTxs(filterBy(nil, transferSelector, approveSelector), txHashes[0], txHashes[1], txHashes[2])
+	expectTxs(filterBy(&[]common.Address{user3}, transferSelector), txHashes[1])
+	// an empty list of selectors doesn't constrain the frames
+	expectTxs(filterBy(&[]common.Address{user3}), txHashes[1])
+
+	var frames []traceFrame
+	badSelectors := []hexutil.Bytes{transferSelector[:3]}
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filterRequest{FromBlock: &fromBlock, Selectors: &badSelectors})
+	if err == nil {
+		Fatal(t, "expected a selector that isn't 4 bytes to be rejected")
+	}
+}
+
+func TestArbTraceAddressActivity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.FilterMaxRange = 4
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	leaf := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(leaf))
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest + 1))
+
+	builder.L2Info.GenerateAccount("User2")
+	var txHashes []common.Hash
+	for _, to := range []common.Address{caller, builder.L2Info.GetAddress("User2"), leaf} {
+		to := to
+		tx := builder.L2Info.PrepareTxTo("Owner", &to, 1e6, big.NewInt(0), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		txHashes = append(txHashes, tx.Hash())
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	activity := func(address common.Address) []traceFrame {
+		t.Helper()
+		var frames []traceFrame
+		Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_addressActivity", address, fromBlock, "latest"))
+		return frames
+	}
+	// the caller receives the first transaction and makes its subcall
+	frames := activity(caller)
+	if len(frames) != 2 || frames[0].Action.To == nil || *frames[0].Action.To != caller || frames[1].Action.From == nil || *frames[1].Action.From != caller {
+		Fatal(t, "unexpected activity of the caller", frames)
+	}
+	// the leaf is called by the first transaction's subcall and the last transaction, in that order
+	frames = activity(leaf)
+	if len(frames) != 2 {
+		Fatal(t, "expected the leaf to be called twice, found", len(frames), "frames")
+	}
+	for i, expected := range []common.Hash{txHashes[0], txHashes[2]} {
+		if frames[i].TransactionHash == nil || common.BytesToHash(*frames[i].TransactionHash) != expected {
+			Fatal(t, "unexpected order of the leaf's activity", frames)
+		}
+	}
+	if frames[0].TraceAddress == nil || len(frames[0].TraceAddress) != 1 {
+		Fatal(t, "expected the leaf's first call to be a subcall", frames[0].TraceAddress)
+	}
+
+	// every block in the range was traced above, so the index skips the two not involving User2
+	skipped := metrics.GetOrRegisterCounter("arb/arbtrace/activity/skipped", nil)
+	skippedBefore := skipped.Snapshot().Count()
+	frames = activity(builder.L2Info.GetAddress("User2"))
+	if len(frames) != 1 || frames[0].TransactionHash == nil || common.BytesToHash(*frames[0].TransactionHash) != txHashes[1] {
+		Fatal(t, "unexpected activity of User2", frames)
+	}
+	if count := skipped.Snapshot().Count() - skippedBefore; count < 2 {
+		Fatal(t, "expected the blocks not involving User2 to be skipped, skipped", count)
+	}
+
+	var ignored []traceFrame
+	tooEarly := rpc.BlockNumberOrHashWithNumber(0)
+	err = l2rpc.CallContext(ctx, &ignored, "arbtrace_addressActivity", leaf, tooEarly, "latest")
+	if err == nil || !strings.Contains(err.Error(), "exceeding the limit") {
+		Fatal(t, "expected the range to be bounded like arbtrace_filter's, got", err)
+	}
+}
+
+func TestArbTraceFilterMaxRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.FilterMaxRange = 2
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	for i := 0; i < 3; i++ {
+		tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+	}
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest - 1))
+	toBlock := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	var frames []traceFrame
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filterRequest{FromBlock: &fromBlock, ToBlock: &toBlock})
+	Require(t, err)
+
+	fromBlock = rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest - 2))
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_filter", filterRequest{FromBlock: &fromBlock, ToBlock: &toBlock})
+	if err == nil {
+		Fatal(t, "expected a range exceeding the limit to be rejected")
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("%v to %v", latest-2, latest)) {
+		Fatal(t, "error doesn't name the resolved range", err)
+	}
+}
+
+func TestArbTraceRequireCanonical(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	startMsgCount, err := builder.L2.ConsensusNode.TxStreamer.GetMessageCount()
+	Require(t, err)
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	orphaned, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	// replace the block with one transferring a different amount
+	Require(t, builder.L2.ConsensusNode.TxStreamer.ReorgTo(startMsgCount))
+	_, err = builder.L2.ExecNode.ExecEngine.HeadMessageNumberSync(t)
+	Require(t, err)
+	builder.L2Info.GetInfoWithPrivKey("Owner").Nonce--
+	tx = builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(2e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	canonical, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	if canonical.BlockHash == orphaned.BlockHash {
+		Fatal(t, "the reorg didn't replace the block")
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	owner := builder.L2Info.GetAddress("Owner")
+	calls := []struct {
+		method string
+		args   func(ref rpc.BlockNumberOrHash) []interface{}
+	}{
+		{"arbtrace_block", func(ref rpc.BlockNumberOrHash) []interface{} { return []interface{}{ref} }},
+		{"arbtrace_replayBlockTransactions", func(ref rpc.BlockNumberOrHash) []interface{} { return []interface{}{ref, []string{"trace"}} }},
+		{"arbtrace_call", func(ref rpc.BlockNumberOrHash) []interface{} {
+			return []interface{}{callTxArgs{From: &owner, To: &owner}, []string{"trace"}, ref}
+		}},
+		{"arbtrace_filter", func(ref rpc.BlockNumberOrHash) []interface{} {
+			return []interface{}{filterRequest{FromBlock: &ref, ToBlock: &ref}}
+		}},
+	}
+	for _, call := range calls {
+		var result json.RawMessage
+		Require(t, l2rpc.CallContext(ctx, &result, call.method, call.args(rpc.BlockNumberOrHashWithHash(canonical.BlockHash, false))...), call.method)
+		Require(t, l2rpc.CallContext(ctx, &result, call.method, call.args(rpc.BlockNumberOrHashWithHash(canonical.BlockHash, true))...), call.method)
+		err := l2rpc.CallContext(ctx, &result, call.method, call.args(rpc.BlockNumberOrHashWithHash(orphaned.BlockHash, true))...)
+		if err == nil || !strings.Contains(err.Error(), "canonical") {
+			Fatal(t, call.method, "traced a non-canonical block despite requireCanonical, got", err)
+		}
+	}
+}
+
+func TestArbTraceRawTransaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	user2 := builder.L2Info.GetAddress("User2")
+	value := big.NewInt(1e12)
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, value, nil)
+	rawTx, err := tx.MarshalBinary()
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_rawTransaction", hexutil.Bytes(rawTx), []string{"trace", "stateDiff"}, latest)
+	Require(t, err)
+	if len(result.Trace) != 1 {
+		Fatal(t, "unexpected number of frames", len(result.Trace))
+	}
+	action := result.Trace[0].Action
+	if action.To == nil || *action.To != user2 || action.Value.ToInt().Cmp(value) != 0 {
+		Fatal(t, "unexpected action", action)
+	}
+	if result.StateDiff[user2] == nil {
+		Fatal(t, "recipient missing from state diff")
+	}
+
+	// the transaction was only traced, so it can still be sent
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_rawTransaction", hexutil.Bytes{0x01, 0x02}, []string{"trace"}, latest)
+	if err == nil {
+		Fatal(t, "expected malformed input to be rejected")
+	}
+}
+
+func TestArbTraceArbFees(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"arbFees"})
+	Require(t, err)
+	fees := result.ArbitrumFees
+	if fees == nil {
+		Fatal(t, "arbitrumFees missing")
+	}
+	if uint64(fees.GasUsed) != receipt.GasUsed {
+		Fatal(t, "gas used", fees.GasUsed, "doesn't match the receipt's", receipt.GasUsed)
+	}
+	if uint64(fees.GasUsedForL1) != receipt.GasUsedForL1 {
+		Fatal(t, "L1 gas used", fees.GasUsedForL1, "doesn't match the receipt's", receipt.GasUsedForL1)
+	}
+	if fees.GasUsedForL1+fees.GasUsedForL2 != fees.GasUsed {
+		Fatal(t, "fee components don't add up", fees)
+	}
+	// the poster is paid for exactly the gas the receipt attributes to L1
+	header, err := builder.L2.Client.HeaderByHash(ctx, receipt.BlockHash)
+	Require(t, err)
+	posterFee := new(big.Int).Mul(header.BaseFee, new(big.Int).SetUint64(receipt.GasUsedForL1))
+	if fees.L1Fee == nil || fees.L1Fee.ToInt().Cmp(posterFee) != 0 {
+		Fatal(t, "L1 fee", fees.L1Fee, "doesn't reconcile with the receipt's", posterFee)
+	}
+
+	var withoutFees traceResult
+	err = l2rpc.CallContext(ctx, &withoutFees, "arbtrace_replayTransaction", tx.Hash(), []string{"trace"})
+	Require(t, err)
+	if withoutFees.ArbitrumFees != nil {
+		Fatal(t, "arbitrumFees returned without being requested")
+	}
+}
+
+func TestArbTraceArbFeesAfterBlobBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	gasInfo, err := precompilesgen.NewArbGasInfo(types.ArbGasInfoAddress, builder.L2.Client)
+	Require(t, err)
+	priceBefore, err := gasInfo.GetL1BaseFeeEstimate(&bind.CallOpts{Context: ctx})
+	Require(t, err)
+
+	// the batch poster can't post blobs here, so report a batch as it would be after posting one: its data is
+	// just the blobs' hashes, while the blobs' cost comes as extra gas
+	pos, err := builder.L2.ConsensusNode.TxStreamer.GetMessageCount()
+	Require(t, err)
+	lastMessage, err := builder.L2.ConsensusNode.TxStreamer.GetMessage(pos - 1)
+	Require(t, err)
+	latest, err := builder.L2.Client.HeaderByNumber(ctx, nil)
+	Require(t, err)
+	batchGasCost := uint64(2_000)
+	blobCostAsGas := uint64(50_000_000)
+	report := append([]byte{}, arbmath.Uint64ToU256Bytes(latest.Time)...)
+	report = append(report, l1pricing.BatchPosterAddress.Bytes()...)
+	report = append(report, common.Hash{}.Bytes()...)
+	report = append(report, arbmath.Uint64ToU256Bytes(1)...)
+	report = append(report, arbmath.U256Bytes(priceBefore)...)
+	report = binary.BigEndian.AppendUint64(report, blobCostAsGas)
+	err = builder.L2.ConsensusNode.TxStreamer.AddMessages(pos, true, []arbostypes.MessageWithMetadata{{
+		Message: &arbostypes.L1IncomingMessage{
+			Header: &arbostypes.L1IncomingMessageHeader{
+				Kind:        arbostypes.L1MessageType_BatchPostingReport,
+				Poster:      l1pricing.BatchPosterAddress,
+				BlockNumber: lastMessage.Message.Header.BlockNumber,
+				Timestamp:   latest.Time,
+				L1BaseFee:   &big.Int{},
+			},
+			L2msg:        report,
+			BatchGasCost: &batchGasCost,
+		},
+		DelayedMessagesRead: lastMessage.DelayedMessagesRead,
+	}})
+	Require(t, err)
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		head, err := builder.L2.ExecNode.ExecEngine.HeadMessageNumberSync(t)
+		Require(t, err)
+		if head >= pos {
+			break
+		}
+		if time.Now().After(deadline) {
+			Fatal(t, "the report wasn't executed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	priceAfter, err := gasInfo.GetL1BaseFeeEstimate(&bind.CallOpts{Context: ctx})
+	Require(t, err)
+	if priceAfter.Cmp(priceBefore) == 0 {
+		Fatal(t, "the blob batch's report left the L1 price at", priceBefore)
+	}
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	if receipt.GasUsedForL1 == 0 {
+		Fatal(t, "expected the transaction to pay for L1 gas")
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"arbFees"}))
+	fees := result.ArbitrumFees
+	if fees == nil {
+		Fatal(t, "arbitrumFees missing")
+	}
+	header, err := builder.L2.Client.HeaderByHash(ctx, receipt.BlockHash)
+	Require(t, err)
+	posterFee := new(big.Int).Mul(header.BaseFee, new(big.Int).SetUint64(receipt.GasUsedForL1))
+	if uint64(fees.GasUsedForL1) != receipt.GasUsedForL1 || fees.L1Fee == nil || fees.L1Fee.ToInt().Cmp(posterFee) != 0 {
+		Fatal(t, "L1 fee", fees.L1Fee, "doesn't reconcile with the receipt's", posterFee, "after a blob batch")
+	}
+}
+
+func TestArbTraceAccessList(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	other := testhelpers.RandomAddress()
+	// reads a slot, another account's balance, and the identity precompile's, then calls the callee
+	code := []byte{
+		byte(vm.PUSH1), 7,
+		byte(vm.SLOAD),
+		byte(vm.POP),
+		byte(vm.PUSH20),
+	}
+	code = append(code, other.Bytes()...)
+	code = append(code, byte(vm.BALANCE), byte(vm.POP), byte(vm.PUSH1), 4, byte(vm.BALANCE), byte(vm.POP))
+	code = append(code, callerCode(callee)...)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"accessList"}))
+	if result.AccessList == nil {
+		Fatal(t, "access list missing")
+	}
+	// the sender and precompiles are always warm, while the recipient is listed only for its slots
+	expected := map[common.Address][]common.Hash{
+		callee:   {},
+		other:    {},
+		contract: {common.BigToHash(big.NewInt(7))},
+	}
+	if len(*result.AccessList) != len(expected) {
+		Fatal(t, "unexpected access list", *result.AccessList)
+	}
+	for i, tuple := range *result.AccessList {
+		slots, ok := expected[tuple.Address]
+		if !ok || len(tuple.StorageKeys) != len(slots) || (len(slots) > 0 && tuple.StorageKeys[0] != slots[0]) {
+			Fatal(t, "unexpected access list entry", tuple)
+		}
+		if i > 0 && bytes.Compare((*result.AccessList)[i-1].Address[:], tuple.Address[:]) >= 0 {
+			Fatal(t, "access list isn't sorted by address", *result.AccessList)
+		}
+	}
+
+	// the access list can be attached to a new transaction doing the same
+	nonce, err := builder.L2.Client.PendingNonceAt(ctx, auth.From)
+	Require(t, err)
+	accessTx := builder.L2Info.SignTxAs("Owner", &types.DynamicFeeTx{
+		ChainID:    builder.L2Info.Signer.ChainID(),
+		Nonce:      nonce,
+		GasTipCap:  common.Big0,
+		GasFeeCap:  builder.L2Info.GasPrice,
+		Gas:        1e6,
+		To:         &contract,
+		Value:      common.Big0,
+		AccessList: *result.AccessList,
+	})
+	Require(t, builder.L2.Client.SendTransaction(ctx, accessTx))
+	_, err = builder.L2.EnsureTxSucceeded(accessTx)
+	Require(t, err)
+}
+
+func TestArbTraceAccessListPrecompiles(t *testing.T) {
+	// ArbWasm is only a precompile from ArbOS 30, before which it's an ordinary account
+	for _, test := range []struct {
+		arbosVersion uint64
+		listed       bool
+	}{
+		{11, true},
+		{params.ArbosVersion_Stylus, false},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		builder := NewNodeBuilder(ctx).DefaultConfig(t, false).WithArbOSVersion(test.arbosVersion)
+		cleanup := builder.Build(t)
+
+		auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+		code := append([]byte{byte(vm.PUSH20)}, types.ArbWasmAddress.Bytes()...)
+		code = append(code, byte(vm.BALANCE), byte(vm.POP), byte(vm.STOP))
+		contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+		tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+
+		var result traceResult
+		Require(t, builder.L2.Stack.Attach().CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"accessList"}))
+		listed := false
+		for _, tuple := range *result.AccessList {
+			listed = listed || tuple.Address == types.ArbWasmAddress
+		}
+		if listed != test.listed {
+			Fatal(t, "at ArbOS", test.arbosVersion, "expected ArbWasm to be listed", test.listed, "in", *result.AccessList)
+		}
+		cleanup()
+		cancel()
+	}
+}
+
+func TestArbTraceOutbox(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	arbSys, err := precompilesgen.NewArbSys(types.ArbSysAddress, builder.L2.Client)
+	Require(t, err)
+	arbSysABI, err := precompilesgen.ArbSysMetaData.GetAbi()
+	Require(t, err)
+	l2rpc := builder.L2.Stack.Attach()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	destination := testhelpers.RandomAddress()
+	calldata := []byte{0xde, 0xad, 0xbe, 0xef}
+	for i := int64(1); i <= 2; i++ {
+		auth.Value = big.NewInt(i * 1e9)
+		tx, err := arbSys.SendTxToL1(&auth, destination, calldata)
+		Require(t, err)
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+
+		var result traceResult
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"outbox"}))
+		if result.Outbox == nil || len(*result.Outbox) != 1 {
+			Fatal(t, "expected a single outbox message", result.Outbox)
+		}
+		message := (*result.Outbox)[0]
+		if message.Caller != auth.From || message.Destination != destination || !bytes.Equal(message.Data, calldata) {
+			Fatal(t, "unexpected outbox message", message)
+		}
+		if message.CallValue.ToInt().Cmp(auth.Value) != 0 || uint64(message.ArbBlockNum) != receipt.BlockNumber.Uint64() {
+			Fatal(t, "unexpected outbox message", message)
+		}
+		var event *precompilesgen.ArbSysL2ToL1Tx
+		for _, entry := range receipt.Logs {
+			if entry.Topics[0] == arbSysABI.Events["L2ToL1Tx"].ID {
+				event, err = arbSys.ParseL2ToL1Tx(*entry)
+				Require(t, err)
+			}
+		}
+		if event == nil {
+			Fatal(t, "receipt is missing the L2ToL1Tx log")
+		}
+		if message.LeafHash != common.BigToHash(event.Hash) || uint64(message.LeafIndex) != event.Position.Uint64() {
+			Fatal(t, "outbox message's leaf", message.LeafIndex, message.LeafHash, "doesn't match the log's", event.Position, event.Hash)
+		}
+	}
+
+	// transactions that don't send messages have none
+	tx := builder.L2Info.PrepareTxTo("Owner", &destination, builder.L2Info.TransferGas, big.NewInt(1), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"outbox"}))
+	if result.Outbox == nil || len(*result.Outbox) != 0 {
+		Fatal(t, "expected no outbox messages", result.Outbox)
+	}
+}
+
+func TestArbTracePrecompileCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	arbSys, err := precompilesgen.NewArbSys(types.ArbSysAddress, builder.L2.Client)
+	Require(t, err)
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	auth.Value = big.NewInt(1e9)
+	destination := testhelpers.RandomAddress()
+	tx, err := arbSys.SendTxToL1(&auth, destination, []byte{0xde, 0xad, 0xbe, 0xef})
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", tx.Hash()))
+	if len(frames) == 0 || frames[0].Precompile == nil {
+		Fatal(t, "expected the call to ArbSys to be decoded")
+	}
+	call := frames[0].Precompile
+	if call.Name != "ArbSys" || call.Method != "sendTxToL1(address,bytes)" {
+		Fatal(t, "unexpected precompile call", call.Name, call.Method)
+	}
+	if arg, ok := call.Args["destination"].(string); !ok || !strings.EqualFold(arg, destination.Hex()) {
+		Fatal(t, "unexpected destination argument", call.Args["destination"])
+	}
+	if arg, ok := call.Args["data"].(string); !ok || arg != "0xdeadbeef" {
+		Fatal(t, "unexpected data argument", call.Args["data"])
+	}
+
+	// calls to other accounts aren't annotated
+	transfer := builder.L2Info.PrepareTxTo("Owner", &destination, builder.L2Info.TransferGas, big.NewInt(1), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, transfer))
+	_, err = builder.L2.EnsureTxSucceeded(transfer)
+	Require(t, err)
+	var transferFrames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &transferFrames, "arbtrace_transaction", transfer.Hash()))
+	if len(transferFrames) != 1 || transferFrames[0].Precompile != nil {
+		Fatal(t, "unexpected precompile annotation of a transfer", transferFrames)
+	}
+}
+
+// callsWithDataCode returns code that calls the callee with each of the calldatas in turn.
+func callsWithDataCode(callee common.Address, calldatas ...[]byte) []byte {
+	code := []byte{}
+	for _, data := range calldatas {
+		for offset := 0; offset < len(data); offset += 32 {
+			chunk := make([]byte, 32)
+			copy(chunk, data[offset:])
+			code = append(code, byte(vm.PUSH32))
+			code = append(code, chunk...)
+			code = append(code, byte(vm.PUSH1), byte(offset), byte(vm.MSTORE))
+		}
+		code = append(code,
+			byte(vm.PUSH1), 0, // retSize
+			byte(vm.PUSH1), 0, // retOffset
+			byte(vm.PUSH1), byte(len(data)), // argsSize
+			byte(vm.PUSH1), 0, // argsOffset
+			byte(vm.PUSH1), 0, // value
+			byte(vm.PUSH20),
+		)
+		code = append(code, callee.Bytes()...)
+		code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+	}
+	return append(code, byte(vm.STOP))
+}
+
+func TestArbTraceAddressTableResolution(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	addressTable, err := precompilesgen.NewArbAddressTable(types.ArbAddressTableAddress, builder.L2.Client)
+	Require(t, err)
+	size, err := addressTable.Size(&bind.CallOpts{Context: ctx})
+	Require(t, err)
+	tableAbi, err := precompilesgen.ArbAddressTableMetaData.GetAbi()
+	Require(t, err)
+	registered := testhelpers.RandomAddress()
+	register, err := tableAbi.Pack("register", registered)
+	Require(t, err)
+	lookupIndex, err := tableAbi.Pack("lookupIndex", size)
+	Require(t, err)
+	compressed := rlp.AppendUint64([]byte{}, size.Uint64())
+	decompress, err := tableAbi.Pack("decompress", compressed, big.NewInt(0))
+	Require(t, err)
+
+	// the index isn't registered as of the latest block
+	l2rpc := builder.L2.Stack.Attach()
+	tableAddress := types.ArbAddressTableAddress
+	var result traceResult
+	callArgs := callTxArgs{To: &tableAddress, Data: (*hexutil.Bytes)(&lookupIndex)}
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_call", callArgs, []string{"trace"}, "latest"))
+	if len(result.Trace) != 1 || result.Trace[0].Precompile == nil {
+		Fatal(t, "expected the lookup to be decoded", result.Trace)
+	}
+	entry := result.Trace[0].Precompile.AddressTable
+	if entry == nil || uint64(entry.Index) != size.Uint64() || entry.Address != nil {
+		Fatal(t, "expected the index to be unregistered", entry)
+	}
+
+	// registering the address and resolving its index in the same transaction
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, callsWithDataCode(tableAddress, register, lookupIndex, decompress))
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, builder.L2Info.TransferGas*10, nil, nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", tx.Hash()))
+	if len(frames) != 4 {
+		Fatal(t, "expected the contract's three calls to ArbAddressTable", frames)
+	}
+	if frames[1].Precompile == nil || frames[1].Precompile.AddressTable != nil {
+		Fatal(t, "registrations don't reference an index", frames[1].Precompile)
+	}
+	for _, frame := range frames[2:] {
+		if frame.Precompile == nil || frame.Precompile.AddressTable == nil {
+			Fatal(t, "expected the index to be resolved", frame.Precompile)
+		}
+		entry := frame.Precompile.AddressTable
+		if uint64(entry.Index) != size.Uint64() || entry.Address == nil || *entry.Address != registered {
+			Fatal(t, "unexpected resolution of", frame.Precompile.Method, entry)
+		}
+	}
+}
+
+func TestArbTraceOnlyServedWhenListed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	callOverHTTP := func(modules []string) error {
+		t.Helper()
+		builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+		builder.l2StackConfig.HTTPHost = "127.0.0.1"
+		builder.l2StackConfig.HTTPModules = modules
+		cleanup := builder.Build(t)
+		defer cleanup()
+
+		client, err := rpc.DialContext(ctx, builder.L2.Stack.HTTPEndpoint())
+		Require(t, err)
+		defer client.Close()
+		var frames []traceFrame
+		return client.CallContext(ctx, &frames, "arbtrace_block", rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+	}
+
+	err := callOverHTTP([]string{"net", "web3", "eth"})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		Fatal(t, "expected arbtrace to be absent when not listed, got", err)
+	}
+	Require(t, callOverHTTP([]string{"net", "web3", "eth", "arbtrace"}))
+}
+
+func TestArbTraceRefund(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// stores the calldata's size to slots 0 and 1, clearing both when called without calldata
+	code := []byte{
+		byte(vm.CALLDATASIZE),
+		byte(vm.PUSH1), 0,
+		byte(vm.SSTORE),
+		byte(vm.CALLDATASIZE),
+		byte(vm.PUSH1), 1,
+		byte(vm.SSTORE),
+		byte(vm.STOP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	l2rpc := builder.L2.Stack.Attach()
+	traceRefund := func(data []byte) (*gasRefund, *gasProfile, *types.Receipt) {
+		t.Helper()
+		tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), data)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		var result traceResult
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"refund", "gasProfile"}))
+		if result.Refund == nil || result.GasProfile == nil {
+			Fatal(t, "refund or gas profile missing")
+		}
+		return result.Refund, result.GasProfile, receipt
+	}
+
+	refund, _, _ := traceRefund([]byte{1})
+	if refund.Total != 0 || refund.Applied != 0 {
+		Fatal(t, "setting slots shouldn't earn a refund", refund)
+	}
+
+	// clearing both slots earns more than the EIP-3529 cap of a fifth of the refundable gas allows
+	refund, profile, receipt := traceRefund(nil)
+	if uint64(refund.Total) != 2*params.SstoreClearsScheduleRefundEIP3529 {
+		Fatal(t, "unexpected total refund", refund)
+	}
+	if refund.Applied != refund.Cap || refund.Cap >= refund.Total {
+		Fatal(t, "expected the refund to be capped", refund)
+	}
+	// the poster's share of the gas isn't refundable
+	gasUsed := uint64(profile.Intrinsic + profile.L1Posting + profile.Frames[0].GasUsed)
+	if uint64(refund.Cap) != (gasUsed-receipt.GasUsedForL1)/params.RefundQuotientEIP3529 {
+		Fatal(t, "unexpected refund cap", refund, "for gas used", gasUsed, "of which", receipt.GasUsedForL1, "was for posting")
+	}
+	if gasUsed-uint64(refund.Applied) != receipt.GasUsed {
+		Fatal(t, "applied refund", refund.Applied, "doesn't match the receipt's gas used", receipt.GasUsed, "of", gasUsed)
+	}
+}
+
+func TestArbTraceGasProfile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// stores to a fresh slot, then expands memory to 33 words
+	code := []byte{
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 0,
+		byte(vm.SSTORE),
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH2), 0x04, 0x00,
+		byte(vm.MSTORE),
+		byte(vm.STOP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"gasProfile"})
+	Require(t, err)
+	profile := result.GasProfile
+	if profile == nil || len(profile.Frames) != 1 {
+		Fatal(t, "expected a gas profile of one frame", profile)
+	}
+	frame := profile.Frames[0]
+	if uint64(profile.L1Posting) != receipt.GasUsedForL1 {
+		Fatal(t, "L1 posting gas", profile.L1Posting, "doesn't match the receipt's", receipt.GasUsedForL1)
+	}
+	if uint64(profile.Intrinsic+profile.L1Posting+frame.GasUsed) != receipt.GasUsed {
+		Fatal(t, "gas profile doesn't add up to the receipt's gas used", profile, receipt.GasUsed)
+	}
+	if frame.Computation+frame.Storage+frame.Memory != frame.GasUsed {
+		Fatal(t, "frame's gas categories don't add up", frame)
+	}
+	if frame.Storage < 20000 {
+		Fatal(t, "storing to a fresh slot was attributed only", frame.Storage, "gas")
+	}
+	if frame.Memory != 33*3+33*33/512 {
+		Fatal(t, "memory expansion was attributed", frame.Memory, "gas")
+	}
+	if frame.Computation == 0 {
+		Fatal(t, "no gas attributed to computation")
+	}
+
+	// ArbOS charges precompiles for reading its storage
+	arbGasInfoAbi, err := precompilesgen.ArbGasInfoMetaData.GetAbi()
+	Require(t, err)
+	data, err := arbGasInfoAbi.Pack("getL1BaseFeeEstimate")
+	Require(t, err)
+	arbGasInfo := common.HexToAddress("0x6c")
+	tx = builder.L2Info.PrepareTxTo("Owner", &arbGasInfo, 1e6, big.NewInt(0), data)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"gasProfile"})
+	Require(t, err)
+	if storage := result.GasProfile.Frames[0].Storage; storage == 0 || storage%800 != 0 {
+		Fatal(t, "ArbOS storage reads were attributed", storage, "gas")
+	}
+}
+
+type callManyOptions struct {
+	Independent       bool `json:"independent"`
+	FailOnRevert      bool `json:"failOnRevert"`
+	IntermediateRoots bool `json:"intermediateRoots"`
+}
+
+func TestArbTraceCallManyIntermediateRoots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User3")
+	owner := builder.L2Info.GetAddress("Owner")
+	user3 := builder.L2Info.GetAddress("User3")
+	transfer := &callTraceRequest{
+		callArgs:   callTxArgs{From: &owner, To: &user3, Value: (*hexutil.Big)(big.NewInt(1e9))},
+		traceTypes: []string{"trace"},
+	}
+	l2rpc := builder.L2.Stack.Attach()
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+	header, err := builder.L2.Client.HeaderByNumber(ctx, new(big.Int).SetUint64(latest))
+	Require(t, err)
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest))
+	callMany := func(calls []*callTraceRequest, options *callManyOptions) []*traceResult {
+		t.Helper()
+		var results []*traceResult
+		if options == nil {
+			Require(t, l2rpc.CallContext(ctx, &results, "arbtrace_callMany", calls, blockNum))
+		} else {
+			Require(t, l2rpc.CallContext(ctx, &results, "arbtrace_callMany", calls, blockNum, options))
+		}
+		return results
+	}
+
+	options := &callManyOptions{IntermediateRoots: true}
+	results := callMany([]*callTraceRequest{transfer, transfer}, options)
+	if len(results) != 2 || results[0].StateRoot == nil || results[1].StateRoot == nil {
+		Fatal(t, "expected a state root after each call", results)
+	}
+	roots := []common.Hash{*results[0].StateRoot, *results[1].StateRoot}
+	if roots[0] == header.Root || roots[1] == roots[0] {
+		Fatal(t, "expected each transfer to change the state root", header.Root, roots)
+	}
+	// executing the same calls again reaches the same states
+	again := callMany([]*callTraceRequest{transfer, transfer}, options)
+	if *again[0].StateRoot != roots[0] || *again[1].StateRoot != roots[1] {
+		Fatal(t, "sequential execution isn't deterministic", roots, again[0].StateRoot, again[1].StateRoot)
+	}
+	// independent calls each start from the block's state
+	independent := callMany([]*callTraceRequest{transfer, transfer}, &callManyOptions{IntermediateRoots: true, Independent: true})
+	if *independent[0].StateRoot != roots[0] || *independent[1].StateRoot != roots[0] {
+		Fatal(t, "unexpected roots of independent calls", independent[0].StateRoot, independent[1].StateRoot)
+	}
+
+	for _, result := range callMany([]*callTraceRequest{transfer}, nil) {
+		if result.StateRoot != nil {
+			Fatal(t, "state root returned without being requested")
+		}
+	}
+}
+
+func TestArbTraceCallManySequencing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User3")
+	owner := builder.L2Info.GetAddress("Owner")
+	user3 := builder.L2Info.GetAddress("User3")
+	value := (*hexutil.Big)(big.NewInt(1e9))
+	transfer := &callTraceRequest{
+		callArgs:   callTxArgs{From: &owner, To: &user3, Value: value},
+		traceTypes: []string{"stateDiff"},
+	}
+	calls := []*callTraceRequest{transfer, transfer}
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	var results []*traceResult
+	err := l2rpc.CallContext(ctx, &results, "arbtrace_callMany", calls, latest)
+	Require(t, err)
+	if len(results) != 2 {
+		Fatal(t, "unexpected number of results", len(results))
+	}
+	// the second transfer sees the balance left by the first
+	if kind := diffKind(t, results[1].StateDiff[user3].Balance); kind != "*" {
+		Fatal(t, "unexpected balance diff of sequential call", kind)
+	}
+
+	err = l2rpc.CallContext(ctx, &results, "arbtrace_callMany", calls, latest, callManyOptions{Independent: true})
+	Require(t, err)
+	if kind := diffKind(t, results[1].StateDiff[user3].Balance); kind != "+" {
+		Fatal(t, "unexpected balance diff of independent call", kind)
+	}
+
+	// always reverts
+	code := []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.REVERT)}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, code)
+	reverting := &callTraceRequest{
+		callArgs:   callTxArgs{From: &owner, To: &reverter},
+		traceTypes: []string{"trace"},
+	}
+	calls = []*callTraceRequest{transfer, reverting}
+	err = l2rpc.CallContext(ctx, &results, "arbtrace_callMany", calls, latest)
+	Require(t, err)
+	err = l2rpc.CallContext(ctx, &results, "arbtrace_callMany", calls, latest, callManyOptions{FailOnRevert: true})
+	if err == nil {
+		Fatal(t, "expected the reverting call to fail the request")
+	}
+}
+
+func TestArbTraceCallManyLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.CallManyMaxCalls = 3
+	builder.execConfig.ArbTrace.CallManyMaxResultSize = 4096
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	owner := builder.L2Info.GetAddress("Owner")
+	user := testhelpers.RandomAddress()
+	transfer := &callTraceRequest{
+		callArgs:   callTxArgs{From: &owner, To: &user, Value: (*hexutil.Big)(big.NewInt(1))},
+		traceTypes: []string{"trace"},
+	}
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	var results []*traceResult
+	err := l2rpc.CallContext(ctx, &results, "arbtrace_callMany", []*callTraceRequest{transfer, transfer, transfer}, latest)
+	Require(t, err)
+	if len(results) != 3 {
+		Fatal(t, "unexpected number of results", len(results))
+	}
+
+	err = l2rpc.CallContext(ctx, &results, "arbtrace_callMany", []*callTraceRequest{transfer, transfer, transfer, transfer}, latest)
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrCallManyTooManyCalls.Error()) {
+		Fatal(t, "expected the request to exceed the call limit, got", err)
+	}
+
+	// returns 4KB of memory, whose output alone exceeds the result size limit
+	code := []byte{
+		byte(vm.PUSH2), 0x10, 0x00,
+		byte(vm.PUSH1), 0,
+		byte(vm.RETURN),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	returner := deployContract(t, ctx, auth, builder.L2.Client, code)
+	large := &callTraceRequest{
+		callArgs:   callTxArgs{From: &owner, To: &returner},
+		traceTypes: []string{"trace"},
+	}
+	err = l2rpc.CallContext(ctx, &results, "arbtrace_callMany", []*callTraceRequest{transfer, large}, latest)
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrCallManyResultTooLarge.Error()) {
+		Fatal(t, "expected the request to exceed the result size limit, got", err)
+	}
+}
+
+func TestArbTraceUnknownTraceTypes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	owner := builder.L2Info.GetAddress("Owner")
+	args := callTxArgs{From: &owner, To: &owner}
+	invalid := []string{"trace", "tracee"}
+	expectRejected := func(method string, err error) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), `unknown trace type "tracee"`) || !strings.Contains(err.Error(), "stateDiff") {
+			Fatal(t, method, "accepted an unknown trace type, got", err)
+		}
+	}
+	var result json.RawMessage
+	expectRejected("call", l2rpc.CallContext(ctx, &result, "arbtrace_call", args, invalid, latest))
+	calls := []*callTraceRequest{{callArgs: args, traceTypes: []string{"trace"}}, {callArgs: args, traceTypes: invalid}}
+	expectRejected("callMany", l2rpc.CallContext(ctx, &result, "arbtrace_callMany", calls, latest))
+	expectRejected("replayTransaction", l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), invalid))
+	expectRejected("replayBlockTransactions", l2rpc.CallContext(ctx, &result, "arbtrace_replayBlockTransactions", latest, invalid))
+
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "stateDiff", "vmTrace", "arbFees"}))
+}
+
+func TestArbTraceBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var frames []traceFrame
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_block", rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64())))
+	Require(t, err)
+	found := false
+	for i, frame := range frames {
+		if frame.BlockHash == nil || common.BytesToHash(*frame.BlockHash) != receipt.BlockHash {
+			Fatal(t, "frame", i, "has the wrong block hash")
+		}
+		if frame.BlockNumber == nil || *frame.BlockNumber != receipt.BlockNumber.Uint64() {
+			Fatal(t, "frame", i, "has the wrong block number")
+		}
+		if frame.TransactionHash == nil || frame.TransactionPosition == nil {
+			Fatal(t, "frame", i, "is missing its transaction")
+		}
+		if common.BytesToHash(*frame.TransactionHash) == tx.Hash() {
+			found = true
+			if *frame.TransactionPosition != uint64(receipt.TransactionIndex) {
+				Fatal(t, "frame", i, "has the wrong transaction position")
+			}
+		}
+	}
+	if !found {
+		Fatal(t, "transaction missing from block frames")
+	}
+}
+
+func TestArbTraceInternalTx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	block, err := builder.L2.Client.BlockByHash(ctx, receipt.BlockHash)
+	Require(t, err)
+	internalTx := block.Transactions()[0]
+	if internalTx.Type() != types.ArbitrumInternalTxType {
+		Fatal(t, "block starts with a transaction of type", internalTx.Type())
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	checkFrames := func(method string, frames []traceFrame) {
+		t.Helper()
+		if len(frames) == 0 {
+			Fatal(t, method, "returned no frames for the internal transaction")
+		}
+		frame := frames[0]
+		if common.BytesToHash(*frame.TransactionHash) != internalTx.Hash() {
+			Fatal(t, method, "returned a first frame of a different transaction")
+		}
+		if frame.Type != "arbInternal" {
+			Fatal(t, method, "returned an internal transaction frame of type", frame.Type)
+		}
+		if frame.Action.Method != "startBlock" {
+			Fatal(t, method, "decoded the internal transaction as", frame.Action.Method)
+		}
+		for _, field := range []string{"l1BlockNumber", "baseFee", "l1PricePerUnit", "arbosVersion"} {
+			raw, ok := frame.Action.Update[field]
+			if !ok {
+				Fatal(t, method, "is missing the update to", field)
+			}
+			if kind := diffKind(t, raw); kind != "=" && kind != "*" {
+				Fatal(t, method, "marked the update to", field, "as", kind)
+			}
+		}
+	}
+
+	var frames []traceFrame
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_block", rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64())))
+	Require(t, err)
+	checkFrames("arbtrace_block", frames)
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", hexutil.Bytes(internalTx.Hash().Bytes()))
+	Require(t, err)
+	checkFrames("arbtrace_transaction", frames)
+	if len(frames) != 1 {
+		Fatal(t, "internal transaction has", len(frames), "frames")
+	}
+}
+
+func TestArbTraceBlockRewards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))
+	var frames []traceFrame
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_block", blockNum)
+	Require(t, err)
+	for _, frame := range frames {
+		if frame.Type == "arbReward" {
+			Fatal(t, "reward frames returned without being requested")
+		}
+	}
+
+	err = l2rpc.CallContext(ctx, &frames, "arbtrace_block", blockNum, map[string]bool{"rewards": true})
+	Require(t, err)
+	total := new(big.Int)
+	for _, frame := range frames {
+		if frame.Type != "arbReward" {
+			continue
+		}
+		if frame.Action.Author == nil || frame.Action.RewardType == "" || frame.Action.Value == nil {
+			Fatal(t, "malformed reward frame", frame.Action)
+		}
+		total.Add(total, frame.Action.Value.ToInt())
+	}
+	// fees are only paid by the transfer, as the block's internal transactions are free
+	fees := new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+	if total.Cmp(fees) != 0 {
+		Fatal(t, "rewards", total, "don't add up to the fees paid", fees)
+	}
+}
+
+// callerCode returns contract code that calls the given address once before stopping
+func callerCode(callee common.Address) []byte {
+	code := []byte{
+		byte(vm.PUSH1), 0, // retSize
+		byte(vm.PUSH1), 0, // retOffset
+		byte(vm.PUSH1), 0, // argsSize
+		byte(vm.PUSH1), 0, // argsOffset
+		byte(vm.PUSH1), 0, // value
+		byte(vm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	return append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+}
+
+func TestArbTraceBlockOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	// sequences the transactions sent together into a single block
+	builder.execConfig.Sequencer.MaxBlockSpeed = time.Second
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// calls the callee twice
+	callTwiceCode := func(callee common.Address) []byte {
+		call := callerCode(callee)
+		call = call[:len(call)-1]
+		return append(append(call, call...), byte(vm.STOP))
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	leaf := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	middle := deployContract(t, ctx, auth, builder.L2.Client, callTwiceCode(leaf))
+	top := deployContract(t, ctx, auth, builder.L2.Client, callTwiceCode(middle))
+
+	var txs []*types.Transaction
+	for i := 0; i < 3; i++ {
+		tx := builder.L2Info.PrepareTxTo("Owner", &top, 1e6, big.NewInt(0), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		txs = append(txs, tx)
+	}
+	var receipts []*types.Receipt
+	for _, tx := range txs {
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		receipts = append(receipts, receipt)
+	}
+	blockNumber := receipts[0].BlockNumber
+	for _, receipt := range receipts {
+		if receipt.BlockNumber.Cmp(blockNumber) != 0 {
+			Fatal(t, "transactions weren't sequenced into the same block")
+		}
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(blockNumber.Int64()))
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_block", blockNum, map[string]bool{"rewards": true}))
+	if len(frames) == 0 || frames[0].Type != "arbInternal" || frames[0].TransactionPosition == nil || *frames[0].TransactionPosition != 0 {
+		Fatal(t, "expected the internal transaction's frame to lead the block")
+	}
+
+	// each transaction's frames in depth-first pre-order
+	expected := [][]int{{}, {0}, {0, 0}, {0, 1}, {1}, {1, 0}, {1, 1}}
+	position := 0
+	for _, receipt := range receipts {
+		for position < len(frames) && (frames[position].TransactionPosition == nil || *frames[position].TransactionPosition < uint64(receipt.TransactionIndex)) {
+			position++
+		}
+		for _, traceAddress := range expected {
+			if position >= len(frames) {
+				Fatal(t, "missing frames of transaction", receipt.TransactionIndex)
+			}
+			frame := frames[position]
+			if frame.TransactionPosition == nil || *frame.TransactionPosition != uint64(receipt.TransactionIndex) {
+				Fatal(t, "frame of the wrong transaction at", position)
+			}
+			if fmt.Sprint(frame.TraceAddress) != fmt.Sprint(traceAddress) {
+				Fatal(t, "expected trace address", traceAddress, "at", position, "got", frame.TraceAddress)
+			}
+			position++
+		}
+	}
+
+	// positions never decrease, and the unpositioned reward frames follow every transaction's
+	var lastPosition uint64
+	rewards := false
+	for i, frame := range frames {
+		if frame.TransactionPosition == nil {
+			if frame.Type != "arbReward" {
+				Fatal(t, "unexpected unpositioned frame", frame.Type)
+			}
+			rewards = true
+			continue
+		}
+		if rewards || *frame.TransactionPosition < lastPosition {
+			Fatal(t, "frame", i, "is out of order")
+		}
+		lastPosition = *frame.TransactionPosition
+	}
+	if !rewards {
+		Fatal(t, "expected reward frames")
+	}
+
+	var again []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &again, "arbtrace_block", blockNum, map[string]bool{"rewards": true}))
+	first, err := json.Marshal(frames)
+	Require(t, err)
+	second, err := json.Marshal(again)
+	Require(t, err)
+	if !bytes.Equal(first, second) {
+		Fatal(t, "tracing the block again reordered its frames")
+	}
+}
+
+func TestArbTraceGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	assertTraceMatchesReceipt(t, ctx, builder.L2, tx.Hash())
+	// as does the internal transaction ArbOS started the block with
+	block, err := builder.L2.Client.BlockByNumber(ctx, receipt.BlockNumber)
+	Require(t, err)
+	assertTraceMatchesReceipt(t, ctx, builder.L2, block.Transactions()[0].Hash())
+
+	l2rpc := builder.L2.Stack.Attach()
+	var frame traceFrame
+	err = l2rpc.CallContext(ctx, &frame, "arbtrace_get", tx.Hash(), []hexutil.Uint64{})
+	Require(t, err)
+	if frame.Action.To == nil || *frame.Action.To != caller || frame.Subtraces != 1 {
+		Fatal(t, "unexpected top-level frame", frame)
+	}
+	err = l2rpc.CallContext(ctx, &frame, "arbtrace_get", tx.Hash(), []hexutil.Uint64{0})
+	Require(t, err)
+	if frame.Action.To == nil || *frame.Action.To != callee || len(frame.TraceAddress) != 1 {
+		Fatal(t, "unexpected nested frame", frame)
+	}
+	if frame.TransactionHash == nil || common.BytesToHash(*frame.TransactionHash) != tx.Hash() {
+		Fatal(t, "nested frame is missing its transaction")
+	}
+
+	// a maxDepth prunes the subtree below the frame, which still counts its pruned children
+	var subtree []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &subtree, "arbtrace_get", tx.Hash(), []hexutil.Uint64{}, hexutil.Uint64(0)))
+	if len(subtree) != 1 || subtree[0].Action.To == nil || *subtree[0].Action.To != caller || subtree[0].Subtraces != 1 {
+		Fatal(t, "unexpected subtree pruned to the top-level frame", subtree)
+	}
+	Require(t, l2rpc.CallContext(ctx, &subtree, "arbtrace_get", tx.Hash(), []hexutil.Uint64{}, hexutil.Uint64(1)))
+	if len(subtree) != 2 || subtree[1].Action.To == nil || *subtree[1].Action.To != callee || len(subtree[1].TraceAddress) != 1 {
+		Fatal(t, "unexpected subtree one level deep", subtree)
+	}
+	Require(t, l2rpc.CallContext(ctx, &subtree, "arbtrace_get", tx.Hash(), []hexutil.Uint64{0}, hexutil.Uint64(5)))
+	if len(subtree) != 1 || subtree[0].Action.To == nil || *subtree[0].Action.To != callee {
+		Fatal(t, "unexpected subtree of the nested frame", subtree)
+	}
+
+	err = l2rpc.CallContext(ctx, &frame, "arbtrace_get", tx.Hash(), []hexutil.Uint64{1})
+	if err == nil || !strings.Contains(err.Error(), "out of range at depth 0") {
+		Fatal(t, "expected an out of range error at depth 0", err)
+	}
+	err = l2rpc.CallContext(ctx, &frame, "arbtrace_get", tx.Hash(), []hexutil.Uint64{0, 0})
+	if err == nil || !strings.Contains(err.Error(), "out of range at depth 1") {
+		Fatal(t, "expected an out of range error at depth 1", err)
+	}
+	err = l2rpc.CallContext(ctx, &frame, "arbtrace_get", tx.Hash(), make([]hexutil.Uint64, 2000))
+	if err == nil {
+		Fatal(t, "expected an overly long path to be rejected")
+	}
+}
+
+// assertTraceMatchesReceipt traces a transaction, checking the trace agrees with its receipt on the gas it
+// used and on whether it failed, so the tracer can't drift from execution unnoticed. Frames follow Parity in
+// leaving intrinsic and parent chain posting gas out of their gasUsed, so the gas compared is the summary's.
+func assertTraceMatchesReceipt(t *testing.T, ctx context.Context, node *TestClient, txHash common.Hash) {
+	t.Helper()
+	receipt, err := node.Client.TransactionReceipt(ctx, txHash)
+	Require(t, err)
+	var result traceResult
+	Require(t, node.Stack.Attach().CallContext(ctx, &result, "arbtrace_replayTransaction", txHash, []string{"trace", "summary"}))
+	if result.Summary == nil || len(result.Trace) == 0 {
+		Fatal(t, "trace of", txHash, "is missing its summary or frames")
+	}
+	if uint64(result.Summary.GasUsed) != receipt.GasUsed {
+		Fatal(t, "trace of", txHash, "used", result.Summary.GasUsed, "gas but its receipt used", receipt.GasUsed)
+	}
+	failed := receipt.Status != types.ReceiptStatusSuccessful
+	if top := result.Trace[0]; (top.Error != nil) != failed {
+		Fatal(t, "trace of", txHash, "has top-level error", top.Error, "but its receipt has status", receipt.Status)
+	}
+	if result.Summary.Reverted != failed {
+		Fatal(t, "summary of", txHash, "has reverted", result.Summary.Reverted, "but its receipt has status", receipt.Status)
+	}
+}
+
+// revertCode returns contract code that reverts with the ABI encoding of Error(reason)
+func revertCode(t *testing.T, reason string) []byte {
+	t.Helper()
+	stringType, err := abi.NewType("string", "", nil)
+	Require(t, err)
+	args, err := abi.Arguments{{Type: stringType}}.Pack(reason)
+	Require(t, err)
+	return revertDataCode(append(crypto.Keccak256([]byte("Error(string)"))[:4], args...))
+}
+
+// revertDataCode returns contract code that reverts with the given data
+func revertDataCode(data []byte) []byte {
+	// copy the revert data, which follows this prelude, into memory and revert with it
+	prelude := []byte{
+		byte(vm.PUSH2), byte(len(data) >> 8), byte(len(data)), // size
+		byte(vm.PUSH1), 14, // offset of the data within the code, just past this prelude
+		byte(vm.PUSH1), 0, // destination in memory
+		byte(vm.CODECOPY),
+		byte(vm.PUSH2), byte(len(data) >> 8), byte(len(data)),
+		byte(vm.PUSH1), 0,
+		byte(vm.REVERT),
+	}
+	return append(prelude, data...)
+}
+
+func TestArbTraceErrorReasons(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	owner := builder.L2Info.GetAddress("Owner")
+	panicData := append(crypto.Keccak256([]byte("Panic(uint256)"))[:4], common.BigToHash(big.NewInt(0x11)).Bytes()...)
+	customData := append(crypto.Keccak256([]byte("InsufficientBalance()"))[:4], 0xab)
+	cases := []struct {
+		code   []byte
+		reason string
+	}{
+		{revertCode(t, "insufficient balance"), "execution reverted: insufficient balance"},
+		{revertDataCode(panicData), "panic: arithmetic overflow (0x11)"},
+		{revertDataCode(customData), "execution reverted: " + hexutil.Encode(customData)},
+		{revertDataCode(nil), "execution reverted"},
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	for _, test := range cases {
+		reverter := deployContract(t, ctx, auth, builder.L2.Client, test.code)
+		var result traceResult
+		latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+		err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, To: &reverter}, []string{"trace"}, latest)
+		Require(t, err)
+		frame := result.Trace[0]
+		if frame.Error == nil || *frame.Error != "Reverted" {
+			Fatal(t, "the raw error should be kept for compatibility", frame.Error)
+		}
+		if frame.ErrorReason == nil || *frame.ErrorReason != test.reason {
+			Fatal(t, "expected error reason", test.reason, "but got", frame.ErrorReason)
+		}
+	}
+}
+
+func TestArbTraceCallFeeArgs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// reads storage slot 0
+	code := []byte{
+		byte(vm.PUSH1), 0,
+		byte(vm.SLOAD),
+		byte(vm.POP),
+		byte(vm.STOP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	owner := builder.L2Info.GetAddress("Owner")
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	gasUsed := func(args callTxArgs) uint64 {
+		t.Helper()
+		var result traceResult
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, latest))
+		if len(result.Trace) == 0 || result.Trace[0].Result == nil {
+			Fatal(t, "call has no result")
+		}
+		return uint64(result.Trace[0].Result.GasUsed)
+	}
+
+	maxFee := (*hexutil.Big)(big.NewInt(params.GWei))
+	tip := (*hexutil.Big)(big.NewInt(0))
+	cold := gasUsed(callTxArgs{From: &owner, To: &contract, MaxFeePerGas: maxFee, MaxPriorityFeePerGas: tip})
+	accessList := types.AccessList{{Address: contract, StorageKeys: []common.Hash{{}}}}
+	warm := gasUsed(callTxArgs{From: &owner, To: &contract, MaxFeePerGas: maxFee, MaxPriorityFeePerGas: tip, AccessList: &accessList})
+	if cold-warm != params.ColdSloadCostEIP2929-params.WarmStorageReadCostEIP2929 {
+		Fatal(t, "access list saved", cold-warm, "gas on a cold read")
+	}
+
+	var result traceResult
+	gasPrice := (*hexutil.Big)(big.NewInt(params.GWei))
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, To: &contract, GasPrice: gasPrice, MaxFeePerGas: maxFee}, []string{"trace"}, latest)
+	if err == nil || !strings.Contains(err.Error(), "both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified") {
+		Fatal(t, "expected conflicting fee fields to be rejected, got", err)
+	}
+}
+
+type blockOverrides struct {
+	Number   *hexutil.Big    `json:"number,omitempty"`
+	Time     *hexutil.Uint64 `json:"time,omitempty"`
+	BaseFee  *hexutil.Big    `json:"baseFee,omitempty"`
+	Coinbase *common.Address `json:"coinbase,omitempty"`
+}
+
+func TestArbTraceCallBlockOverrides(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// returns the timestamp, coinbase, and base fee it sees
+	code := []byte{
+		byte(vm.TIMESTAMP), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.COINBASE), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.BASEFEE), byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x60, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	owner := builder.L2Info.GetAddress("Owner")
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	header, err := builder.L2.Client.HeaderByNumber(ctx, nil)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	base := rpc.BlockNumberOrHashWithHash(header.Hash(), false)
+	args := callTxArgs{From: &owner, To: &contract}
+	future := hexutil.Uint64(header.Time + 3600)
+	coinbase := testhelpers.RandomAddress()
+	baseFee := (*hexutil.Big)(big.NewInt(params.GWei))
+	overrides := blockOverrides{Time: &future, Coinbase: &coinbase, BaseFee: baseFee}
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, base, overrides)
+	Require(t, err)
+	if len(result.Output) != 0x60 {
+		Fatal(t, "unexpected output", result.Output)
+	}
+	if seen := new(big.Int).SetBytes(result.Output[:0x20]).Uint64(); seen != uint64(future) {
+		Fatal(t, "call saw time", seen, "rather than", future)
+	}
+	if seen := common.BytesToAddress(result.Output[0x20:0x40]); seen != coinbase {
+		Fatal(t, "call saw coinbase", seen, "rather than", coinbase)
+	}
+	if seen := new(big.Int).SetBytes(result.Output[0x40:]); seen.Cmp(baseFee.ToInt()) != 0 {
+		Fatal(t, "call saw base fee", seen, "rather than", baseFee)
+	}
+
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, base)
+	Require(t, err)
+	if seen := new(big.Int).SetBytes(result.Output[:0x20]).Uint64(); seen != header.Time {
+		Fatal(t, "call without overrides saw time", seen, "rather than", header.Time)
+	}
+
+	past := hexutil.Uint64(header.Time - 1)
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, base, blockOverrides{Time: &past})
+	if err == nil || !strings.Contains(err.Error(), "precedes the base block") {
+		Fatal(t, "expected a time override preceding the base block to be rejected, got", err)
+	}
+	earlier := (*hexutil.Big)(new(big.Int).Sub(header.Number, common.Big1))
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, base, blockOverrides{Number: earlier})
+	if err == nil || !strings.Contains(err.Error(), "precedes the base block") {
+		Fatal(t, "expected a number override preceding the base block to be rejected, got", err)
+	}
+}
+
+type overrideAccount struct {
+	Balance   *hexutil.Big                 `json:"balance,omitempty"`
+	Code      *hexutil.Bytes               `json:"code,omitempty"`
+	State     *map[common.Hash]common.Hash `json:"state,omitempty"`
+	StateDiff *map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+}
+
+func TestArbTraceCallStateOverrides(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// returns storage slot 0 and its own balance
+	code := hexutil.Bytes{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.SELFBALANCE), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}
+	owner := builder.L2Info.GetAddress("Owner")
+	target := testhelpers.RandomAddress()
+	slots := map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(42))}
+	balance := (*hexutil.Big)(big.NewInt(1e18))
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	args := callTxArgs{From: &owner, To: &target}
+	overrides := map[common.Address]overrideAccount{
+		target: {Balance: balance, Code: &code, StateDiff: &slots},
+	}
+	var result traceResult
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, latest, nil, overrides)
+	Require(t, err)
+	if len(result.Output) != 0x40 {
+		Fatal(t, "unexpected output", result.Output)
+	}
+	if seen := new(big.Int).SetBytes(result.Output[:0x20]); seen.Int64() != 42 {
+		Fatal(t, "call saw slot 0 as", seen)
+	}
+	if seen := new(big.Int).SetBytes(result.Output[0x20:]); seen.Cmp(balance.ToInt()) != 0 {
+		Fatal(t, "call saw balance", seen)
+	}
+
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, latest)
+	Require(t, err)
+	if len(result.Output) != 0 {
+		Fatal(t, "overrides persisted beyond the call that made them")
+	}
+
+	overrides[target] = overrideAccount{State: &slots, StateDiff: &slots}
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, latest, nil, overrides)
+	if err == nil || !strings.Contains(err.Error(), "both state and stateDiff") {
+		Fatal(t, "expected state and stateDiff overrides of one account to be rejected, got", err)
+	}
+}
+
+type bundleResult struct {
+	Results []struct {
+		TxHash       common.Hash    `json:"txHash"`
+		From         common.Address `json:"from"`
+		Success      bool           `json:"success"`
+		Error        *string        `json:"error"`
+		RevertReason *string        `json:"revertReason"`
+		Fees         *arbitrumFees  `json:"fees"`
+		Logs         []*types.Log   `json:"logs"`
+	} `json:"results"`
+	TotalGasUsed    hexutil.Uint64                  `json:"totalGasUsed"`
+	CoinbaseDiff    *hexutil.Big                    `json:"coinbaseDiff"`
+	FeeAccountDiffs map[common.Address]*hexutil.Big `json:"feeAccountDiffs"`
+}
+
+func TestArbTraceSimulateBundle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// always reverts
+	code := []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.REVERT)}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, code)
+
+	// the searcher is only funded by the bundle's state overrides
+	builder.L2Info.GenerateAccount("Searcher")
+	searcher := builder.L2Info.GetAddress("Searcher")
+	coinbase := testhelpers.RandomAddress()
+	payment := big.NewInt(1e12)
+	payCoinbase := builder.L2Info.PrepareTxTo("Searcher", &coinbase, builder.L2Info.TransferGas, payment, nil)
+	revert := builder.L2Info.PrepareTxTo("Searcher", &reverter, 1e6, big.NewInt(0), nil)
+	var rawTxs []hexutil.Bytes
+	for _, tx := range []*types.Transaction{payCoinbase, revert} {
+		rawTx, err := tx.MarshalBinary()
+		Require(t, err)
+		rawTxs = append(rawTxs, rawTx)
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	overrides := map[common.Address]overrideAccount{
+		searcher: {Balance: (*hexutil.Big)(big.NewInt(1e18))},
+	}
+	var result bundleResult
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_simulateBundle", rawTxs, latest, blockOverrides{Coinbase: &coinbase}, overrides)
+	Require(t, err)
+	if len(result.Results) != 2 {
+		Fatal(t, "unexpected number of results", len(result.Results))
+	}
+	paid, reverted := result.Results[0], result.Results[1]
+	if paid.TxHash != payCoinbase.Hash() || paid.From != searcher || !paid.Success || paid.Error != nil {
+		Fatal(t, "unexpected result of the coinbase payment", paid)
+	}
+	if reverted.TxHash != revert.Hash() || reverted.Success || reverted.Error == nil {
+		Fatal(t, "expected the second transaction to revert", reverted)
+	}
+	if result.CoinbaseDiff.ToInt().Cmp(payment) != 0 {
+		Fatal(t, "unexpected coinbase diff", result.CoinbaseDiff)
+	}
+	if result.TotalGasUsed != paid.Fees.GasUsed+reverted.Fees.GasUsed {
+		Fatal(t, "total gas used", result.TotalGasUsed, "doesn't add up")
+	}
+	// ArbOS distributes the base fee of all the gas used between its fee accounts
+	distributed := new(big.Int)
+	for _, diff := range result.FeeAccountDiffs {
+		distributed.Add(distributed, diff.ToInt())
+	}
+	expected := new(big.Int)
+	for _, tx := range result.Results {
+		expected.Add(expected, new(big.Int).Mul(tx.Fees.BaseFee.ToInt(), new(big.Int).SetUint64(uint64(tx.Fees.GasUsed))))
+	}
+	if distributed.Cmp(expected) != 0 {
+		Fatal(t, "fee accounts received", distributed, "rather than", expected)
+	}
+
+	// without the overrides the searcher can't pay for the bundle
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_simulateBundle", rawTxs, latest)
+	if err == nil || !strings.Contains(err.Error(), "transaction 0") {
+		Fatal(t, "expected the unfunded bundle to fail, got", err)
+	}
+}
+
+func TestArbTraceCallResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	owner := builder.L2Info.GetAddress("Owner")
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, revertCode(t, "no thanks"))
+
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	var result traceResult
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, To: &reverter}, []string{"trace"}, latest)
+	Require(t, err)
+	frame := result.Trace[0]
+	if frame.Error == nil || *frame.Error != "Reverted" {
+		Fatal(t, "unexpected error", frame.Error)
+	}
+	if frame.RevertReason == nil || *frame.RevertReason != "no thanks" {
+		Fatal(t, "unexpected revert reason", frame.RevertReason)
+	}
+	if frame.ErrorReason == nil || *frame.ErrorReason != "execution reverted: no thanks" {
+		Fatal(t, "unexpected error reason", frame.ErrorReason)
+	}
+	if frame.Result != nil {
+		Fatal(t, "reverted frame has a result")
+	}
+
+	// deploy a single STOP
+	initCode := hexutil.Bytes(deployContractInitCode([]byte{byte(vm.STOP)}, false))
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, Data: &initCode}, []string{"trace"}, latest)
+	Require(t, err)
+	frame = result.Trace[0]
+	if frame.Type != "create" || frame.Result == nil || frame.Result.Address == nil || frame.Result.Code == nil {
+		Fatal(t, "create frame is missing its result", frame.Result)
+	}
+	if frame.Result.Output != nil {
+		Fatal(t, "create frame has an output")
+	}
+	if !bytes.Equal(*frame.Result.Code, []byte{byte(vm.STOP)}) {
+		Fatal(t, "unexpected deployed code", *frame.Result.Code)
+	}
+}
+
+type blockTraces struct {
+	BlockHash   common.Hash    `json:"blockHash"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	Traces      []traceFrame   `json:"traces"`
+	Results     []*traceResult `json:"results"`
+
+	ProgressToken *string `json:"progressToken"`
+}
+
+func TestArbTraceBlockStateDiff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	owner := builder.L2Info.GetAddress("Owner")
+	user2 := builder.L2Info.GetAddress("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result struct {
+		BlockHash      common.Hash                           `json:"blockHash"`
+		StateDiff      map[common.Address]*accountDiff       `json:"stateDiff"`
+		ArbOSStateDiff map[string]map[string]json.RawMessage `json:"arbosStateDiff"`
+	}
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_blockStateDiff", rpc.BlockNumberOrHashWithHash(receipt.BlockHash, false))
+	Require(t, err)
+	if result.BlockHash != receipt.BlockHash {
+		Fatal(t, "state diff is of block", result.BlockHash, "rather than", receipt.BlockHash)
+	}
+	if result.ArbOSStateDiff == nil {
+		Fatal(t, "ArbOS state diff missing")
+	}
+
+	before := new(big.Int).Sub(receipt.BlockNumber, common.Big1)
+	balanceAt := func(account common.Address, number *big.Int) *big.Int {
+		t.Helper()
+		balance, err := builder.L2.Client.BalanceAt(ctx, account, number)
+		Require(t, err)
+		return balance
+	}
+	ownerDiff := result.StateDiff[owner]
+	if ownerDiff == nil {
+		Fatal(t, "sender missing from the block's state diff")
+	}
+	var changed map[string]struct {
+		From *hexutil.Big `json:"from"`
+		To   *hexutil.Big `json:"to"`
+	}
+	Require(t, json.Unmarshal(ownerDiff.Balance, &changed))
+	if changed["*"].From.ToInt().Cmp(balanceAt(owner, before)) != 0 || changed["*"].To.ToInt().Cmp(balanceAt(owner, receipt.BlockNumber)) != 0 {
+		Fatal(t, "sender's balance diff doesn't match the chain", string(ownerDiff.Balance))
+	}
+	user2Diff := result.StateDiff[user2]
+	if user2Diff == nil {
+		Fatal(t, "recipient missing from the block's state diff")
+	}
+	var born map[string]*hexutil.Big
+	Require(t, json.Unmarshal(user2Diff.Balance, &born))
+	if born["+"] == nil || born["+"].ToInt().Cmp(balanceAt(user2, receipt.BlockNumber)) != 0 {
+		Fatal(t, "recipient's balance diff doesn't match the chain", string(user2Diff.Balance))
+	}
+}
+
+func TestArbTraceBlockRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.FilterMaxRange = 10
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	var receipts []*types.Receipt
+	for i := 0; i < 3; i++ {
+		tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		receipts = append(receipts, receipt)
+	}
+	first := receipts[0].BlockNumber.Int64()
+	last := receipts[len(receipts)-1].BlockNumber.Int64()
+	from := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(first))
+	to := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(last))
+
+	l2rpc := builder.L2.Stack.Attach()
+	var ranges []*blockTraces
+	err := l2rpc.CallContext(ctx, &ranges, "arbtrace_blockRange", from, to, []string{"trace"})
+	Require(t, err)
+	if int64(len(ranges)) != last-first+1 {
+		Fatal(t, "unexpected number of blocks", len(ranges))
+	}
+	for i, traces := range ranges {
+		if int64(traces.BlockNumber) != first+int64(i) {
+			Fatal(t, "blocks out of order", traces.BlockNumber)
+		}
+		if len(traces.Traces) == 0 {
+			Fatal(t, "block", traces.BlockNumber, "has no frames")
+		}
+		if traces.Results != nil {
+			Fatal(t, "results returned without being requested")
+		}
+	}
+
+	err = l2rpc.CallContext(ctx, &ranges, "arbtrace_blockRange", from, to, []string{"stateDiff"})
+	Require(t, err)
+	if len(ranges[0].Results) == 0 || ranges[0].Results[0].StateDiff == nil {
+		Fatal(t, "state diffs missing from the range")
+	}
+
+	from = rpc.BlockNumberOrHashWithNumber(0)
+	to = rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(last + 20))
+	err = l2rpc.CallContext(ctx, &ranges, "arbtrace_blockRange", from, to, []string{"trace"})
+	if err == nil {
+		Fatal(t, "expected a range exceeding the limit to be rejected")
+	}
+}
+
+func TestArbTraceBlockRangeProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	startMsgCount, err := builder.L2.ConsensusNode.TxStreamer.GetMessageCount()
+	Require(t, err)
+	var receipts []*types.Receipt
+	for i := 0; i < 3; i++ {
+		tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		receipts = append(receipts, receipt)
+	}
+	first := receipts[0].BlockNumber.Int64()
+	last := receipts[len(receipts)-1].BlockNumber.Int64()
+	from := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(first))
+	to := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(last))
+
+	l2rpc := builder.L2.Stack.Attach()
+	var ranges []*blockTraces
+	Require(t, l2rpc.CallContext(ctx, &ranges, "arbtrace_blockRange", from, to, []string{"trace"}))
+	for _, traces := range ranges {
+		if traces.ProgressToken != nil {
+			Fatal(t, "progress token returned without being requested")
+		}
+	}
+	Require(t, l2rpc.CallContext(ctx, &ranges, "arbtrace_blockRange", from, to, []string{"trace"}, ""))
+	if int64(len(ranges)) != last-first+1 {
+		Fatal(t, "unexpected number of blocks", len(ranges))
+	}
+	for _, traces := range ranges {
+		if traces.ProgressToken == nil {
+			Fatal(t, "block", traces.BlockNumber, "has no progress token")
+		}
+	}
+	firstToken := *ranges[0].ProgressToken
+	lastToken := *ranges[len(ranges)-1].ProgressToken
+
+	// resuming after the first block returns the rest of the range
+	var resumed []*blockTraces
+	Require(t, l2rpc.CallContext(ctx, &resumed, "arbtrace_blockRange", from, to, []string{"trace"}, firstToken))
+	if len(resumed) != len(ranges)-1 {
+		Fatal(t, "expected", len(ranges)-1, "blocks after resuming, got", len(resumed))
+	}
+	for i, traces := range resumed {
+		if traces.BlockHash != ranges[i+1].BlockHash {
+			Fatal(t, "resumed block", traces.BlockNumber, "doesn't match the original range")
+		}
+	}
+	// and resuming after the last leaves nothing to trace
+	Require(t, l2rpc.CallContext(ctx, &resumed, "arbtrace_blockRange", from, to, []string{"trace"}, lastToken))
+	if len(resumed) != 0 {
+		Fatal(t, "expected a completed range to return no blocks, got", len(resumed))
+	}
+	err = l2rpc.CallContext(ctx, &resumed, "arbtrace_blockRange", from, to, []string{"trace"}, "not a token")
+	if err == nil || !strings.Contains(err.Error(), "invalid progress token") {
+		Fatal(t, "expected a malformed token to be rejected, got", err)
+	}
+
+	// tokens from blocks that have been reorged out are refused
+	Require(t, builder.L2.ConsensusNode.TxStreamer.ReorgTo(startMsgCount))
+	_, err = builder.L2.ExecNode.ExecEngine.HeadMessageNumberSync(t)
+	Require(t, err)
+	builder.L2Info.GetInfoWithPrivKey("Owner").Nonce -= uint64(len(receipts))
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(2e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	replaced, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	if replaced.BlockHash == receipts[0].BlockHash {
+		Fatal(t, "the reorg didn't replace the block")
+	}
+	to = rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(replaced.BlockNumber.Int64()))
+	err = l2rpc.CallContext(ctx, &resumed, "arbtrace_blockRange", from, to, []string{"trace"}, firstToken)
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrStaleProgressToken.Error()) {
+		Fatal(t, "expected a reorged token to be refused, got", err)
+	}
+}
+
+type batchTraces struct {
+	BatchNumber hexutil.Uint64 `json:"batchNumber"`
+	FromBlock   hexutil.Uint64 `json:"fromBlock"`
+	ToBlock     hexutil.Uint64 `json:"toBlock"`
+	Blocks      []*blockTraces `json:"blocks"`
+}
+
+func TestArbTraceBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	waitForSequencer(t, builder, receipt.BlockNumber.Uint64())
+	batchNum, found, err := builder.L2.ConsensusNode.InboxTracker.FindInboxBatchContainingMessage(arbutil.BlockNumberToMessageCount(receipt.BlockNumber.Uint64(), 0) - 1)
+	Require(t, err)
+	if !found {
+		Fatal(t, "transaction's block not found in a batch")
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	var batch batchTraces
+	Require(t, l2rpc.CallContext(ctx, &batch, "arbtrace_batch", hexutil.Uint64(batchNum), []string{"trace"}))
+	if uint64(batch.BatchNumber) != batchNum || uint64(batch.FromBlock) > receipt.BlockNumber.Uint64() || uint64(batch.ToBlock) < receipt.BlockNumber.Uint64() {
+		Fatal(t, "batch doesn't span the transaction's block", batch.BatchNumber, batch.FromBlock, batch.ToBlock)
+	}
+	if len(batch.Blocks) != int(batch.ToBlock-batch.FromBlock)+1 {
+		Fatal(t, "unexpected number of blocks", len(batch.Blocks))
+	}
+	traced := false
+	for i, block := range batch.Blocks {
+		if block.BlockNumber != batch.FromBlock+hexutil.Uint64(i) {
+			Fatal(t, "blocks out of order", block.BlockNumber)
+		}
+		for _, frame := range block.Traces {
+			if frame.TransactionHash != nil && common.BytesToHash(*frame.TransactionHash) == tx.Hash() {
+				traced = true
+			}
+		}
+	}
+	if !traced {
+		Fatal(t, "transaction missing from its batch's traces")
+	}
+
+	batchCount, err := builder.L2.ConsensusNode.InboxTracker.GetBatchCount()
+	Require(t, err)
+	err = l2rpc.CallContext(ctx, &batch, "arbtrace_batch", hexutil.Uint64(batchCount+10), []string{"trace"})
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrBatchNotFound.Error()) {
+		Fatal(t, "expected a batch not yet posted to be rejected", err)
+	}
+}
+
+func TestArbTraceReplayConcurrently(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.Sequencer.MaxBlockSpeed = time.Second
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	var txs []*types.Transaction
+	for i := 0; i < 4; i++ {
+		tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		txs = append(txs, tx)
+	}
+	var receipt *types.Receipt
+	for _, tx := range txs {
+		var err error
+		receipt, err = builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))
+	replay := func(workers int) json.RawMessage {
+		t.Helper()
+		builder.execConfig.ArbTrace.ReplayWorkers = workers
+		var results json.RawMessage
+		err := l2rpc.CallContext(ctx, &results, "arbtrace_replayBlockTransactions", blockNum, []string{"trace", "stateDiff"})
+		Require(t, err)
+		return results
+	}
+	sequential := replay(1)
+	if concurrent := replay(4); !bytes.Equal(sequential, concurrent) {
+		Fatal(t, "concurrent replay differs from sequential replay\n", string(sequential), "\n", string(concurrent))
+	}
+}
+
+// TestArbTraceConcurrentRequests serves a mix of trace requests at once from the one ArbTraceAPI, with
+// replay workers and the trace cache enabled, checking each matches the same request served alone. Run
+// under -race, it checks the handlers share no unsynchronized state.
+func TestArbTraceConcurrentRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.Sequencer.MaxBlockSpeed = time.Second
+	builder.execConfig.ArbTrace.ReplayWorkers = 4
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	storeCode := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE)}
+	callee := deployContract(t, ctx, auth, builder.L2.Client, append(storeCode, byte(vm.STOP)))
+	caller := deployContract(t, ctx, auth, builder.L2.Client, append(storeCode, callerCode(callee)...))
+	builder.L2Info.GenerateAccount("User2")
+	txs := []*types.Transaction{
+		builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil),
+		builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil),
+		builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil),
+	}
+	for _, tx := range txs {
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	}
+	var receipt *types.Receipt
+	for _, tx := range txs {
+		var err error
+		receipt, err = builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	owner := builder.L2Info.GetAddress("Owner")
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64() - 2))
+	requests := []struct {
+		method string
+		args   []interface{}
+	}{
+		{"arbtrace_replayBlockTransactions", []interface{}{blockNum, []string{"trace", "stateDiff"}}},
+		{"arbtrace_block", []interface{}{blockNum}},
+		{"arbtrace_blockRange", []interface{}{fromBlock, blockNum, []string{"trace", "summary"}}},
+		{"arbtrace_filter", []interface{}{filterRequest{FromBlock: &fromBlock, ToBlock: &blockNum}}},
+		{"arbtrace_replayTransaction", []interface{}{txs[0].Hash(), []string{"trace", "vmTrace", "stateDiff", "accessStats"}}},
+		{"arbtrace_transaction", []interface{}{txs[2].Hash()}},
+		{"arbtrace_result", []interface{}{txs[2].Hash()}},
+		{"arbtrace_call", []interface{}{callTxArgs{From: &owner, To: &caller}, []string{"trace", "stateDiff"}, blockNum}},
+		{"arbtrace_callMany", []interface{}{json.RawMessage(fmt.Sprintf(
+			`[[{"from": "%v", "to": "%v"}, ["trace", "stateDiff"]], [{"from": "%v", "to": "%v"}, ["trace"]]]`,
+			owner, caller, owner, callee,
+		)), blockNum}},
+	}
+	expected := make([]json.RawMessage, len(requests))
+	for i, request := range requests {
+		Require(t, l2rpc.CallContext(ctx, &expected[i], request.method, request.args...), request.method)
+	}
+
+	const rounds = 4
+	type response struct {
+		index  int
+		result json.RawMessage
+		err    error
+	}
+	responses := make(chan response, rounds*len(requests))
+	for round := 0; round < rounds; round++ {
+		for i := range requests {
+			go func(i int) {
+				var result json.RawMessage
+				err := l2rpc.CallContext(ctx, &result, requests[i].method, requests[i].args...)
+				responses <- response{i, result, err}
+			}(i)
+		}
+	}
+	for n := 0; n < cap(responses); n++ {
+		response := <-responses
+		method := requests[response.index].method
+		Require(t, response.err, method)
+		if !bytes.Equal(response.result, expected[response.index]) {
+			Fatal(t, method, "served concurrently differs from served alone\n", string(expected[response.index]), "\n", string(response.result))
+		}
+	}
+}
+
+func TestArbTraceBlockStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))
+	var expected []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &expected, "arbtrace_block", blockNum))
+
+	frames := make(chan *traceFrame)
+	sub, err := l2rpc.Subscribe(ctx, "arbtrace", frames, "blockStream", blockNum)
+	Require(t, err)
+	defer sub.Unsubscribe()
+	var streamed []traceFrame
+	for done := false; !done; {
+		select {
+		case frame := <-frames:
+			if frame == nil {
+				done = true
+				break
+			}
+			streamed = append(streamed, *frame)
+		case err := <-sub.Err():
+			Fatal(t, "subscription failed", err)
+		case <-time.After(10 * time.Second):
+			Fatal(t, "timed out after streaming", len(streamed), "frames")
+		}
+	}
+
+	expectedJson, err := json.Marshal(expected)
+	Require(t, err)
+	streamedJson, err := json.Marshal(streamed)
+	Require(t, err)
+	if !bytes.Equal(expectedJson, streamedJson) {
+		Fatal(t, "streamed frames differ from arbtrace_block\n", string(expectedJson), "\n", string(streamedJson))
+	}
+}
+
+func TestArbTraceSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	startMsgCount, err := builder.L2.ConsensusNode.TxStreamer.GetMessageCount()
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	fromBlock := rpc.BlockNumberOrHashWithNumber(1)
+	frames := make(chan *traceFrame)
+	_, err = l2rpc.Subscribe(ctx, "arbtrace", frames, "subscribe", filterRequest{FromBlock: &fromBlock})
+	if err == nil {
+		Fatal(t, "subscriptions shouldn't accept a block range")
+	}
+	sub, err := l2rpc.Subscribe(ctx, "arbtrace", frames, "subscribe", filterRequest{ToAddress: &[]common.Address{callee}})
+	Require(t, err)
+	defer sub.Unsubscribe()
+	nextFrame := func() *traceFrame {
+		t.Helper()
+		select {
+		case frame := <-frames:
+			return frame
+		case err := <-sub.Err():
+			Fatal(t, "subscription failed", err)
+		case <-time.After(10 * time.Second):
+			Fatal(t, "timed out waiting for a frame")
+		}
+		return nil
+	}
+
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	frame := nextFrame()
+	if frame.Removed || frame.Action.To == nil || *frame.Action.To != callee || !bytes.Equal(*frame.TransactionHash, tx.Hash().Bytes()) {
+		Fatal(t, "unexpected frame", frame)
+	}
+
+	// reorging out the transaction's block re-sends its frame as removed
+	Require(t, builder.L2.ConsensusNode.TxStreamer.ReorgTo(startMsgCount))
+	_, err = builder.L2.ExecNode.ExecEngine.HeadMessageNumberSync(t)
+	Require(t, err)
+	frame = nextFrame()
+	if !frame.Removed || !bytes.Equal(*frame.TransactionHash, tx.Hash().Bytes()) {
+		Fatal(t, "expected the reorged frame to be removed", frame)
+	}
+}
+
+func TestArbTraceSubscribeLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	builder.execConfig.ArbTrace.MaxSubscriptions = 1
+	builder.execConfig.ArbTrace.SubscribeMaxLag = 1
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	l2rpc := builder.L2.Stack.Attach()
+	notifications := make(chan json.RawMessage, 1024)
+	sub, err := l2rpc.Subscribe(ctx, "arbtrace", notifications, "subscribe", filterRequest{})
+	Require(t, err)
+	defer sub.Unsubscribe()
+	_, err = l2rpc.Subscribe(ctx, "arbtrace", make(chan json.RawMessage), "subscribe", filterRequest{})
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrTooManySubscriptions.Error()) {
+		Fatal(t, "expected a second subscription to be refused, got", err)
+	}
+
+	// loops until it runs out of gas, which takes the subscription far longer to trace than the blocks after it take to produce
+	spinCode := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	spinner := deployContract(t, ctx, auth, builder.L2.Client, spinCode)
+	tx := builder.L2Info.PrepareTxTo("Owner", &spinner, 30_000_000, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	EnsureTxFailed(t, ctx, builder.L2.Client, tx)
+	for i := 0; i < 3; i++ {
+		TransferBalance(t, "Owner", "Owner", big.NewInt(1), builder.L2Info, builder.L2.Client, ctx)
+	}
+	timeout := time.After(time.Minute)
+	for {
+		var notification json.RawMessage
+		select {
+		case notification = <-notifications:
+		case err := <-sub.Err():
+			Fatal(t, "subscription failed", err)
+		case <-timeout:
+			Fatal(t, "timed out waiting for the lagging subscription to end")
+		}
+		var end struct {
+			Error *string `json:"subscriptionError"`
+		}
+		Require(t, json.Unmarshal(notification, &end))
+		if end.Error == nil {
+			continue
+		}
+		if !strings.Contains(*end.Error, gethexec.ErrSubscriptionLagging.Error()) {
+			Fatal(t, "expected the subscription to end for lagging, got", *end.Error)
+		}
+		break
+	}
+
+	// the ended subscription no longer counts against the limit
+	var resubscribed *rpc.ClientSubscription
+	for i := 0; ; i++ {
+		resubscribed, err = l2rpc.Subscribe(ctx, "arbtrace", make(chan json.RawMessage), "subscribe", filterRequest{})
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			Fatal(t, "expected a new subscription once the lagging one ended, got", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resubscribed.Unsubscribe()
+}
+
+func TestArbTraceCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// loops until it runs out of gas, which takes millions of operations to trace
+	spinCode := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	spinner := deployContract(t, ctx, auth, builder.L2.Client, spinCode)
+	tx := builder.L2Info.PrepareTxTo("Owner", &spinner, 30_000_000, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt := EnsureTxFailed(t, ctx, builder.L2.Client, tx)
+
+	execNode := builder.L2.ExecNode
+	api := gethexec.NewArbTraceAPI(
+		execNode.Backend.ArbInterface().BlockChain(),
+		execNode.ChainDB,
+		execNode.Backend.APIBackend(),
+		nil,
+		nil,
+		nil,
+		func() *gethexec.ArbTraceConfig { return &builder.execConfig.ArbTrace },
+		nil,
+	)
+	traceCtx, cancelTrace := context.WithCancel(ctx)
+	time.AfterFunc(100*time.Millisecond, cancelTrace)
+	start := time.Now()
+	blockNum := gethexec.TraceBlockRef{BlockNumberOrHash: rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))}
+	_, err := api.ReplayBlockTransactions(traceCtx, blockNum, []string{"trace", "vmTrace"})
+	if !errors.Is(err, context.Canceled) {
+		Fatal(t, "expected the replay to be cancelled, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		Fatal(t, "cancelled replay took", elapsed)
+	}
+}
+
+func TestArbTraceReorgDuringRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// loops until it runs out of gas, which takes seconds to trace opcode by opcode
+	spinCode := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	spinner := deployContract(t, ctx, auth, builder.L2.Client, spinCode)
+	startMsgCount, err := builder.L2.ConsensusNode.TxStreamer.GetMessageCount()
+	Require(t, err)
+	builder.L2Info.GenerateAccount("User2")
+	transfer := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, transfer))
+	first, err := builder.L2.EnsureTxSucceeded(transfer)
+	Require(t, err)
+	spin := builder.L2Info.PrepareTxTo("Owner", &spinner, 30_000_000, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, spin))
+	last := EnsureTxFailed(t, ctx, builder.L2.Client, spin)
+
+	execNode := builder.L2.ExecNode
+	api := gethexec.NewArbTraceAPI(
+		execNode.Backend.ArbInterface().BlockChain(),
+		execNode.ChainDB,
+		execNode.Backend.APIBackend(),
+		nil,
+		nil,
+		nil,
+		func() *gethexec.ArbTraceConfig { return &builder.execConfig.ArbTrace },
+		nil,
+	)
+	from := gethexec.TraceBlockRef{BlockNumberOrHash: rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(first.BlockNumber.Int64()))}
+	to := gethexec.TraceBlockRef{BlockNumberOrHash: rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(last.BlockNumber.Int64()))}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := api.BlockRange(ctx, from, to, []string{"trace", "vmTrace"}, nil)
+		errs <- err
+	}()
+
+	// drop both blocks while the spinning transaction is being traced
+	time.Sleep(100 * time.Millisecond)
+	Require(t, builder.L2.ConsensusNode.TxStreamer.ReorgTo(startMsgCount))
+	_, err = builder.L2.ExecNode.ExecEngine.HeadMessageNumberSync(t)
+	Require(t, err)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, gethexec.ErrReorgDuringTrace) {
+			Fatal(t, "expected the range to be rejected as reorged, got", err)
+		}
+	case <-time.After(time.Minute):
+		Fatal(t, "timed out waiting for the range to be traced")
+	}
+}
+
+func TestArbTraceCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	blockNum := gethexec.TraceBlockRef{BlockNumberOrHash: rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))}
+
+	newAPI := func(cacheSize int) *gethexec.ArbTraceAPI {
+		config := builder.execConfig.ArbTrace
+		config.TraceCacheSize = cacheSize
+		execNode := builder.L2.ExecNode
+		return gethexec.NewArbTraceAPI(
+			execNode.Backend.ArbInterface().BlockChain(),
+			execNode.ChainDB,
+			execNode.Backend.APIBackend(),
+			nil,
+			nil,
+			nil,
+			func() *gethexec.ArbTraceConfig { return &config },
+			nil,
+		)
+	}
+	// replaying with a cancelled context fails as soon as the EVM would run, so only cached traces succeed
+	cancelled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	for _, cacheSize := range []int{0, 4} {
+		api := newAPI(cacheSize)
+		expected, err := api.ReplayBlockTransactions(ctx, blockNum, []string{"trace", "stateDiff"})
+		Require(t, err)
+		cached, err := api.ReplayBlockTransactions(cancelled, blockNum, []string{"stateDiff", "trace"})
+		if cacheSize == 0 {
+			if !errors.Is(err, context.Canceled) {
+				Fatal(t, "expected an uncached replay to re-run the EVM, got", err)
+			}
+			continue
+		}
+		Require(t, err, "expected the second replay to be served from the cache")
+		expectedJson, err := json.Marshal(expected)
+		Require(t, err)
+		cachedJson, err := json.Marshal(cached)
+		Require(t, err)
+		if !bytes.Equal(expectedJson, cachedJson) {
+			Fatal(t, "cached traces differ\n", string(expectedJson), "\n", string(cachedJson))
+		}
+		if _, err := api.ReplayBlockTransactions(cancelled, blockNum, []string{"trace"}); !errors.Is(err, context.Canceled) {
+			Fatal(t, "traces of other types shouldn't be served from the cache, got", err)
+		}
+	}
+}
+
+func TestArbTraceMaxFrames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// calls the identity precompile until it runs out of gas, producing thousands of sibling frames
+	fanOutCode := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 4,
+		byte(vm.GAS),
+		byte(vm.CALL),
+		byte(vm.POP),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	fanOut := deployContract(t, ctx, auth, builder.L2.Client, fanOutCode)
+	tx := builder.L2Info.PrepareTxTo("Owner", &fanOut, 1_000_000, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt := EnsureTxFailed(t, ctx, builder.L2.Client, tx)
+	blockNum := gethexec.TraceBlockRef{BlockNumberOrHash: rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(receipt.BlockNumber.Int64()))}
+
+	newAPI := func(maxFrames int) *gethexec.ArbTraceAPI {
+		config := builder.execConfig.ArbTrace
+		config.MaxFrames = maxFrames
+		execNode := builder.L2.ExecNode
+		return gethexec.NewArbTraceAPI(
+			execNode.Backend.ArbInterface().BlockChain(),
+			execNode.ChainDB,
+			execNode.Backend.APIBackend(),
+			nil,
+			nil,
+			nil,
+			func() *gethexec.ArbTraceConfig { return &config },
+			nil,
+		)
+	}
+	txHash := hexutil.Bytes(tx.Hash().Bytes())
+
+	capped := newAPI(100)
+	if _, err := capped.Transaction(ctx, txHash, nil); !errors.Is(err, gethexec.ErrTraceFrameLimit) {
+		Fatal(t, "expected arbtrace_transaction to hit the frame limit, got", err)
+	}
+	if _, err := capped.ReplayBlockTransactions(ctx, blockNum, []string{"stateDiff"}); !errors.Is(err, gethexec.ErrTraceFrameLimit) {
+		Fatal(t, "expected arbtrace_replayBlockTransactions to hit the frame limit, got", err)
+	}
+	if _, err := capped.BlockStateDiff(ctx, blockNum); !errors.Is(err, gethexec.ErrTraceFrameLimit) {
+		Fatal(t, "expected arbtrace_blockStateDiff to hit the frame limit, got", err)
+	}
+
+	result, err := newAPI(0).Transaction(ctx, txHash, nil)
+	Require(t, err)
+	resultJson, err := json.Marshal(result)
+	Require(t, err)
+	var frames []traceFrame
+	Require(t, json.Unmarshal(resultJson, &frames))
+	if len(frames) <= 100 {
+		Fatal(t, "expected an unlimited trace to exceed 100 frames, got", len(frames))
+	}
+}
+
+func TestArbTraceReplayAcrossArbOSUpgrade(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true).WithArbOSVersion(11)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	arbDebug, err := precompilesgen.NewArbDebug(common.HexToAddress("0xff"), builder.L2.Client)
+	Require(t, err)
+	tx, err := arbDebug.BecomeChainOwner(&auth)
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	arbOwner, err := precompilesgen.NewArbOwner(types.ArbOwnerAddress, builder.L2.Client)
+	Require(t, err)
+
+	builder.L2Info.GenerateAccount("User2")
+	transfer := func() *types.Receipt {
+		t.Helper()
+		tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		return receipt
+	}
+	before := transfer()
+	tx, err = arbOwner.ScheduleArbOSUpgrade(&auth, 20, 0)
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	after := transfer()
+	transfer()
+
+	arbSys, err := precompilesgen.NewArbSys(types.ArbSysAddress, builder.L2.Client)
+	Require(t, err)
+	version, err := arbSys.ArbOSVersion(&bind.CallOpts{Context: ctx})
+	Require(t, err)
+	if version.Uint64() != 55+20 {
+		Fatal(t, "ArbOS wasn't upgraded, reporting version", version)
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	for number := before.BlockNumber.Uint64(); number <= after.BlockNumber.Uint64()+1; number++ {
+		block, err := builder.L2.Client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		Require(t, err)
+		var results []*traceResult
+		err = l2rpc.CallContext(ctx, &results, "arbtrace_replayBlockTransactions", rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number)), []string{"arbFees"})
+		Require(t, err)
+		if len(results) != len(block.Transactions()) {
+			Fatal(t, "block", number, "replayed", len(results), "of", len(block.Transactions()), "transactions")
+		}
+		for i, tx := range block.Transactions() {
+			receipt, err := builder.L2.Client.TransactionReceipt(ctx, tx.Hash())
+			Require(t, err)
+			if uint64(results[i].ArbitrumFees.GasUsed) != receipt.GasUsed {
+				Fatal(t, "block", number, "transaction", i, "replayed with gas used", results[i].ArbitrumFees.GasUsed, "but the receipt has", receipt.GasUsed)
+			}
+		}
+	}
+}
+
+func TestArbTracePending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	prechecker, ok := builder.L2.ExecNode.TxPublisher.(*gethexec.TxPreChecker)
+	if !ok {
+		Fatal(t, "prechecker not found on node")
+	}
+	sequencer, ok := prechecker.TransactionPublisher.(*gethexec.Sequencer)
+	if !ok {
+		Fatal(t, "sequencer not found on node")
+	}
+
+	// the account is only funded by a transaction held up in the paused sequencer
+	builder.L2Info.GenerateAccount("Pending")
+	pending := builder.L2Info.GetAddress("Pending")
+	fund := builder.L2Info.PrepareTx("Owner", "Pending", builder.L2Info.TransferGas, big.NewInt(1e18), nil)
+	sequencer.Pause()
+	published := make(chan error, 1)
+	go func() {
+		published <- sequencer.PublishTransaction(ctx, fund, nil)
+	}()
+	for len(sequencer.PendingTransactions()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	to := testhelpers.RandomAddress()
+	value := (*hexutil.Big)(big.NewInt(1e17))
+	args := callTxArgs{From: &pending, To: &to, Value: value}
+	var result traceResult
+	pendingBlock := rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber)
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", args, []string{"trace"}, pendingBlock)
+	Require(t, err)
+	if len(result.Trace) == 0 || result.Trace[0].Error != nil {
+		Fatal(t, "the pending funding should cover the transfer", result.Trace)
+	}
+
+	var latestResult traceResult
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	err = l2rpc.CallContext(ctx, &latestResult, "arbtrace_call", args, []string{"trace"}, latest)
+	if err == nil && (len(latestResult.Trace) == 0 || latestResult.Trace[0].Error == nil) {
+		Fatal(t, "the transfer shouldn't be covered before the funding is sequenced")
+	}
+
+	sequencer.Activate()
+	Require(t, <-published)
+	_, err = builder.L2.EnsureTxSucceeded(fund)
+	Require(t, err)
+	if txs := sequencer.PendingTransactions(); len(txs) != 0 {
+		Fatal(t, "sequenced transactions are still pending", txs)
+	}
+}
+
+func TestArbTraceGasCap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	const gasCap = 100_000
+	builder.execConfig.ArbTrace.TraceGasCap = gasCap
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	owner := builder.L2Info.GetAddress("Owner")
+	to := testhelpers.RandomAddress()
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	gas := func(gas uint64) *hexutil.Uint64 { return (*hexutil.Uint64)(&gas) }
+	cases := []struct {
+		gas       *hexutil.Uint64
+		effective uint64
+		capped    bool
+	}{
+		{nil, gasCap, false},
+		{gas(50_000), 50_000, false},
+		{gas(10_000_000), gasCap, true},
+	}
+	for _, test := range cases {
+		var result traceResult
+		err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, To: &to, Gas: test.gas}, []string{"trace"}, latest)
+		Require(t, err)
+		if result.EffectiveGas == nil || uint64(*result.EffectiveGas) != test.effective {
+			Fatal(t, "asking for gas", test.gas, "ran the call with", result.EffectiveGas, "rather than", test.effective)
+		}
+		if result.GasCapped != test.capped {
+			Fatal(t, "asking for gas", test.gas, "reported the gas as capped:", result.GasCapped)
+		}
+		if len(result.Trace) == 0 || result.Trace[0].Error != nil {
+			Fatal(t, "clamped calls should still run", result.Trace)
+		}
+	}
+}
+
+func TestArbTraceOptionalFields(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	owner := builder.L2Info.GetAddress("Owner")
+	to := testhelpers.RandomAddress()
+	args := callTxArgs{From: &owner, To: &to}
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	call := func(traceTypes []string) map[string]json.RawMessage {
+		t.Helper()
+		var result map[string]json.RawMessage
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_call", args, traceTypes, latest))
+		return result
+	}
+
+	// outputs that weren't requested are absent rather than null
+	result := call([]string{"trace"})
+	for _, field := range []string{
+		"stateDiff", "vmTrace", "destroyedContracts", "arbitrumFees", "gasProfile",
+		"retryable", "accessList", "refund", "outbox", "l2Pricing", "summary",
+	} {
+		if raw, ok := result[field]; ok {
+			Fatal(t, "unrequested output", field, "is present as", string(raw))
+		}
+	}
+
+	// requested outputs with nothing to report are empty rather than null or absent
+	result = call([]string{"stateDiff", "vmTrace", "destroyedContracts", "retryable", "outbox"})
+	if _, ok := result["trace"]; ok {
+		Fatal(t, "unrequested frames are present")
+	}
+	for field, expected := range map[string]string{
+		"destroyedContracts": "[]",
+		"retryable":          "[]",
+		"outbox":             "[]",
+		"vmTrace":            `{"code":"0x","ops":[]}`,
+	} {
+		if raw, ok := result[field]; !ok || string(raw) != expected {
+			Fatal(t, "requested output", field, "is", string(raw), "rather than", expected)
+		}
+	}
+	if raw := result["stateDiff"]; len(raw) == 0 || raw[0] != '{' {
+		Fatal(t, "requested state diff isn't an object", string(raw))
+	}
+	if raw := result["output"]; string(raw) != `"0x"` {
+		Fatal(t, "output should always be present, got", string(raw))
+	}
+
+	// frames located in a block carry its hash and number, while those of calls don't
+	var located []map[string]json.RawMessage
+	Require(t, l2rpc.CallContext(ctx, &located, "arbtrace_block", latest))
+	if len(located) == 0 {
+		Fatal(t, "no frames in the latest block")
+	}
+	for _, field := range []string{"blockHash", "blockNumber", "transactionHash", "transactionPosition"} {
+		if _, ok := located[0][field]; !ok {
+			Fatal(t, "located frame is missing", field)
+		}
+	}
+	var callResult map[string]json.RawMessage
+	Require(t, l2rpc.CallContext(ctx, &callResult, "arbtrace_call", args, []string{"trace"}, latest))
+	var callFrames []map[string]json.RawMessage
+	Require(t, json.Unmarshal(callResult["trace"], &callFrames))
+	if len(callFrames) != 1 {
+		Fatal(t, "expected a single frame, got", len(callFrames))
+	}
+	for _, field := range []string{"blockHash", "blockNumber", "transactionHash", "transactionPosition", "error"} {
+		if raw, ok := callFrames[0][field]; ok {
+			Fatal(t, "call frame has", field, string(raw))
+		}
+	}
+	if raw := callFrames[0]["traceAddress"]; string(raw) != "[]" {
+		Fatal(t, "the root frame's trace address should be empty, got", string(raw))
+	}
+}
+
+func TestArbTraceOpcodeStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// stores to a fresh slot and stops
+	code := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	storer := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &storer, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	block := rpc.BlockNumberOrHashWithHash(receipt.BlockHash, false)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var stats struct {
+		BlockHash common.Hash `json:"blockHash"`
+		Opcodes   map[string]struct {
+			Count hexutil.Uint64 `json:"count"`
+			Gas   hexutil.Uint64 `json:"gas"`
+		} `json:"opcodes"`
+	}
+	Require(t, l2rpc.CallContext(ctx, &stats, "arbtrace_opcodeStats", block))
+	if stats.BlockHash != receipt.BlockHash {
+		Fatal(t, "stats are for block", stats.BlockHash, "rather than", receipt.BlockHash)
+	}
+	for op, count := range map[string]uint64{"PUSH1": 2, "SSTORE": 1, "STOP": 1} {
+		if stat := stats.Opcodes[op]; uint64(stat.Count) != count {
+			Fatal(t, op, "executed", stat.Count, "times rather than", count)
+		}
+	}
+	if len(stats.Opcodes) != 3 {
+		Fatal(t, "unexpected opcodes", stats.Opcodes)
+	}
+	if stats.Opcodes["PUSH1"].Gas != 2*hexutil.Uint64(params.VeryLowGas) {
+		Fatal(t, "unexpected gas for PUSH1", stats.Opcodes["PUSH1"].Gas)
+	}
+
+	// the block's only EVM execution is the transaction's, so the opcodes account for all its execution gas
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", tx.Hash()))
+	total := hexutil.Uint64(0)
+	for _, stat := range stats.Opcodes {
+		total += stat.Gas
+	}
+	if frames[0].Result == nil || total != frames[0].Result.GasUsed {
+		Fatal(t, "opcodes used", total, "gas but the transaction's execution used", frames[0].Result)
+	}
+}
+
+func TestArbTraceCallManyStrictDecoding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	owner := builder.L2Info.GetAddress("Owner")
+	valid := fmt.Sprintf(`[{"from": "%v", "to": "%v"}, ["trace"]]`, owner, testhelpers.RandomAddress())
+	l2rpc := builder.L2.Stack.Attach()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	callMany := func(calls ...string) error {
+		var results []*traceResult
+		batch := json.RawMessage("[" + strings.Join(calls, ",") + "]")
+		return l2rpc.CallContext(ctx, &results, "arbtrace_callMany", batch, latest)
+	}
+	Require(t, callMany(valid, valid))
+
+	cases := []struct {
+		call     string
+		expected string
+	}{
+		{fmt.Sprintf(`[{"from": "%v", "too": "%v"}, ["trace"]]`, owner, owner), `unknown field "too"`},
+		{fmt.Sprintf(`[{"from": "%v", "to": "0x1234"}, ["trace"]]`, owner), "invalid call arguments"},
+		{fmt.Sprintf(`[{"from": "%v"}, "trace"]`, owner), "invalid trace types"},
+		{fmt.Sprintf(`[{"from": "%v"}]`, owner), "expected two arguments per call"},
+	}
+	for _, test := range cases {
+		err := callMany(valid, test.call)
+		if err == nil || !strings.Contains(err.Error(), "call 1:") || !strings.Contains(err.Error(), test.expected) {
+			Fatal(t, "expected", test.call, "to be rejected as call 1 with", test.expected, "but got", err)
+		}
+	}
+}
+
+func TestArbTraceHTTPCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func(wrap func(http.Handler) (http.Handler, error)) { node.WrapHTTPHandler = wrap }(node.WrapHTTPHandler)
+	// the hook is read when a node's HTTP server starts, so each node negotiates the compression configured before it's built
+	build := func(compression []string) (*NodeBuilder, func()) {
+		t.Helper()
+		node.WrapHTTPHandler = nil
+		rpcConfig := genericconf.RpcConfig{Compression: compression}
+		Require(t, rpcConfig.Validate())
+		rpcConfig.InitCompression()
+		builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+		builder.l2StackConfig.HTTPHost = "127.0.0.1"
+		builder.l2StackConfig.HTTPModules = []string{"eth", "arbtrace"}
+		return builder, builder.Build(t)
+	}
+	builder, cleanup := build([]string{"gzip", "deflate"})
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	request := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"arbtrace_block","params":["%v"]}`, hexutil.EncodeBig(receipt.BlockNumber))
+	// the transport would otherwise negotiate and undo the compression itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	post := func(builder *NodeBuilder, acceptEncoding string) (string, []byte) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, builder.L2.Stack.HTTPEndpoint(), strings.NewReader(request))
+		Require(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		Require(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		Require(t, err)
+		return resp.Header.Get("Content-Encoding"), body
+	}
+	var expected struct {
+		Result json.RawMessage `json:"result"`
+	}
+	encoding, plain := post(builder, "")
+	if encoding != "" {
+		Fatal(t, "response was encoded with", encoding, "without the client accepting it")
+	}
+	Require(t, json.Unmarshal(plain, &expected))
+	if len(expected.Result) == 0 {
+		Fatal(t, "expected traces, got", string(plain))
+	}
+	decompressors := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+	for _, test := range []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		// the node's preference wins over the client's order
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"*", "gzip"},
+		{"br", ""},
+	} {
+		encoding, body := post(builder, test.acceptEncoding)
+		if encoding != test.encoding {
+			Fatal(t, "expected", test.acceptEncoding, "to be answered with encoding", test.encoding, "got", encoding)
+		}
+		if encoding == "" {
+			continue
+		}
+		reader, err := decompressors[encoding](bytes.NewReader(body))
+		Require(t, err)
+		decompressed, err := io.ReadAll(reader)
+		Require(t, err)
+		if len(body) >= len(decompressed) {
+			Fatal(t, "compressing the traces with", encoding, "saved nothing,", len(body), "bytes against", len(decompressed))
+		}
+		var actual struct {
+			Result json.RawMessage `json:"result"`
+		}
+		Require(t, json.Unmarshal(decompressed, &actual))
+		if !bytes.Equal(expected.Result, actual.Result) {
+			Fatal(t, encoding, "compressed traces differ\n", string(expected.Result), "\n", string(actual.Result))
+		}
+	}
+
+	// compression is opt-in, so a node configured without it keeps geth's own gzip, and never deflates
+	unconfigured, unconfiguredCleanup := build(nil)
+	defer unconfiguredCleanup()
+	if node.WrapHTTPHandler != nil {
+		Fatal(t, "expected no handler to be added without compression configured")
+	}
+	if encoding, _ := post(unconfigured, "gzip, deflate"); encoding != "gzip" {
+		Fatal(t, "expected a node without compression configured to gzip as geth does, got encoding", encoding)
+	}
+	if encoding, _ := post(unconfigured, "deflate"); encoding != "" {
+		Fatal(t, "expected a node without compression configured not to deflate, got encoding", encoding)
+	}
+}
+
+func TestArbTraceReplayAllOutputs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// stores 0x2a in slot 0
+	code := []byte{byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "stateDiff", "vmTrace"})
+	Require(t, err)
+	if len(result.Trace) != 1 || result.StateDiff == nil || result.VmTrace == nil {
+		Fatal(t, "expected all three outputs, got", result)
+	}
+
+	// the outputs describe the same execution
+	if !bytes.Equal(result.VmTrace.Code, code) {
+		Fatal(t, "vmTrace traced code", result.VmTrace.Code, "rather than the contract's")
+	}
+	if to := result.Trace[0].Action.To; to == nil || *to != contract {
+		Fatal(t, "trace called", to, "rather than the contract")
+	}
+	var store *vmStoreDiff
+	for _, op := range result.VmTrace.Ops {
+		if op.Ex != nil && op.Ex.Store != nil {
+			store = op.Ex.Store
+		}
+	}
+	if store == nil {
+		Fatal(t, "vmTrace recorded no store")
+	}
+	contractDiff := result.StateDiff[contract]
+	if contractDiff == nil {
+		Fatal(t, "the contract is missing from the state diff")
+	}
+	slotDiff := contractDiff.Storage[common.BigToHash(store.Key.ToInt())]
+	if slotDiff == nil {
+		Fatal(t, "the stored slot is missing from the state diff")
+	}
+	var changed map[string]json.RawMessage
+	Require(t, json.Unmarshal(slotDiff, &changed))
+	var stored common.Hash
+	if born, ok := changed["+"]; ok {
+		Require(t, json.Unmarshal(born, &stored))
+	} else {
+		var change struct {
+			To common.Hash `json:"to"`
+		}
+		Require(t, json.Unmarshal(changed["*"], &change))
+		stored = change.To
+	}
+	if stored != common.BigToHash(store.Val.ToInt()) {
+		Fatal(t, "the state diff stored", stored, "but vmTrace stored", store.Val)
+	}
+
+	// each output is the same as when requested alone
+	var all map[string]json.RawMessage
+	err = l2rpc.CallContext(ctx, &all, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "stateDiff", "vmTrace"})
+	Require(t, err)
+	for _, traceType := range []string{"trace", "stateDiff", "vmTrace"} {
+		var alone map[string]json.RawMessage
+		err = l2rpc.CallContext(ctx, &alone, "arbtrace_replayTransaction", tx.Hash(), []string{traceType})
+		Require(t, err)
+		var expected, actual interface{}
+		Require(t, json.Unmarshal(alone[traceType], &expected))
+		Require(t, json.Unmarshal(all[traceType], &actual))
+		if fmt.Sprint(expected) != fmt.Sprint(actual) {
+			Fatal(t, traceType, "differs when requested alone\n", string(alone[traceType]), "\n", string(all[traceType]))
+		}
+	}
+}
+
+func TestArbTraceL2Pricing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	// move the chain past the transaction's block, so it's traced historically
+	builder.L2.TransferBalance(t, "Owner", "User2", big.NewInt(1), builder.L2Info)
+
+	arbGasInfo, err := precompilesgen.NewArbGasInfo(types.ArbGasInfoAddress, builder.L2.Client)
+	Require(t, err)
+	checkPricing := func(pricing *l2Pricing, blockNumber *big.Int) {
+		t.Helper()
+		if pricing == nil {
+			Fatal(t, "l2Pricing missing at block", blockNumber)
+		}
+		header, err := builder.L2.Client.HeaderByNumber(ctx, blockNumber)
+		Require(t, err)
+		if pricing.BaseFee.ToInt().Cmp(header.BaseFee) != 0 {
+			Fatal(t, "base fee", pricing.BaseFee, "isn't the block's", header.BaseFee)
+		}
+		opts := &bind.CallOpts{Context: ctx, BlockNumber: header.Number}
+		minBaseFee, err := arbGasInfo.GetMinimumGasPrice(opts)
+		Require(t, err)
+		inertia, err := arbGasInfo.GetPricingInertia(opts)
+		Require(t, err)
+		tolerance, err := arbGasInfo.GetGasBacklogTolerance(opts)
+		Require(t, err)
+		if pricing.MinBaseFee.ToInt().Cmp(minBaseFee) != 0 || uint64(pricing.PricingInertia) != inertia || uint64(pricing.BacklogTolerance) != tolerance {
+			Fatal(t, "unexpected pricing parameters", pricing, "rather than", minBaseFee, inertia, tolerance)
+		}
+		if pricing.SpeedLimitPerSecond == 0 {
+			Fatal(t, "speed limit missing")
+		}
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	var replayed traceResult
+	err = l2rpc.CallContext(ctx, &replayed, "arbtrace_replayTransaction", tx.Hash(), []string{"l2Pricing"})
+	Require(t, err)
+	checkPricing(replayed.L2Pricing, receipt.BlockNumber)
+
+	latest, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+	owner := builder.L2Info.GetAddress("Owner")
+	var called traceResult
+	latestRef := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(latest))
+	err = l2rpc.CallContext(ctx, &called, "arbtrace_call", callTxArgs{From: &owner, To: &owner}, []string{"l2Pricing"}, latestRef)
+	Require(t, err)
+	checkPricing(called.L2Pricing, new(big.Int).SetUint64(latest))
+}
+
+func TestArbTraceSummary(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// calls a contract that calls another that calls a leaf twice, then calls a reverting contract
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	leaf := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, revertCode(t, "no thanks"))
+	middle := deployContract(t, ctx, auth, builder.L2.Client, callsWithDataCode(leaf, nil, nil))
+	top := deployContract(t, ctx, auth, builder.L2.Client, callsWithDataCode(middle, nil))
+	code := callsWithDataCode(top, nil)
+	code = append(code[:len(code)-1], callerCode(reverter)...)
+	caller := deployContract(t, ctx, auth, builder.L2.Client, code)
+	value := big.NewInt(1e9)
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, value, nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var summary traceSummary
+	Require(t, l2rpc.CallContext(ctx, &summary, "arbtrace_transaction", tx.Hash(), []string{"summary"}))
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", tx.Hash()))
+	if uint64(summary.Frames) != uint64(len(frames)) || summary.Frames != 6 {
+		Fatal(t, "summary counts", summary.Frames, "frames, but the trace has", len(frames))
+	}
+	if summary.MaxDepth != 3 {
+		Fatal(t, "unexpected max depth", summary.MaxDepth)
+	}
+	// the sender and the five contracts
+	if summary.AddressesTouched != 6 {
+		Fatal(t, "unexpected number of addresses touched", summary.AddressesTouched)
+	}
+	if summary.ValueMoved == nil || summary.ValueMoved.ToInt().Cmp(value) != 0 {
+		Fatal(t, "unexpected value moved", summary.ValueMoved)
+	}
+	if uint64(summary.GasUsed) != receipt.GasUsed {
+		Fatal(t, "summary reports", summary.GasUsed, "gas used, but the receipt", receipt.GasUsed)
+	}
+	if !summary.Reverted {
+		Fatal(t, "expected the reverting subcall to be reported")
+	}
+
+	var replayed traceResult
+	Require(t, l2rpc.CallContext(ctx, &replayed, "arbtrace_replayTransaction", tx.Hash(), []string{"summary"}))
+	if replayed.Summary == nil || replayed.Summary.Frames != summary.Frames || replayed.Summary.GasUsed != summary.GasUsed {
+		Fatal(t, "replayed summary differs", replayed.Summary)
+	}
+
+	err = l2rpc.CallContext(ctx, &summary, "arbtrace_transaction", tx.Hash(), []string{"stateDiff"})
+	if err == nil || !strings.Contains(err.Error(), "only supports") {
+		Fatal(t, "expected arbtrace_transaction to reject other trace types, got", err)
+	}
+}
+
+func TestArbTraceVmTraceTruncation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.VmTraceMaxSteps = 100
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// counts down from 50 before storing to slot 0, which takes a few hundred operations
+	code := []byte{
+		byte(vm.PUSH1), 50,
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 1,
+		byte(vm.SWAP1),
+		byte(vm.SUB),
+		byte(vm.DUP1),
+		byte(vm.PUSH1), 2,
+		byte(vm.JUMPI),
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 0,
+		byte(vm.SSTORE),
+		byte(vm.STOP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	looper := deployContract(t, ctx, auth, builder.L2.Client, code)
+	stopper := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	replay := func(to common.Address) traceResult {
+		t.Helper()
+		tx := builder.L2Info.PrepareTxTo("Owner", &to, 1e6, big.NewInt(0), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		_, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		var result traceResult
+		err = builder.L2.Stack.Attach().CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "vmTrace", "stateDiff"})
+		Require(t, err)
+		return result
+	}
+
+	result := replay(looper)
+	if result.VmTrace == nil || !result.VmTrace.Truncated || len(result.VmTrace.Ops) != 100 {
+		Fatal(t, "expected the vmTrace to be truncated at 100 operations", result.VmTrace)
+	}
+	// the other outputs are traced in full
+	if len(result.Trace) != 1 || result.Trace[0].Error != nil {
+		Fatal(t, "unexpected frames", result.Trace)
+	}
+	diff, ok := result.StateDiff[looper]
+	if !ok || len(diff.Storage) != 1 {
+		Fatal(t, "expected the store past the limit to be in the state diff", result.StateDiff)
+	}
+
+	result = replay(stopper)
+	if result.VmTrace == nil || result.VmTrace.Truncated || len(result.VmTrace.Ops) != 1 {
+		Fatal(t, "expected a short vmTrace to be complete", result.VmTrace)
+	}
+}
+
+type feeAccounts struct {
+	BlockHash         common.Hash    `json:"blockHash"`
+	BlockNumber       hexutil.Uint64 `json:"blockNumber"`
+	NetworkFeeAccount common.Address `json:"networkFeeAccount"`
+	InfraFeeAccount   common.Address `json:"infraFeeAccount"`
+	InfraFeeEnabled   bool           `json:"infraFeeEnabled"`
+}
+
+func TestArbTraceFeeAccounts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	callOpts := builder.L2Info.GetDefaultCallOpts("Owner", ctx)
+	arbOwnerPublic, err := precompilesgen.NewArbOwnerPublic(types.ArbOwnerPublicAddress, builder.L2.Client)
+	Require(t, err)
+	networkFeeAccount, err := arbOwnerPublic.GetNetworkFeeAccount(callOpts)
+	Require(t, err)
+	infraFeeAccount, err := arbOwnerPublic.GetInfraFeeAccount(callOpts)
+	Require(t, err)
+	before, err := builder.L2.Client.BlockNumber(ctx)
+	Require(t, err)
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	arbOwner, err := precompilesgen.NewArbOwner(types.ArbOwnerAddress, builder.L2.Client)
+	Require(t, err)
+	newInfraFeeAccount := testhelpers.RandomAddress()
+	tx, err := arbOwner.SetInfraFeeAccount(&auth, newInfraFeeAccount)
+	Require(t, err)
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	accountsAt := func(number uint64) feeAccounts {
+		t.Helper()
+		var accounts feeAccounts
+		Require(t, l2rpc.CallContext(ctx, &accounts, "arbtrace_feeAccounts", rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number))))
+		if uint64(accounts.BlockNumber) != number {
+			Fatal(t, "fee accounts of block", accounts.BlockNumber, "returned for block", number)
+		}
+		return accounts
+	}
+	old := accountsAt(before)
+	if old.NetworkFeeAccount != networkFeeAccount || old.InfraFeeAccount != infraFeeAccount {
+		Fatal(t, "unexpected fee accounts", old, "expected", networkFeeAccount, infraFeeAccount)
+	}
+	if old.InfraFeeEnabled != (infraFeeAccount != common.Address{}) {
+		Fatal(t, "infrastructure fee reported as enabled:", old.InfraFeeEnabled, "for account", infraFeeAccount)
+	}
+	// the accounts are read as of the block asked for
+	updated := accountsAt(receipt.BlockNumber.Uint64())
+	if updated.NetworkFeeAccount != networkFeeAccount || updated.InfraFeeAccount != newInfraFeeAccount || !updated.InfraFeeEnabled {
+		Fatal(t, "unexpected fee accounts after the change", updated)
+	}
+}
+
+func TestArbTraceResultSchema(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// stores 1 in slot 0, so the state diff has storage to report
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP),
+	})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(1), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	allTraceTypes := []string{
+		"trace", "stateDiff", "vmTrace", "destroyedContracts", "arbFees", "gasProfile",
+		"retryable", "accessList", "refund", "outbox", "l2Pricing", "summary",
+	}
+	samples := make(map[string]json.RawMessage)
+	for _, traceTypes := range [][]string{allTraceTypes, {"trace"}, {}} {
+		var replayed json.RawMessage
+		Require(t, l2rpc.CallContext(ctx, &replayed, "arbtrace_replayTransaction", tx.Hash(), traceTypes))
+		samples[fmt.Sprint("replayTransaction ", traceTypes)] = replayed
+	}
+	txArgs := callTxArgs{From: &auth.From, To: &caller}
+	var called json.RawMessage
+	Require(t, l2rpc.CallContext(ctx, &called, "arbtrace_call", txArgs, allTraceTypes, "latest"))
+	samples["call"] = called
+	for name, sample := range samples {
+		if err := gethexec.ValidateTraceResult(sample); err != nil {
+			Fatal(t, "result of", name, "doesn't match its schema:", err, "\n", string(sample))
+		}
+	}
+
+	// renaming, adding, or retyping a field breaks the schema
+	var result map[string]interface{}
+	Require(t, json.Unmarshal(samples["call"], &result))
+	encode := func(value map[string]interface{}) []byte {
+		t.Helper()
+		encoded, err := json.Marshal(value)
+		Require(t, err)
+		return encoded
+	}
+	renamed := make(map[string]interface{}, len(result))
+	for name, value := range result {
+		renamed[name] = value
+	}
+	renamed["traces"] = renamed["trace"]
+	delete(renamed, "trace")
+	if err := gethexec.ValidateTraceResult(encode(renamed)); err == nil || !strings.Contains(err.Error(), "traces") {
+		Fatal(t, "expected a renamed field to be rejected, got", err)
+	}
+	frames, _ := result["trace"].([]interface{})
+	if len(frames) == 0 {
+		Fatal(t, "expected frames in the sample")
+	}
+	frame, _ := frames[0].(map[string]interface{})
+	frame["subtraces"] = "1"
+	if err := gethexec.ValidateTraceResult(encode(result)); err == nil || !strings.Contains(err.Error(), "subtraces") {
+		Fatal(t, "expected a retyped field to be rejected, got", err)
+	}
+	delete(frame, "subtraces")
+	if err := gethexec.ValidateTraceResult(encode(result)); err == nil || !strings.Contains(err.Error(), "subtraces") {
+		Fatal(t, "expected a missing field to be rejected, got", err)
+	}
+
+	for _, schema := range []json.RawMessage{gethexec.TraceResultSchema(), gethexec.TraceFrameSchema()} {
+		var decoded map[string]interface{}
+		Require(t, json.Unmarshal(schema, &decoded))
+		if decoded["$schema"] == nil || decoded["$defs"] == nil {
+			Fatal(t, "unexpected schema", string(schema))
+		}
+	}
+}
+
+func TestArbTraceDelegatecallStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// the implementation stores 42 in slot 5 of whichever account it executes against
+	implementation := deployContract(t, ctx, auth, builder.L2.Client, []byte{
+		byte(vm.PUSH1), 42, byte(vm.PUSH1), 5, byte(vm.SSTORE), byte(vm.STOP),
+	})
+	proxyCode := []byte{
+		byte(vm.PUSH1), 0, // retSize
+		byte(vm.PUSH1), 0, // retOffset
+		byte(vm.PUSH1), 0, // argsSize
+		byte(vm.PUSH1), 0, // argsOffset
+		byte(vm.PUSH20),
+	}
+	proxyCode = append(proxyCode, implementation.Bytes()...)
+	proxyCode = append(proxyCode, byte(vm.GAS), byte(vm.DELEGATECALL), byte(vm.POP), byte(vm.STOP))
+	proxy := deployContract(t, ctx, auth, builder.L2.Client, proxyCode)
+	tx := builder.L2Info.PrepareTxTo("Owner", &proxy, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "stateDiff", "accessList"}))
+	slot := common.BigToHash(big.NewInt(5))
+
+	// the write lands on the proxy, whose storage the implementation's code ran against
+	proxyDiff, ok := result.StateDiff[proxy]
+	if !ok || proxyDiff.Storage[slot] == nil {
+		Fatal(t, "expected the proxy's slot to be in the state diff", result.StateDiff)
+	}
+	if implementationDiff, ok := result.StateDiff[implementation]; ok && len(implementationDiff.Storage) > 0 {
+		Fatal(t, "storage attributed to the implementation", implementationDiff.Storage)
+	}
+	if result.AccessList == nil {
+		Fatal(t, "access list missing")
+	}
+	for _, tuple := range *result.AccessList {
+		if tuple.Address == implementation && len(tuple.StorageKeys) > 0 {
+			Fatal(t, "access list attributes slots to the implementation", tuple.StorageKeys)
+		}
+	}
+
+	// the delegatecall frame names the proxy as the account whose storage it used
+	if len(result.Trace) != 2 || result.Trace[1].Action.CallType != "delegatecall" {
+		Fatal(t, "unexpected frames", result.Trace)
+	}
+	delegated := result.Trace[1]
+	if delegated.StorageAddress == nil || *delegated.StorageAddress != proxy {
+		Fatal(t, "delegatecall frame should name the proxy's storage", delegated.StorageAddress)
+	}
+	if result.Trace[0].StorageAddress != nil {
+		Fatal(t, "only delegated frames should name their storage", result.Trace[0].StorageAddress)
+	}
+	var traceOnly traceResult
+	Require(t, l2rpc.CallContext(ctx, &traceOnly, "arbtrace_replayTransaction", tx.Hash(), []string{"trace"}))
+	if traceOnly.Trace[1].StorageAddress != nil {
+		Fatal(t, "storage is only named when it's reported", traceOnly.Trace[1].StorageAddress)
+	}
+}
+
+func TestArbTraceTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.TraceTimeout = 20 * time.Millisecond
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// loops until it runs out of gas, which takes millions of operations at the trace gas cap
+	code := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	looper := deployContract(t, ctx, auth, builder.L2.Client, code)
+
+	gas := hexutil.Uint64(builder.execConfig.ArbTrace.TraceGasCap)
+	txArgs := callTxArgs{From: &auth.From, To: &looper, Gas: &gas}
+	var result traceResult
+	start := time.Now()
+	err := builder.L2.Stack.Attach().CallContext(ctx, &result, "arbtrace_call", txArgs, []string{"trace"}, "latest")
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrTraceTimeout.Error()) {
+		Fatal(t, "expected the trace to time out, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		Fatal(t, "timed out trace took", elapsed)
+	}
+}
+
+func TestArbTraceLogs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	logCode := func(topic byte) []byte {
+		return []byte{byte(vm.PUSH1), topic, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.LOG1)}
+	}
+	call := func(callee common.Address) []byte {
+		code := callerCode(callee)
+		// drop the trailing STOP
+		return code[:len(code)-1]
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, append(logCode(2), byte(vm.STOP)))
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, append(logCode(4), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.REVERT)))
+	// logs, calls the callee, logs again, then calls the reverter, whose log is discarded
+	var code []byte
+	code = append(code, logCode(1)...)
+	code = append(code, call(callee)...)
+	code = append(code, logCode(3)...)
+	code = append(code, call(reverter)...)
+	code = append(code, byte(vm.STOP))
+	caller := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	if len(receipt.Logs) != 3 {
+		Fatal(t, "unexpected logs in the receipt", receipt.Logs)
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "logs"}))
+	if len(result.Trace) != 3 {
+		Fatal(t, "unexpected frames", result.Trace)
+	}
+	topicsOf := func(frame traceFrame) []byte {
+		t.Helper()
+		if frame.Logs == nil {
+			Fatal(t, "frame is missing its logs", frame)
+		}
+		var topics []byte
+		for _, log := range *frame.Logs {
+			receiptLog := receipt.Logs[log.Index]
+			if log.Address != receiptLog.Address || len(log.Topics) != 1 || log.Topics[0] != receiptLog.Topics[0] {
+				Fatal(t, "log", log.Index, "differs from the receipt's", log, receiptLog)
+			}
+			topics = append(topics, log.Topics[0][31])
+		}
+		return topics
+	}
+	if topics := topicsOf(result.Trace[0]); !bytes.Equal(topics, []byte{1, 3}) || (*result.Trace[0].Logs)[1].Index != 2 {
+		Fatal(t, "unexpected logs of the caller", result.Trace[0].Logs)
+	}
+	if topics := topicsOf(result.Trace[1]); !bytes.Equal(topics, []byte{2}) || (*result.Trace[1].Logs)[0].Index != 1 {
+		Fatal(t, "unexpected logs of the callee", result.Trace[1].Logs)
+	}
+	if result.Trace[2].Error == nil || len(topicsOf(result.Trace[2])) != 0 {
+		Fatal(t, "the reverted frame's logs should be discarded", result.Trace[2])
+	}
+
+	// logs emitted by precompiles are decoded
+	arbSys, err := precompilesgen.NewArbSys(types.ArbSysAddress, builder.L2.Client)
+	Require(t, err)
+	auth.Value = big.NewInt(1e9)
+	destination := testhelpers.RandomAddress()
+	withdrawal, err := arbSys.WithdrawEth(&auth, destination)
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(withdrawal)
+	Require(t, err)
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", withdrawal.Hash(), []string{"trace", "logs"}))
+	if len(result.Trace) == 0 || result.Trace[0].Logs == nil || len(*result.Trace[0].Logs) == 0 {
+		Fatal(t, "expected the withdrawal to log", result.Trace)
+	}
+	event := (*result.Trace[0].Logs)[0].Event
+	if event == nil || event.Name != "ArbSys" || !strings.HasPrefix(event.Event, "L2ToL1Tx(") {
+		Fatal(t, "unexpected decoding of the withdrawal's log", event)
+	}
+	if event.Args["destination"] != strings.ToLower(destination.Hex()) && event.Args["destination"] != destination.Hex() {
+		Fatal(t, "unexpected destination", event.Args)
+	}
+
+	// logs are off by default, and annotate frames so need them
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace"}))
+	if result.Trace[0].Logs != nil {
+		Fatal(t, "logs weren't requested", result.Trace[0].Logs)
+	}
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"logs"})
+	if err == nil {
+		Fatal(t, "expected logs without the trace to be rejected")
+	}
+}
+
+func TestArbTraceRevertedTransaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	storeCode := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE)}
+	callee := deployContract(t, ctx, auth, builder.L2.Client, append(storeCode, byte(vm.STOP)))
+	// stores, calls the callee, which also stores and succeeds, then reverts everything
+	code := append([]byte{}, storeCode...)
+	call := callerCode(callee)
+	code = append(code, call[:len(call)-1]...)
+	code = append(code, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.REVERT))
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &reverter, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	EnsureTxFailed(t, ctx, builder.L2.Client, tx)
+	assertTraceMatchesReceipt(t, ctx, builder.L2, tx.Hash())
+
+	// the trace shows everything that executed, up to the revert
+	l2rpc := builder.L2.Stack.Attach()
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", tx.Hash()))
+	if len(frames) != 2 {
+		Fatal(t, "expected the reverted transaction's frames", frames)
+	}
+	if frames[0].Error == nil || *frames[0].Error != "Reverted" || frames[0].Subtraces != 1 {
+		Fatal(t, "unexpected reverting frame", frames[0])
+	}
+	if frames[1].Action.To == nil || *frames[1].Action.To != callee || frames[1].Error != nil || frames[1].Result == nil {
+		Fatal(t, "expected the callee's frame to show it succeeded before the revert", frames[1])
+	}
+
+	// while its storage writes are rolled back, leaving only what the sender paid
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "stateDiff"}))
+	if len(result.Trace) != len(frames) {
+		Fatal(t, "replayed frames differ", result.Trace)
+	}
+	for _, addr := range []common.Address{reverter, callee} {
+		if diff, ok := result.StateDiff[addr]; ok && len(diff.Storage) > 0 {
+			Fatal(t, "reverted storage write of", addr, "in the state diff", diff.Storage)
+		}
+	}
+	sender, ok := result.StateDiff[auth.From]
+	if !ok || string(sender.Nonce) == `"="` {
+		Fatal(t, "expected the sender's nonce to change", result.StateDiff)
+	}
+}
+
+func TestArbTraceCompatNamespace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.EnableTraceCompat = true
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	blockNum := hexutil.Uint64(receipt.BlockNumber.Uint64())
+	owner := builder.L2Info.GetAddress("Owner")
+	call := callTxArgs{From: &owner, To: &caller}
+	fromBlock := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(blockNum))
+	filter := filterRequest{FromBlock: &fromBlock, ToBlock: &fromBlock}
+	traceTypes := []string{"trace", "stateDiff"}
+	shared := []struct {
+		method string
+		args   []interface{}
+	}{
+		{"call", []interface{}{call, traceTypes, blockNum}},
+		{"callMany", []interface{}{[]interface{}{[]interface{}{call, traceTypes}}, blockNum}},
+		{"replayBlockTransactions", []interface{}{blockNum, traceTypes}},
+		{"replayTransaction", []interface{}{tx.Hash(), traceTypes}},
+		{"transaction", []interface{}{tx.Hash()}},
+		{"get", []interface{}{tx.Hash(), []hexutil.Uint64{0}}},
+		{"block", []interface{}{blockNum}},
+		{"filter", []interface{}{filter}},
+	}
+	for _, test := range shared {
+		var arbtrace, trace json.RawMessage
+		Require(t, l2rpc.CallContext(ctx, &arbtrace, "arbtrace_"+test.method, test.args...))
+		Require(t, l2rpc.CallContext(ctx, &trace, "trace_"+test.method, test.args...))
+		if string(arbtrace) != string(trace) {
+			Fatal(t, "trace_"+test.method, "differs from arbtrace_"+test.method, string(trace), string(arbtrace))
+		}
+		if string(trace) == "null" || string(trace) == "[]" {
+			Fatal(t, "trace_"+test.method, "traced nothing")
+		}
+	}
+
+	// Arbitrum's trace types and extensions are only offered by arbtrace
+	var result json.RawMessage
+	err = l2rpc.CallContext(ctx, &result, "trace_replayTransaction", tx.Hash(), []string{"arbFees"})
+	if err == nil || !strings.Contains(err.Error(), "only offered by arbtrace") {
+		Fatal(t, "expected trace_replayTransaction to reject Arbitrum's trace types", err)
+	}
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"arbFees"}))
+	err = l2rpc.CallContext(ctx, &result, "trace_feeAccounts", blockNum)
+	if err == nil {
+		Fatal(t, "expected arbtrace_feeAccounts not to be offered under trace")
+	}
+}
+
+func TestArbTraceAllowlist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	allowedTx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, allowedTx))
+	receipt, err := builder.L2.EnsureTxSucceeded(allowedTx)
+	Require(t, err)
+	builder.L2Info.GenerateAccount("User2")
+	deniedTx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, deniedTx))
+	_, err = builder.L2.EnsureTxSucceeded(deniedTx)
+	Require(t, err)
+
+	// the allowlist is reloadable, taking effect once the config is validated
+	config := &builder.execConfig.ArbTrace
+	config.TraceAllowlist = []string{caller.Hex()}
+	Require(t, config.Validate())
+
+	l2rpc := builder.L2.Stack.Attach()
+	owner := builder.L2Info.GetAddress("Owner")
+	user2 := builder.L2Info.GetAddress("User2")
+	blockNum := hexutil.Uint64(receipt.BlockNumber.Uint64())
+	traceTypes := []string{"trace"}
+	var result json.RawMessage
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", allowedTx.Hash(), traceTypes))
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_transaction", allowedTx.Hash()))
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &owner, To: &caller}, traceTypes, blockNum))
+
+	expectDenied := func(method string, args ...interface{}) {
+		t.Helper()
+		err := l2rpc.CallContext(ctx, &result, method, args...)
+		if err == nil || !strings.Contains(err.Error(), gethexec.ErrTraceNotAllowed.Error()) {
+			Fatal(t, "expected", method, "to be refused by the allowlist", err)
+		}
+	}
+	expectDenied("arbtrace_replayTransaction", deniedTx.Hash(), traceTypes)
+	expectDenied("arbtrace_get", deniedTx.Hash(), []hexutil.Uint64{})
+	expectDenied("arbtrace_call", callTxArgs{From: &owner, To: &user2}, traceTypes, blockNum)
+	expectDenied("arbtrace_call", callTxArgs{From: &owner, Data: &hexutil.Bytes{byte(vm.STOP)}}, traceTypes, blockNum)
+	expectDenied("arbtrace_block", blockNum)
+	expectDenied("arbtrace_replayBlockTransactions", blockNum, traceTypes)
+
+	config.TraceAllowlist = nil
+	Require(t, config.Validate())
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", deniedTx.Hash(), traceTypes))
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_block", blockNum))
+
+	config.TraceAllowlist = []string{"not an address"}
+	if err := config.Validate(); err == nil {
+		Fatal(t, "expected an invalid allowlist entry to be rejected")
+	}
+	config.TraceAllowlist = nil
+	Require(t, config.Validate())
+}
+
+func TestArbTraceFeeInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	user2 := builder.L2Info.GetAddress("User2")
+	header, err := builder.L2.Client.HeaderByNumber(ctx, nil)
+	Require(t, err)
+	tip := big.NewInt(params.GWei)
+	tx := builder.L2Info.SignTxAs("Owner", &types.DynamicFeeTx{
+		To:        &user2,
+		Gas:       builder.L2Info.TransferGas,
+		GasTipCap: tip,
+		GasFeeCap: new(big.Int).Add(new(big.Int).Mul(header.BaseFee, big.NewInt(2)), tip),
+		Value:     big.NewInt(1e12),
+		Nonce:     atomic.AddUint64(&builder.L2Info.GetInfoWithPrivKey("Owner").Nonce, 1) - 1,
+	})
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	assertTraceMatchesReceipt(t, ctx, builder.L2, tx.Hash())
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"feeInfo"}))
+	fees := result.FeeInfo
+	if fees == nil {
+		Fatal(t, "feeInfo missing")
+	}
+	if fees.EffectiveGasPrice.ToInt().Cmp(receipt.EffectiveGasPrice) != 0 {
+		Fatal(t, "effective gas price", fees.EffectiveGasPrice, "isn't the receipt's", receipt.EffectiveGasPrice)
+	}
+	if uint64(fees.GasUsed) != receipt.GasUsed || uint64(fees.GasUsedForL1) != receipt.GasUsedForL1 {
+		Fatal(t, "gas used", fees.GasUsed, fees.GasUsedForL1, "isn't the receipt's", receipt.GasUsed, receipt.GasUsedForL1)
+	}
+	if fees.PriorityFeePerGas.ToInt().Cmp(tip) != 0 {
+		Fatal(t, "offered tip", fees.PriorityFeePerGas, "isn't the transaction's", tip)
+	}
+	// tips are dropped on every ArbOS version the tests run
+	if !fees.TipDropped || fees.EffectivePriorityFee.ToInt().Sign() != 0 || fees.EffectiveGasPrice.ToInt().Cmp(fees.BaseFee.ToInt()) != 0 {
+		Fatal(t, "expected the tip to be dropped", fees)
+	}
+	totalFee := new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+	if fees.TotalFee.ToInt().Cmp(totalFee) != 0 {
+		Fatal(t, "total fee", fees.TotalFee, "isn't", totalFee)
+	}
+	l1Fee := new(big.Int).Mul(fees.BaseFee.ToInt(), new(big.Int).SetUint64(receipt.GasUsedForL1))
+	if fees.L1Fee.ToInt().Cmp(l1Fee) != 0 {
+		Fatal(t, "l1 fee", fees.L1Fee, "isn't", l1Fee)
+	}
+}
+
+func TestArbTraceAccessStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// reads its own slot 7, which has the same key as the caller's
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{
+		byte(vm.PUSH1), 7, byte(vm.SLOAD), byte(vm.POP), byte(vm.STOP),
+	})
+	// reads slot 7, writes slot 8 and reads it back, then calls the callee
+	code := []byte{
+		byte(vm.PUSH1), 7, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 8, byte(vm.SSTORE),
+		byte(vm.PUSH1), 8, byte(vm.SLOAD), byte(vm.POP),
+	}
+	code = append(code, callerCode(callee)...)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"accessStats"}))
+	stats := result.AccessStats
+	if stats == nil {
+		Fatal(t, "accessStats missing")
+	}
+	// the sender, the contract, and the callee, with the callee's slot 7 distinct from the contract's
+	if stats.Accounts != 3 || stats.SlotsRead != 3 || stats.SlotsWritten != 1 {
+		Fatal(t, "unexpected totals", stats.Accounts, stats.SlotsRead, stats.SlotsWritten)
+	}
+	if len(stats.Frames) != 2 {
+		Fatal(t, "expected a frame for the call and its subcall, got", len(stats.Frames))
+	}
+	top, sub := stats.Frames[0], stats.Frames[1]
+	if len(top.TraceAddress) != 0 || top.Accounts != 3 || top.SlotsRead != 2 || top.SlotsWritten != 1 {
+		Fatal(t, "unexpected top-level frame stats", top)
+	}
+	if len(sub.TraceAddress) != 1 || sub.TraceAddress[0] != 0 || sub.Accounts != 2 || sub.SlotsRead != 1 || sub.SlotsWritten != 0 {
+		Fatal(t, "unexpected subcall stats", sub)
+	}
+}
+
+type txOutcome struct {
+	Success      bool            `json:"success"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	Output       hexutil.Bytes   `json:"output"`
+	Address      *common.Address `json:"address"`
+	Error        *string         `json:"error"`
+	RevertReason *string         `json:"revertReason"`
+	ErrorReason  *string         `json:"errorReason"`
+}
+
+func TestArbTraceResult(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, revertCode(t, "not today"))
+	// calls the reverter, ignoring its failure, and returns a word
+	code := callerCode(reverter)
+	code = append(code[:len(code)-1], byte(vm.PUSH1), 42, byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN))
+	caller := deployContract(t, ctx, auth, builder.L2.Client, code)
+
+	l2rpc := builder.L2.Stack.Attach()
+	tx := builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	var outcome txOutcome
+	Require(t, l2rpc.CallContext(ctx, &outcome, "arbtrace_result", tx.Hash()))
+	if !outcome.Success || outcome.Error != nil || uint64(outcome.GasUsed) != receipt.GasUsed {
+		Fatal(t, "unexpected outcome of a successful transaction", outcome, "with receipt gas", receipt.GasUsed)
+	}
+	if new(big.Int).SetBytes(outcome.Output).Uint64() != 42 {
+		Fatal(t, "unexpected output", outcome.Output)
+	}
+
+	tx = builder.L2Info.PrepareTxTo("Owner", &reverter, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt = EnsureTxFailed(t, ctx, builder.L2.Client, tx)
+	Require(t, l2rpc.CallContext(ctx, &outcome, "arbtrace_result", tx.Hash()))
+	if outcome.Success || outcome.Error == nil || *outcome.Error != "Reverted" || uint64(outcome.GasUsed) != receipt.GasUsed {
+		Fatal(t, "unexpected outcome of a reverted transaction", outcome, "with receipt gas", receipt.GasUsed)
+	}
+	if outcome.RevertReason == nil || *outcome.RevertReason != "not today" {
+		Fatal(t, "revert reason wasn't decoded", outcome.RevertReason)
+	}
+	if outcome.ErrorReason == nil || *outcome.ErrorReason != "execution reverted: not today" {
+		Fatal(t, "unexpected error reason", outcome.ErrorReason)
+	}
+
+	err = l2rpc.CallContext(ctx, &outcome, "arbtrace_result", common.Hash{})
+	if err == nil {
+		Fatal(t, "expected an unknown transaction to be refused")
+	}
+}
+
+// TestArbTraceResultCost benchmarks arbtrace_result against arbtrace_transaction on a transaction making
+// many subcalls, whose frames the former doesn't record.
+func TestArbTraceResultCost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmark")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	call := callerCode(callee)
+	var code []byte
+	for i := 0; i < 100; i++ {
+		code = append(code, call[:len(call)-1]...)
+	}
+	code = append(code, byte(vm.STOP))
+	fanout := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &fanout, 5e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	bench := func(method string) testing.BenchmarkResult {
+		return testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var result json.RawMessage
+				if err := l2rpc.CallContext(ctx, &result, method, tx.Hash()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+	outcome := bench("arbtrace_result")
+	traced := bench("arbtrace_transaction")
+	if outcome.N == 0 || traced.N == 0 {
+		Fatal(t, "benchmark failed")
+	}
+	t.Log("arbtrace_result:", outcome, outcome.MemString())
+	t.Log("arbtrace_transaction:", traced, traced.MemString())
+}
+
+func TestArbTraceCreate2Prediction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// deploys a contract whose code is the single byte 0x2a
+	initCode := []byte{
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0, byte(vm.MSTORE8),
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	}
+	salt := common.HexToHash("0x5a17")
+	// copies the init code into memory and deploys it via CREATE2 with the salt
+	factoryCode := []byte{byte(vm.PUSH10)}
+	factoryCode = append(factoryCode, initCode...)
+	factoryCode = append(factoryCode, byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.PUSH32))
+	factoryCode = append(factoryCode, salt.Bytes()...)
+	factoryCode = append(factoryCode,
+		byte(vm.PUSH1), byte(len(initCode)), // size
+		byte(vm.PUSH1), byte(32-len(initCode)), // offset
+		byte(vm.PUSH1), 0, // value
+		byte(vm.CREATE2),
+		byte(vm.POP),
+		byte(vm.STOP),
+	)
+	factory := deployContract(t, ctx, auth, builder.L2.Client, factoryCode)
+	tx := builder.L2Info.PrepareTxTo("Owner", &factory, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", tx.Hash()))
+	if len(frames) != 2 {
+		Fatal(t, "expected the call and its CREATE2, got", len(frames))
+	}
+	if frames[0].Action.Salt != nil || frames[0].Action.InitCodeHash != nil {
+		Fatal(t, "the call has CREATE2 fields", frames[0].Action)
+	}
+	created := frames[1]
+	if created.Action.CreationMethod != "create2" || created.Result == nil || created.Result.Address == nil {
+		Fatal(t, "unexpected CREATE2 frame", created)
+	}
+	initCodeHash := crypto.Keccak256Hash(initCode)
+	if created.Action.Salt == nil || *created.Action.Salt != salt {
+		Fatal(t, "expected salt", salt, "got", created.Action.Salt)
+	}
+	if created.Action.InitCodeHash == nil || *created.Action.InitCodeHash != initCodeHash {
+		Fatal(t, "expected init code hash", initCodeHash, "got", created.Action.InitCodeHash)
+	}
+	// the address is predictable from the traced fields
+	predicted := crypto.CreateAddress2(created.Action.From, *created.Action.Salt, created.Action.InitCodeHash.Bytes())
+	if *created.Result.Address != predicted {
+		Fatal(t, "deployed to", *created.Result.Address, "but the trace predicts", predicted)
+	}
+	code, err := builder.L2.Client.CodeAt(ctx, predicted, nil)
+	Require(t, err)
+	if !bytes.Equal(code, []byte{0x2a}) {
+		Fatal(t, "unexpected code at the predicted address", code)
+	}
+}
+
+func TestArbTraceChainConfigOverride(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// reads the base fee, which London introduced the opcode for
+	contract := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.BASEFEE), byte(vm.POP), byte(vm.STOP)})
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	replay := func(override interface{}) (*traceResult, error) {
+		var result traceResult
+		err := l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace"}, override)
+		return &result, err
+	}
+	result, err := replay(nil)
+	Require(t, err)
+	if len(result.Trace) == 0 || result.Trace[0].Error != nil {
+		Fatal(t, "expected the transaction to succeed under the chain's rules", result.Trace)
+	}
+
+	// without London, the base fee opcode doesn't exist
+	result, err = replay(map[string]interface{}{"london": nil})
+	Require(t, err)
+	if len(result.Trace) == 0 || result.Trace[0].Error == nil || *result.Trace[0].Error != "Bad instruction" {
+		Fatal(t, "expected the transaction to fail without London", result.Trace)
+	}
+	// which leaves the node's own rules as they were
+	result, err = replay(nil)
+	Require(t, err)
+	if result.Trace[0].Error != nil {
+		Fatal(t, "the override changed the node's chain config", result.Trace[0].Error)
+	}
+	if london := builder.L2.ExecNode.Backend.ArbInterface().BlockChain().Config().LondonBlock; london == nil {
+		Fatal(t, "the override disabled London for the node")
+	}
+
+	for _, override := range []map[string]interface{}{
+		// London builds on Berlin
+		{"london": "0x0", "berlin": nil},
+		{"shanghai": "0x0"},
+		{"frontier2": "0x0"},
+	} {
+		_, err := replay(override)
+		if err == nil || !strings.Contains(err.Error(), gethexec.ErrInvalidChainConfigOverride.Error()) {
+			Fatal(t, "expected override", override, "to be rejected, got", err)
+		}
+	}
+}
+
+func TestArbTraceCallDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	const gasCap = 1_000_000
+	builder.execConfig.ArbTrace.TraceGasCap = gasCap
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// returns its caller followed by the gas price
+	contract := deployContract(t, ctx, auth, builder.L2.Client, []byte{
+		byte(vm.CALLER), byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.GASPRICE), byte(vm.PUSH1), 32, byte(vm.MSTORE),
+		byte(vm.PUSH1), 64, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	})
+	header, err := builder.L2.Client.HeaderByNumber(ctx, nil)
+	Require(t, err)
+	l2rpc := builder.L2.Stack.Attach()
+	at := rpc.BlockNumberOrHashWithHash(header.Hash(), false)
+
+	var result traceResult
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{To: &contract}, []string{"trace"}, at)
+	Require(t, err)
+	if len(result.Trace) == 0 || result.Trace[0].Error != nil || result.Trace[0].Result == nil {
+		Fatal(t, "expected the call to succeed from the unfunded zero address", result.Trace)
+	}
+	if from := result.Trace[0].Action.From; from == nil || *from != (common.Address{}) {
+		Fatal(t, "the call defaulted to sender", from, "rather than the zero address")
+	}
+	if result.EffectiveGas == nil || *result.EffectiveGas != gasCap {
+		Fatal(t, "the call defaulted to gas", result.EffectiveGas, "rather than the gas cap of", gasCap)
+	}
+	output := *result.Trace[0].Result.Output
+	if caller := common.BytesToAddress(output[:32]); caller != (common.Address{}) {
+		Fatal(t, "the contract was called by", caller, "rather than the zero address")
+	}
+	if gasPrice := new(big.Int).SetBytes(output[32:]); gasPrice.Cmp(header.BaseFee) != 0 {
+		Fatal(t, "the call defaulted to gas price", gasPrice, "rather than the base fee of", header.BaseFee)
+	}
+
+	// an explicit gas price, even of zero, isn't replaced
+	var priced traceResult
+	err = l2rpc.CallContext(ctx, &priced, "arbtrace_call", callTxArgs{To: &contract, GasPrice: (*hexutil.Big)(common.Big0)}, []string{"trace"}, at)
+	Require(t, err)
+	if gasPrice := new(big.Int).SetBytes((*priced.Trace[0].Result.Output)[32:]); gasPrice.Sign() != 0 {
+		Fatal(t, "the call ran with gas price", gasPrice, "rather than the zero it asked for")
+	}
+
+	// without a recipient, the data is the init code of a contract to create
+	nonce, err := builder.L2.Client.NonceAt(ctx, common.Address{}, header.Number)
+	Require(t, err)
+	code := []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.RETURN)}
+	data := hexutil.Bytes(deployContractInitCode(code, false))
+	var creation traceResult
+	err = l2rpc.CallContext(ctx, &creation, "arbtrace_call", callTxArgs{Data: &data}, []string{"trace"}, at)
+	Require(t, err)
+	if len(creation.Trace) == 0 || creation.Trace[0].Type != "create" || creation.Trace[0].Result == nil {
+		Fatal(t, "expected the call to create a contract", creation.Trace)
+	}
+	expected := crypto.CreateAddress(common.Address{}, nonce)
+	if created := creation.Trace[0].Result.Address; created == nil || *created != expected {
+		Fatal(t, "the call created", created, "rather than", expected)
+	}
+	if created := creation.Trace[0].Result.Code; created == nil || !bytes.Equal(*created, code) {
+		Fatal(t, "the created contract has code", created, "rather than", hexutil.Bytes(code))
+	}
+}
+
+type vmStep struct {
+	Step    hexutil.Uint64              `json:"step"`
+	Depth   int                         `json:"depth"`
+	Address common.Address              `json:"address"`
+	Pc      uint64                      `json:"pc"`
+	Op      string                      `json:"op"`
+	Gas     hexutil.Uint64              `json:"gas"`
+	GasCost hexutil.Uint64              `json:"gasCost"`
+	Stack   []*hexutil.Big              `json:"stack"`
+	Memory  hexutil.Bytes               `json:"memory"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+func TestArbTraceGetStep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	// stores 42, loads it back, and writes it to memory
+	contract := deployContract(t, ctx, auth, builder.L2.Client, []byte{
+		byte(vm.PUSH1), 42, byte(vm.PUSH1), 0, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0, byte(vm.SLOAD),
+		byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.STOP),
+	})
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var replayed traceResult
+	Require(t, l2rpc.CallContext(ctx, &replayed, "arbtrace_replayTransaction", tx.Hash(), []string{"vmTrace"}))
+	ops := replayed.VmTrace.Ops
+	if len(ops) != 8 {
+		Fatal(t, "expected 8 operations, got", len(ops))
+	}
+	getStep := func(step uint64) *vmStep {
+		t.Helper()
+		var result vmStep
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_getStep", tx.Hash(), hexutil.Uint64(step)))
+		if uint64(result.Step) != step || result.Address != contract || result.Depth != 1 {
+			Fatal(t, "step", step, "is", result.Step, "of", result.Address, "at depth", result.Depth)
+		}
+		// steps follow the vmTrace's order
+		if result.Pc != ops[step].Pc || uint64(result.GasCost) != ops[step].Cost {
+			Fatal(t, "step", step, "is at pc", result.Pc, "costing", result.GasCost, "while the vmTrace has", ops[step].Pc, ops[step].Cost)
+		}
+		return &result
+	}
+	slot := common.Hash{}
+	stored := common.BigToHash(big.NewInt(42))
+
+	// about to store, with nothing touched yet
+	step := getStep(2)
+	if step.Op != "SSTORE" || len(step.Stack) != 2 || step.Stack[0].ToInt().Uint64() != 42 || step.Stack[1].ToInt().Sign() != 0 {
+		Fatal(t, "unexpected state before the store", step.Op, step.Stack)
+	}
+	if len(step.Storage) != 1 || step.Storage[slot] != stored {
+		Fatal(t, "the store should report the value it writes, got", step.Storage)
+	}
+	// about to write the loaded value to memory, which is still empty
+	step = getStep(6)
+	if step.Op != "MSTORE" || len(step.Stack) != 2 || step.Stack[0].ToInt().Uint64() != 42 || len(step.Memory) != 0 {
+		Fatal(t, "unexpected state before the memory write", step.Op, step.Stack, step.Memory)
+	}
+	// done, with the value in memory
+	step = getStep(7)
+	if step.Op != "STOP" || len(step.Stack) != 0 || !bytes.Equal(step.Memory, stored[:]) || step.Storage[slot] != stored {
+		Fatal(t, "unexpected state at the end", step.Op, step.Stack, step.Memory, step.Storage)
+	}
+
+	var result vmStep
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_getStep", tx.Hash(), hexutil.Uint64(8))
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrStepOutOfRange.Error()) || !strings.Contains(err.Error(), "executed 8 steps") {
+		Fatal(t, "expected the step past the last to be rejected, got", err)
+	}
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_getStep", tx.Hash(), hexutil.Uint64(builder.execConfig.ArbTrace.VmTraceMaxSteps))
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrStepOutOfRange.Error()) {
+		Fatal(t, "expected a step past the vmTrace limit to be rejected, got", err)
+	}
+}
+
+type tokenTransfer struct {
+	Standard     string          `json:"standard"`
+	Token        *common.Address `json:"token"`
+	From         common.Address  `json:"from"`
+	To           common.Address  `json:"to"`
+	Value        *hexutil.Big    `json:"value"`
+	TokenId      *hexutil.Big    `json:"tokenId"`
+	Operator     *common.Address `json:"operator"`
+	TraceAddress *[]int          `json:"traceAddress"`
+	LogIndex     *hexutil.Uint64 `json:"logIndex"`
+}
+
+func TestArbTraceTokenTransfers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	from, to, operator := testhelpers.RandomAddress(), testhelpers.RandomAddress(), testhelpers.RandomAddress()
+	transferID := crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	transferSingleID := crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	push := func(code []byte, words ...common.Hash) []byte {
+		// pushed in reverse, so the first word ends up on top
+		for i := len(words) - 1; i >= 0; i-- {
+			code = append(append(code, byte(vm.PUSH32)), words[i][:]...)
+		}
+		return code
+	}
+	store := func(code []byte, offset byte, value byte) []byte {
+		return append(code, byte(vm.PUSH1), value, byte(vm.PUSH1), offset, byte(vm.MSTORE))
+	}
+	word := func(value int64) common.Hash { return common.BigToHash(big.NewInt(value)) }
+	var code []byte
+	code = store(code, 0, 100)
+	// a transfer event with neither token's layout
+	code = push(code, transferID, from.Hash())
+	code = append(code, byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.LOG2))
+	// an ERC-20 transfer of 100
+	code = push(code, transferID, from.Hash(), to.Hash())
+	code = append(code, byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.LOG3))
+	// an ERC-721 transfer of token 7
+	code = push(code, transferID, from.Hash(), to.Hash(), word(7))
+	code = append(code, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.LOG4))
+	// an ERC-1155 transfer of 5 of token 9
+	code = store(code, 0, 9)
+	code = store(code, 32, 5)
+	code = push(code, transferSingleID, operator.Hash(), from.Hash(), to.Hash())
+	code = append(code, byte(vm.PUSH1), 64, byte(vm.PUSH1), 0, byte(vm.LOG4), byte(vm.STOP))
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, code)
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(1e9), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	if len(receipt.Logs) != 4 {
+		Fatal(t, "expected 4 logs, got", len(receipt.Logs))
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"tokenTransfers"}))
+	if result.TokenTransfers == nil {
+		Fatal(t, "tokenTransfers missing")
+	}
+	transfers := *result.TokenTransfers
+	if len(transfers) != 4 {
+		Fatal(t, "expected 4 transfers, got", transfers)
+	}
+	native := transfers[0]
+	owner := builder.L2Info.GetAddress("Owner")
+	if native.Standard != "native" || native.Token != nil || native.From != owner || native.To != contract || native.Value.ToInt().Int64() != 1e9 {
+		Fatal(t, "unexpected native transfer", native)
+	}
+	if native.TraceAddress == nil || len(*native.TraceAddress) != 0 || native.LogIndex != nil {
+		Fatal(t, "the native transfer should name the top-level frame", native.TraceAddress, native.LogIndex)
+	}
+	expected := []struct {
+		standard string
+		value    int64
+		tokenId  int64
+		operator *common.Address
+	}{
+		{"erc20", 100, -1, nil},
+		{"erc721", -1, 7, nil},
+		{"erc1155", 5, 9, &operator},
+	}
+	for i, test := range expected {
+		transfer := transfers[i+1]
+		if transfer.Standard != test.standard || transfer.Token == nil || *transfer.Token != contract || transfer.From != from || transfer.To != to {
+			Fatal(t, "unexpected", test.standard, "transfer", transfer)
+		}
+		// the first log isn't a transfer
+		if transfer.LogIndex == nil || int(*transfer.LogIndex) != i+1 || transfer.TraceAddress != nil {
+			Fatal(t, "the", test.standard, "transfer should name its log, got", transfer.LogIndex, transfer.TraceAddress)
+		}
+		if (test.value < 0) != (transfer.Value == nil) || (transfer.Value != nil && transfer.Value.ToInt().Int64() != test.value) {
+			Fatal(t, "the", test.standard, "transfer has value", transfer.Value, "rather than", test.value)
+		}
+		if (test.tokenId < 0) != (transfer.TokenId == nil) || (transfer.TokenId != nil && transfer.TokenId.ToInt().Int64() != test.tokenId) {
+			Fatal(t, "the", test.standard, "transfer has token id", transfer.TokenId, "rather than", test.tokenId)
+		}
+		if (test.operator == nil) != (transfer.Operator == nil) || (test.operator != nil && *transfer.Operator != *test.operator) {
+			Fatal(t, "the", test.standard, "transfer has operator", transfer.Operator, "rather than", test.operator)
+		}
+	}
+}
+
+func TestArbTraceGasFrames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// the innermost callee loops until it runs out of gas, which its callers survive with the gas they kept
+	spinCode := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	spinner := deployContract(t, ctx, auth, builder.L2.Client, spinCode)
+	middle := deployContract(t, ctx, auth, builder.L2.Client, callerCode(spinner))
+	outer := deployContract(t, ctx, auth, builder.L2.Client, callerCode(middle))
+	tx := builder.L2Info.PrepareTxTo("Owner", &outer, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	_, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var result traceResult
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", tx.Hash(), []string{"trace", "gasFrames"}))
+	if len(result.Trace) != 3 {
+		Fatal(t, "expected 3 frames, got", result.Trace)
+	}
+	for depth, frame := range result.Trace {
+		if len(frame.TraceAddress) != depth {
+			Fatal(t, "frame", depth, "has trace address", frame.TraceAddress)
+		}
+		if frame.GasLeftAtEntry == nil || frame.GasLeftAtExit == nil {
+			Fatal(t, "frame", depth, "is missing its gas left")
+		}
+		if *frame.GasLeftAtEntry != frame.Action.Gas {
+			Fatal(t, "frame", depth, "entered with", *frame.GasLeftAtEntry, "gas, but was given", frame.Action.Gas)
+		}
+		if depth > 0 {
+			caller := result.Trace[depth-1]
+			if uint64(*frame.GasLeftAtEntry) > uint64(*caller.GasLeftAtEntry)*63/64 {
+				Fatal(t, "frame", depth, "entered with", *frame.GasLeftAtEntry, "gas, more than all but a 64th of its caller's", *caller.GasLeftAtEntry)
+			}
+		}
+	}
+	spin := result.Trace[2]
+	if spin.Error == nil || *spin.Error != "Out of gas" || spin.Result != nil {
+		Fatal(t, "the innermost frame should have run out of gas, got", spin.Error, spin.Result)
+	}
+	if *spin.GasLeftAtExit != 0 {
+		Fatal(t, "the innermost frame returned", *spin.GasLeftAtExit, "gas after running out")
+	}
+	for _, frame := range result.Trace[:2] {
+		if frame.Error != nil || frame.Result == nil {
+			Fatal(t, "frame", frame.TraceAddress, "should have succeeded, got", frame.Error)
+		}
+		if *frame.GasLeftAtExit != *frame.GasLeftAtEntry-frame.Result.GasUsed || *frame.GasLeftAtExit == 0 {
+			Fatal(t, "frame", frame.TraceAddress, "returned", *frame.GasLeftAtExit, "of", *frame.GasLeftAtEntry, "gas after using", frame.Result.GasUsed)
+		}
+	}
+
+	var plain traceResult
+	Require(t, l2rpc.CallContext(ctx, &plain, "arbtrace_replayTransaction", tx.Hash(), []string{"trace"}))
+	for _, frame := range plain.Trace {
+		if frame.GasLeftAtEntry != nil || frame.GasLeftAtExit != nil {
+			Fatal(t, "frame", frame.TraceAddress, "reported its gas left without gasFrames being requested")
+		}
+	}
+	err = l2rpc.CallContext(ctx, &plain, "arbtrace_replayTransaction", tx.Hash(), []string{"gasFrames"})
+	if err == nil || !strings.Contains(err.Error(), "requires the \"trace\" trace type") {
+		Fatal(t, "expected gasFrames without trace to be refused, got", err)
+	}
+}
+
+func TestArbTraceImpersonation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// reverts unless its caller holds more than 2^64 wei
+	threshold := append([]byte{byte(vm.PUSH9), 1}, make([]byte, 8)...)
+	richOnlyCode := append(threshold,
+		byte(vm.CALLER),
+		byte(vm.BALANCE),
+		byte(vm.GT),
+		byte(vm.PUSH1), 20,
+		byte(vm.JUMPI),
+		byte(vm.PUSH1), 0,
+		byte(vm.DUP1),
+		byte(vm.REVERT),
+		byte(vm.JUMPDEST),
+		byte(vm.STOP),
+	)
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	contract := deployContract(t, ctx, auth, builder.L2.Client, richOnlyCode)
+	// neither account's key is used to trace their calls
+	whale, minnow := testhelpers.RandomAddress(), testhelpers.RandomAddress()
+	_, receipt := TransferBalanceTo(t, "Owner", whale, new(big.Int).Lsh(big.NewInt(1), 65), builder.L2Info, builder.L2.Client, ctx)
+	blockNum := hexutil.Uint64(receipt.BlockNumber.Uint64())
+
+	l2rpc := builder.L2.Stack.Attach()
+	traceTypes := []string{"trace"}
+	var whaleResult traceResult
+	Require(t, l2rpc.CallContext(ctx, &whaleResult, "arbtrace_call", callTxArgs{From: &whale, To: &contract}, traceTypes, blockNum))
+	if len(whaleResult.Trace) != 1 || whaleResult.Trace[0].Error != nil || whaleResult.Trace[0].Action.From != whale {
+		Fatal(t, "expected the whale's call to succeed", whaleResult.Trace)
+	}
+	var minnowResult traceResult
+	Require(t, l2rpc.CallContext(ctx, &minnowResult, "arbtrace_call", callTxArgs{From: &minnow, To: &contract}, traceTypes, blockNum))
+	if len(minnowResult.Trace) != 1 || minnowResult.Trace[0].Error == nil || *minnowResult.Trace[0].Error != "Reverted" {
+		Fatal(t, "expected the unfunded account's call to revert", minnowResult.Trace)
+	}
+
+	// impersonation is reloadable, and disabling it still allows calls from the default sender
+	config := &builder.execConfig.ArbTrace
+	config.AllowImpersonation = false
+	defer func() { config.AllowImpersonation = true }()
+	var result json.RawMessage
+	err := l2rpc.CallContext(ctx, &result, "arbtrace_call", callTxArgs{From: &whale, To: &contract}, traceTypes, blockNum)
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrImpersonationNotAllowed.Error()) {
+		Fatal(t, "expected the whale's call to be refused without impersonation", err)
+	}
+	calls := []*callTraceRequest{
+		{callArgs: callTxArgs{To: &contract}, traceTypes: traceTypes},
+		{callArgs: callTxArgs{From: &whale, To: &contract}, traceTypes: traceTypes},
+	}
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_callMany", calls, blockNum)
+	if err == nil || !strings.Contains(err.Error(), "call 1") || !strings.Contains(err.Error(), gethexec.ErrImpersonationNotAllowed.Error()) {
+		Fatal(t, "expected the whale's call among many to be refused without impersonation", err)
+	}
+	var defaultResult traceResult
+	Require(t, l2rpc.CallContext(ctx, &defaultResult, "arbtrace_call", callTxArgs{To: &contract}, traceTypes, blockNum))
+	if len(defaultResult.Trace) != 1 || defaultResult.Trace[0].Action.From != (common.Address{}) {
+		Fatal(t, "expected the call from the default sender to be traced", defaultResult.Trace)
+	}
+}
+
+type txExplanation struct {
+	TransactionHash     common.Hash    `json:"transactionHash"`
+	BlockHash           common.Hash    `json:"blockHash"`
+	BlockNumber         hexutil.Uint64 `json:"blockNumber"`
+	TransactionPosition hexutil.Uint64 `json:"transactionPosition"`
+	Call                *struct {
+		Type       string          `json:"type"`
+		From       common.Address  `json:"from"`
+		To         *common.Address `json:"to"`
+		Value      *hexutil.Big    `json:"value"`
+		Input      hexutil.Bytes   `json:"input"`
+		Selector   *hexutil.Bytes  `json:"selector"`
+		Precompile *precompileCall `json:"precompile"`
+	} `json:"call"`
+	Outcome        *txOutcome        `json:"outcome"`
+	Summary        *traceSummary     `json:"summary"`
+	TokenTransfers *[]tokenTransfer  `json:"tokenTransfers"`
+	Outbox         *[]outboxMessage  `json:"outbox"`
+	FeeInfo        *feeInfo          `json:"feeInfo"`
+	Unavailable    map[string]string `json:"unavailable"`
+}
+
+func TestArbTraceExplain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// logs an ERC-20 transfer of 100, then calls the callee
+	from, to := testhelpers.RandomAddress(), testhelpers.RandomAddress()
+	transferID := crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	code := []byte{byte(vm.PUSH1), 100, byte(vm.PUSH1), 0, byte(vm.MSTORE)}
+	for _, topic := range []common.Hash{to.Hash(), from.Hash(), transferID} {
+		code = append(append(code, byte(vm.PUSH32)), topic[:]...)
+	}
+	code = append(code, byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.LOG3))
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	contract := deployContract(t, ctx, auth, builder.L2.Client, append(code, callerCode(callee)...))
+	selector := []byte{0xde, 0xad, 0xbe, 0xef}
+	tx := builder.L2Info.PrepareTxTo("Owner", &contract, 1e6, big.NewInt(1e9), selector)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var explained txExplanation
+	Require(t, l2rpc.CallContext(ctx, &explained, "arbtrace_explain", tx.Hash()))
+	if explained.TransactionHash != tx.Hash() || explained.BlockHash != receipt.BlockHash || uint(explained.TransactionPosition) != receipt.TransactionIndex {
+		Fatal(t, "the explanation doesn't locate the transaction", explained.TransactionHash, explained.BlockHash, explained.TransactionPosition)
+	}
+	call := explained.Call
+	owner := builder.L2Info.GetAddress("Owner")
+	if call == nil || call.Type != "call" || call.From != owner || call.To == nil || *call.To != contract || call.Value.ToInt().Int64() != 1e9 {
+		Fatal(t, "unexpected top-level call", call)
+	}
+	if call.Selector == nil || !bytes.Equal(*call.Selector, selector) || call.Precompile != nil {
+		Fatal(t, "unexpected decoding of the top-level call", call.Selector, call.Precompile)
+	}
+	if explained.Outcome == nil || !explained.Outcome.Success || uint64(explained.Outcome.GasUsed) != receipt.GasUsed {
+		Fatal(t, "unexpected outcome", explained.Outcome)
+	}
+	if explained.Summary == nil || explained.Summary.Frames != 2 {
+		Fatal(t, "expected a summary of 2 frames, got", explained.Summary)
+	}
+	if explained.TokenTransfers == nil || len(*explained.TokenTransfers) != 2 {
+		Fatal(t, "expected a native and an ERC-20 transfer, got", explained.TokenTransfers)
+	}
+	if (*explained.TokenTransfers)[0].Standard != "native" || (*explained.TokenTransfers)[1].Standard != "erc20" {
+		Fatal(t, "unexpected transfers", *explained.TokenTransfers)
+	}
+	if explained.Outbox == nil || len(*explained.Outbox) != 0 {
+		Fatal(t, "expected no outbox messages, got", explained.Outbox)
+	}
+	if explained.FeeInfo == nil || uint64(explained.FeeInfo.GasUsed) != receipt.GasUsed {
+		Fatal(t, "unexpected fee info", explained.FeeInfo)
+	}
+	if len(explained.Unavailable) != 0 {
+		Fatal(t, "expected a complete explanation, missing", explained.Unavailable)
+	}
+
+	// with the subcall exceeding the frame limit, the transaction is explained without its frames
+	config := &builder.execConfig.ArbTrace
+	maxFrames := config.MaxFrames
+	config.MaxFrames = 1
+	var degraded txExplanation
+	err = l2rpc.CallContext(ctx, &degraded, "arbtrace_explain", tx.Hash())
+	config.MaxFrames = maxFrames
+	Require(t, err)
+	if degraded.Summary != nil || !strings.Contains(degraded.Unavailable["summary"], gethexec.ErrTraceFrameLimit.Error()) {
+		Fatal(t, "expected the summary to be unavailable past the frame limit", degraded.Summary, degraded.Unavailable)
+	}
+	if _, ok := degraded.Unavailable["tokenTransfers"]; !ok || degraded.TokenTransfers == nil || len(*degraded.TokenTransfers) != 1 || (*degraded.TokenTransfers)[0].Standard != "erc20" {
+		Fatal(t, "expected only the ERC-20 transfer without tracing, got", degraded.TokenTransfers, degraded.Unavailable)
+	}
+	if degraded.Outcome == nil || !degraded.Outcome.Success || degraded.FeeInfo == nil || degraded.FeeInfo.GasUsed != explained.FeeInfo.GasUsed {
+		Fatal(t, "expected the outcome and fees without tracing, got", degraded.Outcome, degraded.FeeInfo)
+	}
+	if degraded.Call == nil || degraded.Call.To == nil || *degraded.Call.To != contract {
+		Fatal(t, "expected the top-level call without tracing, got", degraded.Call)
+	}
+
+	reverter := deployContract(t, ctx, auth, builder.L2.Client, revertCode(t, "not today"))
+	tx = builder.L2Info.PrepareTxTo("Owner", &reverter, 1e6, big.NewInt(0), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	EnsureTxFailed(t, ctx, builder.L2.Client, tx)
+	var reverted txExplanation
+	Require(t, l2rpc.CallContext(ctx, &reverted, "arbtrace_explain", tx.Hash()))
+	outcome := reverted.Outcome
+	if outcome == nil || outcome.Success || outcome.RevertReason == nil || *outcome.RevertReason != "not today" {
+		Fatal(t, "expected the revert reason to be explained, got", outcome)
+	}
+	if reverted.Call == nil || reverted.Call.Selector != nil {
+		Fatal(t, "a call without input selects no method, got", reverted.Call)
+	}
+}
+
+func TestArbTraceFeedReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	callee := deployContract(t, ctx, auth, builder.L2.Client, []byte{byte(vm.STOP)})
+	caller := deployContract(t, ctx, auth, builder.L2.Client, callerCode(callee))
+	builder.L2Info.GenerateAccount("User2")
+	txs := []*types.Transaction{
+		builder.L2Info.PrepareTxTo("Owner", &caller, 1e6, big.NewInt(0), nil),
+		builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil),
+	}
+	var receipts []*types.Receipt
+	for _, tx := range txs {
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		receipts = append(receipts, receipt)
+	}
+
+	// records the feed the sequencer broadcast for the transactions' blocks, resending its first message
+	// and confirming them as a feed does
+	blockchain := builder.L2.ExecNode.Backend.ArbInterface().BlockChain()
+	genesis := blockchain.Config().ArbitrumChainParams.GenesisBlockNum
+	first := arbutil.BlockNumberToMessageCount(receipts[0].BlockNumber.Uint64(), genesis) - 1
+	last := arbutil.BlockNumberToMessageCount(receipts[len(receipts)-1].BlockNumber.Uint64(), genesis) - 1
+	var broadcasts []*message.BroadcastMessage
+	for seqNum := first; seqNum <= last; seqNum++ {
+		msg, err := builder.L2.ConsensusNode.TxStreamer.GetMessage(seqNum)
+		Require(t, err)
+		broadcasts = append(broadcasts, &message.BroadcastMessage{
+			Version:  message.V1,
+			Messages: []*message.BroadcastFeedMessage{{SequenceNumber: seqNum, Message: *msg}},
+		})
+	}
+	broadcasts = append(broadcasts, broadcasts[0], &message.BroadcastMessage{
+		Version:                        message.V1,
+		ConfirmedSequenceNumberMessage: &message.ConfirmedSequenceNumberMessage{SequenceNumber: last},
+	})
+	dir := t.TempDir()
+	writeFeed := func(name string, broadcasts []*message.BroadcastMessage) string {
+		var feed bytes.Buffer
+		for _, broadcast := range broadcasts {
+			line, err := json.Marshal(broadcast)
+			Require(t, err)
+			feed.Write(append(line, '\n'))
+		}
+		path := filepath.Join(dir, name)
+		Require(t, os.WriteFile(path, feed.Bytes(), 0600))
+		return path
+	}
+
+	execNode := builder.L2.ExecNode
+	api := gethexec.NewArbTraceAPI(
+		blockchain,
+		execNode.ChainDB,
+		execNode.Backend.APIBackend(),
+		nil,
+		nil,
+		execNode.ExecEngine,
+		func() *gethexec.ArbTraceConfig { return &builder.execConfig.ArbTrace },
+		nil,
+	)
+	config := gethexec.FeedReplayTracerConfig{
+		File:       writeFeed("feed.jsonl", broadcasts),
+		Output:     filepath.Join(dir, "traces.jsonl"),
+		TraceTypes: []string{"trace"},
+	}
+	Require(t, config.Validate())
+	Require(t, gethexec.TraceFeedReplay(ctx, api, builder.L2.ConsensusNode, &config))
+
+	output, err := os.ReadFile(config.Output)
+	Require(t, err)
+	type feedReplayTrace struct {
+		SequenceNumber      hexutil.Uint64 `json:"sequenceNumber"`
+		BlockNumber         hexutil.Uint64 `json:"blockNumber"`
+		BlockHash           common.Hash    `json:"blockHash"`
+		TransactionHash     common.Hash    `json:"transactionHash"`
+		TransactionPosition hexutil.Uint64 `json:"transactionPosition"`
+		Result              traceResult    `json:"result"`
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	// the replayed blocks match those the node executed, transaction for transaction
+	var expected []*types.Transaction
+	for number := receipts[0].BlockNumber.Uint64(); number <= receipts[len(receipts)-1].BlockNumber.Uint64(); number++ {
+		expected = append(expected, blockchain.GetBlockByNumber(number).Transactions()...)
+	}
+	if len(lines) != len(expected) {
+		Fatal(t, "expected", len(expected), "traces, got", len(lines))
+	}
+	l2rpc := builder.L2.Stack.Attach()
+	for i, line := range lines {
+		var trace feedReplayTrace
+		Require(t, json.Unmarshal([]byte(line), &trace))
+		block := blockchain.GetBlockByNumber(uint64(trace.BlockNumber))
+		if trace.TransactionHash != expected[i].Hash() || block == nil || trace.BlockHash != block.Hash() {
+			Fatal(t, "trace", i, "of transaction", trace.TransactionHash, "in block", trace.BlockHash, "doesn't match the node's")
+		}
+		if uint64(trace.SequenceNumber) != uint64(arbutil.BlockNumberToMessageCount(block.NumberU64(), genesis)-1) {
+			Fatal(t, "trace", i, "names message", trace.SequenceNumber, "for block", block.NumberU64())
+		}
+		if trace.TransactionHash != txs[0].Hash() {
+			continue
+		}
+		var replayed traceResult
+		Require(t, l2rpc.CallContext(ctx, &replayed, "arbtrace_replayTransaction", trace.TransactionHash, []string{"trace"}))
+		if len(trace.Result.Trace) != 2 || len(replayed.Trace) != 2 || *trace.Result.Trace[1].Action.To != callee {
+			Fatal(t, "expected the feed's trace of the call to match its replay, got", trace.Result.Trace, "rather than", replayed.Trace)
+		}
+	}
+
+	// a feed missing a message can't be replayed past it
+	skipped := *broadcasts[0].Messages[0]
+	skipped.SequenceNumber = first + 2
+	gap := &message.BroadcastMessage{Version: message.V1, Messages: []*message.BroadcastFeedMessage{&skipped}}
+	config.File = writeFeed("gap.jsonl", []*message.BroadcastMessage{broadcasts[0], gap})
+	err = gethexec.TraceFeedReplay(ctx, api, builder.L2.ConsensusNode, &config)
+	if err == nil || !strings.Contains(err.Error(), "skips") {
+		Fatal(t, "expected a feed with a gap to be refused, got", err)
+	}
+}
+
+func TestArbTraceFeedReplayUnstartedNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.Caching.Archive = true
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	_, receipt := TransferBalance(t, "Owner", "Owner", big.NewInt(1), builder.L2Info, builder.L2.Client, ctx)
+	blockchain := builder.L2.ExecNode.Backend.ArbInterface().BlockChain()
+	genesis := blockchain.Config().ArbitrumChainParams.GenesisBlockNum
+	first := arbutil.BlockNumberToMessageCount(receipt.BlockNumber.Uint64(), genesis) - 1
+
+	// advances the parent chain until the batch holding the transfer is reported, and the report executed
+	streamer := builder.L2.ConsensusNode.TxStreamer
+	var report arbutil.MessageIndex
+	for i := 0; ; i++ {
+		if i == 100 {
+			Fatal(t, "timed out waiting for a batch posting report")
+		}
+		builder.L1.SendWaitTestTransactions(t, []*types.Transaction{
+			builder.L1Info.PrepareTx("Faucet", "User", 30000, big.NewInt(1e12), nil),
+		})
+		head, err := builder.L2.ExecNode.ExecEngine.HeadMessageNumber()
+		Require(t, err)
+		for seqNum := first + 1; report == 0 && seqNum <= head; seqNum++ {
+			msg, err := streamer.GetMessage(seqNum)
+			Require(t, err)
+			if msg.Message.Header.Kind == arbostypes.L1MessageType_BatchPostingReport {
+				report = seqNum
+			}
+		}
+		if report != 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// records the feed up to the report, without the batch's gas cost, as feeds from before it was sent lack it,
+	// so that replaying the report needs the batch
+	var feed bytes.Buffer
+	for seqNum := first; seqNum <= report; seqNum++ {
+		msg, err := streamer.GetMessage(seqNum)
+		Require(t, err)
+		msg.Message.BatchGasCost = nil
+		line, err := json.Marshal(&message.BroadcastMessage{
+			Version:  message.V1,
+			Messages: []*message.BroadcastFeedMessage{{SequenceNumber: seqNum, Message: *msg}},
+		})
+		Require(t, err)
+		feed.Write(append(line, '\n'))
+	}
+	dir := t.TempDir()
+	config := gethexec.FeedReplayTracerConfig{
+		File:       filepath.Join(dir, "feed.jsonl"),
+		Output:     filepath.Join(dir, "traces.jsonl"),
+		TraceTypes: []string{"trace"},
+	}
+	Require(t, os.WriteFile(config.File, feed.Bytes(), 0600))
+	Require(t, config.Validate())
+	reportBlock := blockchain.GetBlockByNumber(uint64(arbutil.MessageCountToBlockNumber(report+1, genesis)))
+
+	// reopens the node's databases as the tool mode does, without starting the node, so the execution engine
+	// has no consensus node to fetch batches from
+	deployInfo := builder.L2.ConsensusNode.DeployInfo
+	builder.L2.ConsensusNode.StopAndWait()
+	builder.L2.cleanup = func() {}
+	execConfig := gethexec.ConfigDefaultNonSequencerTest()
+	execConfig.Caching.Archive = true
+	execConfig.RPC.MaxRecreateStateDepth = arbitrum.DefaultArchiveNodeMaxRecreateStateDepth
+	Require(t, execConfig.Validate())
+	initMessage := getInitMessage(ctx, t, builder.L1.Client, deployInfo)
+	_, stack, chainDb, arbDb, blockchain := createL2BlockChainWithStackConfig(t, builder.L2Info, "", builder.chainConfig, initMessage, builder.l2StackConfig, &execConfig.Caching)
+	defer requireClose(t, stack)
+	execNode, err := gethexec.CreateExecutionNode(ctx, stack, chainDb, blockchain, builder.L1.Client, func() *gethexec.Config { return execConfig })
+	Require(t, err)
+	nodeConfig := arbnode.ConfigDefaultL1NonSequencerTest()
+	nodeConfig.BlockValidator.Enable = false
+	Require(t, nodeConfig.Validate())
+	unstarted, err := arbnode.CreateNode(ctx, stack, execNode, arbDb, NewFetcherFromConfig(nodeConfig), blockchain.Config(), builder.L1.Client, deployInfo, nil, nil, nil, make(chan error, 10), big.NewInt(1337), nil)
+	Require(t, err)
+	if execNode.ExecEngine.GetBatchFetcher() != nil {
+		Fatal(t, "expected the unstarted node's execution engine to have no batch fetcher")
+	}
+	api := gethexec.NewArbTraceAPI(
+		blockchain,
+		chainDb,
+		execNode.Backend.APIBackend(),
+		nil,
+		nil,
+		execNode.ExecEngine,
+		func() *gethexec.ArbTraceConfig { return &execConfig.ArbTrace },
+		nil,
+	)
+	err = gethexec.TraceFeedReplay(ctx, api, nil, &config)
+	if err == nil || !strings.Contains(err.Error(), "can't be fetched") {
+		Fatal(t, "expected the report to need its batch, got", err)
+	}
+	Require(t, gethexec.TraceFeedReplay(ctx, api, unstarted, &config))
+
+	output, err := os.ReadFile(config.Output)
+	Require(t, err)
+	var reportTraced bool
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		var trace struct {
+			SequenceNumber hexutil.Uint64 `json:"sequenceNumber"`
+			BlockHash      common.Hash    `json:"blockHash"`
+		}
+		Require(t, json.Unmarshal([]byte(line), &trace))
+		if uint64(trace.SequenceNumber) != uint64(report) {
+			continue
+		}
+		if trace.BlockHash != reportBlock.Hash() {
+			Fatal(t, "the replayed report produced block", trace.BlockHash, "rather than the node's", reportBlock.Hash())
+		}
+		reportTraced = true
+	}
+	if !reportTraced {
+		Fatal(t, "expected the batch posting report's block to be traced")
+	}
+}
+
+func TestArbTraceMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	builder.execConfig.ArbTrace.TraceCacheSize = 4
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	block, err := builder.L2.Client.BlockByHash(ctx, receipt.BlockHash)
+	Require(t, err)
+	txCount := int64(len(block.Transactions()))
+
+	names := []string{
+		"arb/arbtrace/results/computed",
+		"arb/arbtrace/results/cached",
+		"arb/arbtrace/tracetypes/trace",
+		"arb/arbtrace/tracetypes/stateDiff",
+		"arb/arbtrace/tracetypes/vmTrace",
+		"arb/arbtrace/requests/arbtrace_replayBlockTransactions",
+		"arb/arbtrace/requests/arbtrace_replayTransaction",
+	}
+	counts := func() map[string]int64 {
+		values := make(map[string]int64, len(names))
+		for _, name := range names {
+			values[name] = metrics.GetOrRegisterCounter(name, nil).Snapshot().Count()
+		}
+		return values
+	}
+	expectIncreases := func(before map[string]int64, increases map[string]int64) {
+		t.Helper()
+		after := counts()
+		for _, name := range names {
+			if after[name]-before[name] != increases[name] {
+				Fatal(t, "expected", name, "to increase by", increases[name], "but it increased by", after[name]-before[name])
+			}
+		}
+	}
+
+	l2rpc := builder.L2.Stack.Attach()
+	replay := func() {
+		t.Helper()
+		var results []traceResult
+		Require(t, l2rpc.CallContext(ctx, &results, "arbtrace_replayBlockTransactions", hexutil.EncodeBig(receipt.BlockNumber), []string{"trace", "stateDiff"}))
+		if int64(len(results)) != txCount {
+			Fatal(t, "expected", txCount, "results, got", len(results))
+		}
+	}
+	// the first replay traces each transaction once for both types
+	before := counts()
+	replay()
+	expectIncreases(before, map[string]int64{
+		"arb/arbtrace/results/computed":                          txCount,
+		"arb/arbtrace/tracetypes/trace":                          txCount,
+		"arb/arbtrace/tracetypes/stateDiff":                      txCount,
+		"arb/arbtrace/requests/arbtrace_replayBlockTransactions": 1,
+	})
+	// the second is served from the cache, tracing nothing
+	before = counts()
+	replay()
+	expectIncreases(before, map[string]int64{
+		"arb/arbtrace/results/cached":                            txCount,
+		"arb/arbtrace/requests/arbtrace_replayBlockTransactions": 1,
+	})
+}
@@ -9,10 +9,14 @@ package arbtest
 import (
 	"context"
 	"fmt"
+	"math/big"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/offchainlabs/nitro/solgen/go/mocksgen"
 )
 
@@ -62,3 +66,57 @@ func TestBenchmarkGas(t *testing.T) {
 		return ensure(programTest.FillBlockQuickStep(&auth))
 	})
 }
+
+func TestBenchmarkArbTraceReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	builder.execConfig.Sequencer.MaxBlockSpeed = time.Second
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	// counts down from 4096 so that each transaction has plenty of steps to trace
+	loopCode := []byte{
+		byte(vm.PUSH2), 0x10, 0x00,
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 1,
+		byte(vm.SWAP1),
+		byte(vm.SUB),
+		byte(vm.DUP1),
+		byte(vm.PUSH1), 3,
+		byte(vm.JUMPI),
+		byte(vm.STOP),
+	}
+	auth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	loop := deployContract(t, ctx, auth, builder.L2.Client, loopCode)
+
+	var txs []*types.Transaction
+	for i := 0; i < 64; i++ {
+		tx := builder.L2Info.PrepareTxTo("Owner", &loop, 1e6, big.NewInt(0), nil)
+		Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+		txs = append(txs, tx)
+	}
+	var densest *types.Receipt
+	blockTxs := map[uint64]int{}
+	for _, tx := range txs {
+		receipt, err := builder.L2.EnsureTxSucceeded(tx)
+		Require(t, err)
+		blockTxs[receipt.BlockNumber.Uint64()]++
+		if densest == nil || blockTxs[receipt.BlockNumber.Uint64()] > blockTxs[densest.BlockNumber.Uint64()] {
+			densest = receipt
+		}
+	}
+	blockNum := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(densest.BlockNumber.Int64()))
+	fmt.Printf("Replaying block %v with %v transactions\n", densest.BlockNumber, blockTxs[densest.BlockNumber.Uint64()])
+
+	l2rpc := builder.L2.Stack.Attach()
+	for _, workers := range []int{1, runtime.NumCPU()} {
+		builder.execConfig.ArbTrace.ReplayWorkers = workers
+		now := time.Now()
+		var results []interface{}
+		err := l2rpc.CallContext(ctx, &results, "arbtrace_replayBlockTransactions", blockNum, []string{"trace", "vmTrace", "stateDiff"})
+		Require(t, err)
+		fmt.Printf("Bench replay %2v workers %v\n", workers, formatTime(time.Since(now)))
+	}
+}
@@ -19,10 +19,12 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/offchainlabs/nitro/arbcompress"
 	"github.com/offchainlabs/nitro/arbos/l1pricing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/offchainlabs/nitro/execution/gethexec"
 	"github.com/offchainlabs/nitro/solgen/go/precompilesgen"
 	"github.com/offchainlabs/nitro/util/arbmath"
 	"github.com/offchainlabs/nitro/util/colors"
@@ -118,6 +120,79 @@ func TestSequencerFeePaid(t *testing.T) {
 	}
 }
 
+func TestL1PricingInfoRPC(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, true)
+	cleanup := builder.Build(t)
+	defer cleanup()
+
+	l2rpc := builder.L2.Stack.Attach()
+	arbGasInfo, err := precompilesgen.NewArbGasInfo(common.HexToAddress("0x6c"), builder.L2.Client)
+	Require(t, err)
+	getInfo := func(blockNum rpc.BlockNumber) gethexec.L1PricingInfo {
+		t.Helper()
+		var info gethexec.L1PricingInfo
+		Require(t, l2rpc.CallContext(ctx, &info, "arb_getL1PricingInfo", blockNum))
+		return info
+	}
+
+	// the poster fee charged a transaction matches the cost per byte reported for its block
+	builder.L2Info.GenerateAccount("User2")
+	tx := builder.L2Info.PrepareTx("Owner", "User2", builder.L2Info.TransferGas, big.NewInt(1e12), nil)
+	Require(t, builder.L2.Client.SendTransaction(ctx, tx))
+	receipt, err := builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	before := getInfo(rpc.BlockNumber(receipt.BlockNumber.Int64()))
+	header, err := builder.L2.Client.HeaderByHash(ctx, receipt.BlockHash)
+	Require(t, err)
+	posterFee := arbmath.BigMulByUint(before.CostPerByte, compressedTxSize(t, tx))
+	// L1 gas can only be charged in terms of L2 gas, so round down to the gas bought
+	if gasForL1 := arbmath.BigDiv(posterFee, header.BaseFee).Uint64(); gasForL1 != receipt.GasUsedForL1 {
+		Fatal(t, "poster fee of", posterFee, "buys", gasForL1, "gas but the receipt has", receipt.GasUsedForL1)
+	}
+	estimate, err := arbGasInfo.GetL1BaseFeeEstimate(&bind.CallOpts{Context: ctx, BlockNumber: receipt.BlockNumber})
+	Require(t, err)
+	if before.L1BaseFeeEstimate.Cmp(estimate) != 0 {
+		Fatal(t, "L1 base fee estimate", before.L1BaseFeeEstimate, "doesn't match ArbGasInfo's", estimate)
+	}
+	surplus, err := arbGasInfo.GetL1PricingSurplus(&bind.CallOpts{Context: ctx, BlockNumber: receipt.BlockNumber})
+	Require(t, err)
+	if before.Surplus.Cmp(surplus) != 0 {
+		Fatal(t, "surplus", before.Surplus, "doesn't match ArbGasInfo's", surplus)
+	}
+
+	// change the price so historical and latest queries differ
+	ownerAuth := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	arbDebug, err := precompilesgen.NewArbDebug(common.HexToAddress("0xff"), builder.L2.Client)
+	Require(t, err)
+	tx, err = arbDebug.BecomeChainOwner(&ownerAuth)
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	arbOwner, err := precompilesgen.NewArbOwner(common.HexToAddress("0x70"), builder.L2.Client)
+	Require(t, err)
+	newPrice := arbmath.BigMulByUint(before.L1BaseFeeEstimate, 2)
+	tx, err = arbOwner.SetL1PricePerUnit(&ownerAuth, newPrice)
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	latest := getInfo(rpc.LatestBlockNumber)
+	if latest.L1BaseFeeEstimate.Cmp(newPrice) != 0 {
+		Fatal(t, "latest L1 base fee estimate", latest.L1BaseFeeEstimate, "rather than", newPrice)
+	}
+	if latest.BlockNumber <= before.BlockNumber {
+		Fatal(t, "latest block", latest.BlockNumber, "doesn't follow", before.BlockNumber)
+	}
+	historical := getInfo(rpc.BlockNumber(receipt.BlockNumber.Int64()))
+	if historical.L1BaseFeeEstimate.Cmp(before.L1BaseFeeEstimate) != 0 {
+		Fatal(t, "historical L1 base fee estimate changed to", historical.L1BaseFeeEstimate)
+	}
+}
+
 func testSequencerPriceAdjustsFrom(t *testing.T, initialEstimate uint64) {
 	_ = os.Mkdir("test-data", 0766)
 	path := filepath.Join("test-data", fmt.Sprintf("testSequencerPriceAdjustsFrom%v.csv", initialEstimate))
@@ -12,7 +12,9 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/offchainlabs/nitro/arbos"
+	"github.com/offchainlabs/nitro/execution/gethexec"
 	"github.com/offchainlabs/nitro/solgen/go/mocksgen"
 	"github.com/offchainlabs/nitro/solgen/go/precompilesgen"
 	"github.com/offchainlabs/nitro/util/arbmath"
@@ -174,4 +176,14 @@ func TestScheduleArbosUpgrade(t *testing.T) {
 	if scheduled.ArbosVersion != testVersion || scheduled.ScheduledForTimestamp != testTimestamp {
 		t.Errorf("expected upgrade to be scheduled for version %v timestamp %v, got version %v timestamp %v", testVersion, testTimestamp, scheduled.ArbosVersion, scheduled.ScheduledForTimestamp)
 	}
+
+	var info gethexec.ArbOSVersionInfo
+	err = builder.L2.Stack.Attach().CallContext(ctx, &info, "arb_getArbOSVersion", rpc.LatestBlockNumber)
+	Require(t, err)
+	if info.Version != builder.chainConfig.ArbitrumChainParams.InitialArbOSVersion {
+		t.Errorf("arb_getArbOSVersion reported version %v", info.Version)
+	}
+	if info.ScheduledUpgrade == nil || *info.ScheduledUpgrade != testVersion || info.ScheduledUpgradeTimestamp == nil || *info.ScheduledUpgradeTimestamp != testTimestamp {
+		t.Errorf("arb_getArbOSVersion reported scheduled upgrade %v at %v", info.ScheduledUpgrade, info.ScheduledUpgradeTimestamp)
+	}
 }
@@ -4,6 +4,7 @@
 package arbtest
 
 import (
+	"bytes"
 	"context"
 	"math/big"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/gasestimator"
 	"github.com/ethereum/go-ethereum/params"
@@ -22,6 +24,7 @@ import (
 	"github.com/offchainlabs/nitro/arbos/l2pricing"
 	"github.com/offchainlabs/nitro/arbos/retryables"
 	"github.com/offchainlabs/nitro/arbos/util"
+	"github.com/offchainlabs/nitro/arbutil"
 	"github.com/offchainlabs/nitro/execution/gethexec"
 
 	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
@@ -30,6 +33,7 @@ import (
 	"github.com/offchainlabs/nitro/solgen/go/precompilesgen"
 	"github.com/offchainlabs/nitro/util/arbmath"
 	"github.com/offchainlabs/nitro/util/colors"
+	"github.com/offchainlabs/nitro/util/testhelpers"
 )
 
 func retryableSetup(t *testing.T, modifyNodeConfig ...func(*NodeBuilder)) (
@@ -392,6 +396,385 @@ func TestSubmitRetryableFailThenRetry(t *testing.T) {
 	}
 }
 
+func TestArbTraceRetryable(t *testing.T) {
+	t.Parallel()
+	builder, delayedInbox, lookupL2Tx, ctx, teardown := retryableSetup(t)
+	defer teardown()
+
+	ownerTxOpts := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	usertxopts := builder.L1Info.GetDefaultTransactOpts("Faucet", ctx)
+	usertxopts.Value = arbmath.BigMul(big.NewInt(1e12), big.NewInt(1e12))
+
+	simpleAddr, _ := builder.L2.DeploySimple(t, ownerTxOpts)
+	simpleABI, err := mocksgen.SimpleMetaData.GetAbi()
+	Require(t, err)
+
+	beneficiaryAddress := builder.L2Info.GetAddress("Beneficiary")
+	maxSubmissionCost := big.NewInt(1e16)
+	l1tx, err := delayedInbox.CreateRetryableTicket(
+		&usertxopts,
+		simpleAddr,
+		common.Big0,
+		maxSubmissionCost,
+		beneficiaryAddress,
+		beneficiaryAddress,
+		// send enough L2 gas for intrinsic but not compute, so the auto redeem fails
+		big.NewInt(int64(params.TxGas+params.TxDataNonZeroGasEIP2028*4)),
+		big.NewInt(l2pricing.InitialBaseFeeWei*2),
+		simpleABI.Methods["incrementRedeem"].ID,
+	)
+	Require(t, err)
+	l1Receipt, err := builder.L1.EnsureTxSucceeded(l1tx)
+	Require(t, err)
+	waitForL1DelayBlocks(t, ctx, builder)
+	submitTx := lookupL2Tx(l1Receipt)
+	_, err = builder.L2.EnsureTxSucceeded(submitTx)
+	Require(t, err)
+	ticketId := submitTx.Hash()
+	assertTraceMatchesReceipt(t, ctx, builder.L2, ticketId)
+
+	l2rpc := builder.L2.Stack.Attach()
+	traceRetryable := func(txHash common.Hash) []retryableFrame {
+		t.Helper()
+		var result traceResult
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_replayTransaction", txHash, []string{"retryable"}))
+		if result.Retryable == nil {
+			Fatal(t, "retryable frames missing")
+		}
+		return *result.Retryable
+	}
+
+	// the submission creates the ticket and schedules its auto redeem
+	frames := traceRetryable(ticketId)
+	if len(frames) != 2 || frames[0].Type != "create" || frames[1].Type != "scheduleRedeem" {
+		Fatal(t, "unexpected frames for the submission", frames)
+	}
+	created := frames[0]
+	if created.TicketId != ticketId || created.Beneficiary == nil || *created.Beneficiary != beneficiaryAddress {
+		Fatal(t, "unexpected ticket creation", created)
+	}
+	if created.MaxSubmissionCost.ToInt().Cmp(maxSubmissionCost) != 0 || created.CallValue.ToInt().Sign() != 0 || created.Timeout == nil {
+		Fatal(t, "unexpected ticket parameters", created)
+	}
+	scheduled := frames[1]
+	if scheduled.TicketId != ticketId || scheduled.RedeemType != "auto" || scheduled.RetryTxHash == nil {
+		Fatal(t, "unexpected auto redeem", scheduled)
+	}
+	autoRedeem := *scheduled.RetryTxHash
+
+	// the auto redeem runs out of gas, leaving the ticket open
+	assertTraceMatchesReceipt(t, ctx, builder.L2, autoRedeem)
+	frames = traceRetryable(autoRedeem)
+	if len(frames) != 1 || frames[0].Type != "redeem" || frames[0].RedeemType != "auto" {
+		Fatal(t, "unexpected frames for the auto redeem", frames)
+	}
+	if *frames[0].Success || *frames[0].TicketClosed {
+		Fatal(t, "auto redeem should have failed, leaving the ticket open", frames[0])
+	}
+
+	// redeeming the ticket manually schedules another attempt, which succeeds
+	arbRetryableTx, err := precompilesgen.NewArbRetryableTx(common.HexToAddress("6e"), builder.L2.Client)
+	Require(t, err)
+	tx, err := arbRetryableTx.Redeem(&ownerTxOpts, ticketId)
+	Require(t, err)
+	_, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	assertTraceMatchesReceipt(t, ctx, builder.L2, tx.Hash())
+	frames = traceRetryable(tx.Hash())
+	if len(frames) != 1 || frames[0].Type != "scheduleRedeem" || frames[0].RedeemType != "manual" || frames[0].TicketId != ticketId {
+		Fatal(t, "unexpected frames for the manual redeem", frames)
+	}
+	manualRedeem := *frames[0].RetryTxHash
+	_, err = WaitForTx(ctx, builder.L2.Client, manualRedeem, time.Second*5)
+	Require(t, err)
+	assertTraceMatchesReceipt(t, ctx, builder.L2, manualRedeem)
+	frames = traceRetryable(manualRedeem)
+	if len(frames) != 1 || frames[0].Type != "redeem" || frames[0].RedeemType != "manual" {
+		Fatal(t, "unexpected frames for the manual redemption", frames)
+	}
+	if !*frames[0].Success || !*frames[0].TicketClosed {
+		Fatal(t, "manual redemption should have succeeded, closing the ticket", frames[0])
+	}
+}
+
+func TestArbTraceSubmitRetryable(t *testing.T) {
+	t.Parallel()
+	builder, delayedInbox, lookupL2Tx, ctx, teardown := retryableSetup(t)
+	defer teardown()
+
+	usertxopts := builder.L1Info.GetDefaultTransactOpts("Faucet", ctx)
+	deposit := arbmath.BigMul(big.NewInt(1e12), big.NewInt(1e12))
+	usertxopts.Value = deposit
+	user2Address := builder.L2Info.GetAddress("User2")
+	beneficiaryAddress := builder.L2Info.GetAddress("Beneficiary")
+	callValue := big.NewInt(1e6)
+	maxSubmissionCost := big.NewInt(1e16)
+	gasLimit := big.NewInt(int64(params.TxGas + params.TxDataNonZeroGasEIP2028*4))
+	maxFeePerGas := big.NewInt(l2pricing.InitialBaseFeeWei * 2)
+	retryData := []byte{0x32, 0x42, 0x32, 0x88}
+	l1tx, err := delayedInbox.CreateRetryableTicket(
+		&usertxopts,
+		user2Address,
+		callValue,
+		maxSubmissionCost,
+		beneficiaryAddress,
+		beneficiaryAddress,
+		gasLimit,
+		maxFeePerGas,
+		retryData,
+	)
+	Require(t, err)
+	l1Receipt, err := builder.L1.EnsureTxSucceeded(l1tx)
+	Require(t, err)
+	waitForL1DelayBlocks(t, ctx, builder)
+	submitTx := lookupL2Tx(l1Receipt)
+	_, err = builder.L2.EnsureTxSucceeded(submitTx)
+	Require(t, err)
+
+	l2rpc := builder.L2.Stack.Attach()
+	var frames []traceFrame
+	Require(t, l2rpc.CallContext(ctx, &frames, "arbtrace_transaction", submitTx.Hash()))
+	if len(frames) == 0 {
+		Fatal(t, "submission has no frames")
+	}
+	submission := frames[0].Action.SubmitRetryable
+	if submission == nil {
+		Fatal(t, "submission's parameters missing from its top-level frame", frames[0].Action)
+	}
+	if submission.RetryTo == nil || *submission.RetryTo != user2Address || !bytes.Equal(submission.RetryData, retryData) {
+		Fatal(t, "unexpected retry call", submission.RetryTo, submission.RetryData)
+	}
+	if submission.RetryValue.ToInt().Cmp(callValue) != 0 || submission.DepositValue.ToInt().Sign() <= 0 {
+		Fatal(t, "unexpected values", submission.RetryValue, submission.DepositValue)
+	}
+	if submission.MaxSubmissionFee.ToInt().Cmp(maxSubmissionCost) != 0 || submission.GasFeeCap.ToInt().Cmp(maxFeePerGas) != 0 || uint64(submission.Gas) != gasLimit.Uint64() {
+		Fatal(t, "unexpected fee parameters", submission)
+	}
+	if submission.Beneficiary != beneficiaryAddress || submission.FeeRefundAddr != beneficiaryAddress {
+		Fatal(t, "unexpected refund addresses", submission.Beneficiary, submission.FeeRefundAddr)
+	}
+	// frames of the redeem it scheduled are the retry transaction's, so carry no submission
+	for _, frame := range frames[1:] {
+		if frame.Action.SubmitRetryable != nil {
+			Fatal(t, "nested frame carries the submission's parameters", frame)
+		}
+	}
+}
+
+func TestArbTraceSimulateRedeem(t *testing.T) {
+	t.Parallel()
+	builder, delayedInbox, lookupL2Tx, ctx, teardown := retryableSetup(t)
+	defer teardown()
+
+	ownerTxOpts := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	usertxopts := builder.L1Info.GetDefaultTransactOpts("Faucet", ctx)
+	usertxopts.Value = arbmath.BigMul(big.NewInt(1e12), big.NewInt(1e12))
+
+	simpleAddr, simple := builder.L2.DeploySimple(t, ownerTxOpts)
+	simpleABI, err := mocksgen.SimpleMetaData.GetAbi()
+	Require(t, err)
+
+	beneficiaryAddress := builder.L2Info.GetAddress("Beneficiary")
+	l1tx, err := delayedInbox.CreateRetryableTicket(
+		&usertxopts,
+		simpleAddr,
+		common.Big0,
+		big.NewInt(1e16),
+		beneficiaryAddress,
+		beneficiaryAddress,
+		// send enough L2 gas for intrinsic but not compute, so the auto redeem fails
+		big.NewInt(int64(params.TxGas+params.TxDataNonZeroGasEIP2028*4)),
+		big.NewInt(l2pricing.InitialBaseFeeWei*2),
+		simpleABI.Methods["incrementRedeem"].ID,
+	)
+	Require(t, err)
+	l1Receipt, err := builder.L1.EnsureTxSucceeded(l1tx)
+	Require(t, err)
+	waitForL1DelayBlocks(t, ctx, builder)
+	submitTx := lookupL2Tx(l1Receipt)
+	receipt, err := builder.L2.EnsureTxSucceeded(submitTx)
+	Require(t, err)
+	ticketId := submitTx.Hash()
+	_, err = WaitForTx(ctx, builder.L2.Client, receipt.Logs[1].Topics[2], time.Second*5)
+	Require(t, err)
+
+	type redeemSimulation struct {
+		TicketId     common.Hash   `json:"ticketId"`
+		Success      bool          `json:"success"`
+		Error        *string       `json:"error,omitempty"`
+		RevertReason *string       `json:"revertReason,omitempty"`
+		Output       hexutil.Bytes `json:"output"`
+		Trace        []traceFrame  `json:"trace"`
+	}
+	l2rpc := builder.L2.Stack.Attach()
+
+	// the failed auto redeem leaves the ticket open, and a redeem with enough gas would succeed
+	var simulation redeemSimulation
+	Require(t, l2rpc.CallContext(ctx, &simulation, "arbtrace_simulateRedeem", ticketId, "latest"))
+	if simulation.TicketId != ticketId || !simulation.Success || simulation.Error != nil {
+		Fatal(t, "simulated redeem should have succeeded", simulation)
+	}
+	if len(simulation.Trace) == 0 || simulation.Trace[0].Action.To == nil || *simulation.Trace[0].Action.To != simpleAddr {
+		Fatal(t, "unexpected trace for the simulated redeem", simulation.Trace)
+	}
+	if simulation.Trace[0].Action.From == nil || *simulation.Trace[0].Action.From != util.RemapL1Address(usertxopts.From) {
+		Fatal(t, "simulated redeem should be sent by the ticket's aliased creator", simulation.Trace[0].Action.From)
+	}
+	counter, err := simple.Counter(&bind.CallOpts{})
+	Require(t, err)
+	if counter != 0 {
+		Fatal(t, "simulating the redeem changed state, counter is", counter)
+	}
+
+	// unknown tickets are an error
+	err = l2rpc.CallContext(ctx, &simulation, "arbtrace_simulateRedeem", testhelpers.RandomHash(), "latest")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		Fatal(t, "expected an unknown ticket to be an error, got", err)
+	}
+
+	// once the ticket is redeemed it no longer exists to simulate, though it can still be simulated in the past
+	arbRetryableTx, err := precompilesgen.NewArbRetryableTx(common.HexToAddress("6e"), builder.L2.Client)
+	Require(t, err)
+	tx, err := arbRetryableTx.Redeem(&ownerTxOpts, ticketId)
+	Require(t, err)
+	receipt, err = builder.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+	_, err = WaitForTx(ctx, builder.L2.Client, receipt.Logs[0].Topics[2], time.Second*5)
+	Require(t, err)
+	err = l2rpc.CallContext(ctx, &simulation, "arbtrace_simulateRedeem", ticketId, "latest")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		Fatal(t, "expected a redeemed ticket to be an error, got", err)
+	}
+	before := hexutil.Uint64(receipt.BlockNumber.Uint64() - 1)
+	Require(t, l2rpc.CallContext(ctx, &simulation, "arbtrace_simulateRedeem", ticketId, before))
+	if !simulation.Success {
+		Fatal(t, "simulated redeem before the ticket was redeemed should have succeeded", simulation)
+	}
+}
+
+func TestArbTraceByParentChainTx(t *testing.T) {
+	t.Parallel()
+	builder, delayedInbox, lookupL2Tx, ctx, teardown := retryableSetup(t)
+	defer teardown()
+	l2rpc := builder.L2.Stack.Attach()
+	traceByParentChainTx := func(l1Receipt *types.Receipt) parentChainTxTraces {
+		t.Helper()
+		var result parentChainTxTraces
+		Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_byParentChainTx", l1Receipt.TxHash))
+		if !result.Processed {
+			Fatal(t, "parent chain transaction", l1Receipt.TxHash, "should have been processed")
+		}
+		return result
+	}
+
+	// a deposit creates a single transaction
+	depositOpts := builder.L1Info.GetDefaultTransactOpts("Faucet", ctx)
+	depositOpts.Value = big.NewInt(13)
+	l1tx, err := delayedInbox.DepositEth439370b1(&depositOpts)
+	Require(t, err)
+	l1Receipt, err := builder.L1.EnsureTxSucceeded(l1tx)
+	Require(t, err)
+	waitForL1DelayBlocks(t, ctx, builder)
+	depositTx := lookupL2Tx(l1Receipt)
+	_, err = builder.L2.EnsureTxSucceeded(depositTx)
+	Require(t, err)
+	result := traceByParentChainTx(l1Receipt)
+	if len(result.Transactions) != 1 || result.Transactions[0].TransactionHash != depositTx.Hash() {
+		Fatal(t, "unexpected transactions for the deposit", result.Transactions)
+	}
+	if len(result.Transactions[0].Trace) == 0 {
+		Fatal(t, "deposit wasn't traced")
+	}
+
+	// a retryable's submission is followed by its auto redeem
+	ownerTxOpts := builder.L2Info.GetDefaultTransactOpts("Owner", ctx)
+	simpleAddr, _ := builder.L2.DeploySimple(t, ownerTxOpts)
+	simpleABI, err := mocksgen.SimpleMetaData.GetAbi()
+	Require(t, err)
+	beneficiaryAddress := builder.L2Info.GetAddress("Beneficiary")
+	usertxopts := builder.L1Info.GetDefaultTransactOpts("Faucet", ctx)
+	usertxopts.Value = arbmath.BigMul(big.NewInt(1e12), big.NewInt(1e12))
+	l1tx, err = delayedInbox.CreateRetryableTicket(
+		&usertxopts,
+		simpleAddr,
+		common.Big0,
+		big.NewInt(1e16),
+		beneficiaryAddress,
+		beneficiaryAddress,
+		big.NewInt(1e6),
+		big.NewInt(l2pricing.InitialBaseFeeWei*2),
+		simpleABI.Methods["incrementRedeem"].ID,
+	)
+	Require(t, err)
+	l1Receipt, err = builder.L1.EnsureTxSucceeded(l1tx)
+	Require(t, err)
+	waitForL1DelayBlocks(t, ctx, builder)
+	submitTx := lookupL2Tx(l1Receipt)
+	_, err = builder.L2.EnsureTxSucceeded(submitTx)
+	Require(t, err)
+	result = traceByParentChainTx(l1Receipt)
+	if len(result.Transactions) != 2 || result.Transactions[0].TransactionHash != submitTx.Hash() {
+		Fatal(t, "unexpected transactions for the retryable", result.Transactions)
+	}
+	if result.Transactions[0].MessageIndex != result.Transactions[1].MessageIndex {
+		Fatal(t, "auto redeem should share its submission's message", result.Transactions)
+	}
+	retryTx, _, err := builder.L2.Client.TransactionByHash(ctx, result.Transactions[1].TransactionHash)
+	Require(t, err)
+	if retryTx.Type() != types.ArbitrumRetryTxType {
+		Fatal(t, "expected the auto redeem, got a transaction of type", retryTx.Type())
+	}
+	if len(result.Transactions[1].Trace) == 0 {
+		Fatal(t, "auto redeem wasn't traced")
+	}
+}
+
+func TestArbTraceDelayedMessage(t *testing.T) {
+	t.Parallel()
+	builder, delayedInbox, lookupL2Tx, ctx, teardown := retryableSetup(t)
+	defer teardown()
+	l2rpc := builder.L2.Stack.Attach()
+
+	depositOpts := builder.L1Info.GetDefaultTransactOpts("Faucet", ctx)
+	depositOpts.Value = big.NewInt(13)
+	l1tx, err := delayedInbox.DepositEth439370b1(&depositOpts)
+	Require(t, err)
+	l1Receipt, err := builder.L1.EnsureTxSucceeded(l1tx)
+	Require(t, err)
+	waitForL1DelayBlocks(t, ctx, builder)
+	depositTx := lookupL2Tx(l1Receipt)
+	receipt, err := builder.L2.EnsureTxSucceeded(depositTx)
+	Require(t, err)
+	delayedCount, err := builder.L2.ConsensusNode.InboxTracker.GetDelayedCount()
+	Require(t, err)
+	// the deposit is the last message delivered
+	index := hexutil.Uint64(delayedCount - 1)
+
+	var result delayedMessageTraces
+	Require(t, l2rpc.CallContext(ctx, &result, "arbtrace_delayedMessage", index))
+	if result.MessageIndex != index || result.BlockNumber != hexutil.Uint64(receipt.BlockNumber.Uint64()) || result.BlockHash != receipt.BlockHash {
+		Fatal(t, "delayed message", index, "was located in block", result.BlockNumber, result.BlockHash, "rather than the deposit's", receipt.BlockNumber, receipt.BlockHash)
+	}
+	if expected := arbutil.BlockNumberToMessageCount(receipt.BlockNumber.Uint64(), 0) - 1; result.MessageNumber != hexutil.Uint64(expected) {
+		Fatal(t, "delayed message", index, "was sequenced as message", result.MessageNumber, "rather than", expected)
+	}
+	if len(result.Transactions) != 1 || result.Transactions[0].TransactionHash != depositTx.Hash() || len(result.Transactions[0].Trace) == 0 {
+		Fatal(t, "unexpected transactions for the deposit's message", result.Transactions)
+	}
+	if result.Batch != nil {
+		batch, found, err := builder.L2.ConsensusNode.InboxTracker.FindInboxBatchContainingMessage(arbutil.MessageIndex(result.MessageNumber))
+		Require(t, err)
+		if !found || hexutil.Uint64(batch) != *result.Batch {
+			Fatal(t, "delayed message", index, "was reported in batch", *result.Batch, "rather than", batch)
+		}
+	}
+
+	err = l2rpc.CallContext(ctx, &result, "arbtrace_delayedMessage", hexutil.Uint64(delayedCount+10))
+	if err == nil || !strings.Contains(err.Error(), gethexec.ErrDelayedMessageNotFound.Error()) {
+		Fatal(t, "expected a delayed message not yet read to be rejected, got", err)
+	}
+}
+
 func TestSubmissionGasCosts(t *testing.T) {
 	t.Parallel()
 	builder, delayedInbox, lookupL2Tx, ctx, teardown := retryableSetup(t)
@@ -597,6 +980,7 @@ func TestDepositETH(t *testing.T) {
 	if err != nil {
 		t.Fatalf("EnsureTxSucceeded unexpected error: %v", err)
 	}
+	assertTraceMatchesReceipt(t, ctx, builder.L2, l2Receipt.TxHash)
 	newBalance, err := builder.L2.Client.BalanceAt(ctx, faucetAddr, l2Receipt.BlockNumber)
 	if err != nil {
 		t.Fatalf("BalanceAt(%v) unexpected error: %v", faucetAddr, err)